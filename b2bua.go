@@ -0,0 +1,595 @@
+package gsip
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 对话内请求等待另一方最终响应的时间，超过之后以 408 响应
+const callRelayTimeout = 32 * time.Second
+
+// 转发消息时修改头部，from 为收到的消息，to 为发送给另一方的消息
+type B2BUAHeaderHook func(call *Call, from sip.Message, to sip.Message)
+
+// 转发 SDP 时改写，例如替换媒体地址；返回错误时以 488 拒绝请求
+type B2BUASdpHook func(call *Call, from sip.Message, sdp []byte) ([]byte, error)
+
+// B2BUA 的配置选项
+type B2BUAOptions struct {
+	router  Router
+	headers B2BUAHeaderHook
+	sdp     B2BUASdpHook
+	// 呼叫建立时执行
+	answered func(call *Call)
+}
+
+type B2BUAOption func(*B2BUAOptions)
+
+func newB2BUAOptions(router Router, opts ...B2BUAOption) B2BUAOptions {
+	opt := B2BUAOptions{
+		router: router,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
+// 转发消息时修改头部
+func B2BUAHeaders(hook B2BUAHeaderHook) B2BUAOption {
+	return func(o *B2BUAOptions) {
+		o.headers = hook
+	}
+}
+
+// 转发 SDP 时改写，不配置时直接转发
+func B2BUASdp(hook B2BUASdpHook) B2BUAOption {
+	return func(o *B2BUAOptions) {
+		o.sdp = hook
+	}
+}
+
+// 呼叫建立(另一方返回 2xx 并转发给呼叫方)时执行
+func B2BUAAnswered(handler func(call *Call)) B2BUAOption {
+	return func(o *B2BUAOptions) {
+		o.answered = handler
+	}
+}
+
+// B2BUA 的呼叫：呼入的 UAS 对话与呼出的 UAC 对话，两侧使用不同的 Call-ID 以及 tag；
+// 呼出到 Router 返回的所有目标，第一个 2xx 建立呼叫并取消其他目标
+type Call struct {
+	service *service
+	opts    *B2BUAOptions
+	// 呼入的 INVITE 以及两侧的对话
+	invite   sip.Request
+	inbound  sip.Dialog
+	outbound sip.Dialog
+	// 呼出的 INVITE
+	legs    []*callLeg
+	pending int
+	// 所有目标都失败时转发的最佳响应
+	best sip.Response
+	// 另一方已应答、已向呼叫方发送最终响应
+	answered bool
+	final    bool
+	// 等待转发 ACK 的另一方的 2xx，key 为发送 ACK 一方的 Call-ID；
+	// 事务层不自动发送 ACK，2xx 的 ACK 携带呼叫方 ACK 的消息体(延迟 offer 时为 answer)
+	acks map[string]sip.Response
+
+	done chan struct{}
+	once sync.Once
+	mu   sync.RWMutex
+}
+
+// 呼出到一个目标的 INVITE
+type callLeg struct {
+	invite sip.Request
+	cancel context.CancelFunc
+}
+
+// 呼入的 INVITE
+func (call *Call) Invite() sip.Request {
+	return call.invite
+}
+
+// 呼入一侧的对话，发送临时响应之前为 nil
+func (call *Call) Inbound() sip.Dialog {
+	call.mu.RLock()
+	defer call.mu.RUnlock()
+
+	return call.inbound
+}
+
+// 呼出一侧的对话，收到带 tag 的响应之前为 nil
+func (call *Call) Outbound() sip.Dialog {
+	call.mu.RLock()
+	defer call.mu.RUnlock()
+
+	return call.outbound
+}
+
+// 呼叫结束
+func (call *Call) Done() <-chan struct{} {
+	return call.done
+}
+
+// 挂断呼叫：已建立时向两侧发送 BYE，否则取消呼出并以 487 响应呼入的 INVITE
+func (call *Call) Hangup(ctx context.Context) error {
+	call.mu.Lock()
+	if !call.answered {
+		call.terminate()
+		call.mu.Unlock()
+		return nil
+	}
+	dialogs := []sip.Dialog{call.inbound, call.outbound}
+	call.mu.Unlock()
+
+	defer call.end()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, len(dialogs))
+	)
+	for _, dialog := range dialogs {
+		if dialog == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(dialog sip.Dialog) {
+			defer wg.Done()
+			if _, err := call.service.Request(ctx, dialog.CreateRequest(sip.BYE)); err != nil {
+				errs <- err
+			}
+		}(dialog)
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// 呼出到所有目标
+func (call *Call) dial(targets []sip.Uri) {
+	call.mu.Lock()
+	for _, target := range targets {
+		ctx, cancel := context.WithCancel(context.Background())
+		leg := &callLeg{
+			invite: call.createInvite(target),
+			cancel: cancel,
+		}
+		if err := call.relay(call.invite, leg.invite); err != nil {
+			cancel()
+			call.mu.Unlock()
+			logger.Warnf("[G.SIP] -> relay %s failed: %s", call.invite.Short(), err)
+			call.reject(call.invite.CreateResponseReason(sip.StatusNotAcceptableHere, "Not Acceptable Here"))
+			return
+		}
+		call.legs = append(call.legs, leg)
+		call.pending++
+		call.service.addCall(string(*leg.invite.CallID()), call)
+
+		go call.run(ctx, leg)
+	}
+	call.mu.Unlock()
+}
+
+// 呼出的 INVITE：新的 Call-ID 以及 From tag，From、To 与呼入的 INVITE 相同
+func (call *Call) createInvite(target sip.Uri) sip.Request {
+	s := call.service
+	invite := s.CreateRequest(sip.INVITE, "", call.invite.From().Address.Copy(), call.invite.To().Address.Copy())
+	invite.SetRecipient(target.Copy())
+	invite.CSeq().MethodName = sip.INVITE
+	if maxForwards, ok := requestMaxForwards(call.invite); ok && maxForwards > 0 {
+		maxForwards--
+		invite.ReplaceHeader(&maxForwards)
+	}
+	invite.AddHeader(call.contact(call.invite.From().Address))
+
+	return invite
+}
+
+// 发送呼出的 INVITE，临时响应转发给呼叫方
+func (call *Call) run(ctx context.Context, leg *callLeg) {
+	defer leg.cancel()
+
+	res, err := call.service.Request(ctx, leg.invite, ProvisionalCallback(func(res sip.Response, tx sip.ClientTransaction) {
+		call.receiveProvisional(res)
+	}))
+	if err != nil {
+		logger.Warnf("[G.SIP] -> call %s to %s failed: %s", call.invite.Short(), leg.invite.Recipient(), err)
+	}
+	if res == nil {
+		res = leg.invite.CreateResponseReason(sip.StatusRequestTimeout, "Request Timeout")
+	}
+
+	call.receive(res)
+}
+
+// 转发临时响应(100 除外)，同时记录两侧的早期对话
+func (call *Call) receiveProvisional(res sip.Response) {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+
+	if call.final || res.StatusCode() == sip.StatusTrying {
+		return
+	}
+	if dialog := call.service.DialogOf(res); dialog != nil && call.outbound == nil {
+		call.outbound = dialog
+	}
+
+	response := call.invite.CreateResponseReason(res.StatusCode(), res.Reason())
+	response.AddHeader(call.contact(call.invite.To().Address))
+	if err := call.relay(res, response); err != nil {
+		logger.Warnf("[G.SIP] -> relay %s failed: %s", res.Short(), err)
+		return
+	}
+	if call.service.respond(response) && call.inbound == nil {
+		call.inbound = call.service.DialogOf(response)
+	}
+}
+
+// 处理呼出的最终响应：第一个 2xx 转发给呼叫方并取消其他目标，所有目标都失败时转发最佳响应
+func (call *Call) receive(res sip.Response) {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+
+	call.pending--
+	if res.IsSuccess() {
+		dialog := call.service.DialogOf(res)
+		// 已经应答或者呼叫方已经取消，发送 ACK 之后挂断多余的对话
+		if call.answered || call.final {
+			call.ack(res, nil)
+			call.bye(dialog)
+			if call.pending == 0 && !call.answered {
+				call.end()
+			}
+			return
+		}
+		call.answered = true
+		call.outbound = dialog
+		call.cancelLegs()
+		call.answer(res)
+		return
+	}
+
+	if call.best == nil || responseRank(res) < responseRank(call.best) {
+		call.best = res
+	}
+	if call.pending > 0 || call.answered {
+		return
+	}
+	if !call.final {
+		response := call.invite.CreateResponseReason(call.best.StatusCode(), call.best.Reason())
+		if err := call.relay(call.best, response); err != nil {
+			logger.Warnf("[G.SIP] -> relay %s failed: %s", call.best.Short(), err)
+		}
+		call.final = true
+		call.service.respond(response)
+	}
+	call.end()
+}
+
+// 转发 2xx 建立呼叫，改写 SDP 失败时以 500 响应并挂断呼出的对话
+func (call *Call) answer(res sip.Response) {
+	call.final = true
+
+	response := call.invite.CreateResponseReason(res.StatusCode(), res.Reason())
+	response.AddHeader(call.contact(call.invite.To().Address))
+	if err := call.relay(res, response); err != nil {
+		logger.Warnf("[G.SIP] -> relay %s failed: %s", res.Short(), err)
+		call.service.respond(call.invite.CreateResponseReason(sip.StatusServerInternalError, "Server Internal Error"))
+		call.ack(res, nil)
+		call.bye(call.outbound)
+		call.end()
+		return
+	}
+	call.acks[string(*call.invite.CallID())] = res
+	if !call.service.respond(response) {
+		delete(call.acks, string(*call.invite.CallID()))
+		call.ack(res, nil)
+		call.bye(call.outbound)
+		call.end()
+		return
+	}
+	call.inbound = call.service.DialogOf(response)
+
+	if call.opts.answered != nil {
+		go call.opts.answered(call)
+	}
+}
+
+// 呼叫方取消或者应答之前挂断：取消所有呼出，以 487 响应呼入的 INVITE
+func (call *Call) terminate() {
+	if call.final {
+		return
+	}
+	call.final = true
+	call.cancelLegs()
+	call.service.respond(call.invite.CreateResponseReason(sip.StatusRequestTerminated, "Request Terminated"))
+}
+
+// 以 response 拒绝呼入的 INVITE
+func (call *Call) reject(response sip.Response) {
+	call.mu.Lock()
+	call.final = true
+	call.mu.Unlock()
+
+	call.cancelLegs()
+	call.service.respond(response)
+	call.end()
+}
+
+func (call *Call) cancelLegs() {
+	for _, leg := range call.legs {
+		leg.cancel()
+	}
+}
+
+func (call *Call) bye(dialog sip.Dialog) {
+	if dialog == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), callRelayTimeout)
+		defer cancel()
+
+		if _, err := call.service.Request(ctx, dialog.CreateRequest(sip.BYE)); err != nil {
+			logger.Warnf("[G.SIP] -> BYE dialog %s failed: %s", dialog.ID(), err)
+		}
+	}()
+}
+
+// 呼叫结束，移除两侧的 Call-ID
+func (call *Call) end() {
+	call.once.Do(func() {
+		call.service.removeCall(call)
+		close(call.done)
+	})
+}
+
+// 对话内的请求转换为另一方对话内的请求，另一方的响应转换为本请求的响应；
+// BYE 立即返回 200 并挂断另一方
+func (call *Call) relayRequest(req sip.Request, tx sip.ServerTransaction) {
+	call.mu.RLock()
+	peer := call.outbound
+	if string(*req.CallID()) != string(*call.invite.CallID()) {
+		peer = call.inbound
+	}
+	call.mu.RUnlock()
+
+	s := call.service
+	if peer == nil {
+		s.respond(req.CreateResponseReason(sip.StatusCallTransactionDoesNotExist, "Call/Transaction Does Not Exist"))
+		return
+	}
+
+	if req.Method() == sip.BYE {
+		s.respond(req.CreateResponse(sip.StatusOK))
+		call.bye(peer)
+		call.end()
+		return
+	}
+
+	out := peer.CreateRequest(req.Method())
+	// 目标刷新请求携带本端的联系地址，否则传输层使用 From 作为 Contact
+	if req.IsInvite() || req.Method() == sip.UPDATE {
+		out.AddHeader(call.contact(peer.LocalUri()))
+	}
+	if err := call.relay(req, out); err != nil {
+		logger.Warnf("[G.SIP] -> relay %s failed: %s", req.Short(), err)
+		s.respond(req.CreateResponseReason(sip.StatusNotAcceptableHere, "Not Acceptable Here"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), callRelayTimeout)
+	defer cancel()
+
+	res, err := s.Request(ctx, out, ProvisionalCallback(func(res sip.Response, tx sip.ClientTransaction) {
+		if res.StatusCode() == sip.StatusTrying {
+			return
+		}
+		response := req.CreateResponseReason(res.StatusCode(), res.Reason())
+		if err := call.relay(res, response); err == nil {
+			s.respond(response)
+		}
+	}))
+	if res == nil {
+		logger.Warnf("[G.SIP] -> relay %s failed: %s", req.Short(), err)
+		s.respond(req.CreateResponseReason(sip.StatusRequestTimeout, "Request Timeout"))
+		return
+	}
+
+	response := req.CreateResponseReason(res.StatusCode(), res.Reason())
+	if res.IsSuccess() && (req.IsInvite() || req.Method() == sip.UPDATE) {
+		response.AddHeader(call.contact(req.To().Address))
+	}
+	if err := call.relay(res, response); err != nil {
+		logger.Warnf("[G.SIP] -> relay %s failed: %s", res.Short(), err)
+		response = req.CreateResponseReason(sip.StatusServerInternalError, "Server Internal Error")
+	}
+	// re-INVITE 的 2xx 等待请求方的 ACK 之后转发
+	if req.IsInvite() && res.IsSuccess() {
+		if response.IsSuccess() {
+			call.mu.Lock()
+			call.acks[string(*req.CallID())] = res
+			call.mu.Unlock()
+		} else {
+			call.ack(res, nil)
+		}
+	}
+	s.respond(response)
+}
+
+// 转发 2xx 的 ACK：ack 为收到的 ACK，转换为另一方 2xx 的 ACK 并转发消息体
+func (call *Call) relayAck(ack sip.Request) {
+	call.mu.Lock()
+	res, ok := call.acks[string(*ack.CallID())]
+	delete(call.acks, string(*ack.CallID()))
+	call.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	call.ack(res, ack)
+}
+
+// 发送另一方 2xx 的 ACK RFC 3261 - 13.2.2.4，from 不为 nil 时转发其消息体；
+// 事务层记录上层发送的 ACK，2xx 重发时重发该 ACK
+func (call *Call) ack(res sip.Response, from sip.Request) {
+	dialog := call.service.DialogOf(res)
+	if dialog == nil || res.CSeq() == nil {
+		return
+	}
+
+	ack := dialog.CreateRequest(sip.ACK)
+	ack.ReplaceHeader(&sip.CSeq{SeqNo: res.CSeq().SeqNo, MethodName: sip.ACK})
+	if from != nil {
+		if err := call.relay(from, ack); err != nil {
+			logger.Warnf("[G.SIP] -> relay %s failed: %s", from.Short(), err)
+		}
+	}
+	if _, err := call.service.Send(ack); err != nil {
+		logger.Warnf("[G.SIP] -> send ACK for %s failed: %s", res.Short(), err)
+	}
+}
+
+// 转发消息体以及执行头部修改函数：application/sdp 经过 SDP 改写函数，其他消息体直接转发
+func (call *Call) relay(from sip.Message, to sip.Message) error {
+	if body := from.Body(); len(body) > 0 {
+		if contentType := from.ContentType(); contentType != nil {
+			to.ReplaceHeader(contentType.Copy())
+			if call.opts.sdp != nil && strings.HasPrefix(strings.ToLower(string(*contentType)), "application/sdp") {
+				var err error
+				if body, err = call.opts.sdp(call, from, body); err != nil {
+					return err
+				}
+			}
+		}
+		to.SetBody(body, true)
+	}
+	if call.opts.headers != nil {
+		call.opts.headers(call, from, to)
+	}
+
+	return nil
+}
+
+// 本端的联系地址：uri 的用户名@本地 IP
+func (call *Call) contact(uri sip.Uri) *sip.ContactHeader {
+	user := ""
+	if uri != nil && uri.User() != nil {
+		user = uri.User().String()
+	}
+
+	return &sip.ContactHeader{
+		Address: call.service.CreateSipUri(user, call.service.opts.tp.LocalIP().String()),
+		Params:  sip.NewParams(),
+	}
+}
+
+// 收到新的 INVITE 时创建呼叫并呼出到 Router 返回的目标，未知对话内的 INVITE 返回 481
+func (s *service) handleCallInvite(request sip.Request, tx sip.ServerTransaction) {
+	if to := request.To(); to != nil && to.Params.Has("tag") {
+		s.respond(request.CreateResponseReason(sip.StatusCallTransactionDoesNotExist, "Call/Transaction Does Not Exist"))
+		return
+	}
+
+	targets, res := routeTargets(s.opts.b2bua.router, request)
+	if res != nil {
+		s.respond(res)
+		return
+	}
+
+	call := &Call{
+		service: s,
+		opts:    s.opts.b2bua,
+		invite:  request,
+		acks:    make(map[string]sip.Response),
+		done:    make(chan struct{}),
+	}
+	s.addCall(string(*request.CallID()), call)
+	call.dial(targets)
+}
+
+// B2BUA 呼叫的 CANCEL、2xx 的 ACK 以及对话内的请求由呼叫处理，返回是否已处理
+func (s *service) handleCallRequest(request sip.Request, tx sip.ServerTransaction) bool {
+	callID := request.CallID()
+	if callID == nil {
+		return false
+	}
+	s.cmu.RLock()
+	call, ok := s.calls[string(*callID)]
+	s.cmu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	switch {
+	case request.IsCancel():
+		call.mu.Lock()
+		call.terminate()
+		call.mu.Unlock()
+		return true
+	case request.IsAck():
+		call.relayAck(request)
+		return true
+	case request.Method() == sip.PRACK:
+		return false
+	}
+	if to := request.To(); to == nil || !to.Params.Has("tag") {
+		return false
+	}
+
+	call.relayRequest(request, tx)
+	return true
+}
+
+// 事务层已知的对话(例如转移建立的对话)内的 re-INVITE，不属于 B2BUA 的呼叫，按普通请求处理
+func (s *service) isDialogReinvite(request sip.Request) bool {
+	if !request.IsInvite() {
+		return false
+	}
+	if to := request.To(); to == nil || !to.Params.Has("tag") {
+		return false
+	}
+
+	return s.DialogOf(request) != nil
+}
+
+func (s *service) addCall(callID string, call *Call) {
+	s.cmu.Lock()
+	s.calls[callID] = call
+	s.cmu.Unlock()
+}
+
+func (s *service) removeCall(call *Call) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	delete(s.calls, string(*call.invite.CallID()))
+	for _, leg := range call.legs {
+		delete(s.calls, string(*leg.invite.CallID()))
+	}
+}
+
+func (s *service) Calls() []*Call {
+	s.cmu.RLock()
+	defer s.cmu.RUnlock()
+
+	calls := make([]*Call, 0)
+	for callID, call := range s.calls {
+		if callID == string(*call.invite.CallID()) {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
@@ -0,0 +1,243 @@
+package gsip
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/callback"
+	"github.com/zenghr0820/gsip/sip"
+	"github.com/zenghr0820/gsip/transaction"
+)
+
+const (
+	offerSdp  = "v=0\r\no=bob 1 1 IN IP4 127.0.4.3\r\n"
+	answerSdp = "v=0\r\no=alice 1 1 IN IP4 127.0.4.1\r\n"
+)
+
+// 呼叫方 127.0.4.1 经过 B2BUA 127.0.4.2 呼叫被叫方 127.0.4.3，呼叫方以及被叫方由上层发送 ACK
+func newB2BUATest(t *testing.T) (caller Service, callerCallback callback.Callback, b2bua Service, callee Service, calleeCallback callback.Callback) {
+	callee, calleeCallback = newTestService(t, "127.0.4.3", TransactionConfig(transaction.ManualAck()))
+	router := RouterFunc(func(req sip.Request) ([]sip.Uri, error) {
+		return []sip.Uri{testUri("bob", "127.0.4.3")}, nil
+	})
+	b2bua, _ = newTestService(t, "127.0.4.2", B2BUAConfig(router))
+	caller, callerCallback = newTestService(t, "127.0.4.1", TransactionConfig(transaction.ManualAck()))
+
+	return
+}
+
+// 被叫方的 2xx，携带 Contact 以及 SDP
+func calleeSuccess(req sip.Request, sdp string) sip.Response {
+	res := req.CreateResponse(sip.StatusOK)
+	res.AddHeader(&sip.ContactHeader{Address: testUri("bob", "127.0.4.3"), Params: sip.NewParams()})
+	withSdp(res, sdp)
+
+	return res
+}
+
+func withSdp(msg sip.Message, sdp string) {
+	contentType := sip.ContentType("application/sdp")
+	msg.AddHeader(&contentType)
+	msg.SetBody([]byte(sdp), true)
+}
+
+// 发送对话内 2xx 的 ACK
+func sendAck(t *testing.T, s Service, res sip.Response, sdp string) {
+	t.Helper()
+	dialog := s.DialogOf(res)
+	if dialog == nil {
+		t.Fatalf("no dialog of %s", res.Short())
+	}
+	ack := dialog.CreateRequest(sip.ACK)
+	ack.ReplaceHeader(&sip.CSeq{SeqNo: res.CSeq().SeqNo, MethodName: sip.ACK})
+	if sdp != "" {
+		withSdp(ack, sdp)
+	}
+	if _, err := s.Send(ack); err != nil {
+		t.Fatalf("send ACK failed: %s", err)
+	}
+}
+
+// 等待 B2BUA 的所有呼叫结束
+func waitCallsEnded(t *testing.T, b2bua Service) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(b2bua.Calls()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d calls not ended", len(b2bua.Calls()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func receiveRequest(t *testing.T, requests <-chan sip.Request, method sip.RequestMethod) sip.Request {
+	t.Helper()
+	select {
+	case req := <-requests:
+		if req.Method() != method {
+			t.Fatalf("received %s, want %s", req.Short(), method)
+		}
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s not received", method)
+	}
+
+	return nil
+}
+
+// 转发 180 以及携带 offer 的 2xx(延迟 offer)，呼叫方 ACK 中的 answer 转发给被叫方；
+// re-INVITE 转发到另一方，被叫方的 BYE 挂断呼叫方
+func TestB2BUACall(t *testing.T) {
+	caller, callerCallback, b2bua, callee, calleeCallback := newB2BUATest(t)
+	requests := make(chan sip.Request, 8)
+	calleeCallback.AddRequestHandle(sip.INVITE, func(req sip.Request, tx sip.ServerTransaction) {
+		requests <- req
+		if req.To().Params.Has("tag") {
+			_ = tx.SendResponse(calleeSuccess(req, answerSdp))
+			return
+		}
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusRinging))
+		// 180 先于 2xx 到达
+		time.Sleep(50 * time.Millisecond)
+		_ = tx.SendResponse(calleeSuccess(req, offerSdp))
+	})
+	calleeCallback.AddRequestHandle(sip.ACK, func(req sip.Request, tx sip.ServerTransaction) {
+		requests <- req
+	})
+	byes := make(chan sip.Request, 1)
+	callerCallback.AddRequestHandle(sip.BYE, func(req sip.Request, tx sip.ServerTransaction) {
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusOK))
+		byes <- req
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ringing := make(chan sip.Response, 1)
+	invite := caller.CreateRequest(sip.INVITE, "127.0.4.2:5060", testUri("alice", "127.0.4.1"), testUri("bob", "127.0.4.2"))
+	res, err := caller.Request(ctx, invite, ProvisionalCallback(func(res sip.Response, tx sip.ClientTransaction) {
+		ringing <- res
+	}))
+	if err != nil {
+		t.Fatalf("INVITE failed: %s", err)
+	}
+	if res.StatusCode() != sip.StatusOK || string(res.Body()) != offerSdp {
+		t.Fatalf("response = %s with body %q, want 200 with offer", res.Short(), res.Body())
+	}
+	select {
+	case provisional := <-ringing:
+		if provisional.StatusCode() != sip.StatusRinging {
+			t.Errorf("provisional response = %d, want 180", provisional.StatusCode())
+		}
+	default:
+		t.Error("180 not relayed")
+	}
+	if calls := b2bua.Calls(); len(calls) != 1 {
+		t.Fatalf("%d calls, want 1", len(calls))
+	}
+
+	outbound := receiveRequest(t, requests, sip.INVITE)
+	if outbound.CallID().String() == invite.CallID().String() {
+		t.Error("outbound INVITE uses the Call-ID of the caller")
+	}
+	sendAck(t, caller, res, answerSdp)
+	ack := receiveRequest(t, requests, sip.ACK)
+	if string(ack.Body()) != answerSdp {
+		t.Errorf("ACK body = %q, want the answer of the caller", ack.Body())
+	}
+	calleeDialog := callee.DialogOf(ack)
+	if calleeDialog == nil {
+		t.Fatal("no dialog of the callee")
+	}
+
+	// re-INVITE
+	reinvite := caller.DialogOf(res).CreateRequest(sip.INVITE)
+	withSdp(reinvite, offerSdp)
+	res, err = caller.Request(ctx, reinvite)
+	if err != nil {
+		t.Fatalf("re-INVITE failed: %s", err)
+	}
+	if res.StatusCode() != sip.StatusOK || string(res.Body()) != answerSdp {
+		t.Fatalf("response = %s with body %q, want 200 with answer", res.Short(), res.Body())
+	}
+	relayed := receiveRequest(t, requests, sip.INVITE)
+	if string(relayed.Body()) != offerSdp || relayed.CallID().String() != outbound.CallID().String() {
+		t.Errorf("re-INVITE relayed as %s with body %q", relayed.Short(), relayed.Body())
+	}
+	sendAck(t, caller, res, "")
+	if ack := receiveRequest(t, requests, sip.ACK); ack.CSeq().SeqNo != relayed.CSeq().SeqNo {
+		t.Errorf("ACK CSeq = %d, want %d", ack.CSeq().SeqNo, relayed.CSeq().SeqNo)
+	}
+
+	// 被叫方挂断
+	res, err = callee.Request(ctx, calleeDialog.CreateRequest(sip.BYE))
+	if err != nil || res.StatusCode() != sip.StatusOK {
+		t.Fatalf("BYE failed: %v, %v", res, err)
+	}
+	select {
+	case bye := <-byes:
+		if bye.CallID().String() != invite.CallID().String() {
+			t.Errorf("BYE of Call-ID %s, want %s", bye.CallID(), invite.CallID())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BYE not relayed to the caller")
+	}
+	waitCallsEnded(t, b2bua)
+}
+
+// 呼叫方取消时取消呼出，呼叫方收到 487
+func TestB2BUACancel(t *testing.T) {
+	caller, _, b2bua, _, calleeCallback := newB2BUATest(t)
+	invites := make(chan sip.ServerTransaction, 1)
+	calleeCallback.AddRequestHandle(sip.INVITE, func(req sip.Request, tx sip.ServerTransaction) {
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusRinging))
+		invites <- tx
+	})
+	canceled := make(chan sip.Request, 1)
+	calleeCallback.AddRequestHandle(sip.CANCEL, func(req sip.Request, tx sip.ServerTransaction) {
+		canceled <- req
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	invite := caller.CreateRequest(sip.INVITE, "127.0.4.2:5060", testUri("alice", "127.0.4.1"), testUri("bob", "127.0.4.2"))
+	rctx, rcancel := context.WithCancel(ctx)
+	ringing := make(chan struct{}, 1)
+	go func() {
+		select {
+		case <-ringing:
+			rcancel()
+		case <-ctx.Done():
+		}
+	}()
+	res, err := caller.Request(rctx, invite, ProvisionalCallback(func(res sip.Response, tx sip.ClientTransaction) {
+		if res.StatusCode() == sip.StatusRinging {
+			ringing <- struct{}{}
+		}
+	}))
+	if err != context.Canceled {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+	if res == nil || res.StatusCode() != sip.StatusRequestTerminated {
+		t.Fatalf("response = %v, want 487", res)
+	}
+
+	var tx sip.ServerTransaction
+	select {
+	case tx = <-invites:
+	default:
+		t.Fatal("INVITE not received by the callee")
+	}
+	select {
+	case req := <-canceled:
+		_ = tx.SendResponse(tx.Origin().CreateResponse(sip.StatusRequestTerminated))
+		if req.CallID().String() == invite.CallID().String() {
+			t.Error("CANCEL uses the Call-ID of the caller")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CANCEL not relayed to the callee")
+	}
+
+	waitCallsEnded(t, b2bua)
+}
@@ -23,6 +23,10 @@ type Callback interface {
 	// 执行回调函数
 	DoRequest(request sip.Request, tx sip.ServerTransaction) error
 	DoResponse(response sip.Response, tx sip.ClientTransaction) error
+	// 认证请求，未配置认证或者请求方法不需要认证时返回 true，未通过时已发送响应
+	Authenticate(request sip.Request, tx sip.ServerTransaction) bool
+	// 认证代理转发的请求，通过时返回 true，否则返回需要发送的 407/400/403 响应
+	AuthenticateProxy(request sip.Request) (sip.Response, bool)
 	// 返回用户实现的函数
 	GetAllowedMethods() []sip.RequestMethod
 
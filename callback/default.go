@@ -106,11 +106,60 @@ func (c *callback) DoRequest(request sip.Request, tx sip.ServerTransaction) erro
 			name: string(request.Method()),
 		}
 	}
-	go handler(request, tx)
+	go func() {
+		// 认证未通过时已发送响应，不执行回调函数
+		if !c.Authenticate(request, tx) {
+			return
+		}
+		handler(request, tx)
+	}()
 
 	return nil
 }
 
+func (c *callback) Authenticate(request sip.Request, tx sip.ServerTransaction) bool {
+	if !c.needAuthenticate(request.Method()) {
+		return true
+	}
+
+	return c.opts.authenticator.Authenticate(request, tx)
+}
+
+// 请求是否需要认证
+func (c *callback) needAuthenticate(method sip.RequestMethod) bool {
+	if c.opts.authenticator == nil {
+		return false
+	}
+	if len(c.opts.authMethods) == 0 {
+		return true
+	}
+	for _, m := range c.opts.authMethods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 认证代理转发的请求 RFC 3261 - 22.3，认证器需要实现 sip.ProxyAuthenticator，否则无法质询，拒绝转发
+func (c *callback) AuthenticateProxy(request sip.Request) (sip.Response, bool) {
+	if !c.needAuthenticate(request.Method()) || request.IsAck() || request.IsCancel() {
+		return nil, true
+	}
+
+	authenticator, ok := c.opts.authenticator.(sip.ProxyAuthenticator)
+	if !ok {
+		logger.Warnf("[requestHandle] -> authenticator does not support proxy authentication, reject %s", request.Short())
+		return request.CreateResponseReason(sip.StatusForbidden, "Forbidden"), false
+	}
+	if response := authenticator.AuthenticateProxy(request); response != nil {
+		return response, false
+	}
+
+	return nil, true
+}
+
 func (c *callback) DoResponse(response sip.Response, tx sip.ClientTransaction) error {
 	handler, ok := c.GetResponseHandle()
 
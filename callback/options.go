@@ -1,7 +1,23 @@
 package callback
 
+import (
+	"github.com/zenghr0820/gsip/sip"
+)
+
 // callback 的配置选项
 type Options struct {
+	// 请求认证，为 nil 时不认证
+	authenticator sip.Authenticator
+	// 需要认证的请求方法，为空时认证所有请求
+	authMethods []sip.RequestMethod
 }
 
 type Option func(o *Options)
+
+// 执行回调函数之前认证请求，methods 为空时认证所有请求
+func Authenticator(authenticator sip.Authenticator, methods ...sip.RequestMethod) Option {
+	return func(o *Options) {
+		o.authenticator = authenticator
+		o.authMethods = methods
+	}
+}
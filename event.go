@@ -0,0 +1,95 @@
+package gsip
+
+import (
+	"strings"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 消息体类型
+const (
+	// GB28181 的 MANSCDP 消息体类型
+	ContentTypeMANSCDP = "Application/MANSCDP+xml"
+	// REFER 隐式订阅的通知消息体 RFC 3420
+	ContentTypeSipfrag = "message/sipfrag;version=2.0"
+)
+
+// 事件包 RFC 6665 - 7，定义 Event 头部的事件名称、NOTIFY 的消息体类型以及默认有效期
+type EventPackage interface {
+	// Event 头部中的事件名称
+	Name() string
+	// NOTIFY 消息体的 Content-Type，也用作 SUBSCRIBE 的 Accept
+	ContentType() string
+	// SUBSCRIBE 没有 Expires 头部时的有效期(秒)
+	DefaultExpires() uint32
+}
+
+type eventPackage struct {
+	name        string
+	contentType string
+	expires     uint32
+}
+
+// 创建事件包，例如 gsip.NewEventPackage("reg", "application/reginfo+xml", 3600)
+func NewEventPackage(name string, contentType string, expires uint32) EventPackage {
+	return &eventPackage{
+		name:        name,
+		contentType: contentType,
+		expires:     expires,
+	}
+}
+
+func (pkg *eventPackage) Name() string {
+	return pkg.name
+}
+
+func (pkg *eventPackage) ContentType() string {
+	return pkg.contentType
+}
+
+func (pkg *eventPackage) DefaultExpires() uint32 {
+	return pkg.expires
+}
+
+// 常用的事件包
+var (
+	// 在线状态 RFC 3856
+	PresencePackage = NewEventPackage("presence", "application/pidf+xml", 3600)
+	// 对话状态 RFC 4235
+	DialogPackage = NewEventPackage("dialog", "application/dialog-info+xml", 3600)
+	// 消息等待指示 RFC 3842
+	MessageSummaryPackage = NewEventPackage("message-summary", "application/simple-message-summary", 3600)
+	// GB28181 目录订阅
+	CatalogPackage = NewEventPackage("Catalog", ContentTypeMANSCDP, 3600)
+	// GB28181 报警订阅
+	AlarmPackage = NewEventPackage("Alarm", ContentTypeMANSCDP, 3600)
+	// GB28181 移动设备位置订阅
+	MobilePositionPackage = NewEventPackage("MobilePosition", ContentTypeMANSCDP, 3600)
+	// REFER 创建的隐式订阅 RFC 3515 - 2.4.4，有效期由接受 REFER 的一方决定
+	ReferPackage = NewEventPackage("refer", ContentTypeSipfrag, 180)
+)
+
+// 订阅的标识：对话 ID、事件名称以及 id 参数，同一对话中可以有多个订阅 RFC 6665 - 4.5.2
+func subscriptionKey(dialogID string, event string, id string) string {
+	return dialogID + "__" + strings.ToLower(event) + "__" + id
+}
+
+// 消息的事件名称以及 id 参数
+func eventOf(msg sip.Message) (event string, id string, ok bool) {
+	header := sip.Event(msg)
+	if header == nil {
+		return "", "", false
+	}
+
+	return header.EventType, header.ID(), true
+}
+
+// 生成 Event 头部，id 为空时不带 id 参数
+func eventHeader(pkg EventPackage, id string) *sip.EventHeader {
+	params := sip.NewParams()
+	if id != "" {
+		params.Add("id", sip.String{Str: id})
+	}
+
+	return &sip.EventHeader{EventType: pkg.Name(), Params: params}
+}
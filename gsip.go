@@ -1,6 +1,8 @@
 package gsip
 
 import (
+	"context"
+
 	"github.com/zenghr0820/gsip/sip"
 )
 
@@ -8,8 +10,12 @@ import (
 type Service interface {
 	// 返回当前配置选项
 	Options() Options
-	// 返回当前配置选项
-	Session(key string) sip.Session
+	// 根据对话 ID 返回对话，通过 Dialog.CreateRequest 生成对话内的请求
+	Dialog(id string) sip.Dialog
+	// 返回消息所属的对话
+	DialogOf(msg sip.Message) sip.Dialog
+	// 返回 INVITE 的 Replaces 头部匹配的对话
+	Replaces(invite sip.Request) sip.Dialog
 	// 创建 Sip Uri 实体
 	CreateSipUri(user string, domain string) sip.Uri
 	// 创建请求
@@ -19,6 +25,20 @@ type Service interface {
 	Listen(network string, listenAddr string) error
 	// 发送请求或者响应
 	Send(message sip.Message) (sip.Transaction, error)
+	// 发送请求并等待最终响应
+	Request(ctx context.Context, req sip.Request, opts ...RequestOption) (sip.Response, error)
+	// 向注册服务器注册并自动刷新，关闭服务时注销
+	Register(registrar string, aor sip.Uri, opts ...RegisterOption) *Registration
+	// 订阅通知方的事件包并自动刷新，关闭服务时取消订阅
+	Subscribe(target string, from sip.Uri, to sip.Uri, pkg EventPackage, opts ...SubscribeOption) *Subscription
+	// 作为通知方时事件包的所有订阅
+	Subscribers(event string) []*Subscriber
+	// 盲转：对方呼叫 target，通过隐式订阅跟踪转移结果
+	Transfer(ctx context.Context, dialog sip.Dialog, target sip.Uri, opts ...TransferOption) (*Transfer, error)
+	// 咨询转：对方呼叫 replaced 对话的远端并替换该对话
+	AttendedTransfer(ctx context.Context, dialog sip.Dialog, replaced sip.Dialog, opts ...TransferOption) (*Transfer, error)
+	// B2BUA 正在进行的呼叫
+	Calls() []*Call
 	// 开始 SIP 服务
 	Run() error
 	// 关闭服务
@@ -0,0 +1,434 @@
+package gsip
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+	"github.com/zenghr0820/gsip/transaction"
+)
+
+// 通知方默认配置
+const (
+	// 接受的最小、最大订阅有效期(秒)
+	DefaultNotifierMinExpires uint32 = 60
+	DefaultNotifierMaxExpires uint32 = 86400
+	// 单个 NOTIFY 的超时时间
+	notifyTimeout = transaction.TimeF
+)
+
+// 处理新的订阅，返回错误时以 403 拒绝订阅；
+// 可以调用 Subscriber.Pending 等待授权，调用 Subscriber.SetContent 设置第一个 NOTIFY 的消息体
+type SubscribeHandler func(subscriber *Subscriber) error
+
+type eventHandler struct {
+	pkg     EventPackage
+	handler SubscribeHandler
+}
+
+// 通知方的一个订阅 RFC 6665 - 4.2，有效期到达时发送 terminated;reason=timeout 的 NOTIFY
+type Subscriber struct {
+	service *service
+	pkg     EventPackage
+	// 创建订阅的 SUBSCRIBE
+	request sip.Request
+	id      string
+	dialog  sip.Dialog
+	key     string
+	// 本端的 Contact，NOTIFY 是目标刷新请求
+	contact sip.Uri
+	// 对话内 REFER 的隐式订阅与邀请会话共用对话，订阅终止时不关闭对话 RFC 5057 - 5
+	sharedDialog bool
+	// active、pending、terminated
+	state     string
+	expiresAt time.Time
+	// 最后一次通知的消息体，刷新订阅时重新发送
+	body  []byte
+	timer *time.Timer
+
+	done chan struct{}
+	once sync.Once
+	mu   sync.Mutex
+	// 按顺序发送 NOTIFY，避免 CSeq 乱序
+	sendMu sync.Mutex
+}
+
+// 事件包
+func (sub *Subscriber) Event() EventPackage {
+	return sub.pkg
+}
+
+// Event 头部的 id 参数
+func (sub *Subscriber) ID() string {
+	return sub.id
+}
+
+// 创建订阅的 SUBSCRIBE，消息体为订阅条件，例如 GB28181 目录订阅的查询条件
+func (sub *Subscriber) Request() sip.Request {
+	return sub.request
+}
+
+// 订阅对话，接受订阅之前为 nil
+func (sub *Subscriber) Dialog() sip.Dialog {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	return sub.dialog
+}
+
+// 订阅状态：active、pending、terminated
+func (sub *Subscriber) State() string {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	return sub.state
+}
+
+// 订阅剩余的有效期(秒)
+func (sub *Subscriber) Expires() uint32 {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	return sub.remaining()
+}
+
+// 订阅终止
+func (sub *Subscriber) Done() <-chan struct{} {
+	return sub.done
+}
+
+// 订阅等待授权，NOTIFY 的状态为 pending，直到调用 Notify
+func (sub *Subscriber) Pending() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.state != sip.SubscriptionTerminated {
+		sub.state = sip.SubscriptionPending
+	}
+}
+
+// 设置通知的消息体而不发送，用于订阅处理函数中设置第一个 NOTIFY 的内容
+func (sub *Subscriber) SetContent(body []byte) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	sub.body = body
+}
+
+// 发送状态为 active 的 NOTIFY，body 为 nil 时不带消息体；失败时订阅终止 RFC 6665 - 4.2.2
+func (sub *Subscriber) Notify(ctx context.Context, body []byte) error {
+	sub.mu.Lock()
+	if sub.state == sip.SubscriptionTerminated {
+		sub.mu.Unlock()
+		return fmt.Errorf("[G.SIP] -> subscription %s is terminated", sub.pkg.Name())
+	}
+	sub.state = sip.SubscriptionActive
+	sub.body = body
+	sub.mu.Unlock()
+
+	return sub.notify(ctx, "")
+}
+
+// 发送 terminated 的 NOTIFY 终止订阅，reason 为空时不带 reason 参数
+func (sub *Subscriber) Terminate(ctx context.Context, reason string) error {
+	sub.mu.Lock()
+	if sub.state == sip.SubscriptionTerminated {
+		sub.mu.Unlock()
+		return nil
+	}
+	sub.state = sip.SubscriptionTerminated
+	sub.mu.Unlock()
+
+	return sub.notify(ctx, reason)
+}
+
+// 按当前状态发送 NOTIFY，终止的订阅发送之后关闭对话
+func (sub *Subscriber) notify(ctx context.Context, reason string) error {
+	sub.sendMu.Lock()
+	defer sub.sendMu.Unlock()
+
+	sub.mu.Lock()
+	dialog := sub.dialog
+	state := &sip.SubscriptionStateHeader{State: sub.state, Params: sip.NewParams()}
+	if sub.state == sip.SubscriptionTerminated {
+		if reason != "" {
+			state.Params.Add("reason", sip.String{Str: reason})
+		}
+	} else {
+		state.Params.Add("expires", sip.String{Str: fmt.Sprintf("%d", sub.remaining())})
+	}
+	body := sub.body
+	sub.mu.Unlock()
+	if dialog == nil {
+		return fmt.Errorf("[G.SIP] -> subscription %s is not accepted", sub.pkg.Name())
+	}
+
+	req := dialog.CreateRequest(sip.NOTIFY)
+	req.AddHeader(eventHeader(sub.pkg, sub.id))
+	req.AddHeader(state)
+	req.AddHeader(&sip.ContactHeader{
+		Address: sub.contact.Copy(),
+		Params:  sip.NewParams(),
+	})
+	if len(body) > 0 {
+		contentType := sip.ContentType(sub.pkg.ContentType())
+		req.AddHeader(&contentType)
+		req.SetBody(body, true)
+	}
+
+	res, err := sub.service.Request(ctx, req)
+	if err == nil && !res.IsSuccess() {
+		err = fmt.Errorf("[G.SIP] -> notify %s to %s failed: %d %s", sub.pkg.Name(), dialog.RemoteUri(), res.StatusCode(), res.Reason())
+	}
+	// 终止的订阅，或者 NOTIFY 失败并且没有 Retry-After 时删除订阅 RFC 6665 - 4.2.2
+	if state.State == sip.SubscriptionTerminated || (err != nil && (res == nil || len(res.GetHeaders("Retry-After")) == 0)) {
+		sub.remove()
+	}
+
+	return err
+}
+
+// 接受订阅或者刷新订阅之后重置有效期
+func (sub *Subscriber) setExpires(expires uint32) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	sub.expiresAt = time.Now().Add(time.Duration(expires) * time.Second)
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	sub.timer = time.AfterFunc(time.Duration(expires)*time.Second, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+
+		logger.Debugf("[G.SIP] -> subscription %s of %s expired", sub.pkg.Name(), sub.key)
+		if err := sub.Terminate(ctx, sip.SubscriptionReasonTimeout); err != nil {
+			logger.Warn(err)
+		}
+	})
+}
+
+func (sub *Subscriber) remaining() uint32 {
+	if left := time.Until(sub.expiresAt); left > 0 {
+		return uint32((left + time.Second/2) / time.Second)
+	}
+
+	return 0
+}
+
+// 删除订阅并关闭对话
+func (sub *Subscriber) remove() {
+	sub.once.Do(func() {
+		sub.mu.Lock()
+		sub.state = sip.SubscriptionTerminated
+		if sub.timer != nil {
+			sub.timer.Stop()
+		}
+		dialog := sub.dialog
+		sub.mu.Unlock()
+
+		sub.service.smu.Lock()
+		if sub.service.subscribers[sub.key] == sub {
+			delete(sub.service.subscribers, sub.key)
+		}
+		sub.service.smu.Unlock()
+
+		if dialog != nil && !sub.sharedDialog {
+			dialog.Close()
+		}
+		close(sub.done)
+	})
+}
+
+// 通知方处理 SUBSCRIBE RFC 6665 - 4.2.1：
+// 不支持的事件包返回 489 以及 Allow-Events
+// 有效期小于最小值时返回 423 以及 Min-Expires，大于最大值时使用最大值
+// 对话内的 SUBSCRIBE 刷新订阅，找不到订阅时返回 481，Expires 为 0 时终止订阅
+// 接受订阅之后立即发送 NOTIFY，Expires 为 0 的订阅只获取一次当前状态(fetch)
+func (s *service) handleSubscribe(request sip.Request, tx sip.ServerTransaction) {
+	event, id, _ := eventOf(request)
+	handler, ok := s.opts.packages[strings.ToLower(event)]
+	if !ok {
+		logger.Warnf("[G.SIP] -> unsupported event package '%s' of %s", event, request.Short())
+		response := request.CreateResponseReason(sip.StatusBadEvent, "Bad Event")
+		response.AddHeader(&sip.AllowEventsHeader{Events: s.eventPackages()})
+		s.respond(response)
+		return
+	}
+
+	expires := handler.pkg.DefaultExpires()
+	if header := request.Expires(); header != nil {
+		expires = uint32(*header)
+	}
+	if expires > 0 && expires < s.opts.notifierMinExpires {
+		response := request.CreateResponseReason(sip.StatusIntervalTooBrief, "Interval Too Brief")
+		minExpires := sip.MinExpires(s.opts.notifierMinExpires)
+		response.AddHeader(&minExpires)
+		s.respond(response)
+		return
+	}
+	if s.opts.notifierMaxExpires > 0 && expires > s.opts.notifierMaxExpires {
+		expires = s.opts.notifierMaxExpires
+	}
+
+	if to := request.To(); to != nil && to.Params != nil && to.Params.Has("tag") {
+		s.refreshSubscriber(request, event, id, expires)
+		return
+	}
+
+	subscriber := &Subscriber{
+		service: s,
+		pkg:     handler.pkg,
+		request: request,
+		id:      id,
+		state:   sip.SubscriptionActive,
+		done:    make(chan struct{}),
+	}
+	if err := handler.handler(subscriber); err != nil {
+		logger.Warnf("[G.SIP] -> subscription %s of %s rejected: %s", event, request.Short(), err)
+		s.respond(request.CreateResponseReason(sip.StatusForbidden, "Forbidden"))
+		return
+	}
+
+	// 2xx 的 Contact 为请求的 Request-URI
+	subscriber.contact = request.Recipient().Copy()
+	response := request.CreateResponse(sip.StatusOK)
+	expiresHeader := sip.Expires(expires)
+	response.AddHeader(&expiresHeader)
+	response.AddHeader(&sip.ContactHeader{
+		Address: subscriber.contact.Copy(),
+		Params:  sip.NewParams(),
+	})
+	if !s.respond(response) {
+		return
+	}
+	subscriber.dialog = s.DialogOf(response)
+	if subscriber.dialog == nil {
+		logger.Errorf("[G.SIP] -> no dialog created for subscription %s of %s", event, request.Short())
+		return
+	}
+	subscriber.key = subscriptionKey(subscriber.dialog.ID(), event, id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	// RFC 6665 - 4.4.3 fetch：立即发送终止订阅的 NOTIFY
+	if expires == 0 {
+		subscriber.state = sip.SubscriptionTerminated
+		if err := subscriber.notify(ctx, sip.SubscriptionReasonTimeout); err != nil {
+			logger.Warn(err)
+		}
+		return
+	}
+
+	s.smu.Lock()
+	s.subscribers[subscriber.key] = subscriber
+	s.smu.Unlock()
+	subscriber.setExpires(expires)
+
+	if err := subscriber.notify(ctx, ""); err != nil {
+		logger.Warn(err)
+	}
+}
+
+// 对话内的 SUBSCRIBE 刷新订阅，之后发送当前状态的 NOTIFY RFC 6665 - 4.2.1.2
+func (s *service) refreshSubscriber(request sip.Request, event string, id string, expires uint32) {
+	s.smu.RLock()
+	subscriber, ok := s.subscribers[subscriptionKey(sip.ReceivedDialogID(request), event, id)]
+	s.smu.RUnlock()
+	if !ok {
+		s.respond(request.CreateResponseReason(sip.StatusCallTransactionDoesNotExist, "Subscription Does Not Exist"))
+		return
+	}
+
+	response := request.CreateResponse(sip.StatusOK)
+	expiresHeader := sip.Expires(expires)
+	response.AddHeader(&expiresHeader)
+	response.AddHeader(&sip.ContactHeader{
+		Address: subscriber.contact.Copy(),
+		Params:  sip.NewParams(),
+	})
+	if !s.respond(response) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	// RFC 6665 - 4.2.1.4 取消订阅
+	if expires == 0 {
+		if err := subscriber.Terminate(ctx, ""); err != nil {
+			logger.Warn(err)
+		}
+		return
+	}
+
+	subscriber.setExpires(expires)
+	if err := subscriber.notify(ctx, ""); err != nil {
+		logger.Warn(err)
+	}
+}
+
+// 返回事件包的所有订阅，例如 GB28181 目录变化时通知所有订阅方
+func (s *service) Subscribers(event string) []*Subscriber {
+	s.smu.RLock()
+	defer s.smu.RUnlock()
+
+	subscribers := make([]*Subscriber, 0)
+	for _, subscriber := range s.subscribers {
+		if strings.EqualFold(subscriber.pkg.Name(), event) {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+
+	return subscribers
+}
+
+// 支持的事件包，用于 Allow-Events
+func (s *service) eventPackages() []string {
+	events := make([]string, 0, len(s.opts.packages))
+	for _, handler := range s.opts.packages {
+		events = append(events, handler.pkg.Name())
+	}
+
+	return events
+}
+
+// 发送响应，返回是否成功
+func (s *service) respond(response sip.Response) bool {
+	if _, err := s.Send(response); err != nil {
+		logger.Errorf("[G.SIP] -> send %s failed: %s", response.Short(), err)
+		return false
+	}
+
+	return true
+}
+
+// 关闭服务时终止所有订阅，订阅方应当重新订阅
+func (s *service) terminateSubscribers() {
+	s.smu.RLock()
+	subscribers := make([]*Subscriber, 0, len(s.subscribers))
+	for _, subscriber := range s.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	s.smu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), unregisterTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, subscriber := range subscribers {
+		wg.Add(1)
+		go func(subscriber *Subscriber) {
+			defer wg.Done()
+			if err := subscriber.Terminate(ctx, sip.SubscriptionReasonDeactivated); err != nil {
+				logger.Warn(err)
+			}
+		}(subscriber)
+	}
+	wg.Wait()
+}
@@ -1,6 +1,9 @@
 package gsip
 
 import (
+	"errors"
+	"strings"
+
 	"github.com/zenghr0820/gsip/callback"
 	"github.com/zenghr0820/gsip/logger"
 	"github.com/zenghr0820/gsip/sip"
@@ -19,16 +22,38 @@ type Options struct {
 	Callback callback.Callback
 	// 日志
 	Logger logger.Logger
+	// 认证信息，收到 401/407 时自动重新认证
+	auth sip.Authorized
+	// 通知方支持的事件包
+	packages map[string]*eventHandler
+	// 通知方接受的订阅有效期范围(秒)
+	notifierMinExpires uint32
+	notifierMaxExpires uint32
+	// 被转移方处理 REFER
+	referHandler ReferHandler
+	// 作为有状态代理转发没有回调函数的请求
+	proxy *ProxyOptions
+	// 作为 B2BUA 处理 INVITE
+	b2bua *B2BUAOptions
+	// 配置选项的错误，例如同时配置 B2BUA 以及代理，由 Listen 返回
+	err error
 }
 
 type Option func(*Options)
 type LoggerOption func(*logger.Options)
 
+// B2BUA 依赖事务层的对话，代理模式关闭了对话处理
+var errB2BUAWithProxy = errors.New("[G.SIP] -> B2BUAConfig cannot be used with ProxyConfig or StatelessProxyConfig")
+
 func newOptions(opts ...Option) Options {
 	opt := Options{
 		Callback: callback.DefaultCallback,
 		tp:       transport.CreateLayer(),
 		Logger:   logger.NewLogger(),
+		packages: make(map[string]*eventHandler),
+
+		notifierMinExpires: DefaultNotifierMinExpires,
+		notifierMaxExpires: DefaultNotifierMaxExpires,
 	}
 	opt.tx = transaction.CreateLayer(opt.tp)
 
@@ -83,6 +108,155 @@ func DnsConfig(dns string) Option {
 	}
 }
 
+// 配置传输层选项，如 TLS 证书：
+// gsip.TransportConfig(transport.TlsCertificate("server.crt", "server.key"))
+func TransportConfig(opts ...transport.Option) Option {
+	return func(o *Options) {
+		o.tp.Init(opts...)
+	}
+}
+
+// 配置事务层选项，如支持的扩展以及要求可靠的临时响应：
+// gsip.TransactionConfig(transaction.Supported("timer"), transaction.Require100rel())
+func TransactionConfig(opts ...transaction.Option) Option {
+	return func(o *Options) {
+		o.tx.Init(opts...)
+	}
+}
+
+// 配置认证信息，请求收到 401/407 时自动添加认证头部并重发
+// 按 realm 配置可使用 sip.RealmAuthorized
+func AuthConfig(auth sip.Authorized) Option {
+	return func(o *Options) {
+		o.auth = auth
+	}
+}
+
+// 配置服务端认证，执行请求回调函数之前认证请求，methods 为空时认证所有请求
+func AuthenticatorConfig(authenticator sip.Authenticator, methods ...sip.RequestMethod) Option {
+	return func(o *Options) {
+		o.Callback.Init(callback.Authenticator(authenticator, methods...))
+	}
+}
+
+// 作为通知方支持事件包，收到该事件包的 SUBSCRIBE 时执行 handler：
+// gsip.AddEventPackage(gsip.CatalogPackage, func(subscriber *gsip.Subscriber) error { ... })
+// 没有配置 SUBSCRIBE 的请求回调函数时由服务处理 SUBSCRIBE
+func AddEventPackage(pkg EventPackage, handler SubscribeHandler) Option {
+	return func(o *Options) {
+		o.packages[strings.ToLower(pkg.Name())] = &eventHandler{pkg: pkg, handler: handler}
+	}
+}
+
+// 通知方接受的订阅有效期范围(秒)，小于 min 时返回 423，大于 max 时使用 max，max 为 0 时不限制
+func NotifierExpires(min uint32, max uint32) Option {
+	return func(o *Options) {
+		o.notifierMinExpires = min
+		o.notifierMaxExpires = max
+	}
+}
+
+// 接受呼叫转移 RFC 3515：收到 REFER 时执行处理函数，接受之后呼叫 Refer-To 的目标并通过 NOTIFY 报告进度，
+// 没有配置 REFER 的请求回调函数时由服务处理 REFER
+func ReferConfig(handler ReferHandler) Option {
+	return func(o *Options) {
+		o.referHandler = handler
+		o.packages[strings.ToLower(ReferPackage.Name())] = &eventHandler{pkg: ReferPackage, handler: rejectReferSubscribe}
+	}
+}
+
+// 作为有状态代理 RFC 3261 - 16：没有请求回调函数的方法由代理转发，目标由 router 查询，
+// 可以与注册服务器等回调函数同时使用：
+// gsip.ProxyConfig(router, gsip.ProxyFork(gsip.ForkSequential, 20*time.Second))
+func ProxyConfig(router Router, opts ...ProxyOption) Option {
+	return func(o *Options) {
+		if o.b2bua != nil {
+			o.err = errB2BUAWithProxy
+			return
+		}
+		proxy := newProxyOptions(router, opts...)
+		o.proxy = &proxy
+		o.tx.Init(transaction.Proxy())
+	}
+}
+
+// 作为无状态代理 RFC 3261 - 16.11：不使用事务，请求转发到 router 返回的第一个目标，
+// 响应移除本代理的 Via 之后按第二个 Via 返回，适合作为注册服务器之前的分发节点；
+// ProxyFork 对无状态代理无效
+func StatelessProxyConfig(router Router, opts ...ProxyOption) Option {
+	return func(o *Options) {
+		if o.b2bua != nil {
+			o.err = errB2BUAWithProxy
+			return
+		}
+		proxy := newProxyOptions(router, opts...)
+		proxy.stateless = true
+		o.proxy = &proxy
+		o.tx.Init(transaction.Proxy(), transaction.Stateless())
+	}
+}
+
+// 作为 B2BUA：收到新的 INVITE 时呼出到 router 返回的目标，两侧的对话使用不同的 Call-ID 以及 tag，
+// 转发临时以及最终响应、BYE、CANCEL 以及对话内的请求；没有配置 INVITE 的请求回调函数时由服务处理 INVITE，
+// 不能与代理同时使用，同时配置时忽略后配置的选项，Listen 返回错误；
+// 事务层不自动发送 INVITE 的 2xx 的 ACK(同 transaction.ManualAck)，另一方的 ACK 由呼叫方的 ACK 转换，以转发延迟 offer 的 answer，
+// 服务发送的其他 INVITE 的 2xx 同样由上层发送 ACK
+func B2BUAConfig(router Router, opts ...B2BUAOption) Option {
+	return func(o *Options) {
+		if o.proxy != nil {
+			o.err = errB2BUAWithProxy
+			return
+		}
+		b2bua := newB2BUAOptions(router, opts...)
+		o.b2bua = &b2bua
+		o.tx.Init(transaction.ManualAck())
+	}
+}
+
+// 同步请求 Service.Request 的配置选项
+type RequestOptions struct {
+	// 接收临时响应(1xx)
+	provisional sip.ResponseHandler
+	// 认证信息，覆盖 AuthConfig 的配置
+	auth sip.Authorized
+	// 事务定时器，覆盖事务层的配置
+	timers []transaction.TimerOption
+}
+
+type RequestOption func(*RequestOptions)
+
+func newRequestOptions(opts ...RequestOption) RequestOptions {
+	opt := RequestOptions{}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
+// 同步请求时接收临时响应(1xx)，不配置则忽略临时响应
+func ProvisionalCallback(handler sip.ResponseHandler) RequestOption {
+	return func(o *RequestOptions) {
+		o.provisional = handler
+	}
+}
+
+// 同步请求使用的认证信息，覆盖 AuthConfig 的配置
+func RequestAuth(auth sip.Authorized) RequestOption {
+	return func(o *RequestOptions) {
+		o.auth = auth
+	}
+}
+
+// 同步请求使用的事务定时器，覆盖事务层的配置：
+// gsip.RequestTimers(transaction.TimerT1(2*time.Second))
+func RequestTimers(opts ...transaction.TimerOption) RequestOption {
+	return func(o *RequestOptions) {
+		o.timers = append(o.timers, opts...)
+	}
+}
+
 // 配置日志
 func LoggerConfig(opts ...LoggerOption) Option {
 	return func(o *Options) {
@@ -0,0 +1,793 @@
+package gsip
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 代理的路由，返回请求的目标集合 RFC 3261 - 16.5，例如查询注册服务器的位置服务
+type Router interface {
+	// 返回 *RouteError 时以对应的响应拒绝请求，其他错误返回 500，目标集合为空时返回 480
+	Route(req sip.Request) ([]sip.Uri, error)
+}
+
+// 使用函数作为 Router
+type RouterFunc func(req sip.Request) ([]sip.Uri, error)
+
+func (f RouterFunc) Route(req sip.Request) ([]sip.Uri, error) {
+	return f(req)
+}
+
+// 路由拒绝请求时返回的响应，例如用户不存在返回 404
+type RouteError struct {
+	StatusCode sip.StatusCode
+	Reason     string
+}
+
+func (err *RouteError) Error() string {
+	return fmt.Sprintf("route rejected: %d %s", err.StatusCode, err.Reason)
+}
+
+// 多个目标的分叉方式 RFC 3261 - 16.6
+type ForkMode int
+
+const (
+	// 同时发送到所有目标
+	ForkParallel ForkMode = iota
+	// 依次发送，前一个目标返回非 2xx 的最终响应(或者超时)之后发送下一个目标
+	ForkSequential
+)
+
+// 有状态代理的配置选项
+type ProxyOptions struct {
+	router Router
+	fork   ForkMode
+	// 顺序分叉时每个目标等待最终响应的时间，超时之后 CANCEL 并尝试下一个目标，0 为不限制
+	forkTimeout time.Duration
+	// 为创建对话的请求添加 Record-Route，对话内的请求经过代理
+	recordRoute bool
+	// 代理负责的域，Request-URI 为这些域(以及本地 IP)时由 Router 查询目标，否则直接转发
+	domains []string
+	// 无状态转发，不使用事务
+	stateless bool
+}
+
+type ProxyOption func(*ProxyOptions)
+
+func newProxyOptions(router Router, opts ...ProxyOption) ProxyOptions {
+	opt := ProxyOptions{
+		router:      router,
+		recordRoute: true,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
+// 分叉方式，timeout 为顺序分叉时每个目标等待最终响应的时间
+func ProxyFork(mode ForkMode, timeout time.Duration) ProxyOption {
+	return func(o *ProxyOptions) {
+		o.fork = mode
+		o.forkTimeout = timeout
+	}
+}
+
+// 是否添加 Record-Route，默认添加
+func ProxyRecordRoute(enabled bool) ProxyOption {
+	return func(o *ProxyOptions) {
+		o.recordRoute = enabled
+	}
+}
+
+// 代理负责的域
+func ProxyDomains(domains ...string) ProxyOption {
+	return func(o *ProxyOptions) {
+		o.domains = append(o.domains, domains...)
+	}
+}
+
+// 有状态代理转发的请求 RFC 3261 - 16：每个目标一个客户端事务(分支)，按 16.7 选择转发的响应
+type proxyContext struct {
+	service *service
+	opts    *ProxyOptions
+	// 收到的请求以及对应的服务端事务
+	request sip.Request
+	tx      sip.ServerTransaction
+	// 路由处理之后的请求，每个分支在此基础上修改
+	forward sip.Request
+	// 环路检测的 hash，附加在分支的 branch 之后
+	hash    string
+	targets []sip.Uri
+	next    int
+
+	branches []*proxyBranch
+	// 目前最佳的最终响应以及 401/407 的认证质询
+	best       sip.Response
+	challenges []sip.Header
+	// 已转发最终响应、已收到 CANCEL
+	final    bool
+	canceled bool
+
+	mu sync.Mutex
+	wg sync.WaitGroup
+}
+
+// 转发到一个目标的客户端事务
+type proxyBranch struct {
+	request sip.Request
+	cancel  chan struct{}
+	once    sync.Once
+	// 收到最终响应(或者失败)之后结束等待，事务继续吸收重发的响应
+	done sync.Once
+}
+
+// 代理处理没有回调函数的请求：2xx 的 ACK 直接转发，CANCEL 取消对应的所有分支，其他请求创建响应上下文
+func (s *service) proxyRequest(req sip.Request, tx sip.ServerTransaction) {
+	if !s.authenticateProxy(req, tx) {
+		return
+	}
+
+	switch {
+	case s.opts.proxy.stateless:
+		s.forwardStateless(req)
+		return
+	case req.IsAck():
+		s.proxyAck(req)
+		return
+	case req.IsCancel():
+		s.proxyCancel(req)
+		return
+	case tx == nil:
+		return
+	}
+
+	if res := s.validateProxyRequest(req); res != nil {
+		logger.Warnf("[G.SIP] -> proxy reject %s: %d %s", req.Short(), res.StatusCode(), res.Reason())
+		if err := tx.SendResponse(res); err != nil {
+			logger.Errorf("[G.SIP] -> send %d for %s failed: %s", res.StatusCode(), req.Short(), err)
+		}
+		return
+	}
+
+	forward := sip.CopyRequest(req)
+	s.preprocessRoute(forward)
+
+	ctx := &proxyContext{
+		service: s,
+		opts:    s.opts.proxy,
+		request: req,
+		tx:      tx,
+		forward: forward,
+		hash:    loopHash(req, topVia(req)),
+	}
+
+	// 路由之前登记，查询目标期间收到的 CANCEL 也可以取消请求
+	key := proxyKey(req)
+	s.xmu.Lock()
+	s.proxies[key] = ctx
+	s.xmu.Unlock()
+	defer func() {
+		s.xmu.Lock()
+		delete(s.proxies, key)
+		s.xmu.Unlock()
+	}()
+
+	targets, res := s.proxyTargets(forward)
+	if res != nil {
+		if err := tx.SendResponse(res); err != nil {
+			logger.Errorf("[G.SIP] -> send %d for %s failed: %s", res.StatusCode(), req.Short(), err)
+		}
+		return
+	}
+	ctx.mu.Lock()
+	ctx.targets = targets
+	ctx.mu.Unlock()
+
+	ctx.run()
+}
+
+// 转发之前认证请求 RFC 3261 - 22.3，未通过时以 407 质询(或者 403 拒绝)，不转发
+func (s *service) authenticateProxy(req sip.Request, tx sip.ServerTransaction) bool {
+	res, ok := s.opts.Callback.AuthenticateProxy(req)
+	if ok {
+		return true
+	}
+
+	logger.Infof("[G.SIP] -> proxy authenticate %s: %d %s", req.Short(), res.StatusCode(), res.Reason())
+	if tx == nil {
+		s.replyStateless(req, res)
+		return false
+	}
+	if err := tx.SendResponse(res); err != nil {
+		logger.Errorf("[G.SIP] -> send %d for %s failed: %s", res.StatusCode(), req.Short(), err)
+	}
+
+	return false
+}
+
+// 校验请求 RFC 3261 - 16.3：URI scheme、Max-Forwards、环路以及 Proxy-Require，不通过时返回拒绝的响应
+func (s *service) validateProxyRequest(req sip.Request) sip.Response {
+	if _, ok := req.Recipient().(*sip.SipUri); !ok {
+		return req.CreateResponseReason(sip.StatusUnsupportedURIScheme, "Unsupported URI Scheme")
+	}
+	if maxForwards, ok := requestMaxForwards(req); ok && maxForwards == 0 {
+		return req.CreateResponseReason(sip.StatusTooManyHops, "Too Many Hops")
+	}
+	if s.detectLoop(req) {
+		return req.CreateResponseReason(sip.StatusLoopDetected, "Loop Detected")
+	}
+	if unsupported := sip.UnsupportedProxyOptions(req, s.opts.tx.Supported()); len(unsupported) > 0 {
+		res := req.CreateResponseReason(sip.StatusBadExtension, "Bad Extension")
+		res.AddHeader(&sip.UnsupportedHeader{Options: unsupported})
+		return res
+	}
+
+	return nil
+}
+
+// 环路检测 RFC 3261 - 16.3：本代理的 Via 的 branch 包含相同的 hash 时，请求在没有变化的情况下回到了本代理；
+// 本代理转发时收到的请求的第一个 Via 为本代理的 Via 之后的 Via，Request-URI 等变化时为螺旋(spiral)，继续转发
+func (s *service) detectLoop(req sip.Request) bool {
+	hops := viaHops(req)
+	for i, hop := range hops {
+		if i+1 >= len(hops) || !s.isLocalSentBy(hop) || hop.Params == nil {
+			continue
+		}
+		branch, ok := hop.Params.Get("branch")
+		if ok && branch != nil && strings.HasSuffix(branch.String(), "."+loopHash(req, hops[i+1])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 环路检测的 hash RFC 3261 - 16.6 第 8 步：收到的请求的第一个 Via(top)、Request-URI、From/To tag、Call-ID、CSeq 序号、
+// Proxy-Require 以及 Proxy-Authorization
+func loopHash(req sip.Request, top *sip.ViaHop) string {
+	var builder strings.Builder
+	if top != nil {
+		builder.WriteString(viaKey(top))
+	}
+	builder.WriteString(req.Recipient().String())
+	if from := req.From(); from != nil {
+		if tag, ok := from.Params.Get("tag"); ok && tag != nil {
+			builder.WriteString(tag.String())
+		}
+	}
+	if to := req.To(); to != nil {
+		if tag, ok := to.Params.Get("tag"); ok && tag != nil {
+			builder.WriteString(tag.String())
+		}
+	}
+	if callID := req.CallID(); callID != nil {
+		builder.WriteString(string(*callID))
+	}
+	if cseq := req.CSeq(); cseq != nil {
+		builder.WriteString(fmt.Sprint(cseq.SeqNo))
+	}
+	for _, name := range []string{"Proxy-Require", "Proxy-Authorization"} {
+		for _, hdr := range req.GetHeaders(name) {
+			builder.WriteString(hdr.String())
+		}
+	}
+
+	return fmt.Sprintf("%x", md5.Sum([]byte(builder.String())))
+}
+
+// 预处理路由信息 RFC 3261 - 16.4：严格路由的上游将本代理的 Record-Route 放在 Request-URI 时，
+// 使用最后一个 Route 恢复 Request-URI；第一个 Route 为本代理时移除
+func (s *service) preprocessRoute(req sip.Request) {
+	routes := requestRoutes(req)
+	if s.isRecordRoute(req.Recipient()) && len(routes) > 0 {
+		req.SetRecipient(routes[len(routes)-1])
+		routes = routes[:len(routes)-1]
+		setRoutes(req, routes)
+	}
+	if len(routes) > 0 && s.isLocalUri(routes[0]) {
+		setRoutes(req, routes[1:])
+	}
+}
+
+// 确定目标集合 RFC 3261 - 16.5：Request-URI 不属于本代理时为唯一的目标，否则由 Router 查询
+func (s *service) proxyTargets(req sip.Request) ([]sip.Uri, sip.Response) {
+	if !s.isLocalUri(req.Recipient()) {
+		return []sip.Uri{req.Recipient().Copy()}, nil
+	}
+
+	return routeTargets(s.opts.proxy.router, req)
+}
+
+// 查询 Router 的目标集合，失败时返回拒绝请求的响应
+func routeTargets(router Router, req sip.Request) ([]sip.Uri, sip.Response) {
+	targets, err := router.Route(req)
+	if err != nil {
+		logger.Warnf("[G.SIP] -> route %s failed: %s", req.Short(), err)
+		if routeErr, ok := err.(*RouteError); ok {
+			return nil, req.CreateResponseReason(routeErr.StatusCode, routeErr.Reason)
+		}
+		return nil, req.CreateResponseReason(sip.StatusServerInternalError, "Server Internal Error")
+	}
+	if len(targets) == 0 {
+		return nil, req.CreateResponseReason(sip.StatusNoResponse, "Temporarily Unavailable")
+	}
+
+	return targets, nil
+}
+
+// 生成转发到目标的请求 RFC 3261 - 16.6：Request-URI、Max-Forwards、Record-Route、严格路由以及 branch 的 Via
+func (s *service) proxyForward(template sip.Request, target sip.Uri, branch string, recordRoute bool) sip.Request {
+	req := sip.CopyRequest(template)
+	req.SetRecipient(target.Copy())
+
+	maxForwards := *sip.DefaultMaxForwards()
+	if value, ok := requestMaxForwards(req); ok {
+		maxForwards = value - 1
+	}
+	req.ReplaceHeader(&maxForwards)
+
+	if recordRoute && createsDialog(req) {
+		req.PrependHeader(&sip.RecordRouteHeader{Addresses: []sip.Uri{s.recordRouteUri()}})
+	}
+
+	// 下一跳为严格路由时，Request-URI 放在 Route 的最后，第一个 Route 作为 Request-URI
+	if routes := requestRoutes(req); len(routes) > 0 && !routes[0].UriParams().Has("lr") {
+		next := routes[0]
+		routes = append(routes[1:], req.Recipient())
+		req.SetRecipient(next)
+		setRoutes(req, routes)
+	}
+
+	params := sip.NewParams()
+	params.Add("branch", sip.String{Str: branch})
+	req.PrependHeader(sip.ViaHeader{&sip.ViaHop{
+		ProtocolName:    "SIP",
+		ProtocolVersion: "2.0",
+		Transport:       sip.DefaultProtocol,
+		Host:            s.opts.tp.LocalIP().String(),
+		Params:          params,
+	}})
+
+	return req
+}
+
+// 2xx 的 ACK 为端到端的请求，路由处理之后直接转发，目标为本代理时丢弃
+func (s *service) proxyAck(ack sip.Request) {
+	if maxForwards, ok := requestMaxForwards(ack); ok && maxForwards == 0 {
+		return
+	}
+	forward := sip.CopyRequest(ack)
+	s.preprocessRoute(forward)
+	if s.isLocalUri(forward.Recipient()) && len(requestRoutes(forward)) == 0 {
+		logger.Debugf("[G.SIP] -> drop %s addressed to proxy", ack.Short())
+		return
+	}
+
+	req := s.proxyForward(forward, forward.Recipient(), sip.GenerateBranch()+"."+loopHash(ack, topVia(ack)), false)
+	if _, err := s.opts.tx.Send(req); err != nil {
+		logger.Errorf("[G.SIP] -> forward %s failed: %s", ack.Short(), err)
+	}
+}
+
+// CANCEL 取消对应请求的所有分支 RFC 3261 - 16.10，事务层已经为 CANCEL 返回 200
+func (s *service) proxyCancel(cancel sip.Request) {
+	s.xmu.Lock()
+	ctx, ok := s.proxies[proxyKey(cancel)]
+	s.xmu.Unlock()
+	if !ok {
+		logger.Debugf("[G.SIP] -> %s does not match any proxied request", cancel.Short())
+		return
+	}
+
+	ctx.mu.Lock()
+	ctx.canceled = true
+	ctx.cancelBranches(nil)
+	ctx.mu.Unlock()
+}
+
+// 发送到第一个目标(顺序分叉)或者所有目标(并行分叉)，等待所有分支结束之后转发最佳响应
+func (ctx *proxyContext) run() {
+	ctx.mu.Lock()
+	if ctx.opts.fork == ForkParallel {
+		for ctx.fork() {
+		}
+	} else {
+		ctx.fork()
+	}
+	ctx.mu.Unlock()
+
+	ctx.wg.Wait()
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if !ctx.final {
+		ctx.forwardBest()
+	}
+}
+
+// 转发到下一个目标，没有剩余目标时返回 false
+func (ctx *proxyContext) fork() bool {
+	if ctx.canceled || ctx.next >= len(ctx.targets) {
+		return false
+	}
+	target := ctx.targets[ctx.next]
+	ctx.next++
+
+	branch := &proxyBranch{
+		request: ctx.service.proxyForward(ctx.forward, target, sip.GenerateBranch()+"."+ctx.hash, ctx.opts.recordRoute),
+		cancel:  make(chan struct{}),
+	}
+	ctx.branches = append(ctx.branches, branch)
+
+	ctx.wg.Add(1)
+	go ctx.serveBranch(branch)
+
+	return true
+}
+
+// 发送分支的请求并接收响应，发送失败视为 503，事务超时视为 408 RFC 3261 - 16.8、16.9
+func (ctx *proxyContext) serveBranch(branch *proxyBranch) {
+	defer branch.finish(ctx)
+
+	s := ctx.service
+	responses := s.watchResponses(branch.request)
+	defer s.unwatchResponses(branch.request)
+
+	tx, err := s.opts.tx.SendRequest(branch.request)
+	if err != nil {
+		logger.Warnf("[G.SIP] -> forward %s to %s failed: %s", ctx.request.Short(), branch.request.Recipient(), err)
+		ctx.receive(branch, branch.request.CreateResponseReason(sip.StatusServiceUnavailable, "Service Unavailable"))
+		return
+	}
+
+	var (
+		txErrs     = tx.Errors()
+		canceled   = branch.cancel
+		terminated <-chan time.Time
+		timeout    <-chan time.Time
+		final      bool
+	)
+	if ctx.opts.fork == ForkSequential && ctx.opts.forkTimeout > 0 {
+		timer := time.NewTimer(ctx.opts.forkTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		select {
+		case res := <-responses:
+			final = final || !res.IsProvisional()
+			ctx.receive(branch, res)
+			// 非 2xx 的最终响应的事务在 Timer D 之后才结束，不等待事务结束即可选择最佳响应
+			if final {
+				branch.finish(ctx)
+			}
+		case err, ok := <-txErrs:
+			if ok {
+				logger.Warnf("[G.SIP] -> branch %s failed: %s", branch.request.Short(), err)
+				if !final {
+					final = true
+					ctx.receive(branch, branch.request.CreateResponseReason(sip.StatusRequestTimeout, "Request Timeout"))
+					branch.finish(ctx)
+				}
+				continue
+			}
+			// 事务已结束，最终响应可能仍在传递中
+			txErrs = nil
+			terminated = time.After(s.opts.tx.T1())
+		case <-terminated:
+			if !final {
+				ctx.receive(branch, branch.request.CreateResponseReason(sip.StatusRequestTimeout, "Request Timeout"))
+			}
+			return
+		case <-timeout:
+			timeout = nil
+			if !final {
+				ctx.mu.Lock()
+				branch.stop()
+				ctx.mu.Unlock()
+			}
+		case <-canceled:
+			canceled = nil
+			// 非 INVITE 请求不能取消，等待最终响应
+			if branch.request.IsInvite() && !final {
+				if err := tx.Cancel(); err != nil {
+					logger.Warnf("[G.SIP] -> cancel %s failed: %s", branch.request.Short(), err)
+				}
+			}
+		}
+	}
+}
+
+// 处理分支的响应 RFC 3261 - 16.7：除 100 之外的临时响应以及 2xx 立即转发，其他最终响应保存，
+// INVITE 收到 2xx 或者 6xx 时取消其他分支，顺序分叉时尝试下一个目标
+func (ctx *proxyContext) receive(branch *proxyBranch, res sip.Response) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	switch {
+	case res.IsProvisional():
+		// 100 Trying 为逐跳的响应，由服务端事务发送
+		if res.StatusCode() == sip.StatusTrying || ctx.final {
+			return
+		}
+		ctx.forwardResponse(res)
+	case res.IsSuccess():
+		// INVITE 的每个 2xx 都需要转发，UAC 为每个分叉创建对话；其他请求只转发一个最终响应
+		if ctx.final && !ctx.request.IsInvite() {
+			return
+		}
+		first := !ctx.final
+		ctx.final = true
+		ctx.forwardResponse(res)
+		if first {
+			ctx.cancelBranches(branch)
+		}
+	default:
+		if ctx.final {
+			return
+		}
+		ctx.collect(res)
+		if res.StatusCode() >= 600 {
+			ctx.cancelBranches(branch)
+			return
+		}
+		if ctx.opts.fork == ForkSequential {
+			ctx.fork()
+		}
+	}
+}
+
+// 保存最终响应，按 RFC 3261 - 16.7 第 6 步选择最佳响应，并合并 401/407 的认证质询
+func (ctx *proxyContext) collect(res sip.Response) {
+	switch res.StatusCode() {
+	case sip.StatusUnauthorized:
+		for _, hdr := range res.GetHeaders("WWW-Authenticate") {
+			ctx.challenges = append(ctx.challenges, hdr.Copy())
+		}
+	case sip.StatusProxyAuthenticationRequired:
+		for _, hdr := range res.GetHeaders("Proxy-Authenticate") {
+			ctx.challenges = append(ctx.challenges, hdr.Copy())
+		}
+	}
+
+	if ctx.best == nil || responseRank(res) < responseRank(ctx.best) {
+		ctx.best = res
+	}
+}
+
+// 最佳响应的排序，越小越好：6xx 优先，其次为最低的类别，4xx 中优先 401、407、415、420、484
+func responseRank(res sip.Response) int {
+	code := int(res.StatusCode())
+	switch code {
+	case 401, 407, 415, 420, 484:
+		return 40
+	}
+	if code >= 600 {
+		return 0
+	}
+
+	return code/100*10 + 1
+}
+
+// 所有分支结束之后转发最佳响应，503 转换为 500，401/407 携带所有分支的认证质询
+func (ctx *proxyContext) forwardBest() {
+	ctx.final = true
+	if ctx.best == nil {
+		// 转发之前收到 CANCEL 时没有分支，以 487 响应 RFC 3261 - 16.10
+		if ctx.canceled && len(ctx.branches) == 0 {
+			ctx.best = ctx.request.CreateResponseReason(sip.StatusRequestTerminated, "Request Terminated")
+		} else {
+			ctx.best = ctx.request.CreateResponseReason(sip.StatusRequestTimeout, "Request Timeout")
+		}
+		ctx.send(ctx.best)
+		return
+	}
+
+	res := sip.CopyResponse(ctx.best)
+	if res.StatusCode() == sip.StatusServiceUnavailable {
+		res.SetStatusCode(sip.StatusServerInternalError)
+		res.SetReason("Server Internal Error")
+	}
+	if isChallenge(res) && len(ctx.challenges) > 0 {
+		res.DelHeader("WWW-Authenticate", "Proxy-Authenticate")
+		for _, hdr := range ctx.challenges {
+			res.AddHeader(hdr)
+		}
+	}
+
+	ctx.forwardResponse(res)
+}
+
+// 取消 except 之外的所有分支，不再尝试剩余的目标
+func (ctx *proxyContext) cancelBranches(except *proxyBranch) {
+	ctx.next = len(ctx.targets)
+	for _, branch := range ctx.branches {
+		if branch != except {
+			branch.stop()
+		}
+	}
+}
+
+// 分支已有最终响应
+func (branch *proxyBranch) finish(ctx *proxyContext) {
+	branch.done.Do(ctx.wg.Done)
+}
+
+func (branch *proxyBranch) stop() {
+	branch.once.Do(func() {
+		close(branch.cancel)
+	})
+}
+
+// 移除本代理的 Via 之后通过服务端事务转发响应 RFC 3261 - 16.7 第 9 步
+func (ctx *proxyContext) forwardResponse(res sip.Response) {
+	forward := sip.CopyResponse(res)
+	hops := viaHops(forward)
+	if len(hops) < 2 {
+		logger.Warnf("[G.SIP] -> drop %s without next Via", res.Short())
+		return
+	}
+	forward.DelHeader("Via")
+	forward.PrependHeader(sip.ViaHeader(hops[1:]))
+	// 响应发送到请求的来源地址 RFC 3261 - 18.2.2
+	forward.SetDestination(ctx.request.Source())
+
+	ctx.send(forward)
+}
+
+// 服务端事务已经结束时(例如 Accepted 状态之后分叉的 2xx)由传输层直接发送 RFC 3261 - 16.7 第 10 步
+func (ctx *proxyContext) send(res sip.Response) {
+	select {
+	case <-ctx.tx.Done():
+		if err := ctx.service.opts.tp.Send(res); err != nil {
+			logger.Errorf("[G.SIP] -> forward %s failed: %s", res.Short(), err)
+		}
+		return
+	default:
+	}
+
+	if err := ctx.tx.SendResponse(res); err != nil {
+		logger.Errorf("[G.SIP] -> forward %s failed: %s", res.Short(), err)
+	}
+}
+
+// 请求的 Max-Forwards
+func requestMaxForwards(req sip.Request) (sip.MaxForwards, bool) {
+	for _, hdr := range req.GetHeaders("Max-Forwards") {
+		if maxForwards, ok := hdr.(*sip.MaxForwards); ok {
+			return *maxForwards, true
+		}
+	}
+
+	return 0, false
+}
+
+// 请求的 Route 集合
+func requestRoutes(req sip.Request) []sip.Uri {
+	routes := make([]sip.Uri, 0)
+	for _, hdr := range req.GetHeaders("Route") {
+		if route, ok := hdr.(*sip.RouteHeader); ok {
+			for _, uri := range route.Addresses {
+				routes = append(routes, uri.Copy())
+			}
+		}
+	}
+
+	return routes
+}
+
+func setRoutes(req sip.Request, routes []sip.Uri) {
+	req.DelHeader("Route")
+	if len(routes) > 0 {
+		req.AddHeader(&sip.RouteHeader{Addresses: routes})
+	}
+}
+
+// 需要添加 Record-Route 的请求：没有 To tag 的 INVITE、SUBSCRIBE、REFER 以及 NOTIFY
+func createsDialog(req sip.Request) bool {
+	switch req.Method() {
+	case sip.INVITE, sip.SUBSCRIBE, sip.REFER, sip.NOTIFY:
+	default:
+		return false
+	}
+	if to := req.To(); to != nil {
+		if _, ok := to.Params.Get("tag"); ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CANCEL 与原请求的第一个 Via 相同
+func proxyKey(req sip.Request) string {
+	hop, ok := req.ViaHop()
+	if !ok {
+		return ""
+	}
+
+	return viaKey(hop)
+}
+
+// 消息的所有 Via，多个 Via 头部按顺序合并
+func viaHops(msg sip.Message) []*sip.ViaHop {
+	hops := make([]*sip.ViaHop, 0)
+	for _, hdr := range msg.GetHeaders("Via") {
+		if via, ok := hdr.(sip.ViaHeader); ok {
+			hops = append(hops, via...)
+		}
+	}
+
+	return hops
+}
+
+// 请求的第一个 Via，没有 Via 时为 nil
+func topVia(req sip.Request) *sip.ViaHop {
+	if hop, ok := req.ViaHop(); ok {
+		return hop
+	}
+
+	return nil
+}
+
+// Via 的 branch 以及 sent-by
+func viaKey(hop *sip.ViaHop) string {
+	var branch sip.MaybeString
+	if hop.Params != nil {
+		branch, _ = hop.Params.Get("branch")
+	}
+	if branch == nil {
+		return hop.SentBy()
+	}
+
+	return branch.String() + "|" + hop.SentBy()
+}
+
+// URI 是否指向本代理：本地 IP 或者配置的域
+func (s *service) isLocalUri(uri sip.Uri) bool {
+	sipUri, ok := uri.(*sip.SipUri)
+	if !ok {
+		return false
+	}
+	host := sipUri.FDomain.Host
+	if host == s.opts.tp.LocalIP().String() {
+		return true
+	}
+	for _, domain := range s.opts.proxy.domains {
+		if strings.EqualFold(host, domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 本代理添加的 Record-Route：没有用户名并且带 lr 参数
+func (s *service) isRecordRoute(uri sip.Uri) bool {
+	if !s.isLocalUri(uri) || (uri.User() != nil && uri.User().String() != "") {
+		return false
+	}
+
+	return uri.UriParams() != nil && uri.UriParams().Has("lr")
+}
+
+func (s *service) recordRouteUri() sip.Uri {
+	params := sip.NewParams()
+	params.Add("lr", nil)
+
+	return &sip.SipUri{
+		FDomain:    sip.Addr{Host: s.opts.tp.LocalIP().String()},
+		FUriParams: params,
+		FHeaders:   sip.NewParams(),
+	}
+}
@@ -0,0 +1,571 @@
+package gsip
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/callback"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 测试使用的服务：使用单独的回调函数，监听 ip 的 UDP 5060 端口
+func newTestService(t *testing.T, ip string, opts ...Option) (Service, callback.Callback) {
+	cb := callback.NewCallback()
+	opts = append([]Option{Transport(ip), func(o *Options) { o.Callback = cb }}, opts...)
+	s := NewService(opts...)
+	if err := s.Listen("udp", ip+":5060"); err != nil {
+		t.Fatalf("listen %s failed: %s", ip, err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	return s, cb
+}
+
+func testUri(user string, host string) sip.Uri {
+	return &sip.SipUri{
+		FUser:      sip.String{Str: user},
+		FDomain:    sip.Addr{Host: host},
+		FUriParams: sip.NewParams(),
+		FHeaders:   sip.NewParams(),
+	}
+}
+
+// 配置了认证的代理以 407 质询未认证的请求，不转发
+func TestProxyAuthenticate(t *testing.T) {
+	var forwarded int32
+	_, uasCallback := newTestService(t, "127.0.1.3")
+	uasCallback.AddRequestHandle(sip.INVITE, func(req sip.Request, tx sip.ServerTransaction) {
+		atomic.AddInt32(&forwarded, 1)
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusBusyHere))
+	})
+
+	router := RouterFunc(func(req sip.Request) ([]sip.Uri, error) {
+		return []sip.Uri{testUri("bob", "127.0.1.3")}, nil
+	})
+	authenticator := sip.NewDigestAuthenticator("gsip", sip.PasswordCredentials{"alice": "secret"})
+	newTestService(t, "127.0.1.2", ProxyConfig(router), AuthenticatorConfig(authenticator, sip.INVITE))
+	uac, _ := newTestService(t, "127.0.1.1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	invite := uac.CreateRequest(sip.INVITE, "127.0.1.2:5060", testUri("alice", "127.0.1.1"), testUri("bob", "127.0.1.2"))
+	res, err := uac.Request(ctx, invite)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	if res.StatusCode() != sip.StatusProxyAuthenticationRequired {
+		t.Fatalf("status = %d, want 407", res.StatusCode())
+	}
+	if len(res.GetHeaders("Proxy-Authenticate")) == 0 {
+		t.Error("407 without Proxy-Authenticate")
+	}
+	if n := atomic.LoadInt32(&forwarded); n != 0 {
+		t.Errorf("unauthenticated INVITE forwarded %d times", n)
+	}
+
+	invite = uac.CreateRequest(sip.INVITE, "127.0.1.2:5060", testUri("alice", "127.0.1.1"), testUri("bob", "127.0.1.2"))
+	res, err = uac.Request(ctx, invite, RequestAuth(&sip.DefaultAuthorized{
+		User:     sip.String{Str: "alice"},
+		Password: sip.String{Str: "secret"},
+	}))
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	if res.StatusCode() != sip.StatusBusyHere {
+		t.Fatalf("status = %d, want 486", res.StatusCode())
+	}
+	if n := atomic.LoadInt32(&forwarded); n != 1 {
+		t.Errorf("authenticated INVITE forwarded %d times, want 1", n)
+	}
+}
+
+// 代理 ip 使用 router 转发，返回代理服务
+func newTestProxy(t *testing.T, ip string, router RouterFunc, opts ...ProxyOption) *service {
+	s, _ := newTestService(t, ip, ProxyConfig(router, opts...))
+	return s.(*service)
+}
+
+// 只有 Max-Forwards 不同的请求，为 0 时以 483 拒绝
+func TestProxyTooManyHops(t *testing.T) {
+	var forwarded int32
+	_, uasCallback := newTestService(t, "127.0.5.3")
+	uasCallback.AddRequestHandle(sip.MESSAGE, func(req sip.Request, tx sip.ServerTransaction) {
+		atomic.AddInt32(&forwarded, 1)
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusOK))
+	})
+	newTestProxy(t, "127.0.5.2", func(req sip.Request) ([]sip.Uri, error) {
+		return []sip.Uri{testUri("bob", "127.0.5.3")}, nil
+	})
+	uac, _ := newTestService(t, "127.0.5.1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, test := range []struct {
+		maxForwards sip.MaxForwards
+		status      sip.StatusCode
+	}{
+		{maxForwards: 0, status: sip.StatusTooManyHops},
+		{maxForwards: 1, status: sip.StatusOK},
+	} {
+		req := uac.CreateRequest(sip.MESSAGE, "127.0.5.2:5060", testUri("alice", "127.0.5.1"), testUri("bob", "127.0.5.2"))
+		maxForwards := test.maxForwards
+		req.ReplaceHeader(&maxForwards)
+		res, err := uac.Request(ctx, req)
+		if err != nil {
+			t.Fatalf("request failed: %s", err)
+		}
+		if res.StatusCode() != test.status {
+			t.Errorf("Max-Forwards %d: status = %d, want %d", test.maxForwards, res.StatusCode(), test.status)
+		}
+	}
+	if n := atomic.LoadInt32(&forwarded); n != 1 {
+		t.Errorf("forwarded %d times, want 1", n)
+	}
+}
+
+// 转发给自身并且 Request-URI 不变时为环路，返回 482；Request-URI 变化时为螺旋，继续转发
+func TestProxyLoop(t *testing.T) {
+	received := make(chan sip.Request, 1)
+	_, uasCallback := newTestService(t, "127.0.5.13")
+	uasCallback.AddRequestHandle(sip.MESSAGE, func(req sip.Request, tx sip.ServerTransaction) {
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusOK))
+		received <- req
+	})
+	newTestProxy(t, "127.0.5.12", func(req sip.Request) ([]sip.Uri, error) {
+		switch req.Recipient().User().String() {
+		case "carol":
+			return []sip.Uri{testUri("dave", "127.0.5.12")}, nil
+		case "dave":
+			return []sip.Uri{testUri("dave", "127.0.5.13")}, nil
+		default:
+			return []sip.Uri{req.Recipient().Copy()}, nil
+		}
+	})
+	uac, _ := newTestService(t, "127.0.5.11")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req := uac.CreateRequest(sip.MESSAGE, "127.0.5.12:5060", testUri("alice", "127.0.5.11"), testUri("bob", "127.0.5.12"))
+	res, err := uac.Request(ctx, req)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	if res.StatusCode() != sip.StatusLoopDetected {
+		t.Errorf("loop: status = %d, want 482", res.StatusCode())
+	}
+
+	req = uac.CreateRequest(sip.MESSAGE, "127.0.5.12:5060", testUri("alice", "127.0.5.11"), testUri("carol", "127.0.5.12"))
+	res, err = uac.Request(ctx, req)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	if res.StatusCode() != sip.StatusOK {
+		t.Fatalf("spiral: status = %d, want 200", res.StatusCode())
+	}
+	select {
+	case forwarded := <-received:
+		if hops := viaHops(forwarded); len(hops) != 3 {
+			t.Errorf("spiral forwarded with %d Via, want 3", len(hops))
+		}
+	default:
+		t.Error("spiral not forwarded")
+	}
+}
+
+func TestProxyForward(t *testing.T) {
+	s := newTestProxy(t, "127.0.5.21", func(req sip.Request) ([]sip.Uri, error) {
+		return nil, nil
+	})
+	target := testUri("bob", "192.0.2.3")
+	route := func(uri string) sip.Uri {
+		parsed, err := sip.ParseUri(uri)
+		if err != nil {
+			t.Fatalf("parse %s failed: %s", uri, err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name        string
+		method      sip.RequestMethod
+		routes      []string
+		recordRoute bool
+		recipient   string
+		wantRoutes  []string
+		wantRecord  bool
+	}{
+		{
+			name:        "record route",
+			method:      sip.INVITE,
+			recordRoute: true,
+			recipient:   target.String(),
+			wantRecord:  true,
+		},
+		{
+			name:        "no record route for non dialog request",
+			method:      sip.MESSAGE,
+			recordRoute: true,
+			recipient:   target.String(),
+		},
+		{
+			name:       "loose route",
+			method:     sip.INVITE,
+			routes:     []string{"sip:192.0.2.4;lr", "sip:192.0.2.5;lr"},
+			recipient:  target.String(),
+			wantRoutes: []string{"sip:192.0.2.4;lr", "sip:192.0.2.5;lr"},
+		},
+		{
+			name:       "strict route",
+			method:     sip.INVITE,
+			routes:     []string{"sip:192.0.2.4", "sip:192.0.2.5;lr"},
+			recipient:  "sip:192.0.2.4",
+			wantRoutes: []string{"sip:192.0.2.5;lr", target.String()},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			template := sip.CreateRequest(test.method, "127.0.5.21:5060", testUri("alice", "192.0.2.2"), testUri("bob", "127.0.5.21"))
+			routes := make([]sip.Uri, 0)
+			for _, uri := range test.routes {
+				routes = append(routes, route(uri))
+			}
+			setRoutes(template, routes)
+
+			req := s.proxyForward(template, target, "z9hG4bKtest", test.recordRoute)
+			if req.Recipient().String() != test.recipient {
+				t.Errorf("Request-URI = %s, want %s", req.Recipient(), test.recipient)
+			}
+			got := make([]string, 0)
+			for _, uri := range requestRoutes(req) {
+				got = append(got, uri.String())
+			}
+			if strings.Join(got, ",") != strings.Join(test.wantRoutes, ",") {
+				t.Errorf("Route = %v, want %v", got, test.wantRoutes)
+			}
+			if records := req.GetHeaders("Record-Route"); (len(records) > 0) != test.wantRecord {
+				t.Errorf("Record-Route = %v, want %t", records, test.wantRecord)
+			} else if test.wantRecord && !s.isRecordRoute(records[0].(*sip.RecordRouteHeader).Addresses[0]) {
+				t.Errorf("Record-Route %s is not the proxy", records[0])
+			}
+			if maxForwards, _ := requestMaxForwards(req); maxForwards != *sip.DefaultMaxForwards()-1 {
+				t.Errorf("Max-Forwards = %d, want %d", maxForwards, *sip.DefaultMaxForwards()-1)
+			}
+			if hop, _ := req.ViaHop(); !s.isLocalSentBy(hop) || hop.Params == nil || !hop.Params.Has("branch") {
+				t.Errorf("top Via = %s", hop)
+			}
+		})
+	}
+}
+
+// 第一个 Route 为本代理时移除；严格路由的上游将本代理的 Record-Route 作为 Request-URI 时使用最后一个 Route 恢复
+func TestProxyPreprocessRoute(t *testing.T) {
+	s := newTestProxy(t, "127.0.5.22", func(req sip.Request) ([]sip.Uri, error) {
+		return nil, nil
+	})
+
+	tests := []struct {
+		name       string
+		recipient  string
+		routes     []string
+		wantUri    string
+		wantRoutes string
+	}{
+		{
+			name:       "loose route",
+			recipient:  "sip:bob@192.0.2.3",
+			routes:     []string{"sip:127.0.5.22;lr", "sip:192.0.2.4;lr"},
+			wantUri:    "sip:bob@192.0.2.3",
+			wantRoutes: "sip:192.0.2.4;lr",
+		},
+		{
+			name:       "strict upstream",
+			recipient:  "sip:127.0.5.22;lr",
+			routes:     []string{"sip:192.0.2.4;lr", "sip:bob@192.0.2.3"},
+			wantUri:    "sip:bob@192.0.2.3",
+			wantRoutes: "sip:192.0.2.4;lr",
+		},
+		{
+			name:       "other route",
+			recipient:  "sip:bob@192.0.2.3",
+			routes:     []string{"sip:192.0.2.4;lr"},
+			wantUri:    "sip:bob@192.0.2.3",
+			wantRoutes: "sip:192.0.2.4;lr",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recipient, err := sip.ParseUri(test.recipient)
+			if err != nil {
+				t.Fatalf("parse %s failed: %s", test.recipient, err)
+			}
+			req := sip.CreateRequest(sip.BYE, "127.0.5.22:5060", testUri("alice", "192.0.2.2"), testUri("bob", "192.0.2.3"))
+			req.SetRecipient(recipient)
+			routes := make([]sip.Uri, 0)
+			for _, uri := range test.routes {
+				route, err := sip.ParseUri(uri)
+				if err != nil {
+					t.Fatalf("parse %s failed: %s", uri, err)
+				}
+				routes = append(routes, route)
+			}
+			setRoutes(req, routes)
+
+			s.preprocessRoute(req)
+			if req.Recipient().String() != test.wantUri {
+				t.Errorf("Request-URI = %s, want %s", req.Recipient(), test.wantUri)
+			}
+			got := make([]string, 0)
+			for _, uri := range requestRoutes(req) {
+				got = append(got, uri.String())
+			}
+			if strings.Join(got, ",") != test.wantRoutes {
+				t.Errorf("Route = %v, want %s", got, test.wantRoutes)
+			}
+		})
+	}
+}
+
+// 并行分叉：2xx 转发给 UAC 并取消其他分支，被叫方收到的 INVITE 携带代理的 Record-Route
+func TestProxyParallelFork(t *testing.T) {
+	ringing := make(chan sip.ServerTransaction, 1)
+	canceled := make(chan sip.Request, 1)
+	_, ringingCallback := newTestService(t, "127.0.5.33")
+	ringingCallback.AddRequestHandle(sip.INVITE, func(req sip.Request, tx sip.ServerTransaction) {
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusRinging))
+		ringing <- tx
+	})
+	ringingCallback.AddRequestHandle(sip.CANCEL, func(req sip.Request, tx sip.ServerTransaction) {
+		canceled <- req
+	})
+	answered := make(chan sip.Request, 1)
+	_, answerCallback := newTestService(t, "127.0.5.34")
+	answerCallback.AddRequestHandle(sip.INVITE, func(req sip.Request, tx sip.ServerTransaction) {
+		answered <- req
+		time.Sleep(100 * time.Millisecond)
+		res := req.CreateResponse(sip.StatusOK)
+		res.AddHeader(&sip.ContactHeader{Address: testUri("bob", "127.0.5.34"), Params: sip.NewParams()})
+		_ = tx.SendResponse(res)
+	})
+	answerCallback.AddRequestHandle(sip.ACK, func(req sip.Request, tx sip.ServerTransaction) {})
+	proxy := newTestProxy(t, "127.0.5.32", func(req sip.Request) ([]sip.Uri, error) {
+		return []sip.Uri{testUri("bob", "127.0.5.33"), testUri("bob", "127.0.5.34")}, nil
+	})
+	uac, _ := newTestService(t, "127.0.5.31")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	invite := uac.CreateRequest(sip.INVITE, "127.0.5.32:5060", testUri("alice", "127.0.5.31"), testUri("bob", "127.0.5.32"))
+	res, err := uac.Request(ctx, invite)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	if res.StatusCode() != sip.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode())
+	}
+
+	req := <-answered
+	records := req.GetHeaders("Record-Route")
+	if len(records) == 0 || !proxy.isRecordRoute(records[0].(*sip.RecordRouteHeader).Addresses[0]) {
+		t.Errorf("Record-Route = %v, want the proxy", records)
+	}
+	select {
+	case <-canceled:
+		tx := <-ringing
+		_ = tx.SendResponse(tx.Origin().CreateResponse(sip.StatusRequestTerminated))
+	case <-time.After(5 * time.Second):
+		t.Fatal("ringing branch not canceled")
+	}
+}
+
+// 顺序分叉：第一个目标超时之后取消，再转发到下一个目标
+func TestProxySequentialFork(t *testing.T) {
+	events := make(chan string, 4)
+	_, firstCallback := newTestService(t, "127.0.5.43")
+	first := make(chan sip.ServerTransaction, 1)
+	firstCallback.AddRequestHandle(sip.INVITE, func(req sip.Request, tx sip.ServerTransaction) {
+		events <- "invite first"
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusRinging))
+		first <- tx
+	})
+	firstCallback.AddRequestHandle(sip.CANCEL, func(req sip.Request, tx sip.ServerTransaction) {
+		events <- "cancel first"
+		invite := <-first
+		_ = invite.SendResponse(invite.Origin().CreateResponse(sip.StatusRequestTerminated))
+	})
+	_, secondCallback := newTestService(t, "127.0.5.44")
+	secondCallback.AddRequestHandle(sip.INVITE, func(req sip.Request, tx sip.ServerTransaction) {
+		events <- "invite second"
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusBusyHere))
+	})
+	newTestProxy(t, "127.0.5.42", func(req sip.Request) ([]sip.Uri, error) {
+		return []sip.Uri{testUri("bob", "127.0.5.43"), testUri("bob", "127.0.5.44")}, nil
+	}, ProxyFork(ForkSequential, 300*time.Millisecond))
+	uac, _ := newTestService(t, "127.0.5.41")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	invite := uac.CreateRequest(sip.INVITE, "127.0.5.42:5060", testUri("alice", "127.0.5.41"), testUri("bob", "127.0.5.42"))
+	res, err := uac.Request(ctx, invite)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	// 487 与 486 同为 4xx，先收到的 487 为最佳响应
+	if res.StatusCode() != sip.StatusRequestTerminated && res.StatusCode() != sip.StatusBusyHere {
+		t.Errorf("status = %d, want 4xx", res.StatusCode())
+	}
+
+	want := []string{"invite first", "cancel first", "invite second"}
+	for _, event := range want {
+		select {
+		case got := <-events:
+			if got != event {
+				t.Fatalf("event %q, want %q", got, event)
+			}
+		default:
+			t.Fatalf("event %q not happened", event)
+		}
+	}
+}
+
+func TestResponseRank(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes []sip.StatusCode
+		best  sip.StatusCode
+	}{
+		{name: "lowest class", codes: []sip.StatusCode{503, 486, 302}, best: 302},
+		{name: "6xx", codes: []sip.StatusCode{302, 603, 486}, best: 603},
+		{name: "challenge", codes: []sip.StatusCode{486, 407, 404}, best: 407},
+		{name: "preferred 4xx", codes: []sip.StatusCode{404, 415}, best: 415},
+		{name: "first of same rank", codes: []sip.StatusCode{486, 404}, best: 486},
+		{name: "5xx", codes: []sip.StatusCode{503, 500}, best: 503},
+	}
+
+	req := sip.CreateRequest(sip.INVITE, "192.0.2.2:5060", testUri("alice", "192.0.2.1"), testUri("bob", "192.0.2.2"))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := &proxyContext{}
+			for _, code := range test.codes {
+				ctx.collect(req.CreateResponse(code))
+			}
+			if ctx.best.StatusCode() != test.best {
+				t.Errorf("best = %d, want %d", ctx.best.StatusCode(), test.best)
+			}
+		})
+	}
+}
+
+// 所有分支结束之后转发最佳响应：503 转换为 500，401/407 合并所有分支的质询
+func TestProxyForwardBest(t *testing.T) {
+	challenge := func(status sip.StatusCode, name string, realm string) func(req sip.Request) sip.Response {
+		return func(req sip.Request) sip.Response {
+			res := req.CreateResponse(status)
+			res.AddHeader(&sip.GenericHeader{HeaderName: name, Contents: fmt.Sprintf(`Digest realm="%s", nonce="%s"`, realm, realm)})
+			return res
+		}
+	}
+	status := func(status sip.StatusCode) func(req sip.Request) sip.Response {
+		return func(req sip.Request) sip.Response {
+			return req.CreateResponse(status)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		responses []func(req sip.Request) sip.Response
+		status    sip.StatusCode
+		realms    []string
+	}{
+		{
+			name:      "service unavailable",
+			responses: []func(req sip.Request) sip.Response{status(503), status(503)},
+			status:    sip.StatusServerInternalError,
+		},
+		{
+			name:      "merge challenges",
+			responses: []func(req sip.Request) sip.Response{challenge(401, "WWW-Authenticate", "a"), challenge(401, "WWW-Authenticate", "b")},
+			status:    sip.StatusUnauthorized,
+			realms:    []string{`realm="a"`, `realm="b"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targets := make([]sip.Uri, 0)
+			for i, respond := range test.responses {
+				ip := fmt.Sprintf("127.0.5.%d", 53+i)
+				respond := respond
+				_, uasCallback := newTestService(t, ip)
+				uasCallback.AddRequestHandle(sip.MESSAGE, func(req sip.Request, tx sip.ServerTransaction) {
+					_ = tx.SendResponse(respond(req))
+				})
+				targets = append(targets, testUri("bob", ip))
+			}
+			newTestProxy(t, "127.0.5.52", func(req sip.Request) ([]sip.Uri, error) {
+				return targets, nil
+			})
+			uac, _ := newTestService(t, "127.0.5.51")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			req := uac.CreateRequest(sip.MESSAGE, "127.0.5.52:5060", testUri("alice", "127.0.5.51"), testUri("bob", "127.0.5.52"))
+			res, err := uac.Request(ctx, req)
+			if err != nil {
+				t.Fatalf("request failed: %s", err)
+			}
+			if res.StatusCode() != test.status {
+				t.Errorf("status = %d, want %d", res.StatusCode(), test.status)
+			}
+			if challenges := res.GetHeaders("WWW-Authenticate"); len(challenges) != len(test.realms) {
+				t.Errorf("%d challenges, want %d", len(challenges), len(test.realms))
+			}
+			for _, realm := range test.realms {
+				if !strings.Contains(res.String(), realm) {
+					t.Errorf("challenge of %s not forwarded", realm)
+				}
+			}
+		})
+	}
+}
+
+// 查询目标期间收到 CANCEL 时不转发，以 487 响应
+func TestProxyEarlyCancel(t *testing.T) {
+	var forwarded int32
+	_, uasCallback := newTestService(t, "127.0.5.63")
+	uasCallback.AddRequestHandle(sip.INVITE, func(req sip.Request, tx sip.ServerTransaction) {
+		atomic.AddInt32(&forwarded, 1)
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusBusyHere))
+	})
+	newTestProxy(t, "127.0.5.62", func(req sip.Request) ([]sip.Uri, error) {
+		// 100 Trying 之后 UAC 才能发送 CANCEL
+		time.Sleep(time.Second)
+		return []sip.Uri{testUri("bob", "127.0.5.63")}, nil
+	})
+	uac, _ := newTestService(t, "127.0.5.61")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	invite := uac.CreateRequest(sip.INVITE, "127.0.5.62:5060", testUri("alice", "127.0.5.61"), testUri("bob", "127.0.5.62"))
+	res, err := uac.Request(ctx, invite)
+	if res == nil || res.StatusCode() != sip.StatusRequestTerminated {
+		t.Fatalf("response = %v, error = %v, want 487", res, err)
+	}
+	if n := atomic.LoadInt32(&forwarded); n != 0 {
+		t.Errorf("canceled INVITE forwarded %d times", n)
+	}
+}
@@ -0,0 +1,436 @@
+package gsip
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+	"github.com/zenghr0820/gsip/utils"
+)
+
+// 注册默认配置
+const (
+	// 请求的有效期(秒)
+	DefaultRegisterExpires uint32 = 3600
+	// 失败重试的最小、最大间隔
+	DefaultRegisterRetryMin = 5 * time.Second
+	DefaultRegisterRetryMax = 5 * time.Minute
+	// 注销的超时时间
+	unregisterTimeout = 5 * time.Second
+)
+
+// 注册状态
+type RegisterState int
+
+const (
+	RegisterStateNone RegisterState = iota
+	// 注册成功或者刷新成功
+	RegisterStateRegistered
+	// 注册或者刷新失败，稍后重试
+	RegisterStateFailed
+	// 刷新失败且已超过服务器允许的有效期
+	RegisterStateExpired
+	// 已注销
+	RegisterStateUnregistered
+)
+
+func (state RegisterState) String() string {
+	switch state {
+	case RegisterStateRegistered:
+		return "Registered"
+	case RegisterStateFailed:
+		return "Failed"
+	case RegisterStateExpired:
+		return "Expired"
+	case RegisterStateUnregistered:
+		return "Unregistered"
+	default:
+		return "None"
+	}
+}
+
+// 注册状态变化事件
+type RegisterEvent struct {
+	State RegisterState
+	// 服务器允许的有效期(秒)
+	Expires uint32
+	// 最后一次 REGISTER 的最终响应，可能为 nil
+	Response sip.Response
+	Err      error
+}
+
+// 注册的配置选项
+type RegisterOptions struct {
+	expires  uint32
+	contact  sip.Uri
+	auth     sip.Authorized
+	handler  func(event RegisterEvent)
+	retryMin time.Duration
+	retryMax time.Duration
+}
+
+type RegisterOption func(*RegisterOptions)
+
+func newRegisterOptions(opts ...RegisterOption) RegisterOptions {
+	opt := RegisterOptions{
+		expires:  DefaultRegisterExpires,
+		retryMin: DefaultRegisterRetryMin,
+		retryMax: DefaultRegisterRetryMax,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
+// 请求的有效期(秒)，服务器可能返回更小的值
+func RegisterExpires(expires uint32) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.expires = expires
+	}
+}
+
+// 注册的联系地址，默认为 AOR 用户名@本地 IP
+func RegisterContact(contact sip.Uri) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.contact = contact
+	}
+}
+
+// 注册使用的认证信息，覆盖 AuthConfig 的配置
+func RegisterAuth(auth sip.Authorized) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.auth = auth
+	}
+}
+
+// 注册状态变化的回调函数，也可以通过 Registration.Events 接收
+func RegisterCallback(handler func(event RegisterEvent)) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.handler = handler
+	}
+}
+
+// 失败重试的间隔，每次失败后加倍直到最大值
+func RegisterRetry(min time.Duration, max time.Duration) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.retryMin = min
+		o.retryMax = max
+	}
+}
+
+// 向注册服务器注册 RFC 3261 - 10.2，在有效期到达之前自动刷新
+type Registration struct {
+	service   *service
+	registrar string
+	aor       sip.Uri
+	contact   sip.Uri
+	opts      RegisterOptions
+	// RFC 3261 - 10.2.4 刷新使用相同的 Call-ID，CSeq 递增
+	callID  sip.CallID
+	fromTag string
+	cseq    uint32
+	// 请求的有效期，收到 423 时更新为 Min-Expires
+	expires uint32
+	// 服务器允许的有效期到达的时间
+	expiresAt time.Time
+	state     RegisterState
+
+	events chan RegisterEvent
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.RWMutex
+}
+
+func newRegistration(s *service, registrar string, aor sip.Uri, opts ...RegisterOption) *Registration {
+	r := &Registration{
+		service:   s,
+		registrar: registrar,
+		aor:       aor,
+		opts:      newRegisterOptions(opts...),
+		callID:    *sip.DefaultCallID(),
+		fromTag:   utils.RandString(10, true),
+		events:    make(chan RegisterEvent, 16),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	r.expires = r.opts.expires
+	r.contact = r.opts.contact
+	if r.contact == nil {
+		contact := aor.Copy()
+		contact.SetDomain(sip.Addr{Host: s.opts.tp.LocalIP().String()})
+		contact.SetUriParams(sip.NewParams())
+		r.contact = contact
+	}
+
+	return r
+}
+
+// 注册状态变化事件，未及时接收的事件会被丢弃
+func (r *Registration) Events() <-chan RegisterEvent {
+	return r.events
+}
+
+// 当前注册状态
+func (r *Registration) State() RegisterState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.state
+}
+
+// 停止刷新并注销(Expires: 0)，等待进行中的注册以及注销直到 ctx 结束
+func (r *Registration) Unregister(ctx context.Context) error {
+	stopped := false
+	r.once.Do(func() {
+		close(r.stop)
+		stopped = true
+	})
+	if stopped {
+		defer r.service.removeRegistration(r)
+	}
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if !stopped {
+		return nil
+	}
+
+	// 服务器上没有未过期的绑定时无需注销
+	r.mu.RLock()
+	bound := !r.expiresAt.IsZero() && time.Now().Before(r.expiresAt)
+	r.mu.RUnlock()
+	if !bound {
+		r.setState(RegisterStateUnregistered, 0, nil, nil)
+		return nil
+	}
+
+	res, err := r.register(ctx, 0)
+	r.setState(RegisterStateUnregistered, 0, res, err)
+
+	return err
+}
+
+// 注册以及刷新
+func (r *Registration) run() {
+	defer close(r.done)
+
+	retry := r.opts.retryMin
+	// 单次 REGISTER 的超时时间为事务层配置的 Timer F(64*T1)
+	timeout := 64 * r.service.opts.tx.T1()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		// 停止时取消进行中的注册，注销不必等待 Timer F
+		go func() {
+			select {
+			case <-r.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		res, err := r.register(ctx, r.expires)
+		cancel()
+		if err != nil && r.stopped() {
+			return
+		}
+
+		// 423 Interval Too Brief 使用 Min-Expires 立即重试
+		if err == nil && res.StatusCode() == sip.StatusIntervalTooBrief {
+			if value, ok := minExpires(res); ok && value > r.expires {
+				r.expires = value
+				continue
+			}
+		}
+
+		var wait time.Duration
+		if err == nil && res.IsSuccess() {
+			expires := r.grantedExpires(res)
+			// 有效期为 0 时服务器没有保留绑定，视为已注销并停止刷新
+			if expires == 0 {
+				r.stopUnregistered(res)
+				return
+			}
+			r.mu.Lock()
+			r.expiresAt = time.Now().Add(time.Duration(expires) * time.Second)
+			r.mu.Unlock()
+			r.setState(RegisterStateRegistered, expires, res, nil)
+
+			retry = r.opts.retryMin
+			wait = refreshInterval(expires)
+		} else {
+			if err == nil {
+				err = fmt.Errorf("[G.SIP] -> register %s failed: %d %s", r.aor, res.StatusCode(), res.Reason())
+			}
+			logger.Warn(err)
+			r.setState(RegisterStateFailed, 0, res, err)
+
+			wait = retry
+			if retry *= 2; retry > r.opts.retryMax {
+				retry = r.opts.retryMax
+			}
+		}
+
+		if !r.wait(wait, err) {
+			return
+		}
+	}
+}
+
+func (r *Registration) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// 服务器没有保留绑定时停止刷新，之后的 Unregister 不再发送注销请求
+func (r *Registration) stopUnregistered(res sip.Response) {
+	logger.Warnf("[G.SIP] -> registrar granted %s expires 0, stop refreshing", r.aor)
+	r.once.Do(func() {
+		close(r.stop)
+	})
+	r.mu.Lock()
+	r.expiresAt = time.Time{}
+	r.mu.Unlock()
+	r.setState(RegisterStateUnregistered, 0, res, nil)
+	r.service.removeRegistration(r)
+}
+
+// 等待下一次注册，刷新失败且超过有效期时通知过期，停止时返回 false
+func (r *Registration) wait(wait time.Duration, err error) bool {
+	var expired <-chan time.Time
+	r.mu.RLock()
+	if r.state == RegisterStateFailed && !r.expiresAt.IsZero() {
+		expiredTimer := time.NewTimer(time.Until(r.expiresAt))
+		defer expiredTimer.Stop()
+		expired = expiredTimer.C
+	}
+	r.mu.RUnlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return false
+		case <-expired:
+			expired = nil
+			r.mu.Lock()
+			r.expiresAt = time.Time{}
+			r.mu.Unlock()
+			r.setState(RegisterStateExpired, 0, nil, err)
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// 发送一次 REGISTER，expires 为 0 时注销
+func (r *Registration) register(ctx context.Context, expires uint32) (sip.Response, error) {
+	r.mu.Lock()
+	r.cseq++
+	cseq := r.cseq
+	r.mu.Unlock()
+
+	req := r.service.CreateRequest(sip.REGISTER, r.registrar, r.aor, r.aor)
+	req.From().Params.Add("tag", sip.String{Str: r.fromTag})
+	callID := r.callID
+	req.ReplaceHeader(&callID)
+	req.CSeq().SeqNo = cseq
+	req.CSeq().MethodName = sip.REGISTER
+	req.AddHeader(&sip.ContactHeader{
+		Address: r.contact.Copy(),
+		Params:  sip.NewParams(),
+	})
+	expiresHeader := sip.Expires(expires)
+	req.AddHeader(&expiresHeader)
+
+	opts := make([]RequestOption, 0, 1)
+	if r.opts.auth != nil {
+		opts = append(opts, RequestAuth(r.opts.auth))
+	}
+	res, err := r.service.Request(ctx, req, opts...)
+
+	// 认证重发的请求的 CSeq 会递增，按最终响应的 CSeq 更新
+	if res != nil {
+		r.mu.Lock()
+		if seq := res.CSeq(); seq != nil && seq.SeqNo > r.cseq {
+			r.cseq = seq.SeqNo
+		}
+		r.mu.Unlock()
+	}
+
+	return res, err
+}
+
+// 服务器允许的有效期：匹配的 Contact 的 expires 参数优先，其次是 Expires 头部 RFC 3261 - 10.2.4
+func (r *Registration) grantedExpires(res sip.Response) uint32 {
+	for _, hdr := range res.GetHeaders("Contact") {
+		contact, ok := hdr.(*sip.ContactHeader)
+		if !ok || contact.Params == nil || !contact.Address.Equals(r.contact) {
+			continue
+		}
+		if value, ok := contact.Params.Get("expires"); ok && value != nil {
+			if expires, err := strconv.ParseUint(value.String(), 10, 32); err == nil {
+				return uint32(expires)
+			}
+		}
+	}
+	if expires := res.Expires(); expires != nil {
+		return uint32(*expires)
+	}
+
+	return r.expires
+}
+
+func (r *Registration) setState(state RegisterState, expires uint32, res sip.Response, err error) {
+	r.mu.Lock()
+	r.state = state
+	r.mu.Unlock()
+
+	event := RegisterEvent{
+		State:    state,
+		Expires:  expires,
+		Response: res,
+		Err:      err,
+	}
+	select {
+	case r.events <- event:
+	default:
+	}
+	if r.opts.handler != nil {
+		r.opts.handler(event)
+	}
+}
+
+// 在有效期到达之前刷新：有效期较短时提前一半，否则提前 30 秒
+func refreshInterval(expires uint32) time.Duration {
+	interval := time.Duration(expires) * time.Second
+	if interval <= time.Minute {
+		return interval / 2
+	}
+
+	return interval - 30*time.Second
+}
+
+func minExpires(msg sip.Message) (uint32, bool) {
+	for _, hdr := range msg.GetHeaders("Min-Expires") {
+		if header, ok := hdr.(*sip.MinExpires); ok {
+			return uint32(*header), true
+		}
+	}
+
+	return 0, false
+}
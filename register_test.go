@@ -0,0 +1,104 @@
+package gsip
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 服务器返回的有效期为 0 时视为已注销，不再刷新
+func TestRegisterGrantedZeroExpires(t *testing.T) {
+	var registers int32
+	_, registrarCallback := newTestService(t, "127.0.2.7")
+	registrarCallback.AddRequestHandle(sip.REGISTER, func(req sip.Request, tx sip.ServerTransaction) {
+		atomic.AddInt32(&registers, 1)
+		res := req.CreateResponse(sip.StatusOK)
+		expires := sip.Expires(0)
+		res.AddHeader(&expires)
+		_ = tx.SendResponse(res)
+	})
+
+	uac, _ := newTestService(t, "127.0.2.6")
+	registration := uac.Register("127.0.2.7:5060", testUri("alice", "127.0.2.7"))
+
+	select {
+	case event := <-registration.Events():
+		if event.State != RegisterStateUnregistered {
+			t.Fatalf("state = %s, want Unregistered", event.State)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no register event")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&registers); n != 1 {
+		t.Errorf("REGISTER sent %d times, want 1", n)
+	}
+	if err := registration.Unregister(context.Background()); err != nil {
+		t.Errorf("unregister failed: %s", err)
+	}
+	if n := atomic.LoadInt32(&registers); n != 1 {
+		t.Errorf("REGISTER sent %d times after unregister, want 1", n)
+	}
+}
+
+// 注销时取消进行中的注册，不等待 Timer F
+func TestUnregisterPending(t *testing.T) {
+	registers := make(chan sip.Request, 8)
+	_, registrarCallback := newTestService(t, "127.0.8.2")
+	registrarCallback.AddRequestHandle(sip.REGISTER, func(req sip.Request, tx sip.ServerTransaction) {
+		registers <- req
+	})
+
+	uac, _ := newTestService(t, "127.0.8.1")
+	registration := uac.Register("127.0.8.2:5060", testUri("alice", "127.0.8.2"))
+	receiveRequest(t, registers, sip.REGISTER)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := registration.Unregister(ctx); err != nil {
+		t.Errorf("unregister failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("unregister took %s", elapsed)
+	}
+	if state := registration.State(); state != RegisterStateUnregistered {
+		t.Errorf("state = %s, want Unregistered", state)
+	}
+}
+
+// 服务器不响应注销时 Unregister 在 ctx 结束时返回
+func TestUnregisterContext(t *testing.T) {
+	_, registrarCallback := newTestService(t, "127.0.8.4")
+	registrarCallback.AddRequestHandle(sip.REGISTER, func(req sip.Request, tx sip.ServerTransaction) {
+		if expires := req.Expires(); expires != nil && *expires == 0 {
+			return
+		}
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusOK))
+	})
+
+	uac, _ := newTestService(t, "127.0.8.3")
+	registration := uac.Register("127.0.8.4:5060", testUri("alice", "127.0.8.4"))
+	select {
+	case event := <-registration.Events():
+		if event.State != RegisterStateRegistered {
+			t.Fatalf("state = %s, want Registered", event.State)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no register event")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := registration.Unregister(ctx); err != context.DeadlineExceeded {
+		t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("unregister took %s", elapsed)
+	}
+}
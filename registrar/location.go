@@ -0,0 +1,74 @@
+package registrar
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 注册绑定 RFC 3261 - 10.3
+type Binding struct {
+	// address-of-record
+	Aor string
+	// 注册的联系地址
+	Contact *sip.ContactHeader
+	CallID  string
+	CSeq    uint32
+	// q 参数，未指定时为 -1
+	Q float64
+	// 过期时间
+	Expires time.Time
+	// 注册请求的来源地址
+	Source string
+}
+
+// 绑定的联系地址，同一 AOR 下唯一
+func (binding *Binding) Key() string {
+	return binding.Contact.Address.String()
+}
+
+// 剩余有效时间(秒)
+func (binding *Binding) ExpiresIn() uint32 {
+	remaining := time.Until(binding.Expires)
+	if remaining <= 0 {
+		return 0
+	}
+
+	return uint32((remaining + time.Second - 1) / time.Second)
+}
+
+func (binding *Binding) String() string {
+	return fmt.Sprintf("%s -> %s (expires %d)", binding.Aor, binding.Key(), binding.ExpiresIn())
+}
+
+// 绑定过期时的回调函数
+type ExpireHandler func(binding *Binding)
+
+// 位置服务，保存 AOR 与联系地址的绑定
+type LocationStore interface {
+	// 返回 AOR 未过期的绑定
+	Get(aor string) ([]*Binding, error)
+	// 添加或更新绑定，联系地址相同时替换
+	Put(binding *Binding) error
+	// 删除 AOR 的某个绑定
+	Remove(aor string, key string) error
+	// 删除 AOR 的所有绑定
+	RemoveAll(aor string) error
+}
+
+// 规范化的 AOR RFC 3261 - 10.3：scheme:user@host，去除端口以及参数
+func AorOf(uri sip.Uri) string {
+	scheme := "sip"
+	if uri.IsEncrypted() {
+		scheme = "sips"
+	}
+
+	host := strings.ToLower(uri.Domain().Host)
+	if user := uri.User(); user != nil && user.String() != "" {
+		return fmt.Sprintf("%s:%s@%s", scheme, user.String(), host)
+	}
+
+	return fmt.Sprintf("%s:%s", scheme, host)
+}
@@ -0,0 +1,119 @@
+package registrar
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+)
+
+// 内存中的位置服务
+func NewMemoryLocationStore(onExpire ExpireHandler) LocationStore {
+	return &memoryLocationStore{
+		bindings: make(map[string]map[string]*memoryBinding),
+		onExpire: onExpire,
+	}
+}
+
+type memoryLocationStore struct {
+	bindings map[string]map[string]*memoryBinding
+	onExpire ExpireHandler
+	mu       sync.RWMutex
+}
+
+type memoryBinding struct {
+	binding *Binding
+	timer   *time.Timer
+}
+
+func (store *memoryLocationStore) Get(aor string) ([]*Binding, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	now := time.Now()
+	bindings := make([]*Binding, 0, len(store.bindings[aor]))
+	for _, entry := range store.bindings[aor] {
+		if entry.binding.Expires.After(now) {
+			bindings = append(bindings, entry.binding)
+		}
+	}
+
+	return bindings, nil
+}
+
+func (store *memoryLocationStore) Put(binding *Binding) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := binding.Key()
+	entries, ok := store.bindings[binding.Aor]
+	if !ok {
+		entries = make(map[string]*memoryBinding)
+		store.bindings[binding.Aor] = entries
+	}
+	if old, ok := entries[key]; ok {
+		old.timer.Stop()
+	}
+
+	entry := &memoryBinding{binding: binding}
+	entry.timer = time.AfterFunc(time.Until(binding.Expires), func() {
+		store.expire(entry)
+	})
+	entries[key] = entry
+
+	return nil
+}
+
+func (store *memoryLocationStore) Remove(aor string, key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.remove(aor, key)
+
+	return nil
+}
+
+func (store *memoryLocationStore) RemoveAll(aor string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for key := range store.bindings[aor] {
+		store.remove(aor, key)
+	}
+
+	return nil
+}
+
+func (store *memoryLocationStore) remove(aor string, key string) {
+	entries, ok := store.bindings[aor]
+	if !ok {
+		return
+	}
+	if entry, ok := entries[key]; ok {
+		entry.timer.Stop()
+		delete(entries, key)
+	}
+	if len(entries) == 0 {
+		delete(store.bindings, aor)
+	}
+}
+
+// 绑定过期，删除后执行回调函数
+func (store *memoryLocationStore) expire(entry *memoryBinding) {
+	binding := entry.binding
+
+	store.mu.Lock()
+	// 绑定已被更新或删除
+	if current, ok := store.bindings[binding.Aor][binding.Key()]; !ok || current != entry {
+		store.mu.Unlock()
+		return
+	}
+	store.remove(binding.Aor, binding.Key())
+	store.mu.Unlock()
+
+	logger.Debugf("[registrar] -> binding expired: %s", binding)
+
+	if store.onExpire != nil {
+		store.onExpire(binding)
+	}
+}
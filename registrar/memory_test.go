@@ -0,0 +1,96 @@
+package registrar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+func newBinding(t *testing.T, contact string, cseq uint32, expires time.Duration) *Binding {
+	t.Helper()
+	uri, err := sip.ParseUri(contact)
+	if err != nil {
+		t.Fatalf("parse %s failed: %s", contact, err)
+	}
+
+	return &Binding{
+		Aor:     testAor,
+		Contact: &sip.ContactHeader{Address: uri, Params: sip.NewParams()},
+		CallID:  "call-1",
+		CSeq:    cseq,
+		Q:       -1,
+		Expires: time.Now().Add(expires),
+	}
+}
+
+func TestMemoryLocationStore(t *testing.T) {
+	store := NewMemoryLocationStore(nil)
+
+	_ = store.Put(newBinding(t, "sip:alice@192.0.2.1", 1, time.Hour))
+	_ = store.Put(newBinding(t, "sip:alice@192.0.2.2", 1, time.Hour))
+	// 联系地址相同时替换
+	_ = store.Put(newBinding(t, "sip:alice@192.0.2.1", 2, time.Hour))
+	bindings, _ := store.Get(testAor)
+	if len(bindings) != 2 {
+		t.Fatalf("%d bindings, want 2", len(bindings))
+	}
+	for _, binding := range bindings {
+		if binding.Key() == "sip:alice@192.0.2.1" && binding.CSeq != 2 {
+			t.Errorf("binding %s not replaced", binding)
+		}
+	}
+
+	_ = store.Remove(testAor, "sip:alice@192.0.2.1")
+	if bindings, _ := store.Get(testAor); len(bindings) != 1 || bindings[0].Key() != "sip:alice@192.0.2.2" {
+		t.Errorf("bindings = %v after remove", bindings)
+	}
+	_ = store.RemoveAll(testAor)
+	if bindings, _ := store.Get(testAor); len(bindings) != 0 {
+		t.Errorf("bindings = %v after remove all", bindings)
+	}
+	if len(store.(*memoryLocationStore).bindings) != 0 {
+		t.Error("empty AOR not deleted")
+	}
+}
+
+// 过期的绑定被删除并执行回调函数，已更新的绑定不会按旧的过期时间删除
+func TestMemoryLocationStoreExpire(t *testing.T) {
+	expired := make(chan *Binding, 2)
+	store := NewMemoryLocationStore(func(binding *Binding) {
+		expired <- binding
+	})
+
+	_ = store.Put(newBinding(t, "sip:alice@192.0.2.1", 1, 20*time.Millisecond))
+	_ = store.Put(newBinding(t, "sip:alice@192.0.2.2", 1, 20*time.Millisecond))
+	_ = store.Put(newBinding(t, "sip:alice@192.0.2.2", 2, time.Hour))
+
+	select {
+	case binding := <-expired:
+		if binding.Key() != "sip:alice@192.0.2.1" {
+			t.Errorf("expired %s", binding)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("binding not expired")
+	}
+	select {
+	case binding := <-expired:
+		t.Errorf("updated binding %s expired", binding)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if bindings, _ := store.Get(testAor); len(bindings) != 1 || bindings[0].CSeq != 2 {
+		t.Errorf("bindings = %v, want the updated binding", bindings)
+	}
+}
+
+// 过期但尚未删除的绑定不返回
+func TestMemoryLocationStoreGetExpired(t *testing.T) {
+	store := NewMemoryLocationStore(nil).(*memoryLocationStore)
+	_ = store.Put(newBinding(t, "sip:alice@192.0.2.1", 1, time.Hour))
+	store.bindings[testAor]["sip:alice@192.0.2.1"].binding.Expires = time.Now().Add(-time.Second)
+
+	if bindings, _ := store.Get(testAor); len(bindings) != 0 {
+		t.Errorf("bindings = %v, want none", bindings)
+	}
+}
@@ -0,0 +1,55 @@
+package registrar
+
+// 默认注册有效期(秒)
+const (
+	DefaultExpires    uint32 = 3600
+	DefaultMinExpires uint32 = 60
+	DefaultMaxExpires uint32 = 7200
+)
+
+// registrar 的配置选项
+type Options struct {
+	// 请求未指定有效期时使用的值
+	defaultExpires uint32
+	// 小于该值时返回 423 Interval Too Brief
+	minExpires uint32
+	// 大于该值时使用该值
+	maxExpires uint32
+}
+
+type Option func(o *Options)
+
+func newOptions(opts ...Option) Options {
+	opt := Options{
+		defaultExpires: DefaultExpires,
+		minExpires:     DefaultMinExpires,
+		maxExpires:     DefaultMaxExpires,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
+// 配置默认有效期
+func Expires(expires uint32) Option {
+	return func(o *Options) {
+		o.defaultExpires = expires
+	}
+}
+
+// 配置最小有效期
+func MinExpires(expires uint32) Option {
+	return func(o *Options) {
+		o.minExpires = expires
+	}
+}
+
+// 配置最大有效期
+func MaxExpires(expires uint32) Option {
+	return func(o *Options) {
+		o.maxExpires = expires
+	}
+}
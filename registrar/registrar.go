@@ -0,0 +1,263 @@
+package registrar
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+/**
+创建注册服务器 RFC 3261 - 10.3：
+	store：保存注册绑定的位置服务
+	opts：有效期等配置
+认证由 gsip.AuthenticatorConfig 在回调函数之前完成
+*/
+func NewRegistrar(store LocationStore, opts ...Option) *Registrar {
+	return &Registrar{
+		opts:  newOptions(opts...),
+		store: store,
+		locks: make(map[string]*aorLock),
+	}
+}
+
+type Registrar struct {
+	opts  Options
+	store LocationStore
+	// 同一 AOR 的注册串行处理，读取、校验以及更新绑定之间不会交错
+	locks map[string]*aorLock
+	mu    sync.Mutex
+}
+
+type aorLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// 锁定 AOR，返回解锁函数，没有等待者时删除该 AOR 的锁
+func (r *Registrar) lock(aor string) func() {
+	r.mu.Lock()
+	l, ok := r.locks[aor]
+	if !ok {
+		l = &aorLock{}
+		r.locks[aor] = l
+	}
+	l.refs++
+	r.mu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		r.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(r.locks, aor)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// 位置服务
+func (r *Registrar) Store() LocationStore {
+	return r.store
+}
+
+// 返回 AOR 当前的绑定
+func (r *Registrar) Lookup(aor string) ([]*Binding, error) {
+	return r.store.Get(aor)
+}
+
+// REGISTER 请求回调函数，可通过 gsip.AddRequestCallback(sip.REGISTER, registrar.Handle) 注册
+func (r *Registrar) Handle(request sip.Request, tx sip.ServerTransaction) {
+	response := r.handleRegister(request)
+	if tx == nil {
+		logger.Warnf("[registrar] -> no server transaction to send %s", response.Short())
+		return
+	}
+	if err := tx.SendResponse(response); err != nil {
+		logger.Errorf("[registrar] -> send %s failed: %s", response.Short(), err)
+	}
+}
+
+func (r *Registrar) handleRegister(request sip.Request) sip.Response {
+	to := request.To()
+	callID := request.CallID()
+	cseq := request.CSeq()
+	if to == nil || to.Address == nil || callID == nil || cseq == nil {
+		return request.CreateResponseReason(sip.StatusBadRequest, "Bad Request")
+	}
+	aor := AorOf(to.Address)
+	unlock := r.lock(aor)
+	defer unlock()
+
+	contacts := make([]*sip.ContactHeader, 0)
+	for _, hdr := range request.GetHeaders("Contact") {
+		if contact, ok := hdr.(*sip.ContactHeader); ok {
+			contacts = append(contacts, contact)
+		}
+	}
+
+	// 没有 Contact 时仅查询当前绑定
+	if len(contacts) == 0 {
+		return r.okResponse(request, aor)
+	}
+
+	current, err := r.store.Get(aor)
+	if err != nil {
+		logger.Errorf("[registrar] -> get bindings of %s failed: %s", aor, err)
+		return request.CreateResponseReason(sip.StatusServerInternalError, "Server Internal Error")
+	}
+
+	// RFC 3261 - 10.3 第 6 步：通配符 * 删除所有绑定
+	for _, contact := range contacts {
+		if _, ok := contact.Address.(*sip.WildcardUri); !ok {
+			continue
+		}
+		if len(contacts) != 1 || request.Expires() == nil || *request.Expires() != 0 {
+			return request.CreateResponseReason(sip.StatusBadRequest, "Bad Request")
+		}
+		for _, binding := range current {
+			if !isNewer(binding, string(*callID), cseq.SeqNo) {
+				return request.CreateResponseReason(sip.StatusServerInternalError, "Server Internal Error")
+			}
+		}
+		if err := r.store.RemoveAll(aor); err != nil {
+			logger.Errorf("[registrar] -> remove bindings of %s failed: %s", aor, err)
+			return request.CreateResponseReason(sip.StatusServerInternalError, "Server Internal Error")
+		}
+
+		return r.okResponse(request, aor)
+	}
+
+	existing := make(map[string]*Binding, len(current))
+	for _, binding := range current {
+		existing[binding.Key()] = binding
+	}
+
+	// 先校验所有 Contact，全部通过后再更新绑定
+	bindings := make([]*Binding, 0, len(contacts))
+	for _, contact := range contacts {
+		expires := r.contactExpires(request, contact)
+		if expires > 0 && expires < r.opts.minExpires {
+			response := request.CreateResponseReason(sip.StatusIntervalTooBrief, "Interval Too Brief")
+			minExpires := sip.MinExpires(r.opts.minExpires)
+			response.AddHeader(&minExpires)
+			return response
+		}
+		if expires > r.opts.maxExpires {
+			expires = r.opts.maxExpires
+		}
+
+		q, err := contactQ(contact)
+		if err != nil {
+			logger.Warnf("[registrar] -> %s", err)
+			return request.CreateResponseReason(sip.StatusBadRequest, "Bad Request")
+		}
+
+		binding := &Binding{
+			Aor:     aor,
+			Contact: contact.Copy().(*sip.ContactHeader),
+			CallID:  string(*callID),
+			CSeq:    cseq.SeqNo,
+			Q:       q,
+			Expires: time.Now().Add(time.Duration(expires) * time.Second),
+			Source:  request.Source(),
+		}
+		// RFC 3261 - 10.3 第 7 步：Call-ID 相同时 CSeq 必须递增
+		if old, ok := existing[binding.Key()]; ok && !isNewer(old, binding.CallID, binding.CSeq) {
+			return request.CreateResponseReason(sip.StatusServerInternalError, "Server Internal Error")
+		}
+		bindings = append(bindings, binding)
+	}
+
+	for _, binding := range bindings {
+		if binding.Expires.After(time.Now()) {
+			err = r.store.Put(binding)
+		} else {
+			err = r.store.Remove(aor, binding.Key())
+		}
+		if err != nil {
+			logger.Errorf("[registrar] -> update binding %s failed: %s", binding, err)
+			return request.CreateResponseReason(sip.StatusServerInternalError, "Server Internal Error")
+		}
+	}
+
+	return r.okResponse(request, aor)
+}
+
+// 200 OK 包含 AOR 当前的所有绑定 RFC 3261 - 10.3 第 8 步
+func (r *Registrar) okResponse(request sip.Request, aor string) sip.Response {
+	bindings, err := r.store.Get(aor)
+	if err != nil {
+		logger.Errorf("[registrar] -> get bindings of %s failed: %s", aor, err)
+		return request.CreateResponseReason(sip.StatusServerInternalError, "Server Internal Error")
+	}
+
+	// 按 q 值从高到低排列，未指定 q 时视为 1
+	priority := func(binding *Binding) float64 {
+		if binding.Q < 0 {
+			return 1
+		}
+		return binding.Q
+	}
+	sort.SliceStable(bindings, func(i, j int) bool {
+		return priority(bindings[i]) > priority(bindings[j])
+	})
+
+	response := request.CreateResponseReason(sip.StatusOK, "OK")
+	for _, binding := range bindings {
+		contact := binding.Contact.Copy().(*sip.ContactHeader)
+		if contact.Params == nil {
+			contact.Params = sip.NewParams()
+		}
+		contact.Params.Add("expires", sip.String{Str: strconv.FormatUint(uint64(binding.ExpiresIn()), 10)})
+		response.AddHeader(contact)
+	}
+
+	return response
+}
+
+// Contact 的 expires 参数优先，其次是 Expires 头部，都没有时使用默认值
+func (r *Registrar) contactExpires(request sip.Request, contact *sip.ContactHeader) uint32 {
+	if contact.Params != nil {
+		if value, ok := contact.Params.Get("expires"); ok && value != nil {
+			if expires, err := strconv.ParseUint(value.String(), 10, 32); err == nil {
+				return uint32(expires)
+			}
+		}
+	}
+	if expires := request.Expires(); expires != nil {
+		return uint32(*expires)
+	}
+
+	return r.opts.defaultExpires
+}
+
+// q 参数取值范围 0 - 1
+func contactQ(contact *sip.ContactHeader) (float64, error) {
+	if contact.Params == nil {
+		return -1, nil
+	}
+	value, ok := contact.Params.Get("q")
+	if !ok || value == nil {
+		return -1, nil
+	}
+
+	q, err := strconv.ParseFloat(value.String(), 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, fmt.Errorf("invalid contact q value %s", value)
+	}
+
+	return q, nil
+}
+
+// Call-ID 不同，或者 Call-ID 相同且 CSeq 更大时可以更新绑定
+func isNewer(binding *Binding, callID string, cseq uint32) bool {
+	return binding.CallID != callID || cseq > binding.CSeq
+}
@@ -0,0 +1,203 @@
+package registrar
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+const testAor = "sip:alice@registrar.test"
+
+// alice 的 REGISTER，headers 为 Contact、Expires 等头部
+func registerRequest(t *testing.T, callID string, cseq uint32, headers ...string) sip.Request {
+	t.Helper()
+	lines := append([]string{
+		"REGISTER sip:registrar.test SIP/2.0",
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=" + sip.GenerateBranch(),
+		"From: <sip:alice@registrar.test>;tag=alice",
+		"To: <sip:alice@registrar.test:5060>",
+		"Call-ID: " + callID,
+		fmt.Sprintf("CSeq: %d REGISTER", cseq),
+		"Max-Forwards: 70",
+	}, headers...)
+	msg, err := sip.ParseMessage([]byte(strings.Join(lines, "\r\n") + "\r\nContent-Length: 0\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("parse REGISTER failed: %s", err)
+	}
+
+	return msg.(sip.Request)
+}
+
+// 响应中的联系地址以及 expires 参数，按响应中的顺序
+func responseContacts(res sip.Response) ([]string, []string) {
+	uris := make([]string, 0)
+	expires := make([]string, 0)
+	for _, hdr := range res.GetHeaders("Contact") {
+		contact := hdr.(*sip.ContactHeader)
+		uris = append(uris, contact.Address.String())
+		value, _ := contact.Params.Get("expires")
+		expires = append(expires, value.String())
+	}
+
+	return uris, expires
+}
+
+func expectStatus(t *testing.T, res sip.Response, status sip.StatusCode) {
+	t.Helper()
+	if res.StatusCode() != status {
+		t.Fatalf("response = %d %s, want %d", res.StatusCode(), res.Reason(), status)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistrar(NewMemoryLocationStore(nil), MinExpires(60), MaxExpires(3600))
+
+	res := r.handleRegister(registerRequest(t, "call-1", 1,
+		"Contact: <sip:alice@192.0.2.1>;q=0.5",
+		"Contact: <sip:alice@192.0.2.2>;expires=7200;q=0.9",
+		"Expires: 1800"))
+	expectStatus(t, res, sip.StatusOK)
+	uris, expires := responseContacts(res)
+	// 按 q 值从高到低排列，有效期不超过最大值
+	if want := []string{"sip:alice@192.0.2.2", "sip:alice@192.0.2.1"}; strings.Join(uris, ",") != strings.Join(want, ",") {
+		t.Errorf("contacts = %v, want %v", uris, want)
+	}
+	if want := []string{"3600", "1800"}; strings.Join(expires, ",") != strings.Join(want, ",") {
+		t.Errorf("expires = %v, want %v", expires, want)
+	}
+	if bindings, _ := r.Lookup(testAor); len(bindings) != 2 {
+		t.Fatalf("%d bindings of %s, want 2", len(bindings), testAor)
+	}
+
+	// 没有 Contact 时只查询
+	res = r.handleRegister(registerRequest(t, "call-2", 1))
+	expectStatus(t, res, sip.StatusOK)
+	if uris, _ := responseContacts(res); len(uris) != 2 {
+		t.Errorf("query returned %v", uris)
+	}
+
+	// 有效期为 0 时删除绑定
+	res = r.handleRegister(registerRequest(t, "call-1", 2, "Contact: <sip:alice@192.0.2.1>;expires=0"))
+	expectStatus(t, res, sip.StatusOK)
+	if uris, _ := responseContacts(res); len(uris) != 1 || uris[0] != "sip:alice@192.0.2.2" {
+		t.Errorf("contacts = %v after removal", uris)
+	}
+}
+
+func TestRegisterRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		callID  string
+		cseq    uint32
+		headers []string
+		status  sip.StatusCode
+	}{
+		{name: "interval too brief", callID: "call-2", cseq: 1, headers: []string{"Contact: <sip:alice@192.0.2.1>", "Expires: 30"}, status: sip.StatusIntervalTooBrief},
+		{name: "bad q", callID: "call-2", cseq: 1, headers: []string{"Contact: <sip:alice@192.0.2.1>;q=2"}, status: sip.StatusBadRequest},
+		{name: "same cseq", callID: "call-1", cseq: 5, headers: []string{"Contact: <sip:alice@192.0.2.1>"}, status: sip.StatusServerInternalError},
+		{name: "older cseq", callID: "call-1", cseq: 4, headers: []string{"Contact: <sip:alice@192.0.2.1>"}, status: sip.StatusServerInternalError},
+		{name: "wildcard with expires", callID: "call-1", cseq: 6, headers: []string{"Contact: *", "Expires: 60"}, status: sip.StatusBadRequest},
+		{name: "wildcard with contacts", callID: "call-1", cseq: 6, headers: []string{"Contact: *", "Contact: <sip:alice@192.0.2.2>", "Expires: 0"}, status: sip.StatusBadRequest},
+		{name: "wildcard with older cseq", callID: "call-1", cseq: 4, headers: []string{"Contact: *", "Expires: 0"}, status: sip.StatusServerInternalError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRegistrar(NewMemoryLocationStore(nil), MinExpires(60))
+			expectStatus(t, r.handleRegister(registerRequest(t, "call-1", 5, "Contact: <sip:alice@192.0.2.1>")), sip.StatusOK)
+
+			res := r.handleRegister(registerRequest(t, test.callID, test.cseq, test.headers...))
+			expectStatus(t, res, test.status)
+			if test.status == sip.StatusIntervalTooBrief && len(res.GetHeaders("Min-Expires")) == 0 {
+				t.Error("423 without Min-Expires")
+			}
+			if bindings, _ := r.Lookup(testAor); len(bindings) != 1 || bindings[0].CSeq != 5 {
+				t.Errorf("bindings = %v, want the original binding", bindings)
+			}
+		})
+	}
+}
+
+// 通配符 * 且 Expires 为 0 时删除所有绑定
+func TestRegisterWildcard(t *testing.T) {
+	r := NewRegistrar(NewMemoryLocationStore(nil))
+	expectStatus(t, r.handleRegister(registerRequest(t, "call-1", 1, "Contact: <sip:alice@192.0.2.1>", "Contact: <sip:alice@192.0.2.2>")), sip.StatusOK)
+
+	res := r.handleRegister(registerRequest(t, "call-1", 2, "Contact: *", "Expires: 0"))
+	expectStatus(t, res, sip.StatusOK)
+	if uris, _ := responseContacts(res); len(uris) != 0 {
+		t.Errorf("contacts = %v after removing all", uris)
+	}
+}
+
+// 读取绑定较慢的位置服务，扩大并发注册交错的时间窗口
+type slowStore struct {
+	LocationStore
+}
+
+func (store *slowStore) Get(aor string) ([]*Binding, error) {
+	bindings, err := store.LocationStore.Get(aor)
+	time.Sleep(time.Millisecond)
+
+	return bindings, err
+}
+
+// 同一 AOR 的并发注册串行处理：最终的绑定是接受的注册中 CSeq 最大的一个
+func TestRegisterConcurrent(t *testing.T) {
+	r := NewRegistrar(&slowStore{NewMemoryLocationStore(nil)})
+
+	const n = 16
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted uint32
+	)
+	start := make(chan struct{})
+	for i := 1; i <= n; i++ {
+		req := registerRequest(t, "call-1", uint32(i), "Contact: <sip:alice@192.0.2.1>")
+		wg.Add(1)
+		go func(cseq uint32) {
+			defer wg.Done()
+			<-start
+			if res := r.handleRegister(req); res.StatusCode() == sip.StatusOK {
+				mu.Lock()
+				if cseq > accepted {
+					accepted = cseq
+				}
+				mu.Unlock()
+			}
+		}(uint32(i))
+	}
+	close(start)
+	wg.Wait()
+
+	bindings, _ := r.Lookup(testAor)
+	if len(bindings) != 1 || bindings[0].CSeq != accepted {
+		t.Errorf("bindings = %v, want CSeq %d", bindings, accepted)
+	}
+	if len(r.locks) != 0 {
+		t.Errorf("%d locks left", len(r.locks))
+	}
+}
+
+func TestAorOf(t *testing.T) {
+	tests := map[string]string{
+		"sip:alice@Example.COM:5060;transport=tcp": "sip:alice@example.com",
+		"sips:alice@example.com":                   "sips:alice@example.com",
+		"sip:example.com":                          "sip:example.com",
+	}
+
+	for uri, aor := range tests {
+		parsed, err := sip.ParseUri(uri)
+		if err != nil {
+			t.Fatalf("parse %s failed: %s", uri, err)
+		}
+		if got := AorOf(parsed); got != aor {
+			t.Errorf("AOR of %s = %s, want %s", uri, got, aor)
+		}
+	}
+}
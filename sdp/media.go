@@ -0,0 +1,265 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 媒体类型以及传输协议
+const (
+	MediaAudio = "audio"
+	MediaVideo = "video"
+
+	ProtoRtpAvp    = "RTP/AVP"
+	ProtoRtpSavp   = "RTP/SAVP"
+	ProtoTcpRtpAvp = "TCP/RTP/AVP"
+)
+
+// 媒体传输方向 RFC 3264 - 5.1
+type Direction string
+
+const (
+	SendRecv Direction = "sendrecv"
+	SendOnly Direction = "sendonly"
+	RecvOnly Direction = "recvonly"
+	Inactive Direction = "inactive"
+)
+
+// 是否发送媒体
+func (d Direction) Send() bool {
+	return d == SendRecv || d == SendOnly
+}
+
+// 是否接收媒体
+func (d Direction) Recv() bool {
+	return d == SendRecv || d == RecvOnly
+}
+
+// 根据是否发送、接收生成方向
+func NewDirection(send bool, recv bool) Direction {
+	switch {
+	case send && recv:
+		return SendRecv
+	case send:
+		return SendOnly
+	case recv:
+		return RecvOnly
+	default:
+		return Inactive
+	}
+}
+
+// 编码：m= 中的格式以及对应的 a=rtpmap、a=fmtp
+type Codec struct {
+	Payload   uint8
+	Name      string
+	ClockRate int
+	// 音频的声道数，默认为 1
+	Channels int
+	Fmtp     string
+}
+
+// a=rtpmap:<payload type> <encoding name>/<clock rate>[/<encoding parameters>]
+func (c Codec) rtpmap() string {
+	value := strconv.Itoa(int(c.Payload)) + " " + c.Name + "/" + strconv.Itoa(c.ClockRate)
+	if c.Channels > 1 {
+		value += "/" + strconv.Itoa(c.Channels)
+	}
+	return value
+}
+
+// 名称、时钟频率以及声道数相同时为同一编码
+func (c Codec) Matches(other Codec) bool {
+	channels := func(n int) int {
+		if n <= 0 {
+			return 1
+		}
+		return n
+	}
+
+	return strings.EqualFold(c.Name, other.Name) && c.ClockRate == other.ClockRate &&
+		channels(c.Channels) == channels(other.Channels)
+}
+
+// 静态负载类型 RFC 3551 - 6
+var staticCodecs = map[uint8]Codec{
+	0:  {Payload: 0, Name: "PCMU", ClockRate: 8000, Channels: 1},
+	3:  {Payload: 3, Name: "GSM", ClockRate: 8000, Channels: 1},
+	4:  {Payload: 4, Name: "G723", ClockRate: 8000, Channels: 1},
+	8:  {Payload: 8, Name: "PCMA", ClockRate: 8000, Channels: 1},
+	9:  {Payload: 9, Name: "G722", ClockRate: 8000, Channels: 1},
+	18: {Payload: 18, Name: "G729", ClockRate: 8000, Channels: 1},
+	26: {Payload: 26, Name: "JPEG", ClockRate: 90000},
+	31: {Payload: 31, Name: "H261", ClockRate: 90000},
+	32: {Payload: 32, Name: "MPV", ClockRate: 90000},
+	33: {Payload: 33, Name: "MP2T", ClockRate: 90000},
+	34: {Payload: 34, Name: "H263", ClockRate: 90000},
+}
+
+// 媒体的编码列表，按 m= 中的顺序，没有 rtpmap 的静态负载类型使用 RFC 3551 的定义
+func (m *Media) Codecs() []Codec {
+	codecs := make([]Codec, 0, len(m.Formats))
+	for _, format := range m.Formats {
+		if codec, ok := m.Codec(format); ok {
+			codecs = append(codecs, codec)
+		}
+	}
+
+	return codecs
+}
+
+// 返回格式对应的编码
+func (m *Media) Codec(format string) (Codec, bool) {
+	pt, err := strconv.ParseUint(format, 10, 8)
+	if err != nil {
+		return Codec{}, false
+	}
+
+	codec, ok := staticCodecs[uint8(pt)]
+	for _, attr := range m.Attributes {
+		if !strings.EqualFold(attr.Key, "rtpmap") {
+			continue
+		}
+		if rtpmap, ok2 := parseRtpmap(attr.Value); ok2 && rtpmap.Payload == uint8(pt) {
+			codec, ok = rtpmap, true
+			break
+		}
+	}
+	if !ok {
+		return Codec{}, false
+	}
+	codec.Fmtp = m.Fmtp(format)
+
+	return codec, true
+}
+
+// 添加编码，动态负载类型添加 a=rtpmap
+func (m *Media) AddCodec(codec Codec) *Media {
+	format := strconv.Itoa(int(codec.Payload))
+	m.Formats = append(m.Formats, format)
+	if static, ok := staticCodecs[codec.Payload]; !ok || !static.Matches(codec) {
+		m.Attributes = append(m.Attributes, Attribute{Key: "rtpmap", Value: codec.rtpmap()})
+	}
+	if codec.Fmtp != "" {
+		m.Attributes = append(m.Attributes, Attribute{Key: "fmtp", Value: format + " " + codec.Fmtp})
+	}
+
+	return m
+}
+
+// 返回格式对应的 a=fmtp 参数
+func (m *Media) Fmtp(format string) string {
+	for _, attr := range m.Attributes {
+		if !strings.EqualFold(attr.Key, "fmtp") {
+			continue
+		}
+		if pt, params, ok := cut(attr.Value, " "); ok && pt == format {
+			return strings.TrimSpace(params)
+		}
+	}
+
+	return ""
+}
+
+// 媒体级的传输方向，没有方向属性时为 sendrecv，需要考虑会话级属性时使用 Session.MediaDirection
+func (m *Media) Direction() Direction {
+	if direction, ok := attributesDirection(m.Attributes); ok {
+		return direction
+	}
+
+	return SendRecv
+}
+
+// 设置媒体级的传输方向，替换已有的方向属性
+func (m *Media) SetDirection(direction Direction) *Media {
+	attrs := make([]Attribute, 0, len(m.Attributes)+1)
+	for _, attr := range m.Attributes {
+		if _, ok := directionOf(attr.Key); !ok {
+			attrs = append(attrs, attr)
+		}
+	}
+	m.Attributes = append(attrs, Attribute{Key: string(direction)})
+
+	return m
+}
+
+// 返回媒体级属性的值
+func (m *Media) GetAttribute(key string) (string, bool) {
+	return getAttribute(m.Attributes, key)
+}
+
+// 添加媒体级属性
+func (m *Media) AddAttribute(key string, value string) *Media {
+	m.Attributes = append(m.Attributes, Attribute{Key: key, Value: value})
+	return m
+}
+
+// 端口为 0 时媒体被拒绝或者禁用 RFC 3264 - 6
+func (m *Media) Rejected() bool {
+	return m.Port == 0
+}
+
+func attributesDirection(attrs []Attribute) (Direction, bool) {
+	for _, attr := range attrs {
+		if direction, ok := directionOf(attr.Key); ok {
+			return direction, true
+		}
+	}
+
+	return "", false
+}
+
+func directionOf(key string) (Direction, bool) {
+	switch direction := Direction(strings.ToLower(key)); direction {
+	case SendRecv, SendOnly, RecvOnly, Inactive:
+		return direction, true
+	default:
+		return "", false
+	}
+}
+
+func parseRtpmap(value string) (Codec, bool) {
+	pt, encoding, ok := cut(strings.TrimSpace(value), " ")
+	if !ok {
+		return Codec{}, false
+	}
+	payload, err := strconv.ParseUint(pt, 10, 8)
+	if err != nil {
+		return Codec{}, false
+	}
+
+	parts := strings.Split(strings.TrimSpace(encoding), "/")
+	if len(parts) < 2 {
+		return Codec{}, false
+	}
+	clockRate, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Codec{}, false
+	}
+	codec := Codec{
+		Payload:   uint8(payload),
+		Name:      parts[0],
+		ClockRate: clockRate,
+	}
+	if len(parts) > 2 {
+		if channels, err := strconv.Atoi(parts[2]); err == nil {
+			codec.Channels = channels
+		}
+	}
+
+	return codec, true
+}
+
+func cut(s string, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
+
+// NTP 时间戳(秒) RFC 4566 - 5.2
+func ntpTime() uint64 {
+	return uint64(time.Now().Unix()) + 2208988800
+}
@@ -0,0 +1,65 @@
+package sdp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// SDP 的 Content-Type RFC 4566 - 8
+const ContentType = "application/sdp"
+
+// 消息的 Content-Type 是否为 application/sdp
+func IsSdp(msg sip.Message) bool {
+	ct := msg.ContentType()
+	if ct == nil {
+		return false
+	}
+	mediaType, _, _ := cut(string(*ct), ";")
+
+	return strings.EqualFold(strings.TrimSpace(mediaType), ContentType)
+}
+
+// 解析消息体中的会话描述，多部分消息体使用第一个 application/sdp 部分
+func FromMessage(msg sip.Message) (*Session, error) {
+	if IsSdp(msg) {
+		body := msg.Body()
+		if ce := msg.ContentEncoding(); ce != nil {
+			var err error
+			if body, err = sip.DecodeBody(string(*ce), body); err != nil {
+				return nil, fmt.Errorf("[sdp] -> decode body of %s failed: %w", msg.Short(), err)
+			}
+		}
+		return Parse(string(body))
+	}
+
+	mb, err := msg.Multipart()
+	if err != nil {
+		return nil, fmt.Errorf("[sdp] -> parse multipart body of %s failed: %w", msg.Short(), err)
+	}
+	if mb != nil {
+		if part := mb.Part(ContentType); part != nil {
+			body, err := part.Decoded()
+			if err != nil {
+				return nil, fmt.Errorf("[sdp] -> decode SDP part of %s failed: %w", msg.Short(), err)
+			}
+			return Parse(string(body))
+		}
+	}
+
+	return nil, fmt.Errorf("[sdp] -> message %s has no SDP body", msg.Short())
+}
+
+// 将会话描述设置为消息体，同时设置 Content-Type 以及 Content-Length
+// 需要同时携带其他内容时使用 NewPart 以及 sip.Message.SetMultipart
+func SetBody(msg sip.Message, s *Session) {
+	ct := sip.ContentType(ContentType)
+	msg.ReplaceHeader(&ct)
+	msg.SetBody([]byte(s.String()), true)
+}
+
+// 会话描述作为多部分消息体中的一个部分，Content-Disposition 为 session
+func NewPart(s *Session) *sip.BodyPart {
+	return sip.NewBodyPart(ContentType, []byte(s.String())).SetContentDisposition("session")
+}
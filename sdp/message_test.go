@@ -0,0 +1,82 @@
+package sdp
+
+import (
+	"testing"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+func newInvite(t *testing.T) sip.Request {
+	t.Helper()
+	from, err := sip.ParseUri("sip:alice@192.0.2.1")
+	if err != nil {
+		t.Fatalf("parse from failed: %s", err)
+	}
+	to, err := sip.ParseUri("sip:bob@192.0.2.2")
+	if err != nil {
+		t.Fatalf("parse to failed: %s", err)
+	}
+
+	return sip.CreateRequest(sip.INVITE, "192.0.2.2:5060", from, to)
+}
+
+func TestFromMessage(t *testing.T) {
+	invite := newInvite(t)
+	if _, err := FromMessage(invite); err == nil {
+		t.Error("parsed SDP of message without body")
+	}
+
+	SetBody(invite, parse(t, offerSdp))
+	if !IsSdp(invite) {
+		t.Errorf("Content-Type = %s, want %s", invite.ContentType(), ContentType)
+	}
+
+	// 经过序列化以及解析后的消息
+	msg, err := sip.ParseMessage([]byte(invite.String()))
+	if err != nil {
+		t.Fatalf("parse message failed: %s", err)
+	}
+	s, err := FromMessage(msg)
+	if err != nil {
+		t.Fatalf("parse SDP failed: %s", err)
+	}
+	if s.String() != offerSdp {
+		t.Errorf("SDP = %q, want %q", s, offerSdp)
+	}
+}
+
+// 多部分消息体使用 application/sdp 部分，部分按 Content-Encoding 解码
+func TestFromMultipartMessage(t *testing.T) {
+	encoded, err := sip.EncodeBody(sip.EncodingGzip, []byte(offerSdp))
+	if err != nil {
+		t.Fatalf("encode SDP failed: %s", err)
+	}
+	part := sip.NewBodyPart(ContentType, encoded).SetContentDisposition("session")
+	part.Header.Set("Content-Encoding", sip.EncodingGzip)
+
+	invite := newInvite(t)
+	invite.SetMultipart(sip.NewMultipartBody("", sip.NewBodyPart("application/isup", []byte{0x01, 0x00}), part))
+	if IsSdp(invite) {
+		t.Error("multipart message is SDP")
+	}
+	msg, err := sip.ParseMessage([]byte(invite.String()))
+	if err != nil {
+		t.Fatalf("parse message failed: %s", err)
+	}
+	s, err := FromMessage(msg)
+	if err != nil {
+		t.Fatalf("parse SDP failed: %s", err)
+	}
+	if s.String() != offerSdp {
+		t.Errorf("SDP = %q, want %q", s, offerSdp)
+	}
+
+	if newPart := NewPart(s); newPart.ContentType() != ContentType || newPart.ContentDisposition() != "session" {
+		t.Errorf("part = %s; %s", newPart.ContentType(), newPart.ContentDisposition())
+	}
+
+	invite.SetMultipart(sip.NewMultipartBody("", sip.NewBodyPart("application/isup", []byte{0x01, 0x00})))
+	if _, err := FromMessage(invite); err == nil {
+		t.Error("parsed SDP of multipart body without SDP part")
+	}
+}
@@ -0,0 +1,271 @@
+package sdp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/**
+根据本地能力生成应答 RFC 3264 - 6：
+	offer：收到的 offer
+	local：本地能力，每种媒体类型的端口、编码以及方向
+应答的 m= 与 offer 一一对应，没有共同编码的媒体端口为 0；
+编码按本地的优先级排列并使用 offer 中的负载类型，方向取双方的交集
+*/
+func Answer(offer *Session, local *Session) (*Session, error) {
+	if offer == nil || local == nil {
+		return nil, fmt.Errorf("[sdp] -> offer and local description are required")
+	}
+
+	answer := &Session{
+		Version:    0,
+		Origin:     local.Origin,
+		Name:       local.Name,
+		Info:       local.Info,
+		Connection: local.Connection.Copy(),
+		Bandwidths: append([]Bandwidth(nil), local.Bandwidths...),
+		SSRC:       local.SSRC,
+		Format:     local.Format,
+	}
+	if answer.Name == "" || answer.Name == "-" {
+		answer.Name = offer.Name
+	}
+	// RFC 3264 - 6 应答的 t= 必须与 offer 相同
+	answer.Timings = offer.Copy().Timings
+	if answer.SSRC == "" {
+		answer.SSRC = offer.SSRC
+	}
+	for _, attr := range local.Attributes {
+		if _, ok := directionOf(attr.Key); !ok {
+			answer.Attributes = append(answer.Attributes, attr)
+		}
+	}
+
+	used := make(map[*Media]bool)
+	for _, offered := range offer.Media {
+		var capability *Media
+		for _, m := range local.Media {
+			if !used[m] && !m.Rejected() && m.Type == offered.Type && strings.EqualFold(m.Proto, offered.Proto) {
+				capability = m
+				break
+			}
+		}
+
+		media := answerMedia(offer, offered, local, capability)
+		if !media.Rejected() {
+			used[capability] = true
+		}
+		answer.Media = append(answer.Media, media)
+	}
+
+	return answer, nil
+}
+
+func answerMedia(offer *Session, offered *Media, local *Session, capability *Media) *Media {
+	rejected := &Media{
+		Type:    offered.Type,
+		Port:    0,
+		Proto:   offered.Proto,
+		Formats: append([]string(nil), offered.Formats...),
+	}
+	if offered.Rejected() || capability == nil {
+		return rejected
+	}
+
+	// 编码取交集
+	offeredCodecs := offered.Codecs()
+	codecs := make([]Codec, 0)
+	for _, localCodec := range capability.Codecs() {
+		for _, offeredCodec := range offeredCodecs {
+			if !localCodec.Matches(offeredCodec) {
+				continue
+			}
+			codec := localCodec
+			codec.Payload = offeredCodec.Payload
+			if codec.Fmtp == "" {
+				codec.Fmtp = offeredCodec.Fmtp
+			}
+			codecs = append(codecs, codec)
+			break
+		}
+	}
+	if len(codecs) == 0 {
+		return rejected
+	}
+
+	media := &Media{
+		Type:       offered.Type,
+		Port:       capability.Port,
+		PortCount:  capability.PortCount,
+		Proto:      offered.Proto,
+		Info:       capability.Info,
+		Connection: capability.Connection.Copy(),
+		Bandwidths: append([]Bandwidth(nil), capability.Bandwidths...),
+	}
+	for _, codec := range codecs {
+		media.AddCodec(codec)
+	}
+	for _, attr := range capability.Attributes {
+		key := strings.ToLower(attr.Key)
+		if _, ok := directionOf(key); ok || key == "rtpmap" || key == "fmtp" {
+			continue
+		}
+		media.Attributes = append(media.Attributes, attr)
+	}
+
+	// 方向取交集：对方发送且本地接收时接收，对方接收且本地发送时发送
+	offeredDirection := offer.MediaDirection(offered)
+	localDirection := local.MediaDirection(capability)
+	media.SetDirection(NewDirection(
+		offeredDirection.Recv() && localDirection.Send(),
+		offeredDirection.Send() && localDirection.Recv(),
+	))
+
+	return media
+}
+
+// offer/answer 协商状态 RFC 3264
+type Negotiator struct {
+	// 本地能力
+	local *Session
+	// 最后一次发送的 offer 或者 answer
+	sent *Session
+	// 已发送、尚未收到应答的 offer
+	pending *Session
+	// 对方最后一次的 offer 或者 answer
+	remote *Session
+
+	mu sync.Mutex
+}
+
+/**
+创建 offer/answer 协商：
+	local：本地能力，用于生成 offer 以及应答
+同一个协商中的描述使用相同的会话 ID，内容变化时版本递增 RFC 3264 - 8
+*/
+func NewNegotiator(local *Session) *Negotiator {
+	return &Negotiator{
+		local: local.Copy(),
+	}
+}
+
+// 更新本地能力，之后生成的 offer 或者应答使用新的能力，例如保持通话时修改方向
+func (n *Negotiator) SetLocal(local *Session) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.local = local.Copy()
+}
+
+// 最后一次发送的描述
+func (n *Negotiator) Local() *Session {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.sent.Copy()
+}
+
+// 对方最后一次的描述
+func (n *Negotiator) Remote() *Session {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.remote.Copy()
+}
+
+// 生成 offer，上一个 offer 尚未收到应答时返回错误
+func (n *Negotiator) CreateOffer() (*Session, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.pending != nil {
+		return nil, fmt.Errorf("[sdp] -> offer %d is waiting for answer", n.pending.Origin.SessionVersion)
+	}
+
+	offer := n.local.Copy()
+	// RFC 3264 - 8 新的 offer 不能减少 m= 的数量，多余的媒体端口为 0
+	if n.sent != nil {
+		for i := len(offer.Media); i < len(n.sent.Media); i++ {
+			old := n.sent.Media[i]
+			offer.Media = append(offer.Media, &Media{
+				Type:    old.Type,
+				Port:    0,
+				Proto:   old.Proto,
+				Formats: append([]string(nil), old.Formats...),
+			})
+		}
+	}
+	offer = n.stamp(offer)
+	n.pending = offer
+	n.sent = offer
+
+	return offer.Copy(), nil
+}
+
+// 处理对方的应答，校验 m= 与 offer 一一对应
+func (n *Negotiator) ReceiveAnswer(answer *Session) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.pending == nil {
+		return fmt.Errorf("[sdp] -> no offer is waiting for answer")
+	}
+	if len(answer.Media) != len(n.pending.Media) {
+		return fmt.Errorf("[sdp] -> answer has %d media, offer has %d", len(answer.Media), len(n.pending.Media))
+	}
+	for i, media := range answer.Media {
+		if media.Type != n.pending.Media[i].Type {
+			return fmt.Errorf("[sdp] -> answer media %d is %s, offer is %s", i, media.Type, n.pending.Media[i].Type)
+		}
+	}
+
+	n.pending = nil
+	n.remote = answer.Copy()
+
+	return nil
+}
+
+// 处理对方的 offer 并生成应答，本地 offer 尚未收到应答时返回错误(例如 re-INVITE 冲突，应答 491)
+func (n *Negotiator) ReceiveOffer(offer *Session) (*Session, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.pending != nil {
+		return nil, fmt.Errorf("[sdp] -> offer %d is waiting for answer", n.pending.Origin.SessionVersion)
+	}
+
+	answer, err := Answer(offer, n.local)
+	if err != nil {
+		return nil, err
+	}
+	answer = n.stamp(answer)
+	n.remote = offer.Copy()
+	n.sent = answer
+
+	return answer.Copy(), nil
+}
+
+// 放弃尚未收到应答的 offer，例如 re-INVITE 被拒绝
+func (n *Negotiator) Rollback() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.pending = nil
+}
+
+// 使用上一次描述的会话 ID，内容变化时版本加 1
+func (n *Negotiator) stamp(desc *Session) *Session {
+	if n.sent == nil {
+		return desc
+	}
+
+	desc.Origin.Username = n.sent.Origin.Username
+	desc.Origin.SessionID = n.sent.Origin.SessionID
+	desc.Origin.SessionVersion = n.sent.Origin.SessionVersion
+	if desc.String() != n.sent.String() {
+		desc.Origin.SessionVersion++
+	}
+
+	return desc
+}
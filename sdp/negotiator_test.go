@@ -0,0 +1,198 @@
+package sdp
+
+import (
+	"strings"
+	"testing"
+)
+
+const offerSdp = "v=0\r\n" +
+	"o=alice 100 100 IN IP4 192.0.2.1\r\n" +
+	"s=call\r\n" +
+	"c=IN IP4 192.0.2.1\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 49170 RTP/AVP 0 8 111\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=fmtp:111 useinbandfec=1\r\n" +
+	"a=sendonly\r\n" +
+	"m=video 51372 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n"
+
+// 本地能力：音频优先 opus，其次 PCMA，不支持视频
+func localAudio() *Session {
+	s := NewSession("bob", "192.0.2.2")
+	s.AddMedia(NewMedia(MediaAudio, 3456).
+		AddCodec(Codec{Payload: 97, Name: "opus", ClockRate: 48000, Channels: 2}).
+		AddCodec(Codec{Payload: 8, Name: "PCMA", ClockRate: 8000}).
+		AddAttribute("ptime", "20"))
+
+	return s
+}
+
+func formats(media *Media) string {
+	return strings.Join(media.Formats, " ")
+}
+
+func TestAnswer(t *testing.T) {
+	offer := parse(t, offerSdp)
+	answer, err := Answer(offer, localAudio())
+	if err != nil {
+		t.Fatalf("answer failed: %s", err)
+	}
+
+	if answer.Origin.Username != "bob" || answer.Name != "call" {
+		t.Errorf("origin = %+v, name = %s", answer.Origin, answer.Name)
+	}
+	if len(answer.Media) != len(offer.Media) {
+		t.Fatalf("%d media in answer, want %d", len(answer.Media), len(offer.Media))
+	}
+
+	// 编码按本地优先级排列，使用 offer 中的负载类型以及 fmtp
+	audio := answer.Media[0]
+	if audio.Port != 3456 || formats(audio) != "111 8" {
+		t.Errorf("audio = %s, want port 3456 with 111 8", audio.mediaLine())
+	}
+	if codec, _ := audio.Codec("111"); codec.Name != "opus" || codec.Fmtp != "useinbandfec=1" {
+		t.Errorf("codec 111 = %+v", codec)
+	}
+	if ptime, _ := audio.GetAttribute("ptime"); ptime != "20" {
+		t.Errorf("ptime = %q, want local attribute", ptime)
+	}
+	// 对方只发送时本地只接收
+	if direction := answer.MediaDirection(audio); direction != RecvOnly {
+		t.Errorf("audio direction = %s, want recvonly", direction)
+	}
+
+	// 不支持的媒体端口为 0，格式与 offer 相同
+	video := answer.Media[1]
+	if !video.Rejected() || video.Type != MediaVideo || formats(video) != "96" {
+		t.Errorf("video = %s, want rejected", video.mediaLine())
+	}
+
+	// 应答可以被解析并且与 offer 一一对应
+	if reparsed := parse(t, answer.String()); len(reparsed.Media) != 2 || reparsed.Media[0].Port != 3456 {
+		t.Errorf("answer reparsed as %+v", reparsed)
+	}
+}
+
+func TestAnswerDirection(t *testing.T) {
+	tests := []struct {
+		offered Direction
+		local   Direction
+		want    Direction
+	}{
+		{SendRecv, SendRecv, SendRecv},
+		{SendOnly, SendRecv, RecvOnly},
+		{RecvOnly, SendRecv, SendOnly},
+		{Inactive, SendRecv, Inactive},
+		{SendRecv, SendOnly, SendOnly},
+		{SendOnly, SendOnly, Inactive},
+		{RecvOnly, RecvOnly, Inactive},
+	}
+
+	for _, test := range tests {
+		offer := NewSession("alice", "192.0.2.1")
+		// 对方使用会话级方向属性
+		offer.AddAttribute(string(test.offered), "")
+		offer.AddMedia(NewMedia(MediaAudio, 49170).AddCodec(Codec{Payload: 0, Name: "PCMU", ClockRate: 8000}))
+		local := NewSession("bob", "192.0.2.2")
+		local.AddMedia(NewMedia(MediaAudio, 3456).AddCodec(Codec{Payload: 0, Name: "PCMU", ClockRate: 8000}).SetDirection(test.local))
+
+		answer, err := Answer(offer, local)
+		if err != nil {
+			t.Fatalf("answer failed: %s", err)
+		}
+		if direction := answer.MediaDirection(answer.Media[0]); direction != test.want {
+			t.Errorf("offer %s, local %s: answer direction = %s, want %s", test.offered, test.local, direction, test.want)
+		}
+	}
+}
+
+// 没有共同编码或者 offer 拒绝的媒体端口为 0
+func TestAnswerRejected(t *testing.T) {
+	offer := parse(t, "v=0\r\no=alice 1 1 IN IP4 192.0.2.1\r\ns=-\r\nt=0 0\r\n"+
+		"m=audio 49170 RTP/AVP 18\r\nm=audio 0 RTP/AVP 8\r\nm=audio 49172 RTP/SAVP 8\r\n")
+	answer, err := Answer(offer, localAudio())
+	if err != nil {
+		t.Fatalf("answer failed: %s", err)
+	}
+	for i, media := range answer.Media {
+		if !media.Rejected() {
+			t.Errorf("media %d = %s, want rejected", i, media.mediaLine())
+		}
+	}
+
+	if _, err := Answer(nil, localAudio()); err == nil {
+		t.Error("answered nil offer")
+	}
+}
+
+func TestNegotiator(t *testing.T) {
+	local := localAudio()
+	alice := NewNegotiator(local)
+	bob := NewNegotiator(parse(t, offerSdp))
+
+	offer, err := alice.CreateOffer()
+	if err != nil {
+		t.Fatalf("create offer failed: %s", err)
+	}
+	if _, err := alice.CreateOffer(); err == nil {
+		t.Error("created offer while waiting for answer")
+	}
+	// offer 尚未收到应答时收到对方的 offer
+	if _, err := alice.ReceiveOffer(parse(t, offerSdp)); err == nil {
+		t.Error("received offer while waiting for answer")
+	}
+
+	answer, err := bob.ReceiveOffer(offer)
+	if err != nil {
+		t.Fatalf("receive offer failed: %s", err)
+	}
+	if err := alice.ReceiveAnswer(&Session{}); err == nil {
+		t.Error("accepted answer without media")
+	}
+	if err := alice.ReceiveAnswer(answer); err != nil {
+		t.Fatalf("receive answer failed: %s", err)
+	}
+	if err := alice.ReceiveAnswer(answer); err == nil {
+		t.Error("accepted answer without offer")
+	}
+	if alice.Remote().String() != answer.String() || bob.Remote().String() != offer.String() {
+		t.Error("remote descriptions not saved")
+	}
+
+	// 内容不变时版本不变
+	same, err := alice.CreateOffer()
+	if err != nil {
+		t.Fatalf("create offer failed: %s", err)
+	}
+	if same.Origin.SessionID != offer.Origin.SessionID || same.Origin.SessionVersion != offer.Origin.SessionVersion {
+		t.Errorf("origin of unchanged offer = %+v, want %+v", same.Origin, offer.Origin)
+	}
+	alice.Rollback()
+
+	// 保持通话：内容变化时会话 ID 不变，版本加 1
+	hold := local.Copy()
+	hold.Media[0].SetDirection(SendOnly)
+	alice.SetLocal(hold)
+	reoffer, err := alice.CreateOffer()
+	if err != nil {
+		t.Fatalf("create offer failed: %s", err)
+	}
+	if reoffer.Origin.SessionID != offer.Origin.SessionID || reoffer.Origin.SessionVersion != offer.Origin.SessionVersion+1 {
+		t.Errorf("origin of changed offer = %+v, want version %d", reoffer.Origin, offer.Origin.SessionVersion+1)
+	}
+	if alice.Local().String() != reoffer.String() {
+		t.Error("local description is not the last offer")
+	}
+	alice.Rollback()
+
+	// 新的 offer 不能减少 m= 的数量
+	alice.SetLocal(NewSession("alice", "192.0.2.1"))
+	fewer, err := alice.CreateOffer()
+	if err != nil {
+		t.Fatalf("create offer failed: %s", err)
+	}
+	if len(fewer.Media) != 1 || !fewer.Media[0].Rejected() || fewer.Media[0].Type != MediaAudio {
+		t.Errorf("offer with fewer media = %+v", fewer.Media)
+	}
+}
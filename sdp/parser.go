@@ -0,0 +1,216 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 解析会话描述 RFC 4566 - 5，兼容 GB28181 的 y=、f= 行
+func Parse(body string) (*Session, error) {
+	s := &Session{}
+	var media *Media
+	seenVersion := false
+
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if len(line) < 2 || line[1] != '=' {
+			return nil, fmt.Errorf("[sdp] -> invalid line %d: %q", i+1, line)
+		}
+		typ, value := line[0], line[2:]
+
+		if !seenVersion && typ != 'v' {
+			return nil, fmt.Errorf("[sdp] -> session description must start with v=, got %q", line)
+		}
+
+		var err error
+		switch typ {
+		case 'v':
+			if seenVersion {
+				return nil, fmt.Errorf("[sdp] -> duplicate v= at line %d", i+1)
+			}
+			seenVersion = true
+			s.Version, err = strconv.Atoi(strings.TrimSpace(value))
+		case 'o':
+			s.Origin, err = parseOrigin(value)
+		case 's':
+			s.Name = value
+		case 'i':
+			if media != nil {
+				media.Info = value
+			} else {
+				s.Info = value
+			}
+		case 'u':
+			s.URI = value
+		case 'e':
+			s.Emails = append(s.Emails, value)
+		case 'p':
+			s.Phones = append(s.Phones, value)
+		case 'c':
+			var conn *Connection
+			if conn, err = parseConnection(value); err == nil {
+				if media != nil {
+					media.Connection = conn
+				} else {
+					s.Connection = conn
+				}
+			}
+		case 'b':
+			var bw Bandwidth
+			if bw, err = parseBandwidth(value); err == nil {
+				if media != nil {
+					media.Bandwidths = append(media.Bandwidths, bw)
+				} else {
+					s.Bandwidths = append(s.Bandwidths, bw)
+				}
+			}
+		case 't':
+			var timing Timing
+			if timing, err = parseTiming(value); err == nil {
+				s.Timings = append(s.Timings, timing)
+			}
+		case 'r':
+			if len(s.Timings) == 0 {
+				err = fmt.Errorf("r= without t=")
+			} else {
+				last := &s.Timings[len(s.Timings)-1]
+				last.Repeats = append(last.Repeats, value)
+			}
+		case 'z':
+			s.TimeZones = value
+		case 'k':
+			if media != nil {
+				media.Key = value
+			} else {
+				s.Key = value
+			}
+		case 'a':
+			attr := parseAttribute(value)
+			if media != nil {
+				media.Attributes = append(media.Attributes, attr)
+			} else {
+				s.Attributes = append(s.Attributes, attr)
+			}
+		case 'm':
+			if media, err = parseMedia(value); err == nil {
+				s.Media = append(s.Media, media)
+			}
+		case 'y':
+			s.SSRC = strings.TrimSpace(value)
+		case 'f':
+			s.Format = strings.TrimSpace(value)
+		default:
+			// 忽略不认识的行
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[sdp] -> invalid line %d %q: %w", i+1, line, err)
+		}
+	}
+
+	if !seenVersion {
+		return nil, fmt.Errorf("[sdp] -> empty session description")
+	}
+
+	return s, nil
+}
+
+func parseOrigin(value string) (Origin, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 6 {
+		return Origin{}, fmt.Errorf("expected 6 fields, got %d", len(fields))
+	}
+	id, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return Origin{}, err
+	}
+	version, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return Origin{}, err
+	}
+
+	return Origin{
+		Username:       fields[0],
+		SessionID:      id,
+		SessionVersion: version,
+		NetType:        fields[3],
+		AddrType:       fields[4],
+		Address:        fields[5],
+	}, nil
+}
+
+func parseConnection(value string) (*Connection, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("expected 3 fields, got %d", len(fields))
+	}
+
+	return &Connection{
+		NetType:  fields[0],
+		AddrType: fields[1],
+		Address:  fields[2],
+	}, nil
+}
+
+func parseBandwidth(value string) (Bandwidth, error) {
+	typ, bw, ok := cut(value, ":")
+	if !ok {
+		return Bandwidth{}, fmt.Errorf("missing ':'")
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(bw))
+	if err != nil {
+		return Bandwidth{}, err
+	}
+
+	return Bandwidth{Type: typ, Value: n}, nil
+}
+
+func parseTiming(value string) (Timing, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return Timing{}, fmt.Errorf("expected 2 fields, got %d", len(fields))
+	}
+	start, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return Timing{}, err
+	}
+	stop, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return Timing{}, err
+	}
+
+	return Timing{Start: start, Stop: stop}, nil
+}
+
+func parseAttribute(value string) Attribute {
+	key, val, _ := cut(value, ":")
+	return Attribute{Key: key, Value: val}
+}
+
+func parseMedia(value string) (*Media, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("expected at least 3 fields, got %d", len(fields))
+	}
+
+	media := &Media{
+		Type:    fields[0],
+		Proto:   fields[2],
+		Formats: append([]string(nil), fields[3:]...),
+	}
+	port, count, hasCount := cut(fields[1], "/")
+	var err error
+	if media.Port, err = strconv.Atoi(port); err != nil {
+		return nil, err
+	}
+	if hasCount {
+		if media.PortCount, err = strconv.Atoi(count); err != nil {
+			return nil, err
+		}
+	}
+
+	return media, nil
+}
@@ -0,0 +1,234 @@
+package sdp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 字段顺序与 Session.String 相同的会话描述，解析后序列化不变
+const (
+	audioVideoSdp = "v=0\r\n" +
+		"o=alice 2890844526 2890844527 IN IP4 192.0.2.1\r\n" +
+		"s=call\r\n" +
+		"i=audio and video\r\n" +
+		"e=alice@example.com\r\n" +
+		"c=IN IP4 192.0.2.1\r\n" +
+		"b=AS:256\r\n" +
+		"t=0 0\r\n" +
+		"a=tool:gsip\r\n" +
+		"m=audio 49170 RTP/AVP 0 8 97\r\n" +
+		"a=rtpmap:97 opus/48000/2\r\n" +
+		"a=fmtp:97 useinbandfec=1\r\n" +
+		"a=sendrecv\r\n" +
+		"m=video 51372/2 RTP/AVP 96\r\n" +
+		"c=IN IP4 192.0.2.3\r\n" +
+		"b=AS:192\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"a=fmtp:96 profile-level-id=42e01f\r\n" +
+		"a=recvonly\r\n"
+
+	// GB28181 - 附录 F 实时点播
+	gbPlaySdp = "v=0\r\n" +
+		"o=34020000002000000001 0 0 IN IP4 192.168.1.10\r\n" +
+		"s=Play\r\n" +
+		"c=IN IP4 192.168.1.10\r\n" +
+		"t=0 0\r\n" +
+		"m=video 6000 RTP/AVP 96 98 97\r\n" +
+		"a=recvonly\r\n" +
+		"a=rtpmap:96 PS/90000\r\n" +
+		"a=rtpmap:98 H264/90000\r\n" +
+		"a=rtpmap:97 MPEG4/90000\r\n" +
+		"y=0100000001\r\n" +
+		"f=v/2/4///a///\r\n"
+)
+
+func parse(t *testing.T, body string) *Session {
+	t.Helper()
+	s, err := Parse(body)
+	if err != nil {
+		t.Fatalf("parse failed: %s", err)
+	}
+
+	return s
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	for _, body := range []string{audioVideoSdp, gbPlaySdp} {
+		s := parse(t, body)
+		if str := s.String(); str != body {
+			t.Errorf("serialized as\n%s\nwant\n%s", str, body)
+		}
+		// 副本与原会话描述相同，修改副本不影响原会话描述
+		c := s.Copy()
+		if !reflect.DeepEqual(c, s) {
+			t.Errorf("copy = %+v, want %+v", c, s)
+		}
+		c.Media[0].Formats[0] = "126"
+		c.Media[0].Attributes[0].Value = "changed"
+		if s.String() != body {
+			t.Error("modifying copy changed the session description")
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	s := parse(t, audioVideoSdp)
+
+	origin := Origin{
+		Username:       "alice",
+		SessionID:      2890844526,
+		SessionVersion: 2890844527,
+		NetType:        "IN",
+		AddrType:       "IP4",
+		Address:        "192.0.2.1",
+	}
+	if s.Origin != origin {
+		t.Errorf("origin = %+v, want %+v", s.Origin, origin)
+	}
+	if s.Name != "call" || s.Info != "audio and video" || len(s.Emails) != 1 {
+		t.Errorf("session = %+v", s)
+	}
+	if len(s.Bandwidths) != 1 || s.Bandwidths[0] != (Bandwidth{Type: "AS", Value: 256}) {
+		t.Errorf("bandwidths = %v", s.Bandwidths)
+	}
+	if tool, ok := s.GetAttribute("tool"); !ok || tool != "gsip" {
+		t.Errorf("a=tool = %q, %t", tool, ok)
+	}
+	if len(s.Media) != 2 {
+		t.Fatalf("%d media, want 2", len(s.Media))
+	}
+
+	audio := s.GetMedia(MediaAudio)
+	codecs := []Codec{
+		{Payload: 0, Name: "PCMU", ClockRate: 8000, Channels: 1},
+		{Payload: 8, Name: "PCMA", ClockRate: 8000, Channels: 1},
+		{Payload: 97, Name: "opus", ClockRate: 48000, Channels: 2, Fmtp: "useinbandfec=1"},
+	}
+	if !reflect.DeepEqual(audio.Codecs(), codecs) {
+		t.Errorf("audio codecs = %+v, want %+v", audio.Codecs(), codecs)
+	}
+	if conn := s.MediaConnection(audio); conn.Address != "192.0.2.1" {
+		t.Errorf("audio connection = %s, want session connection", conn)
+	}
+
+	video := s.GetMedia(MediaVideo)
+	if video.Port != 51372 || video.PortCount != 2 || video.Proto != ProtoRtpAvp {
+		t.Errorf("video media = %s", video.mediaLine())
+	}
+	if conn := s.MediaConnection(video); conn.Address != "192.0.2.3" {
+		t.Errorf("video connection = %s, want media connection", conn)
+	}
+	if direction := s.MediaDirection(video); direction != RecvOnly || direction.Send() || !direction.Recv() {
+		t.Errorf("video direction = %s, want recvonly", direction)
+	}
+	if codec, ok := video.Codec("96"); !ok || codec.Name != "H264" || codec.Fmtp != "profile-level-id=42e01f" {
+		t.Errorf("video codec 96 = %+v, %t", codec, ok)
+	}
+	if _, ok := video.Codec("100"); ok {
+		t.Error("codec of unknown payload type found")
+	}
+}
+
+func TestParseGB28181(t *testing.T) {
+	s := parse(t, gbPlaySdp)
+
+	if s.SSRC != "0100000001" || s.Format != "v/2/4///a///" {
+		t.Errorf("y= %q, f= %q", s.SSRC, s.Format)
+	}
+	video := s.GetMedia(MediaVideo)
+	if video == nil {
+		t.Fatal("no video media")
+	}
+	var names []string
+	for _, codec := range video.Codecs() {
+		names = append(names, codec.Name)
+	}
+	if strings.Join(names, " ") != "PS H264 MPEG4" {
+		t.Errorf("codecs = %v, want PS H264 MPEG4", names)
+	}
+	if s.MediaDirection(video) != RecvOnly {
+		t.Errorf("direction = %s, want recvonly", s.MediaDirection(video))
+	}
+}
+
+// 媒体级方向优先于会话级方向，都没有时为 sendrecv
+func TestMediaDirection(t *testing.T) {
+	s := parse(t, "v=0\r\no=- 1 1 IN IP4 192.0.2.1\r\ns=-\r\nt=0 0\r\na=sendonly\r\n"+
+		"m=audio 49170 RTP/AVP 0\r\nm=video 51372 RTP/AVP 96\r\na=inactive\r\n")
+	if direction := s.MediaDirection(s.Media[0]); direction != SendOnly {
+		t.Errorf("audio direction = %s, want session sendonly", direction)
+	}
+	if direction := s.MediaDirection(s.Media[1]); direction != Inactive {
+		t.Errorf("video direction = %s, want inactive", direction)
+	}
+	if direction := s.Media[0].Direction(); direction != SendRecv {
+		t.Errorf("audio media direction = %s, want sendrecv", direction)
+	}
+
+	s.Media[1].SetDirection(SendRecv)
+	if value := s.Media[1].Attributes; len(value) != 1 || value[0].Key != "sendrecv" {
+		t.Errorf("attributes after SetDirection = %v", value)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"empty", "\r\n"},
+		{"without version", "o=- 1 1 IN IP4 192.0.2.1\r\n"},
+		{"duplicate version", "v=0\r\nv=0\r\n"},
+		{"invalid line", "v=0\r\ngarbage\r\n"},
+		{"invalid origin", "v=0\r\no=- 1 IN IP4 192.0.2.1\r\n"},
+		{"invalid connection", "v=0\r\nc=IN IP4\r\n"},
+		{"invalid bandwidth", "v=0\r\nb=AS\r\n"},
+		{"repeat without timing", "v=0\r\nr=7d 1h 0 25h\r\n"},
+		{"invalid media port", "v=0\r\nm=audio port RTP/AVP 0\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if s, err := Parse(test.body); err == nil {
+				t.Errorf("parsed as %+v", s)
+			}
+		})
+	}
+}
+
+// 构建的会话描述：静态负载类型不添加 rtpmap，动态负载类型添加 rtpmap 以及 fmtp
+func TestBuild(t *testing.T) {
+	s := NewSession("alice", "192.0.2.1")
+	audio := NewMedia(MediaAudio, 49170).
+		AddCodec(Codec{Payload: 0, Name: "PCMU", ClockRate: 8000}).
+		AddCodec(Codec{Payload: 101, Name: "telephone-event", ClockRate: 8000, Fmtp: "0-16"}).
+		SetDirection(SendOnly)
+	s.AddMedia(audio)
+
+	parsed := parse(t, s.String())
+	if parsed.Origin != s.Origin || parsed.Origin.AddrType != "IP4" || parsed.Connection.Address != "192.0.2.1" {
+		t.Errorf("origin = %+v, connection = %s", parsed.Origin, parsed.Connection)
+	}
+	media := parsed.GetMedia(MediaAudio)
+	if media.mediaLine() != "audio 49170 RTP/AVP 0 101" {
+		t.Errorf("media line = %s", media.mediaLine())
+	}
+	if _, ok := media.GetAttribute("rtpmap"); !ok || strings.Contains(s.String(), "rtpmap:0") {
+		t.Errorf("rtpmap attributes = %v", media.Attributes)
+	}
+	codecs := []Codec{
+		{Payload: 0, Name: "PCMU", ClockRate: 8000, Channels: 1},
+		{Payload: 101, Name: "telephone-event", ClockRate: 8000, Fmtp: "0-16"},
+	}
+	if !reflect.DeepEqual(media.Codecs(), codecs) {
+		t.Errorf("codecs = %+v, want %+v", media.Codecs(), codecs)
+	}
+	if parsed.MediaDirection(media) != SendOnly {
+		t.Errorf("direction = %s, want sendonly", parsed.MediaDirection(media))
+	}
+
+	if ipv6 := NewSession("-", "2001:db8::1"); ipv6.Origin.AddrType != "IP6" || ipv6.Connection.AddrType != "IP6" {
+		t.Errorf("address type of IPv6 = %s", ipv6.Origin.AddrType)
+	}
+}
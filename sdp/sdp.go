@@ -0,0 +1,373 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 会话描述 RFC 4566 - 5
+type Session struct {
+	// v=
+	Version int
+	// o=
+	Origin Origin
+	// s=
+	Name string
+	// i=
+	Info string
+	// u=，GB28181 中为回放的通道 ID
+	URI string
+	// e=
+	Emails []string
+	// p=
+	Phones []string
+	// c=
+	Connection *Connection
+	// b=
+	Bandwidths []Bandwidth
+	// t= 以及 r=
+	Timings []Timing
+	// z=
+	TimeZones string
+	// k=
+	Key string
+	// a=
+	Attributes []Attribute
+	// m=
+	Media []*Media
+
+	// GB28181 - 附录 F：y= 十进制 SSRC
+	SSRC string
+	// GB28181 - 附录 F：f= 媒体描述
+	Format string
+}
+
+// o=<username> <sess-id> <sess-version> <nettype> <addrtype> <unicast-address>
+type Origin struct {
+	Username       string
+	SessionID      uint64
+	SessionVersion uint64
+	NetType        string
+	AddrType       string
+	Address        string
+}
+
+func (origin Origin) String() string {
+	username := origin.Username
+	if username == "" {
+		username = "-"
+	}
+
+	return fmt.Sprintf("%s %d %d %s %s %s", username, origin.SessionID, origin.SessionVersion,
+		origin.NetType, origin.AddrType, origin.Address)
+}
+
+// c=<nettype> <addrtype> <connection-address>
+type Connection struct {
+	NetType  string
+	AddrType string
+	Address  string
+}
+
+func (conn *Connection) String() string {
+	return fmt.Sprintf("%s %s %s", conn.NetType, conn.AddrType, conn.Address)
+}
+
+func (conn *Connection) Copy() *Connection {
+	if conn == nil {
+		return nil
+	}
+	c := *conn
+	return &c
+}
+
+// b=<bwtype>:<bandwidth>
+type Bandwidth struct {
+	Type  string
+	Value int
+}
+
+func (bw Bandwidth) String() string {
+	return fmt.Sprintf("%s:%d", bw.Type, bw.Value)
+}
+
+// t=<start-time> <stop-time>，Repeats 为之后的 r= 行
+type Timing struct {
+	Start   uint64
+	Stop    uint64
+	Repeats []string
+}
+
+// a=<attribute> 或者 a=<attribute>:<value>
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+func (attr Attribute) String() string {
+	if attr.Value == "" {
+		return attr.Key
+	}
+
+	return attr.Key + ":" + attr.Value
+}
+
+// 媒体描述 RFC 4566 - 5.14
+type Media struct {
+	// m=<media> <port>/<number of ports> <proto> <fmt> ...
+	Type      string
+	Port      int
+	PortCount int
+	Proto     string
+	Formats   []string
+	// i=
+	Info string
+	// c=
+	Connection *Connection
+	// b=
+	Bandwidths []Bandwidth
+	// k=
+	Key string
+	// a=
+	Attributes []Attribute
+}
+
+/**
+创建会话描述：
+	username：o= 中的用户名，GB28181 中一般为设备或者平台 ID
+	address：o= 以及 c= 中的 IPv4 地址
+会话 ID 与版本默认使用当前 NTP 时间
+*/
+func NewSession(username string, address string) *Session {
+	id := ntpTime()
+	addrType := "IP4"
+	if strings.Contains(address, ":") {
+		addrType = "IP6"
+	}
+
+	return &Session{
+		Version: 0,
+		Origin: Origin{
+			Username:       username,
+			SessionID:      id,
+			SessionVersion: id,
+			NetType:        "IN",
+			AddrType:       addrType,
+			Address:        address,
+		},
+		Name: "-",
+		Connection: &Connection{
+			NetType:  "IN",
+			AddrType: addrType,
+			Address:  address,
+		},
+		Timings: []Timing{{Start: 0, Stop: 0}},
+	}
+}
+
+// 创建媒体描述，协议为 RTP/AVP
+func NewMedia(mediaType string, port int) *Media {
+	return &Media{
+		Type:  mediaType,
+		Port:  port,
+		Proto: ProtoRtpAvp,
+	}
+}
+
+// 添加媒体描述
+func (s *Session) AddMedia(media *Media) *Session {
+	s.Media = append(s.Media, media)
+	return s
+}
+
+// 返回指定类型的第一个媒体描述
+func (s *Session) GetMedia(mediaType string) *Media {
+	for _, media := range s.Media {
+		if media.Type == mediaType {
+			return media
+		}
+	}
+
+	return nil
+}
+
+// 返回会话级属性的值
+func (s *Session) GetAttribute(key string) (string, bool) {
+	return getAttribute(s.Attributes, key)
+}
+
+// 添加会话级属性
+func (s *Session) AddAttribute(key string, value string) *Session {
+	s.Attributes = append(s.Attributes, Attribute{Key: key, Value: value})
+	return s
+}
+
+// 媒体的传输方向：媒体级属性优先，其次是会话级属性，默认为 sendrecv RFC 4566 - 6
+func (s *Session) MediaDirection(media *Media) Direction {
+	if direction, ok := attributesDirection(media.Attributes); ok {
+		return direction
+	}
+	if direction, ok := attributesDirection(s.Attributes); ok {
+		return direction
+	}
+
+	return SendRecv
+}
+
+// 媒体的连接地址：媒体级 c= 优先，其次是会话级 c=
+func (s *Session) MediaConnection(media *Media) *Connection {
+	if media.Connection != nil {
+		return media.Connection
+	}
+
+	return s.Connection
+}
+
+func (s *Session) Copy() *Session {
+	if s == nil {
+		return nil
+	}
+
+	c := *s
+	c.Emails = append([]string(nil), s.Emails...)
+	c.Phones = append([]string(nil), s.Phones...)
+	c.Connection = s.Connection.Copy()
+	c.Bandwidths = append([]Bandwidth(nil), s.Bandwidths...)
+	c.Timings = make([]Timing, 0, len(s.Timings))
+	for _, timing := range s.Timings {
+		timing.Repeats = append([]string(nil), timing.Repeats...)
+		c.Timings = append(c.Timings, timing)
+	}
+	c.Attributes = append([]Attribute(nil), s.Attributes...)
+	c.Media = make([]*Media, 0, len(s.Media))
+	for _, media := range s.Media {
+		c.Media = append(c.Media, media.Copy())
+	}
+
+	return &c
+}
+
+// 序列化会话描述，字段顺序遵循 RFC 4566 - 5，GB28181 的 y=、f= 放在最后
+func (s *Session) String() string {
+	var buffer strings.Builder
+
+	writeLine := func(typ byte, value string) {
+		buffer.WriteByte(typ)
+		buffer.WriteByte('=')
+		buffer.WriteString(value)
+		buffer.WriteString("\r\n")
+	}
+
+	writeLine('v', strconv.Itoa(s.Version))
+	writeLine('o', s.Origin.String())
+	name := s.Name
+	if name == "" {
+		// RFC 4566 - 5.3 s= 不能为空
+		name = "-"
+	}
+	writeLine('s', name)
+	if s.Info != "" {
+		writeLine('i', s.Info)
+	}
+	if s.URI != "" {
+		writeLine('u', s.URI)
+	}
+	for _, email := range s.Emails {
+		writeLine('e', email)
+	}
+	for _, phone := range s.Phones {
+		writeLine('p', phone)
+	}
+	if s.Connection != nil {
+		writeLine('c', s.Connection.String())
+	}
+	for _, bw := range s.Bandwidths {
+		writeLine('b', bw.String())
+	}
+	timings := s.Timings
+	if len(timings) == 0 {
+		timings = []Timing{{Start: 0, Stop: 0}}
+	}
+	for _, timing := range timings {
+		writeLine('t', fmt.Sprintf("%d %d", timing.Start, timing.Stop))
+		for _, repeat := range timing.Repeats {
+			writeLine('r', repeat)
+		}
+	}
+	if s.TimeZones != "" {
+		writeLine('z', s.TimeZones)
+	}
+	if s.Key != "" {
+		writeLine('k', s.Key)
+	}
+	for _, attr := range s.Attributes {
+		writeLine('a', attr.String())
+	}
+
+	for _, media := range s.Media {
+		writeLine('m', media.mediaLine())
+		if media.Info != "" {
+			writeLine('i', media.Info)
+		}
+		if media.Connection != nil {
+			writeLine('c', media.Connection.String())
+		}
+		for _, bw := range media.Bandwidths {
+			writeLine('b', bw.String())
+		}
+		if media.Key != "" {
+			writeLine('k', media.Key)
+		}
+		for _, attr := range media.Attributes {
+			writeLine('a', attr.String())
+		}
+	}
+
+	if s.SSRC != "" {
+		writeLine('y', s.SSRC)
+	}
+	if s.Format != "" {
+		writeLine('f', s.Format)
+	}
+
+	return buffer.String()
+}
+
+func (m *Media) mediaLine() string {
+	port := strconv.Itoa(m.Port)
+	if m.PortCount > 1 {
+		port += "/" + strconv.Itoa(m.PortCount)
+	}
+	formats := m.Formats
+	if len(formats) == 0 {
+		// RFC 4566 - 5.14 至少需要一个格式
+		formats = []string{"0"}
+	}
+
+	return fmt.Sprintf("%s %s %s %s", m.Type, port, m.Proto, strings.Join(formats, " "))
+}
+
+func (m *Media) Copy() *Media {
+	if m == nil {
+		return nil
+	}
+
+	c := *m
+	c.Formats = append([]string(nil), m.Formats...)
+	c.Connection = m.Connection.Copy()
+	c.Bandwidths = append([]Bandwidth(nil), m.Bandwidths...)
+	c.Attributes = append([]Attribute(nil), m.Attributes...)
+
+	return &c
+}
+
+func getAttribute(attrs []Attribute, key string) (string, bool) {
+	for _, attr := range attrs {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Value, true
+		}
+	}
+
+	return "", false
+}
@@ -1,22 +1,45 @@
 package gsip
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/zenghr0820/gsip/callback"
 	"github.com/zenghr0820/gsip/logger"
 	"github.com/zenghr0820/gsip/sip"
+	"github.com/zenghr0820/gsip/transaction"
 	"github.com/zenghr0820/gsip/utils"
 )
 
+// 同一请求自动重新认证的最大次数
+const authMaxAttempts = 2
+
 type service struct {
 	opts Options
-	// session
-	session map[string]sip.Session
+	// 同步请求等待的响应
+	pending map[sip.Request]*pendingResponses
+	// 认证后重发的请求已重新认证的次数，事务结束时删除
+	authAttempts map[sip.Request]int
+	pmu          sync.RWMutex
+	// 自动刷新的注册，关闭服务时注销
+	registrations map[*Registration]struct{}
+	rmu           sync.Mutex
+	// 订阅方的订阅、通知方的订阅以及转移方的隐式订阅
+	subscriptions map[string]*Subscription
+	subscribers   map[string]*Subscriber
+	transfers     map[string]*Transfer
+	smu           sync.RWMutex
+	// 有状态代理正在转发的请求
+	proxies map[string]*proxyContext
+	xmu     sync.Mutex
+	// B2BUA 的呼叫，两侧的 Call-ID 都指向同一呼叫
+	calls map[string]*Call
+	cmu   sync.RWMutex
 
 	close chan bool
 	hwg   sync.WaitGroup
@@ -26,13 +49,25 @@ type service struct {
 func newService(opts ...Option) Service {
 	service := new(service)
 	service.opts = newOptions(opts...)
-	service.session = make(map[string]sip.Session)
+	service.pending = make(map[sip.Request]*pendingResponses)
+	service.authAttempts = make(map[sip.Request]int)
+	service.registrations = make(map[*Registration]struct{})
+	service.subscriptions = make(map[string]*Subscription)
+	service.subscribers = make(map[string]*Subscriber)
+	service.transfers = make(map[string]*Transfer)
+	service.proxies = make(map[string]*proxyContext)
+	service.calls = make(map[string]*Call)
+	service.close = make(chan bool)
 	// 开启 goroutine 监听 SIP 服务
 	go service.start()
 	return service
 }
 
 func (s *service) Listen(network string, listenAddr string) error {
+	if s.opts.err != nil {
+		return s.opts.err
+	}
+
 	return s.opts.tp.Listen(network, listenAddr)
 }
 
@@ -49,8 +84,399 @@ func (s *service) Send(message sip.Message) (sip.Transaction, error) {
 	return s.opts.tx.Send(message)
 }
 
-func (s *service) Session(key string) sip.Session {
-	return s.opts.tx.Session(key)
+// 发送请求，timers 覆盖事务层配置的定时器
+func (s *service) sendRequest(req sip.Request, timers []transaction.TimerOption) (sip.Transaction, error) {
+	if len(timers) == 0 {
+		return s.Send(req)
+	}
+
+	select {
+	case <-s.close:
+		return nil, fmt.Errorf("[G.SIP] -> G.SIP Service Closed")
+	default:
+	}
+
+	s.autoFillMessageHeaderAndSend(req)
+	return s.opts.tx.SendRequest(req, timers...)
+}
+
+/**
+ * 发送请求并等待最终响应
+ *
+ * @param ctx context.Context 取消时 INVITE 请求发送 CANCEL，非 INVITE 请求停止事务
+ * @param req sip.Request 请求(不能为 ACK)
+ * @param opts RequestOption 可选配置，如接收临时响应 ProvisionalCallback
+ * @return sip.Response 最终响应；INVITE 被取消时返回 CANCEL 后收到的最终响应以及 ctx.Err()
+ */
+func (s *service) Request(ctx context.Context, req sip.Request, opts ...RequestOption) (sip.Response, error) {
+	if req.IsAck() {
+		return nil, fmt.Errorf("[G.SIP] -> ACK request has no response")
+	}
+
+	options := newRequestOptions(opts...)
+	auth := options.auth
+	if auth == nil {
+		auth = s.opts.auth
+	}
+
+	// 发送之前注册，避免响应先于注册到达；认证后重发的请求替换 current
+	current := req
+	responses := s.watchResponses(req)
+	defer func() {
+		s.unwatchResponses(current)
+	}()
+
+	tx, err := s.sendRequest(req, options.timers)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ctxDone      = ctx.Done()
+		txErrs       = tx.Errors()
+		terminated   <-chan time.Time
+		canceling    bool
+		authAttempts int
+	)
+
+	for {
+		select {
+		case res := <-responses:
+			if res.IsProvisional() {
+				if options.provisional != nil {
+					clientTx, _ := tx.(sip.ClientTransaction)
+					options.provisional(res, clientTx)
+				}
+				continue
+			}
+
+			if canceling {
+				return res, ctx.Err()
+			}
+
+			// 401/407 添加认证信息后重发
+			if isChallenge(res) && auth != nil && authAttempts < authMaxAttempts && !req.IsCancel() {
+				authAttempts++
+				retry, err := authorizedRetry(current, res, auth)
+				if err != nil {
+					logger.Warnf("[G.SIP] -> authorize %s failed: %s", req.Short(), err)
+					return res, nil
+				}
+				s.rewatchResponses(current, retry)
+				current = retry
+				if tx, err = s.sendRequest(current, options.timers); err != nil {
+					return nil, err
+				}
+				txErrs = tx.Errors()
+				terminated = nil
+				continue
+			}
+
+			// 422 使用对方的 Min-SE 重发
+			if retry, ok := raiseSessionExpires(current, res); ok {
+				s.rewatchResponses(current, retry)
+				current = retry
+				if tx, err = s.sendRequest(current, options.timers); err != nil {
+					return nil, err
+				}
+				txErrs = tx.Errors()
+				terminated = nil
+				continue
+			}
+
+			return res, nil
+		case err, ok := <-txErrs:
+			if ok {
+				if canceling {
+					return nil, ctx.Err()
+				}
+				return nil, err
+			}
+			// 事务已结束，最终响应可能仍在传递中
+			txErrs = nil
+			terminated = time.After(s.opts.tx.T1(options.timers...))
+		case <-terminated:
+			if canceling {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("[G.SIP] -> transaction of %s terminated without final response", req.Short())
+		case <-ctxDone:
+			ctxDone = nil
+
+			if !req.IsInvite() {
+				if t, ok := tx.(interface{ Close() }); ok {
+					t.Close()
+				}
+				return nil, ctx.Err()
+			}
+
+			// 事务在收到临时响应之后发送 CANCEL，INVITE 以 487 结束，CANCEL 之后 64*T1 内没有最终响应时事务超时
+			canceling = true
+			if clientTx, ok := tx.(sip.ClientTransaction); ok {
+				if err := clientTx.Cancel(); err != nil {
+					logger.Warnf("[G.SIP] -> cancel %s failed: %s", req.Short(), err)
+				}
+			}
+		}
+	}
+}
+
+// 异步发送的请求收到 401/407 时使用 AuthConfig 配置的认证信息重发，返回是否已重发
+func (s *service) reauthorize(res sip.Response) bool {
+	tx := res.Transaction()
+	if tx == nil || tx.Origin() == nil || res.IsProvisional() {
+		return false
+	}
+	req := requestOf(tx)
+
+	s.pmu.RLock()
+	attempts := s.authAttempts[req]
+	s.pmu.RUnlock()
+	if !isChallenge(res) || s.opts.auth == nil || attempts >= authMaxAttempts || req.IsCancel() {
+		return false
+	}
+
+	retry, err := authorizedRetry(req, res, s.opts.auth)
+	if err != nil {
+		logger.Warnf("[G.SIP] -> authorize %s failed: %s", req.Short(), err)
+		return false
+	}
+
+	// 发送之前记录，避免响应先于记录到达
+	s.pmu.Lock()
+	s.authAttempts[retry] = attempts + 1
+	s.pmu.Unlock()
+
+	retryTx, err := s.Send(retry)
+	if err != nil {
+		logger.Errorf("[G.SIP] -> resend authorized %s failed: %s", req.Short(), err)
+
+		s.pmu.Lock()
+		delete(s.authAttempts, retry)
+		s.pmu.Unlock()
+		return false
+	}
+
+	go func() {
+		<-retryTx.Done()
+
+		s.pmu.Lock()
+		delete(s.authAttempts, retry)
+		s.pmu.Unlock()
+	}()
+
+	return true
+}
+
+// 认证后重发的请求：复制请求并添加认证信息，不修改调用方的请求
+func authorizedRetry(req sip.Request, res sip.Response, auth sip.Authorized) (sip.Request, error) {
+	retry := copyForRetry(req)
+	if err := auth.AddAuthInfo(retry, res); err != nil {
+		return nil, err
+	}
+
+	return retry, nil
+}
+
+// 事务对应的上层请求，客户端事务故障转移之后 origin 为使用新 branch 的副本
+func requestOf(tx sip.Transaction) sip.Request {
+	if t, ok := tx.(interface{ Request() sip.Request }); ok {
+		return t.Request()
+	}
+
+	return tx.Origin()
+}
+
+// 重发使用的请求副本，保留显式设置的目的地址，原请求仍是之前事务的 origin
+func copyForRetry(req sip.Request) sip.Request {
+	retry := sip.CopyRequest(req)
+	if dest := req.ExplicitDestination(); dest != "" {
+		retry.SetDestination(dest)
+	}
+
+	return retry
+}
+
+// 异步发送的请求收到 422 时使用对方的 Min-SE 重发，返回是否已重发
+func (s *service) resendSessionInterval(res sip.Response) bool {
+	tx := res.Transaction()
+	if tx == nil || tx.Origin() == nil {
+		return false
+	}
+	retry, ok := raiseSessionExpires(tx.Origin(), res)
+	if !ok {
+		return false
+	}
+
+	if _, err := s.Send(retry); err != nil {
+		logger.Errorf("[G.SIP] -> resend %s with larger session interval failed: %s", retry.Short(), err)
+		return false
+	}
+
+	return true
+}
+
+/**
+请求收到 422 并且对方的 Min-SE 大于请求的 Session-Expires 时，
+返回 Session-Expires 以及 Min-SE 更新为对方的 Min-SE 的请求副本，以及是否可以重发 RFC 4028 - 7.3
+原请求仍是收到 422 的事务的 origin，ACK 的重传需要使用其 branch 以及 CSeq
+*/
+func raiseSessionExpires(req sip.Request, res sip.Response) (sip.Request, bool) {
+	if res.StatusCode() != sip.StatusSessionIntervalTooSmall {
+		return nil, false
+	}
+	se := sip.SessionExpires(req)
+	minSE := sip.MinSE(res)
+	if se == nil || se.Delta >= minSE {
+		return nil, false
+	}
+
+	retry := copyForRetry(req)
+	retry.ReplaceHeader(&sip.SessionExpiresHeader{Delta: minSE, Refresher: se.Refresher, Params: sip.CopyWithNil(se.Params)})
+	retry.ReplaceHeader(&sip.MinSEHeader{Delta: minSE})
+	if viaHop, ok := retry.ViaHop(); ok {
+		viaHop.Params.Add("branch", sip.String{Str: sip.GenerateBranch()})
+	}
+	if cseq := retry.CSeq(); cseq != nil {
+		cseq.SeqNo++
+	}
+
+	return retry, true
+}
+
+// 是否是认证质询响应
+func isChallenge(res sip.Response) bool {
+	return res.StatusCode() == sip.StatusUnauthorized || res.StatusCode() == sip.StatusProxyAuthenticationRequired
+}
+
+// 向注册服务器注册，在有效期到达之前自动刷新，关闭服务时注销
+func (s *service) Register(registrar string, aor sip.Uri, opts ...RegisterOption) *Registration {
+	registration := newRegistration(s, registrar, aor, opts...)
+
+	s.rmu.Lock()
+	s.registrations[registration] = struct{}{}
+	s.rmu.Unlock()
+
+	go registration.run()
+	return registration
+}
+
+func (s *service) removeRegistration(registration *Registration) {
+	s.rmu.Lock()
+	delete(s.registrations, registration)
+	s.rmu.Unlock()
+}
+
+// 注销所有注册
+func (s *service) unregisterAll() {
+	s.rmu.Lock()
+	registrations := make([]*Registration, 0, len(s.registrations))
+	for registration := range s.registrations {
+		registrations = append(registrations, registration)
+	}
+	s.rmu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), unregisterTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, registration := range registrations {
+		wg.Add(1)
+		go func(registration *Registration) {
+			defer wg.Done()
+			if err := registration.Unregister(ctx); err != nil {
+				logger.Warnf("[G.SIP] -> unregister %s failed: %s", registration.aor, err)
+			}
+		}(registration)
+	}
+	wg.Wait()
+}
+
+// 同步请求等待的响应，停止等待时关闭 done
+type pendingResponses struct {
+	responses chan sip.Response
+	done      chan struct{}
+}
+
+// 注册同步请求等待的响应
+func (s *service) watchResponses(req sip.Request) chan sip.Response {
+	pending := &pendingResponses{
+		responses: make(chan sip.Response, 16),
+		done:      make(chan struct{}),
+	}
+
+	s.pmu.Lock()
+	s.pending[req] = pending
+	s.pmu.Unlock()
+
+	return pending.responses
+}
+
+// 重发的请求使用原请求等待的响应
+func (s *service) rewatchResponses(req sip.Request, retry sip.Request) {
+	s.pmu.Lock()
+	if pending, ok := s.pending[req]; ok {
+		delete(s.pending, req)
+		s.pending[retry] = pending
+	}
+	s.pmu.Unlock()
+}
+
+func (s *service) unwatchResponses(req sip.Request) {
+	s.pmu.Lock()
+	if pending, ok := s.pending[req]; ok {
+		delete(s.pending, req)
+		close(pending.done)
+	}
+	s.pmu.Unlock()
+}
+
+// 将响应传递给等待的同步请求，返回是否已处理；
+// 接收不及时时丢弃临时响应，最终响应等待接收直到停止等待，不阻塞消息循环
+func (s *service) deliverPending(res sip.Response) bool {
+	tx := res.Transaction()
+	if tx == nil {
+		return false
+	}
+
+	s.pmu.RLock()
+	pending, ok := s.pending[requestOf(tx)]
+	s.pmu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	select {
+	case pending.responses <- res:
+		return true
+	default:
+	}
+
+	if res.IsProvisional() {
+		logger.Warnf("[G.SIP] -> drop response %s, request %s is not receiving", res.Short(), tx.Origin().Short())
+		return true
+	}
+
+	go func() {
+		select {
+		case pending.responses <- res:
+		case <-pending.done:
+		}
+	}()
+
+	return true
+}
+
+func (s *service) Dialog(id string) sip.Dialog {
+	return s.opts.tx.Dialog(id)
+}
+
+func (s *service) DialogOf(msg sip.Message) sip.Dialog {
+	return s.opts.tx.DialogOf(msg)
+}
+
+func (s *service) Replaces(invite sip.Request) sip.Dialog {
+	return s.opts.tx.Replaces(invite)
 }
 
 func (s *service) autoFillMessageHeaderAndSend(message sip.Message) {
@@ -79,7 +505,7 @@ func (s *service) autoFillMessageHeaderAndSend(message sip.Message) {
 			hdrs := message.GetHeaders("Allow")
 			if len(hdrs) == 0 {
 				allow := make(sip.AllowHeader, 0)
-				for _, method := range s.opts.Callback.GetAllowedMethods() {
+				for _, method := range s.allowedMethods() {
 					allow = append(allow, method)
 				}
 
@@ -89,7 +515,7 @@ func (s *service) autoFillMessageHeaderAndSend(message sip.Message) {
 			hdrs = message.GetHeaders("Supported")
 			if len(hdrs) == 0 {
 				message.AddHeader(&sip.SupportedHeader{
-					Options: []string{},
+					Options: s.opts.tx.Supported(),
 				})
 			}
 		}
@@ -118,6 +544,9 @@ func (s *service) Run() error {
 func (s *service) Close() error {
 	// 确保关闭方法只执行一次
 	s.once.Do(func() {
+		s.unregisterAll()
+		s.unsubscribeAll()
+		s.terminateSubscribers()
 		s.stop()
 	})
 
@@ -153,6 +582,10 @@ func (s *service) start() {
 			if !ok {
 				return
 			}
+			// 同步请求的响应不传递给回调函数
+			if s.deliverPending(res) {
+				continue
+			}
 			s.hwg.Add(1)
 			go s.handleResponse(res)
 		case err, ok := <-s.opts.tx.Errors():
@@ -198,10 +631,58 @@ func (s *service) handleRequest(request sip.Request) {
 		tx = t.(sip.ServerTransaction)
 	}
 
+	// 订阅的 NOTIFY 不传递给回调函数
+	if request.Method() == sip.NOTIFY && s.handleNotify(request) {
+		return
+	}
+
+	// B2BUA 呼叫的 CANCEL 以及对话内的请求转换到另一方
+	if s.opts.b2bua != nil && s.handleCallRequest(request, tx) {
+		return
+	}
+
+	// 没有回调函数时由服务内置的处理函数处理，认证与回调函数相同
+	if _, ok := s.opts.Callback.GetRequestHandle(request.Method()); !ok && !s.isDialogReinvite(request) {
+		if handler, ok := s.builtinHandler(request.Method()); ok {
+			if s.opts.Callback.Authenticate(request, tx) {
+				handler(request, tx)
+			}
+			return
+		}
+	}
+
+	// 代理转发没有回调函数的请求
+	if s.opts.proxy != nil {
+		if _, ok := s.opts.Callback.GetRequestHandle(request.Method()); !ok {
+			s.proxyRequest(request, tx)
+			return
+		}
+	}
+
 	err := s.opts.Callback.DoRequest(request, tx)
 	// NotExitCallbackError
 	var notExitCallbackError *callback.NotExitCallbackError
 	if err != nil && errors.As(err, &notExitCallbackError) {
+		// 事务层已确认 PRACK 对应的可靠临时响应，没有回调函数时直接返回 200
+		if request.Method() == sip.PRACK {
+			if _, err := s.Send(request.CreateResponse(sip.StatusOK)); err != nil {
+				logger.Errorf("[G.SIP] -> Send '200 OK' for PRACK failed: %s", err)
+			}
+			return
+		}
+		// ACK 没有响应
+		if request.IsAck() {
+			return
+		}
+		// 没有对应订阅的 NOTIFY 返回 481 RFC 6665 - 4.1.3
+		if request.Method() == sip.NOTIFY {
+			response := request.CreateResponseReason(sip.StatusCallTransactionDoesNotExist, "Subscription Does Not Exist")
+			if _, err := s.Send(response); err != nil {
+				logger.Errorf("[G.SIP] -> Send '481 Subscription Does Not Exist' failed: %s", err)
+			}
+			return
+		}
+
 		logger.Warnf("[G.SIP] -> SIP %s request handler not found", request.Method())
 
 		response := request.CreateResponseReason(sip.StatusMethodNotAllowed, "Method Not Allowed")
@@ -213,9 +694,49 @@ func (s *service) handleRequest(request sip.Request) {
 	}
 }
 
+// 没有对应的请求回调函数时由服务处理的请求：配置了事件包时通知方处理 SUBSCRIBE，
+// 配置了 REFER 处理函数时接受转移，配置了 B2BUA 时处理新的呼叫
+func (s *service) builtinHandler(method sip.RequestMethod) (sip.RequestHandler, bool) {
+	switch {
+	case method == sip.SUBSCRIBE && len(s.opts.packages) > 0:
+		return s.handleSubscribe, true
+	case method == sip.REFER && s.opts.referHandler != nil:
+		return s.handleRefer, true
+	case method == sip.INVITE && s.opts.b2bua != nil:
+		return s.handleCallInvite, true
+	default:
+		return nil, false
+	}
+}
+
+// Allow 头部的方法：请求回调函数以及服务内置处理的方法
+func (s *service) allowedMethods() []sip.RequestMethod {
+	methods := s.opts.Callback.GetAllowedMethods()
+	for _, method := range []sip.RequestMethod{sip.SUBSCRIBE, sip.REFER, sip.INVITE} {
+		if _, ok := s.opts.Callback.GetRequestHandle(method); ok {
+			continue
+		}
+		if _, ok := s.builtinHandler(method); ok {
+			methods = append(methods, method)
+		}
+	}
+
+	return methods
+}
+
 // 处理响应
 func (s *service) handleResponse(response sip.Response) {
 	defer s.hwg.Done()
+
+	// 无状态代理转发的请求的响应
+	if s.opts.proxy != nil && s.opts.proxy.stateless && response.Transaction() == nil &&
+		s.forwardStatelessResponse(response) {
+		return
+	}
+	// 已自动重新认证或者重发，不传递给回调函数
+	if s.reauthorize(response) || s.resendSessionInterval(response) {
+		return
+	}
 	var tx sip.ClientTransaction
 	if t := response.Transaction(); t != nil {
 		tx = t.(sip.ClientTransaction)
@@ -0,0 +1,209 @@
+package gsip
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/callback"
+	"github.com/zenghr0820/gsip/sip"
+	"github.com/zenghr0820/gsip/transaction"
+)
+
+// 服务内置的处理函数不注册到共享的 callback.DefaultCallback
+func TestBuiltinHandlersKeepDefaultCallback(t *testing.T) {
+	s := NewService(
+		Transport("127.0.2.1"),
+		AddEventPackage(PresencePackage, func(subscriber *Subscriber) error { return nil }),
+		ReferConfig(func(referral *Referral) error { return nil }),
+		B2BUAConfig(RouterFunc(func(req sip.Request) ([]sip.Uri, error) { return nil, nil })),
+	)
+	defer s.Close()
+
+	for _, method := range []sip.RequestMethod{sip.SUBSCRIBE, sip.REFER, sip.INVITE} {
+		if _, ok := callback.DefaultCallback.GetRequestHandle(method); ok {
+			t.Errorf("%s handler registered on callback.DefaultCallback", method)
+		}
+		if _, ok := s.(*service).builtinHandler(method); !ok {
+			t.Errorf("%s is not handled by the service", method)
+		}
+	}
+}
+
+// B2BUA 依赖事务层的对话，不能与代理同时配置，Listen 返回错误
+func TestB2BUAWithProxy(t *testing.T) {
+	router := RouterFunc(func(req sip.Request) ([]sip.Uri, error) { return nil, nil })
+	tests := [][]Option{
+		{B2BUAConfig(router), ProxyConfig(router)},
+		{StatelessProxyConfig(router), B2BUAConfig(router)},
+	}
+
+	for _, opts := range tests {
+		s := NewService(append([]Option{Transport("127.0.2.1")}, opts...)...)
+		if err := s.Listen("udp", "127.0.2.1:5060"); err != errB2BUAWithProxy {
+			t.Errorf("listen error = %v, want %v", err, errB2BUAWithProxy)
+		}
+		_ = s.Close()
+	}
+}
+
+type originTx struct {
+	origin sip.Request
+}
+
+func (tx *originTx) Origin() sip.Request  { return tx.origin }
+func (tx *originTx) String() string       { return "origin" }
+func (tx *originTx) Errors() <-chan error { return nil }
+func (tx *originTx) Done() <-chan bool    { return nil }
+
+// 同步请求接收不及时时只丢弃临时响应，最终响应在接收之后仍然传递
+func TestDeliverPendingKeepsFinal(t *testing.T) {
+	s := NewService(Transport("127.0.2.2")).(*service)
+	defer s.Close()
+
+	req := sip.CreateRequest(sip.OPTIONS, "", testUri("alice", "127.0.2.2"), testUri("bob", "127.0.2.3"))
+	tx := &originTx{origin: req}
+	responses := s.watchResponses(req)
+	defer s.unwatchResponses(req)
+
+	for i := 0; i <= cap(responses); i++ {
+		res := req.CreateResponseReason(sip.StatusTrying, "Trying")
+		res.SetTransaction(tx)
+		s.deliverPending(res)
+	}
+	final := req.CreateResponseReason(sip.StatusOK, "OK")
+	final.SetTransaction(tx)
+	s.deliverPending(final)
+
+	timeout := time.After(time.Second)
+	for i := 0; i <= cap(responses); i++ {
+		select {
+		case res := <-responses:
+			if i < cap(responses) && !res.IsProvisional() {
+				t.Fatalf("response %d = %s, want provisional", i, res.Short())
+			}
+			if i == cap(responses) && res.StatusCode() != sip.StatusOK {
+				t.Fatalf("response %d = %s, want 200", i, res.Short())
+			}
+		case <-timeout:
+			t.Fatalf("received %d responses, final response dropped", i)
+		}
+	}
+}
+
+// 认证后重发请求的副本，不修改调用方的请求，重发的事务结束后不保留认证次数
+func TestReauthorizeKeepsRequest(t *testing.T) {
+	_, uasCallback := newTestService(t, "127.0.2.5",
+		AuthenticatorConfig(sip.NewDigestAuthenticator("gsip", sip.PasswordCredentials{"alice": "secret"}), sip.OPTIONS))
+	uasCallback.AddRequestHandle(sip.OPTIONS, func(req sip.Request, tx sip.ServerTransaction) {
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusOK))
+	})
+
+	responses := make(chan sip.Response, 1)
+	uac, uacCallback := newTestService(t, "127.0.2.4",
+		AuthConfig(CreateAuthInfo("alice", "secret")),
+		TransactionConfig(transaction.Timers(transaction.TimerT4(10*time.Millisecond))))
+	_ = uacCallback.SetResponseHandle(func(res sip.Response, tx sip.ClientTransaction) {
+		responses <- res
+	})
+
+	// 同步请求
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	options := uac.CreateRequest(sip.OPTIONS, "127.0.2.5:5060", testUri("alice", "127.0.2.4"), testUri("bob", "127.0.2.5"))
+	seq := options.CSeq().SeqNo
+	res, err := uac.Request(ctx, options)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	if res.StatusCode() != sip.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode())
+	}
+	if len(options.GetHeaders("Authorization")) != 0 || options.CSeq().SeqNo != seq {
+		t.Errorf("request modified by authorization: %s", options.Short())
+	}
+
+	// 异步请求
+	options = uac.CreateRequest(sip.OPTIONS, "127.0.2.5:5060", testUri("alice", "127.0.2.4"), testUri("bob", "127.0.2.5"))
+	seq = options.CSeq().SeqNo
+	if _, err := uac.Send(options); err != nil {
+		t.Fatalf("send failed: %s", err)
+	}
+	select {
+	case res := <-responses:
+		if res.StatusCode() != sip.StatusOK {
+			t.Fatalf("status = %d, want 200", res.StatusCode())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no final response")
+	}
+	if len(options.GetHeaders("Authorization")) != 0 || options.CSeq().SeqNo != seq {
+		t.Errorf("request modified by authorization: %s", options.Short())
+	}
+
+	s := uac.(*service)
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.pmu.RLock()
+		attempts := len(s.authAttempts)
+		s.pmu.RUnlock()
+		if attempts == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d auth attempts kept after the transaction terminated", attempts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 422 使用对方的 Min-SE 重发请求的副本，不修改调用方的请求
+func TestSessionIntervalTooSmallRetry(t *testing.T) {
+	_, uasCallback := newTestService(t, "127.0.2.9", TransactionConfig(transaction.SessionTimer(1800, 1800)))
+	expires := make(chan uint32, 2)
+	uasCallback.AddRequestHandle(sip.INVITE, func(req sip.Request, tx sip.ServerTransaction) {
+		if se := sip.SessionExpires(req); se != nil {
+			expires <- se.Delta
+		}
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusBusyHere))
+	})
+
+	uac, _ := newTestService(t, "127.0.2.8")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	invite := uac.CreateRequest(sip.INVITE, "127.0.2.9:5060", testUri("alice", "127.0.2.8"), testUri("bob", "127.0.2.9"))
+	invite.AddHeader(&sip.SessionExpiresHeader{Delta: 90})
+	seq := invite.CSeq().SeqNo
+	viaHop, _ := invite.ViaHop()
+	branch, _ := viaHop.Params.Get("branch")
+
+	res, err := uac.Request(ctx, invite)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	if res.StatusCode() != sip.StatusBusyHere {
+		t.Fatalf("status = %d, want 486", res.StatusCode())
+	}
+	select {
+	case delta := <-expires:
+		if delta != 1800 {
+			t.Errorf("Session-Expires of retry = %d, want 1800", delta)
+		}
+	default:
+		t.Fatal("retry not received")
+	}
+	if res.CSeq().SeqNo != seq+1 {
+		t.Errorf("CSeq of retry = %d, want %d", res.CSeq().SeqNo, seq+1)
+	}
+
+	// 原请求仍是收到 422 的事务的 origin
+	if se := sip.SessionExpires(invite); se == nil || se.Delta != 90 {
+		t.Errorf("Session-Expires of request modified: %v", se)
+	}
+	if invite.CSeq().SeqNo != seq {
+		t.Errorf("CSeq of request = %d, want %d", invite.CSeq().SeqNo, seq)
+	}
+	if got, _ := viaHop.Params.Get("branch"); got.String() != branch.String() {
+		t.Errorf("branch of request = %s, want %s", got, branch)
+	}
+}
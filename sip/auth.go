@@ -1,23 +1,55 @@
 package sip
 
 import (
+	"container/list"
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"regexp"
 	"strings"
+	"sync"
 )
 
+// 摘要认证算法 RFC 2617、RFC 7616、RFC 8760
+const (
+	AlgorithmMD5           = "MD5"
+	AlgorithmMD5Sess       = "MD5-sess"
+	AlgorithmSHA256        = "SHA-256"
+	AlgorithmSHA256Sess    = "SHA-256-sess"
+	AlgorithmSHA512256     = "SHA-512-256"
+	AlgorithmSHA512256Sess = "SHA-512-256-sess"
+	QopAuth                = "auth"
+	QopAuthInt             = "auth-int"
+	// 记录的 nonce 数量上限，超过后淘汰最久未使用或者过期的 nonce
+	maxNonceCounts = 1024
+)
+
+// 质询中存在多个算法时的选择顺序 RFC 8760 - 2.4
+var digestAlgorithms = []string{
+	AlgorithmSHA512256,
+	AlgorithmSHA512256Sess,
+	AlgorithmSHA256,
+	AlgorithmSHA256Sess,
+	AlgorithmMD5,
+	AlgorithmMD5Sess,
+}
+
+var authParamRegexp = regexp.MustCompile(`([\w-]+)\s*=\s*(?:"([^"]*)"|([^\s,"]+))`)
+
 func CreateAuthorization() *Authorization {
 	auth := &Authorization{
-		Algorithm: "MD5",
+		Algorithm: AlgorithmMD5,
 		Other:     NewParams(),
 	}
 
 	return auth
 }
 
-// currently only Digest and MD5
+// currently only Digest
 type Authorization struct {
 	name      string
 	mode      string
@@ -29,7 +61,17 @@ type Authorization struct {
 	Uri       string
 	Response  string
 	Method    string
-	Other     Params
+	// 质询中为可选的 qop 列表，认证头部中为选择的 qop
+	Qop    string
+	Cnonce string
+	// nonce-count
+	Nc     uint32
+	Opaque string
+	// 质询中 nonce 过期，仅需使用新的 nonce 重新计算
+	Stale bool
+	Other Params
+	// qop=auth-int 时计算摘要使用的消息体
+	body []byte
 }
 
 func (auth *Authorization) Name() string {
@@ -51,6 +93,12 @@ func (auth *Authorization) Copy() Header {
 		Uri:       auth.Uri,
 		Response:  auth.Response,
 		Method:    auth.Method,
+		Qop:       auth.Qop,
+		Cnonce:    auth.Cnonce,
+		Nc:        auth.Nc,
+		Opaque:    auth.Opaque,
+		Stale:     auth.Stale,
+		body:      auth.body,
 	}
 
 	if auth.Other != nil {
@@ -71,6 +119,10 @@ func (auth *Authorization) Equals(Other interface{}) bool {
 			strings.Compare(auth.Uri, h.Uri) == 0 &&
 			strings.Compare(auth.Response, h.Response) == 0 &&
 			strings.Compare(auth.Method, h.Method) == 0 &&
+			strings.Compare(auth.Qop, h.Qop) == 0 &&
+			strings.Compare(auth.Cnonce, h.Cnonce) == 0 &&
+			auth.Nc == h.Nc &&
+			strings.Compare(auth.Opaque, h.Opaque) == 0 &&
 			auth.Other.Equals(h.Other)
 	}
 
@@ -93,6 +145,11 @@ func (auth *Authorization) SetMethod(Method string) {
 	auth.Method = Method
 }
 
+// qop=auth-int 时需要设置请求的消息体
+func (auth *Authorization) SetBody(body []byte) {
+	auth.body = body
+}
+
 func (auth *Authorization) Mode() string {
 	if auth.mode == "" {
 		return "Digest"
@@ -107,15 +164,52 @@ func (auth *Authorization) SetPassword(Password string) {
 	auth.Password = Password
 }
 
+// 计算摘要，不支持的算法 Response 为空
 func (auth *Authorization) CalcResponse() {
-	auth.Response = calcResponse(
-		auth.Username,
-		auth.Realm,
-		auth.Password,
-		auth.Method,
-		auth.Uri,
+	auth.Response = ""
+	newHash := digestHash(auth.Algorithm)
+	if newHash == nil {
+		return
+	}
+
+	auth.Response = auth.CalcResponseWithHA1(hashHex(newHash, auth.Username+":"+auth.Realm+":"+auth.Password))
+}
+
+/**
+使用 H(username:realm:password) 计算摘要 RFC 7616 - 3.4.1：
+	服务端可以只保存 HA1 而不保存明文密码
+*/
+func (auth *Authorization) CalcResponseWithHA1(ha1 string) string {
+	newHash := digestHash(auth.Algorithm)
+	if newHash == nil {
+		return ""
+	}
+
+	// RFC 7616 - 3.4.2 -sess 算法
+	if strings.HasSuffix(strings.ToLower(auth.Algorithm), "-sess") {
+		ha1 = hashHex(newHash, ha1+":"+auth.Nonce+":"+auth.Cnonce)
+	}
+
+	// RFC 7616 - 3.4.3 A2
+	a2 := strings.ToUpper(auth.Method) + ":" + auth.Uri
+	if strings.EqualFold(auth.Qop, QopAuthInt) {
+		a2 += ":" + hashHex(newHash, string(auth.body))
+	}
+	ha2 := hashHex(newHash, a2)
+
+	// RFC 2069 兼容，没有 qop
+	if auth.Qop == "" {
+		return hashHex(newHash, ha1+":"+auth.Nonce+":"+ha2)
+	}
+
+	return hashHex(newHash, strings.Join([]string{
+		ha1,
 		auth.Nonce,
-	)
+		fmt.Sprintf("%08x", auth.Nc),
+		auth.Cnonce,
+		strings.ToLower(auth.Qop),
+		ha2,
+	}, ":"))
 }
 
 func (auth *Authorization) String() string {
@@ -128,7 +222,8 @@ func (auth *Authorization) String() string {
 		)
 	}
 
-	return fmt.Sprintf(
+	var buffer strings.Builder
+	buffer.WriteString(fmt.Sprintf(
 		`%s: %s username="%s",realm="%s",nonce="%s",uri="%s",response="%s",algorithm=%s`,
 		auth.Name(),
 		auth.Mode(),
@@ -138,7 +233,15 @@ func (auth *Authorization) String() string {
 		auth.Uri,
 		auth.Response,
 		auth.Algorithm,
-	)
+	))
+	if auth.Qop != "" {
+		buffer.WriteString(fmt.Sprintf(`,qop=%s,nc=%08x,cnonce="%s"`, auth.Qop, auth.Nc, auth.Cnonce))
+	}
+	if auth.Opaque != "" {
+		buffer.WriteString(fmt.Sprintf(`,opaque="%s"`, auth.Opaque))
+	}
+
+	return buffer.String()
 }
 
 // 解析
@@ -148,26 +251,40 @@ func (auth *Authorization) ParseAuthorization(value string) {
 		auth.SetMode("Capability")
 	}
 
-	re := regexp.MustCompile(`([\w]+)="([^"]+)"`)
-	matches := re.FindAllStringSubmatch(value, -1)
+	matches := authParamRegexp.FindAllStringSubmatch(value, -1)
 	for _, match := range matches {
-		switch match[1] {
+		// 带引号的值以及 token 值
+		value := match[2]
+		if value == "" {
+			value = match[3]
+		}
+		switch strings.ToLower(match[1]) {
 		case "realm":
-			auth.Realm = match[2]
+			auth.Realm = value
 		case "algorithm":
-			auth.Algorithm = match[2]
+			auth.Algorithm = value
 		case "nonce":
-			auth.Nonce = match[2]
+			auth.Nonce = value
 		case "username":
-			auth.Username = match[2]
+			auth.Username = value
 		case "uri":
-			auth.Uri = match[2]
+			auth.Uri = value
 		case "response":
-			auth.Response = match[2]
+			auth.Response = value
 		case "method":
-			auth.Method = match[2]
+			auth.Method = value
+		case "qop":
+			auth.Qop = value
+		case "cnonce":
+			auth.Cnonce = value
+		case "nc":
+			_, _ = fmt.Sscanf(value, "%x", &auth.Nc)
+		case "opaque":
+			auth.Opaque = value
+		case "stale":
+			auth.Stale = strings.EqualFold(value, "true")
 		default:
-			auth.Other.Add(match[1], String{Str: match[2]})
+			auth.Other.Add(match[1], String{Str: value})
 		}
 	}
 }
@@ -181,27 +298,126 @@ func parseAuthorization(headerName string, headerData string) (headers []Header,
 	return
 }
 
-// calculates Authorization Response https://www.ietf.org/rfc/rfc2617.txt
-func calcResponse(Username string, Realm string, Password string, Method string, Uri string, Nonce string) string {
-	calcA1 := func() string {
-		encoder := md5.New()
-		encoder.Write([]byte(Username + ":" + Realm + ":" + Password))
+// 算法对应的哈希函数，不支持的算法返回 nil
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(algorithm), "-sess")) {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	case "SHA-512-256":
+		return sha512.New512_256
+	default:
+		return nil
+	}
+}
+
+func hashHex(newHash func() hash.Hash, data string) string {
+	encoder := newHash()
+	encoder.Write([]byte(data))
+
+	return hex.EncodeToString(encoder.Sum(nil))
+}
+
+// 记录每个 nonce 使用的次数 RFC 7616 - 3.4 nonce-count
+// 按 realm 以及 nonce 记录已发送的 nonce-count，超过上限时淘汰最久未使用的 nonce
+type nonceCounter struct {
+	mu     sync.Mutex
+	counts map[string]*list.Element
+	lru    *list.List
+}
+
+type nonceCount struct {
+	key   string
+	count uint32
+}
 
-		return hex.EncodeToString(encoder.Sum(nil))
+var nonceCounts = newNonceCounter()
+
+func newNonceCounter() *nonceCounter {
+	return &nonceCounter{
+		counts: make(map[string]*list.Element),
+		lru:    list.New(),
 	}
-	calcA2 := func() string {
-		encoder := md5.New()
-		encoder.Write([]byte(strings.ToUpper(Method) + ":" + Uri))
+}
+
+func (c *nonceCounter) next(realm string, nonce string) uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		return hex.EncodeToString(encoder.Sum(nil))
+	key := realm + ":" + nonce
+	if elem, ok := c.counts[key]; ok {
+		c.lru.MoveToFront(elem)
+		count := elem.Value.(*nonceCount)
+		count.count++
+		return count.count
 	}
 
-	encoder := md5.New()
-	encoder.Write([]byte(calcA1() + ":" + Nonce + ":" + calcA2()))
+	for c.lru.Len() >= maxNonceCounts {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.counts, oldest.Value.(*nonceCount).key)
+	}
+	c.counts[key] = c.lru.PushFront(&nonceCount{key: key, count: 1})
 
-	return hex.EncodeToString(encoder.Sum(nil))
+	return 1
 }
 
+func generateCnonce() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return GenerateBranch()
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// 选择支持的 qop，优先使用 auth
+func selectQop(qops string) (string, bool) {
+	if qops == "" {
+		return "", true
+	}
+
+	var authInt bool
+	for _, qop := range strings.Split(qops, ",") {
+		switch strings.ToLower(strings.TrimSpace(qop)) {
+		case QopAuth:
+			return QopAuth, true
+		case QopAuthInt:
+			authInt = true
+		}
+	}
+	if authInt {
+		return QopAuthInt, true
+	}
+
+	return "", false
+}
+
+// 从多个质询中选择安全性最高且支持的算法
+func selectChallenge(hdrs []Header) *Authorization {
+	challenges := make([]*Authorization, 0, len(hdrs))
+	for _, hdr := range hdrs {
+		auth := challengeOf(hdr)
+		if strings.ToLower(auth.Mode()) != "digest" || digestHash(auth.Algorithm) == nil {
+			continue
+		}
+		challenges = append(challenges, auth)
+	}
+
+	for _, algorithm := range digestAlgorithms {
+		for _, challenge := range challenges {
+			if strings.EqualFold(challenge.Algorithm, algorithm) {
+				return challenge
+			}
+		}
+	}
+
+	return nil
+}
+
+// 在 request 上添加认证头部，并更新 Via 的 branch 以及 CSeq 序号；
+// 会修改 request，需要保留原请求时先使用 CopyRequest 复制
 func AuthorizeRequest(request Request, Response Response, user, Password MaybeString) error {
 	if user == nil {
 		return fmt.Errorf("authorize request: user is nil")
@@ -218,33 +434,60 @@ func AuthorizeRequest(request Request, Response Response, user, Password MaybeSt
 		authorizeHeaderName = "Proxy-Authorization"
 	}
 
-	if hdrs := Response.GetHeaders(authenticateHeaderName); len(hdrs) > 0 {
-		authenticateHeader := hdrs[0].(*GenericHeader)
+	hdrs := Response.GetHeaders(authenticateHeaderName)
+	if len(hdrs) == 0 {
+		return fmt.Errorf("authorize request: header '%s' not found in Response", authenticateHeaderName)
+	}
 
-		auth := CreateAuthorization()
-		auth.ParseAuthorization(authenticateHeader.Contents)
-		auth.SetMethod(string(request.Method()))
-		auth.SetUri(request.Recipient().String())
-		auth.SetUsername(user.String())
+	auth := selectChallenge(hdrs)
+	if auth == nil {
+		return fmt.Errorf("authorize request: no supported digest algorithm in header '%s'", authenticateHeaderName)
+	}
+	qop, ok := selectQop(auth.Qop)
+	if !ok {
+		return fmt.Errorf("authorize request: unsupported qop '%s'", auth.Qop)
+	}
 
-		if Password != nil {
-			auth.SetPassword(Password.String())
-		}
+	auth.SetName(strings.ToLower(authorizeHeaderName))
+	auth.SetMethod(string(request.Method()))
+	auth.SetUri(request.Recipient().String())
+	auth.SetUsername(user.String())
+	auth.SetBody(request.Body())
+	auth.Qop = qop
+	if qop != "" {
+		auth.Cnonce = generateCnonce()
+		auth.Nc = nonceCounts.next(auth.Realm, auth.Nonce)
+	}
+	auth.Stale = false
+	// 质询中的其他参数(domain 等)不回传
+	auth.Other = NewParams()
+
+	if Password != nil {
+		auth.SetPassword(Password.String())
+	}
 
-		auth.CalcResponse()
+	auth.CalcResponse()
 
-		var authorizationHeader *Authorization
-		hdrs = request.GetHeaders(authorizeHeaderName)
-		if len(hdrs) > 0 {
-			authorizationHeader = hdrs[0].(*Authorization)
-			authorizationHeader = auth
-		} else {
-			authorizationHeader = auth
-			request.AddHeader(authorizationHeader)
+	// 替换相同 realm 的认证头部，保留其他 realm 的认证头部
+	others := make([]Header, 0)
+	for _, hdr := range request.GetHeaders(authorizeHeaderName) {
+		if authorization, ok := hdr.(*Authorization); ok && authorization.Realm == auth.Realm {
+			continue
 		}
-	} else {
-		return fmt.Errorf("authorize request: header '%s' not found in Response", authenticateHeaderName)
+		if header, ok := hdr.(*GenericHeader); ok {
+			old := CreateAuthorization()
+			old.ParseAuthorization(header.Contents)
+			if old.Realm == auth.Realm {
+				continue
+			}
+		}
+		others = append(others, hdr)
+	}
+	request.DelHeader(authorizeHeaderName)
+	for _, hdr := range others {
+		request.AddHeader(hdr)
 	}
+	request.AddHeader(auth)
 
 	if viaHop, ok := request.ViaHop(); ok {
 		viaHop.Params.Add("branch", String{Str: GenerateBranch()})
@@ -261,6 +504,51 @@ type Authorized interface {
 	AddAuthInfo(request Request, Response Response) error
 }
 
+// 按 realm 选择认证信息，realm 为空字符串的认证信息作为默认值
+type RealmAuthorized map[string]Authorized
+
+func (auth RealmAuthorized) AddAuthInfo(request Request, response Response) error {
+	realm := ChallengeRealm(response)
+	if authorized, ok := auth[realm]; ok && authorized != nil {
+		return authorized.AddAuthInfo(request, response)
+	}
+	if authorized, ok := auth[""]; ok && authorized != nil {
+		return authorized.AddAuthInfo(request, response)
+	}
+
+	return fmt.Errorf("authorize request: no credentials for realm '%s'", realm)
+}
+
+// 返回 401/407 响应中认证质询的 realm
+func ChallengeRealm(response Response) string {
+	authenticateHeaderName := "WWW-Authenticate"
+	if response.StatusCode() == 407 {
+		authenticateHeaderName = "Proxy-Authenticate"
+	}
+
+	for _, hdr := range response.GetHeaders(authenticateHeaderName) {
+		return challengeOf(hdr).Realm
+	}
+
+	return ""
+}
+
+// 将 WWW-Authenticate、Proxy-Authenticate 质询转换为认证头部
+func challengeOf(hdr Header) *Authorization {
+	switch header := hdr.(type) {
+	case *AuthenticateHeader:
+		return header.Authorization()
+	case *GenericHeader:
+		auth := CreateAuthorization()
+		auth.ParseAuthorization(header.Contents)
+		return auth
+	default:
+		auth := CreateAuthorization()
+		auth.ParseAuthorization(header.String())
+		return auth
+	}
+}
+
 type DefaultAuthorized struct {
 	User     MaybeString
 	Password MaybeString
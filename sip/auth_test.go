@@ -0,0 +1,175 @@
+package sip
+
+import (
+	"fmt"
+	"testing"
+)
+
+const (
+	rfc7616Nonce  = "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"
+	rfc7616Cnonce = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	authIntBody   = "v=0\r\no=- 0 0 IN IP4 192.0.2.1\r\n"
+)
+
+// RFC 7616 - 3.9.1 的参数
+func rfc7616Authorization(algorithm string) *Authorization {
+	auth := CreateAuthorization()
+	auth.Algorithm = algorithm
+	auth.Username = "Mufasa"
+	auth.Realm = "http-auth@example.org"
+	auth.Password = "Circle of Life"
+	auth.Nonce = rfc7616Nonce
+	auth.Cnonce = rfc7616Cnonce
+	auth.Nc = 1
+	auth.Qop = QopAuth
+	auth.Method = "GET"
+	auth.Uri = "/dir/index.html"
+
+	return auth
+}
+
+// qop=auth-int 使用 INVITE 以及消息体
+func authIntAuthorization(algorithm string) *Authorization {
+	auth := rfc7616Authorization(algorithm)
+	auth.Qop = QopAuthInt
+	auth.Method = "INVITE"
+	auth.Uri = "sip:bob@example.org"
+	auth.SetBody([]byte(authIntBody))
+
+	return auth
+}
+
+func TestCalcResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     *Authorization
+		response string
+	}{
+		{
+			name: "RFC 2617 - 3.5",
+			auth: &Authorization{
+				Algorithm: AlgorithmMD5,
+				Username:  "Mufasa",
+				Realm:     "testrealm@host.com",
+				Password:  "Circle Of Life",
+				Nonce:     "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+				Cnonce:    "0a4f113b",
+				Nc:        1,
+				Qop:       QopAuth,
+				Method:    "GET",
+				Uri:       "/dir/index.html",
+			},
+			response: "6629fae49393a05397450978507c4ef1",
+		},
+		{
+			name: "RFC 2069 without qop",
+			auth: &Authorization{
+				Algorithm: AlgorithmMD5,
+				Username:  "Mufasa",
+				Realm:     "testrealm@host.com",
+				Password:  "Circle Of Life",
+				Nonce:     "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+				Method:    "GET",
+				Uri:       "/dir/index.html",
+			},
+			response: "670fd8c2df070c60b045671b8b24ff02",
+		},
+		{
+			name:     "RFC 7616 - 3.9.1 MD5",
+			auth:     rfc7616Authorization(AlgorithmMD5),
+			response: "8ca523f5e9506fed4657c9700eebdbec",
+		},
+		{
+			name:     "RFC 7616 - 3.9.1 SHA-256",
+			auth:     rfc7616Authorization(AlgorithmSHA256),
+			response: "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+		},
+		// RFC 7616 - 3.9.2 的示例响应与其参数的计算结果不一致，SHA-512-256 以及以下的期望值使用 3.9.1 的参数计算
+		{
+			name:     "SHA-512-256",
+			auth:     rfc7616Authorization(AlgorithmSHA512256),
+			response: "430d05014cecc49cab6fbe03176d41a1da86cbfe24a16580e22aaad928d960d0",
+		},
+		{
+			name:     "MD5-sess",
+			auth:     rfc7616Authorization(AlgorithmMD5Sess),
+			response: "e783283f46242139c486a698fec7211d",
+		},
+		{
+			name:     "SHA-256-sess",
+			auth:     rfc7616Authorization(AlgorithmSHA256Sess),
+			response: "2fd51b3a77ad75bad6afad6003e818d767133c46d9e2749e7f5232ae1ea3efd7",
+		},
+		{
+			name:     "SHA-512-256-sess",
+			auth:     rfc7616Authorization(AlgorithmSHA512256Sess),
+			response: "3f2a34f923c38b0fb26dce2fdfc2ce326c23cecf86fbb1444f3e51fbbc2cb92e",
+		},
+		{
+			name:     "MD5 auth-int",
+			auth:     authIntAuthorization(AlgorithmMD5),
+			response: "b1a4f6eb4d6b91e1d89f03123ec6ce52",
+		},
+		{
+			name:     "SHA-256 auth-int",
+			auth:     authIntAuthorization(AlgorithmSHA256),
+			response: "2cc104b52777d14489a9e17eb5f32dff4417b4b2e8b468a2f6e5d8c45a1e1ec1",
+		},
+		{
+			name:     "SHA-512-256 auth-int",
+			auth:     authIntAuthorization(AlgorithmSHA512256),
+			response: "72083f03650ab04c75e366d7669a8c88f78e2e677b3edb640a0e2be2e901d50e",
+		},
+		{
+			name:     "MD5-sess auth-int",
+			auth:     authIntAuthorization(AlgorithmMD5Sess),
+			response: "0974b8dfc6970890ed653a88437910aa",
+		},
+		{
+			name:     "SHA-256-sess auth-int",
+			auth:     authIntAuthorization(AlgorithmSHA256Sess),
+			response: "14b32a1ed9a13f649e3963fa3b3992b91a0a7ddffb6f984cb93c8d494e16088c",
+		},
+		{
+			name:     "SHA-512-256-sess auth-int",
+			auth:     authIntAuthorization(AlgorithmSHA512256Sess),
+			response: "4ec439ea971626e064b9c6e1e675837f46b22fd2a7209047d16d40d2aab88fdd",
+		},
+		{
+			name:     "unsupported algorithm",
+			auth:     rfc7616Authorization("SHA-1"),
+			response: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.auth.CalcResponse()
+			if test.auth.Response != test.response {
+				t.Errorf("response = %s, want %s", test.auth.Response, test.response)
+			}
+		})
+	}
+}
+
+// 超过上限时只淘汰最久未使用的 nonce，正在使用的 nonce 的计数不会重置
+func TestNonceCounterEviction(t *testing.T) {
+	counter := newNonceCounter()
+	for i := 0; i < maxNonceCounts; i++ {
+		counter.next("realm", fmt.Sprintf("nonce-%d", i))
+	}
+	if nc := counter.next("realm", "nonce-0"); nc != 2 {
+		t.Fatalf("nc of nonce-0 = %d, want 2", nc)
+	}
+
+	counter.next("realm", "new")
+	if len(counter.counts) != maxNonceCounts || counter.lru.Len() != maxNonceCounts {
+		t.Fatalf("counts = %d, lru = %d, want %d", len(counter.counts), counter.lru.Len(), maxNonceCounts)
+	}
+	if nc := counter.next("realm", "nonce-0"); nc != 3 {
+		t.Errorf("nc of recently used nonce-0 = %d, want 3", nc)
+	}
+	if nc := counter.next("realm", "nonce-1"); nc != 1 {
+		t.Errorf("nc of evicted nonce-1 = %d, want 1", nc)
+	}
+}
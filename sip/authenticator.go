@@ -0,0 +1,329 @@
+package sip
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+)
+
+// nonce 默认有效期
+const DefaultNonceTTL = 5 * time.Minute
+
+// 服务端认证请求
+type Authenticator interface {
+	// 认证通过返回 true，未通过时已经发送 401/407/400/403 响应
+	Authenticate(request Request, tx ServerTransaction) bool
+}
+
+// 代理认证 RFC 3261 - 22.3：使用 Proxy-Authorization 以及 407 质询，
+// 认证通过返回 nil，否则返回 407/400/403 响应，由代理发送(无状态代理没有服务端事务)
+type ProxyAuthenticator interface {
+	AuthenticateProxy(request Request) Response
+}
+
+// 服务端认证使用的凭证
+type CredentialStore interface {
+	// 返回 H(username:realm:password)，algorithm 为不含 -sess 的摘要算法，用户不存在时返回 false
+	HA1(username string, realm string, algorithm string) (string, bool)
+}
+
+// 明文密码凭证，key 为用户名
+type PasswordCredentials map[string]string
+
+func (credentials PasswordCredentials) HA1(username string, realm string, algorithm string) (string, bool) {
+	password, ok := credentials[username]
+	if !ok {
+		return "", false
+	}
+	newHash := digestHash(algorithm)
+	if newHash == nil {
+		return "", false
+	}
+
+	return hashHex(newHash, username+":"+realm+":"+password), true
+}
+
+type AuthenticatorOption func(*DigestAuthenticator)
+
+// 摘要认证 RFC 3261 - 22.4、RFC 7616
+type DigestAuthenticator struct {
+	realm     string
+	store     CredentialStore
+	algorithm string
+	qop       string
+	// 使用 407 Proxy-Authenticate 质询
+	proxy bool
+	// nonce 签名密钥
+	secret   []byte
+	nonceTTL time.Duration
+	// 每个 nonce 已使用的最大 nonce-count，防止重放
+	nonces map[string]nonceState
+	mu     sync.Mutex
+}
+
+type nonceState struct {
+	nc      uint32
+	expires time.Time
+}
+
+/**
+创建摘要认证：
+	realm：认证域
+	store：用户凭证
+	opts：可选配置，默认 MD5、qop=auth、nonce 有效期 DefaultNonceTTL
+*/
+func NewDigestAuthenticator(realm string, store CredentialStore, opts ...AuthenticatorOption) *DigestAuthenticator {
+	authenticator := &DigestAuthenticator{
+		realm:     realm,
+		store:     store,
+		algorithm: AlgorithmMD5,
+		qop:       QopAuth,
+		nonceTTL:  DefaultNonceTTL,
+		nonces:    make(map[string]nonceState),
+	}
+	for _, o := range opts {
+		o(authenticator)
+	}
+	if len(authenticator.secret) == 0 {
+		authenticator.secret = make([]byte, 32)
+		if _, err := rand.Read(authenticator.secret); err != nil {
+			panic(err)
+		}
+	}
+
+	return authenticator
+}
+
+// 质询使用的算法
+func AuthenticatorAlgorithm(algorithm string) AuthenticatorOption {
+	return func(a *DigestAuthenticator) {
+		a.algorithm = algorithm
+	}
+}
+
+// 质询使用的 qop，为空时兼容 RFC 2069
+func AuthenticatorQop(qop string) AuthenticatorOption {
+	return func(a *DigestAuthenticator) {
+		a.qop = qop
+	}
+}
+
+// 作为代理认证，使用 407 以及 Proxy-Authenticate
+func AuthenticatorProxy() AuthenticatorOption {
+	return func(a *DigestAuthenticator) {
+		a.proxy = true
+	}
+}
+
+// nonce 有效期
+func AuthenticatorNonceTTL(ttl time.Duration) AuthenticatorOption {
+	return func(a *DigestAuthenticator) {
+		a.nonceTTL = ttl
+	}
+}
+
+// nonce 签名密钥，多个实例共享密钥时可以验证彼此签发的 nonce
+func AuthenticatorSecret(secret []byte) AuthenticatorOption {
+	return func(a *DigestAuthenticator) {
+		a.secret = secret
+	}
+}
+
+func (a *DigestAuthenticator) Authenticate(request Request, tx ServerTransaction) bool {
+	response := a.verify(request, a.proxy)
+	if response == nil {
+		return true
+	}
+
+	a.respond(tx, response)
+	return false
+}
+
+// 认证代理转发的请求，未配置 AuthenticatorProxy 时同样使用 407 质询
+func (a *DigestAuthenticator) AuthenticateProxy(request Request) Response {
+	return a.verify(request, true)
+}
+
+// 认证请求，通过时返回 nil，否则返回 401/407 质询、uri 不匹配时的 400 或者 403 响应
+func (a *DigestAuthenticator) verify(request Request, proxy bool) Response {
+	// ACK、CANCEL 无法重新提交，不进行认证 RFC 3261 - 22.1
+	if request.IsAck() || request.IsCancel() {
+		return nil
+	}
+
+	auth := a.credentials(request, proxy)
+	if auth == nil {
+		return a.challenge(request, proxy, false)
+	}
+	if !digestUriMatches(auth.Uri, request.Recipient()) {
+		logger.Warnf("[authenticator] -> digest uri %s does not match %s", auth.Uri, request.Recipient())
+		return request.CreateResponseReason(StatusBadRequest, "Bad Request")
+	}
+
+	expires, valid := a.verifyNonce(auth.Nonce)
+	if !valid {
+		return a.challenge(request, proxy, false)
+	}
+
+	if !strings.EqualFold(auth.Algorithm, a.algorithm) || (a.qop != "" && !strings.EqualFold(auth.Qop, a.qop)) {
+		return a.reject(request)
+	}
+	ha1, ok := a.store.HA1(auth.Username, a.realm, strings.TrimSuffix(a.algorithm, "-sess"))
+	if !ok {
+		return a.reject(request)
+	}
+
+	auth.SetMethod(string(request.Method()))
+	auth.SetBody(request.Body())
+	expected := auth.CalcResponseWithHA1(ha1)
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(auth.Response)), []byte(expected)) != 1 {
+		return a.reject(request)
+	}
+
+	// 摘要正确但 nonce 已过期，通知客户端使用新的 nonce RFC 7616 - 3.3
+	if time.Now().After(expires) {
+		return a.challenge(request, proxy, true)
+	}
+
+	if auth.Qop != "" && !a.useNonce(auth.Nonce, auth.Nc, expires) {
+		logger.Warnf("[authenticator] -> replayed nonce-count %08x for %s", auth.Nc, auth.Username)
+		return a.challenge(request, proxy, true)
+	}
+
+	return nil
+}
+
+// 返回 realm 匹配的认证头部
+func (a *DigestAuthenticator) credentials(request Request, proxy bool) *Authorization {
+	name := "Authorization"
+	if proxy {
+		name = "Proxy-Authorization"
+	}
+	for _, hdr := range request.GetHeaders(name) {
+		var auth *Authorization
+		switch header := hdr.(type) {
+		case *Authorization:
+			auth = header
+		case *GenericHeader:
+			auth = CreateAuthorization()
+			auth.ParseAuthorization(header.Contents)
+		default:
+			continue
+		}
+		if strings.ToLower(auth.Mode()) == "digest" && auth.Realm == a.realm {
+			return auth
+		}
+	}
+
+	return nil
+}
+
+// 认证头部的 uri 必须与 Request-URI 相同 RFC 2617 - 3.2.2.5
+func digestUriMatches(uri string, recipient Uri) bool {
+	if recipient == nil {
+		return false
+	}
+	if uri == recipient.String() {
+		return true
+	}
+	parsed, err := ParseUri(uri)
+
+	return err == nil && parsed.Equals(recipient)
+}
+
+// 401/407 质询
+func (a *DigestAuthenticator) challenge(request Request, proxy bool, stale bool) Response {
+	statusCode, reason := StatusUnauthorized, "Unauthorized"
+	challenge := CreateAuthenticate()
+	challenge.SetName("www-authenticate")
+	if proxy {
+		statusCode, reason = StatusProxyAuthenticationRequired, "Proxy Authentication Required"
+		challenge.SetName("proxy-authenticate")
+	}
+	challenge.Realm = a.realm
+	challenge.Nonce = a.generateNonce()
+	challenge.Algorithm = a.algorithm
+	challenge.Qop = a.qop
+	challenge.Stale = stale
+
+	response := request.CreateResponseReason(statusCode, reason)
+	response.AddHeader(challenge)
+	return response
+}
+
+// 认证信息错误 403
+func (a *DigestAuthenticator) reject(request Request) Response {
+	return request.CreateResponseReason(StatusForbidden, "Forbidden")
+}
+
+func (a *DigestAuthenticator) respond(tx ServerTransaction, response Response) {
+	if tx == nil {
+		logger.Warnf("[authenticator] -> no server transaction to send %s", response.Short())
+		return
+	}
+	if err := tx.SendResponse(response); err != nil {
+		logger.Errorf("[authenticator] -> send %s failed: %s", response.Short(), err)
+	}
+}
+
+// nonce = hex(签发时间 + 随机数 + HMAC)
+func (a *DigestAuthenticator) generateNonce() string {
+	buf := make([]byte, 16, 48)
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(buf[8:16]); err != nil {
+		logger.Errorf("[authenticator] -> generate nonce failed: %s", err)
+	}
+
+	return hex.EncodeToString(append(buf, a.sign(buf)...))
+}
+
+// 验证 nonce 签名，返回过期时间
+func (a *DigestAuthenticator) verifyNonce(nonce string) (time.Time, bool) {
+	buf, err := hex.DecodeString(nonce)
+	if err != nil || len(buf) != 48 {
+		return time.Time{}, false
+	}
+	if !hmac.Equal(buf[16:], a.sign(buf[:16])) {
+		return time.Time{}, false
+	}
+
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(buf)))
+	return issued.Add(a.nonceTTL), true
+}
+
+func (a *DigestAuthenticator) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// nonce-count 必须递增，否则视为重放 RFC 7616 - 3.4
+func (a *DigestAuthenticator) useNonce(nonce string, nc uint32, expires time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if len(a.nonces) >= maxNonceCounts {
+		for key, state := range a.nonces {
+			if now.After(state.expires) {
+				delete(a.nonces, key)
+			}
+		}
+	}
+
+	state, ok := a.nonces[nonce]
+	if ok && nc <= state.nc {
+		return false
+	}
+	a.nonces[nonce] = nonceState{nc: nc, expires: expires}
+
+	return true
+}
@@ -0,0 +1,143 @@
+package sip
+
+import (
+	"testing"
+	"time"
+)
+
+func newAuthRequest(t *testing.T) Request {
+	from, err := ParseUri("sip:alice@192.0.2.1")
+	if err != nil {
+		t.Fatalf("parse from failed: %s", err)
+	}
+	to, err := ParseUri("sip:registrar@192.0.2.2")
+	if err != nil {
+		t.Fatalf("parse to failed: %s", err)
+	}
+
+	return CreateRequest(REGISTER, "192.0.2.2:5060", from, to)
+}
+
+// 按质询添加认证信息，返回认证后的请求副本
+func authorize(t *testing.T, req Request, challenge Response, password string) Request {
+	authorized := CopyRequest(req)
+	err := AuthorizeRequest(authorized, challenge, String{Str: "alice"}, String{Str: password})
+	if err != nil {
+		t.Fatalf("authorize failed: %s", err)
+	}
+
+	return authorized
+}
+
+func challengeOfResponse(t *testing.T, res Response) *Authorization {
+	hdrs := res.GetHeaders("WWW-Authenticate")
+	if len(hdrs) == 0 {
+		t.Fatalf("%d without WWW-Authenticate", res.StatusCode())
+	}
+
+	return challengeOf(hdrs[0])
+}
+
+func expectStatus(t *testing.T, res Response, status StatusCode, stale bool) {
+	t.Helper()
+	if status == 0 {
+		if res != nil {
+			t.Fatalf("response = %d, want authenticated", res.StatusCode())
+		}
+		return
+	}
+	if res == nil {
+		t.Fatalf("authenticated, want %d", status)
+	}
+	if res.StatusCode() != status {
+		t.Fatalf("status = %d, want %d", res.StatusCode(), status)
+	}
+	if status == StatusUnauthorized {
+		if challenge := challengeOfResponse(t, res); challenge.Stale != stale {
+			t.Errorf("stale = %t, want %t", challenge.Stale, stale)
+		}
+	}
+}
+
+func TestDigestAuthenticator(t *testing.T) {
+	credentials := PasswordCredentials{"alice": "secret"}
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	authenticator := NewDigestAuthenticator("gsip", credentials, AuthenticatorSecret(secret))
+	req := newAuthRequest(t)
+
+	challenge := authenticator.verify(req, false)
+	expectStatus(t, challenge, StatusUnauthorized, false)
+
+	t.Run("authenticated", func(t *testing.T) {
+		expectStatus(t, authenticator.verify(authorize(t, req, challenge, "secret"), false), 0, false)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		expectStatus(t, authenticator.verify(authorize(t, req, challenge, "wrong"), false), StatusForbidden, false)
+	})
+
+	t.Run("uri mismatch", func(t *testing.T) {
+		authorized := authorize(t, req, challenge, "secret")
+		other, _ := ParseUri("sip:other@192.0.2.2")
+		authorized.SetRecipient(other)
+		expectStatus(t, authenticator.verify(authorized, false), StatusBadRequest, false)
+	})
+
+	t.Run("forged nonce", func(t *testing.T) {
+		auth := challengeOfResponse(t, challenge)
+		// 修改签名的最后一个字符
+		last := auth.Nonce[len(auth.Nonce)-1:]
+		replaced := "0"
+		if last == "0" {
+			replaced = "1"
+		}
+		nonce := auth.Nonce[:len(auth.Nonce)-1] + replaced
+		forgedChallenge := req.CreateResponseReason(StatusUnauthorized, "Unauthorized")
+		header := CreateAuthenticate()
+		header.SetName("www-authenticate")
+		header.Realm = auth.Realm
+		header.Nonce = nonce
+		header.Algorithm = auth.Algorithm
+		header.Qop = auth.Qop
+		forgedChallenge.AddHeader(header)
+
+		expectStatus(t, authenticator.verify(authorize(t, req, forgedChallenge, "secret"), false), StatusUnauthorized, false)
+	})
+
+	t.Run("nonce of other secret", func(t *testing.T) {
+		other := NewDigestAuthenticator("gsip", credentials)
+		otherChallenge := other.verify(req, false)
+		expectStatus(t, authenticator.verify(authorize(t, req, otherChallenge, "secret"), false), StatusUnauthorized, false)
+
+		// 共享密钥的实例可以验证彼此签发的 nonce
+		shared := NewDigestAuthenticator("gsip", credentials, AuthenticatorSecret(secret))
+		expectStatus(t, shared.verify(authorize(t, req, challenge, "secret"), false), 0, false)
+	})
+
+	t.Run("nonce-count replay", func(t *testing.T) {
+		fresh := authenticator.verify(req, false)
+		authorized := authorize(t, req, fresh, "secret")
+		expectStatus(t, authenticator.verify(authorized, false), 0, false)
+		expectStatus(t, authenticator.verify(authorized, false), StatusUnauthorized, true)
+
+		// 同一 nonce 递增的 nonce-count 可以继续使用
+		expectStatus(t, authenticator.verify(authorize(t, req, fresh, "secret"), false), 0, false)
+	})
+}
+
+// nonce 过期但摘要正确时以 stale=true 质询
+func TestDigestAuthenticatorStaleNonce(t *testing.T) {
+	authenticator := NewDigestAuthenticator("gsip", PasswordCredentials{"alice": "secret"},
+		AuthenticatorNonceTTL(time.Millisecond))
+	req := newAuthRequest(t)
+
+	challenge := authenticator.verify(req, false)
+	expectStatus(t, challenge, StatusUnauthorized, false)
+	authorized := authorize(t, req, challenge, "secret")
+	time.Sleep(5 * time.Millisecond)
+	expectStatus(t, authenticator.verify(authorized, false), StatusUnauthorized, true)
+
+	// 摘要错误时不提示 stale
+	time.Sleep(5 * time.Millisecond)
+	expectStatus(t, authenticator.verify(authorize(t, req, challenge, "wrong"), false), StatusForbidden, false)
+}
@@ -46,6 +46,8 @@ const (
 	NOTIFY    RequestMethod = "NOTIFY"
 	REFER     RequestMethod = "REFER"
 	INFO      RequestMethod = "INFO"
+	UPDATE    RequestMethod = "UPDATE"
+	PRACK     RequestMethod = "PRACK"
 )
 
 const (
@@ -61,6 +63,8 @@ const (
 	DefaultUdpPort  Port = 5060
 	DefaultTcpPort  Port = 5060
 	DefaultTlsPort  Port = 5061
+	DefaultWsPort   Port = 80
+	DefaultWssPort  Port = 443
 )
 
 // 符合 RFC - 3261 Branch 的标识
@@ -168,6 +172,10 @@ func DefaultPort(protocol string) Port {
 	switch strings.ToLower(protocol) {
 	case "tls":
 		return DefaultTlsPort
+	case "ws":
+		return DefaultWsPort
+	case "wss":
+		return DefaultWssPort
 	case "tcp":
 		return DefaultTcpPort
 	case "udp":
@@ -0,0 +1,288 @@
+package sip
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/zenghr0820/gsip/utils"
+)
+
+// 消息体编码 RFC 3261 - 20.12
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// 多部分消息体 RFC 5621，例如 SDP 与 ISUP、SDP 与 XML
+type MultipartBody struct {
+	// multipart/mixed、multipart/alternative、multipart/related
+	ContentType string
+	Boundary    string
+	Parts       []*BodyPart
+}
+
+// 多部分消息体中的一个部分
+type BodyPart struct {
+	// 部分的 MIME 头部，Content-Type、Content-Disposition、Content-Encoding、Content-ID 等
+	Header textproto.MIMEHeader
+	Body   []byte
+}
+
+/**
+创建多部分消息体：
+	contentType：multipart/mixed、multipart/alternative 等，为空时使用 multipart/mixed
+边界随机生成
+*/
+func NewMultipartBody(contentType string, parts ...*BodyPart) *MultipartBody {
+	if contentType == "" {
+		contentType = "multipart/mixed"
+	}
+
+	return &MultipartBody{
+		ContentType: contentType,
+		Boundary:    "gsip-" + utils.RandString(24, true),
+		Parts:       parts,
+	}
+}
+
+// 创建消息体的一个部分
+func NewBodyPart(contentType string, body []byte) *BodyPart {
+	part := &BodyPart{
+		Header: make(textproto.MIMEHeader),
+		Body:   body,
+	}
+	if contentType != "" {
+		part.Header.Set("Content-Type", contentType)
+	}
+
+	return part
+}
+
+// 部分的 Content-Type
+func (part *BodyPart) ContentType() string {
+	return part.Header.Get("Content-Type")
+}
+
+// 部分的 Content-Disposition
+func (part *BodyPart) ContentDisposition() string {
+	return part.Header.Get("Content-Disposition")
+}
+
+// 设置部分的 Content-Disposition，例如 session、render;handling=optional
+func (part *BodyPart) SetContentDisposition(disposition string) *BodyPart {
+	part.Header.Set("Content-Disposition", disposition)
+	return part
+}
+
+// 部分的 Content-Encoding
+func (part *BodyPart) ContentEncoding() string {
+	return part.Header.Get("Content-Encoding")
+}
+
+// 按 Content-Encoding 解码后的部分内容
+func (part *BodyPart) Decoded() ([]byte, error) {
+	return DecodeBody(part.ContentEncoding(), part.Body)
+}
+
+// 嵌套的多部分消息体，部分不是 multipart/* 时返回 nil
+func (part *BodyPart) Multipart() (*MultipartBody, error) {
+	if !IsMultipart(part.ContentType()) {
+		return nil, nil
+	}
+
+	return ParseMultipart(part.ContentType(), part.Body)
+}
+
+// 消息体的 Content-Type，包含 boundary 参数
+func (mb *MultipartBody) Type() string {
+	return mime.FormatMediaType(mb.ContentType, map[string]string{"boundary": mb.Boundary})
+}
+
+// 添加部分
+func (mb *MultipartBody) AddPart(part *BodyPart) *MultipartBody {
+	mb.Parts = append(mb.Parts, part)
+	return mb
+}
+
+// 返回第一个指定 Content-Type 的部分，会查找嵌套的多部分消息体
+func (mb *MultipartBody) Part(contentType string) *BodyPart {
+	for _, part := range mb.Parts {
+		mediaType, _, err := mime.ParseMediaType(part.ContentType())
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(mediaType, contentType) {
+			return part
+		}
+		if nested, err := part.Multipart(); err == nil && nested != nil {
+			if found := nested.Part(contentType); found != nil {
+				return found
+			}
+		}
+	}
+
+	return nil
+}
+
+// 序列化消息体 RFC 2046 - 5.1.1
+func (mb *MultipartBody) Bytes() []byte {
+	var buffer bytes.Buffer
+
+	for _, part := range mb.Parts {
+		buffer.WriteString("--" + mb.Boundary + "\r\n")
+		for _, key := range sortedKeys(part.Header) {
+			for _, value := range part.Header[key] {
+				buffer.WriteString(key + ": " + value + "\r\n")
+			}
+		}
+		buffer.WriteString("\r\n")
+		buffer.Write(part.Body)
+		buffer.WriteString("\r\n")
+	}
+	buffer.WriteString("--" + mb.Boundary + "--\r\n")
+
+	return buffer.Bytes()
+}
+
+// Content-Type 是否为 multipart/*
+func IsMultipart(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.HasPrefix(strings.ToLower(mediaType), "multipart/")
+}
+
+// 解析多部分消息体，部分的内容保持原样不做解码
+func ParseMultipart(contentType string, body []byte) (*MultipartBody, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+	if !strings.HasPrefix(strings.ToLower(mediaType), "multipart/") {
+		return nil, fmt.Errorf("content type %q is not multipart", contentType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, fmt.Errorf("content type %q has no boundary", contentType)
+	}
+
+	mb := &MultipartBody{
+		ContentType: mediaType,
+		Boundary:    boundary,
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		// NextRawPart 不会解码 quoted-printable
+		p, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read multipart body failed: %w", err)
+		}
+		data, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, fmt.Errorf("read multipart body failed: %w", err)
+		}
+		mb.Parts = append(mb.Parts, &BodyPart{
+			Header: p.Header,
+			Body:   data,
+		})
+	}
+
+	return mb, nil
+}
+
+// 按 Content-Encoding 解码消息体，支持 gzip 以及 deflate，identity 或者为空时原样返回
+func DecodeBody(encoding string, body []byte) ([]byte, error) {
+	for _, coding := range reverseCodings(encoding) {
+		var reader io.ReadCloser
+		switch coding {
+		case "", "identity":
+			continue
+		case EncodingGzip:
+			r, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			reader = r
+		case EncodingDeflate:
+			reader = flate.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", coding)
+		}
+
+		decoded, err := ioutil.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+
+	return body, nil
+}
+
+// 按 Content-Encoding 编码消息体
+func EncodeBody(encoding string, body []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case EncodingGzip:
+		writer = gzip.NewWriter(&buffer)
+	case EncodingDeflate:
+		w, err := flate.NewWriter(&buffer, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		writer = w
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// 多个编码按应用顺序列出，解码时逆序
+func reverseCodings(encoding string) []string {
+	codings := strings.Split(encoding, ",")
+	reversed := make([]string, 0, len(codings))
+	for i := len(codings) - 1; i >= 0; i-- {
+		reversed = append(reversed, strings.ToLower(strings.TrimSpace(codings[i])))
+	}
+
+	return reversed
+}
+
+// 按固定顺序输出部分的头部，Content-Type 在最前
+func sortedKeys(header textproto.MIMEHeader) []string {
+	keys := make([]string, 0, len(header))
+	if _, ok := header["Content-Type"]; ok {
+		keys = append(keys, "Content-Type")
+	}
+	others := make([]string, 0, len(header))
+	for key := range header {
+		if key != "Content-Type" {
+			others = append(others, key)
+		}
+	}
+	sort.Strings(others)
+
+	return append(keys, others...)
+}
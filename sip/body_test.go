@@ -0,0 +1,218 @@
+package sip
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	multipartSdp = "v=0\r\no=alice 1 1 IN IP4 192.0.2.1\r\ns=-\r\nt=0 0\r\n"
+	multipartXml = "<?xml version=\"1.0\"?>\r\n<resource-lists/>"
+)
+
+// RFC 5621 - 3.1 的消息体：SDP 与嵌套的 multipart/alternative
+const nestedMultipart = "--outer\r\n" +
+	"Content-Type: multipart/alternative;boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"plain\r\n" +
+	"--inner\r\n" +
+	"Content-Type: application/sdp\r\n" +
+	"Content-Disposition: session\r\n" +
+	"\r\n" +
+	multipartSdp + "\r\n" +
+	"--inner--\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/resource-lists+xml\r\n" +
+	"Content-Disposition: recipient-list\r\n" +
+	"\r\n" +
+	multipartXml + "\r\n" +
+	"--outer--\r\n"
+
+func TestMultipartRoundTrip(t *testing.T) {
+	// 二进制内容保持不变
+	isup := []byte{0x01, 0x00, 0x0d, 0x0a, 0xff}
+	mb := NewMultipartBody("",
+		NewBodyPart("application/sdp", []byte(multipartSdp)).SetContentDisposition("session"),
+		NewBodyPart("application/isup; version=itu-t92+", isup).SetContentDisposition("signal;handling=optional"))
+	mb.Parts[1].Header.Set("Content-ID", "<isup@example.com>")
+
+	if mb.ContentType != "multipart/mixed" || !strings.HasPrefix(mb.Boundary, "gsip-") {
+		t.Errorf("content type = %s, boundary = %s", mb.ContentType, mb.Boundary)
+	}
+	if want := "multipart/mixed; boundary=" + mb.Boundary; mb.Type() != want {
+		t.Errorf("type = %s, want %s", mb.Type(), want)
+	}
+
+	parsed, err := ParseMultipart(mb.Type(), mb.Bytes())
+	if err != nil {
+		t.Fatalf("parse multipart failed: %s", err)
+	}
+	if parsed.ContentType != mb.ContentType || parsed.Boundary != mb.Boundary || len(parsed.Parts) != 2 {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+	for i, part := range parsed.Parts {
+		want := mb.Parts[i]
+		if !bytes.Equal(part.Body, want.Body) {
+			t.Errorf("body of part %d = %q, want %q", i, part.Body, want.Body)
+		}
+		if part.ContentType() != want.ContentType() || part.ContentDisposition() != want.ContentDisposition() {
+			t.Errorf("part %d = %s; %s, want %s; %s", i, part.ContentType(), part.ContentDisposition(),
+				want.ContentType(), want.ContentDisposition())
+		}
+	}
+	if id := parsed.Parts[1].Header.Get("Content-ID"); id != "<isup@example.com>" {
+		t.Errorf("Content-ID = %s", id)
+	}
+	// 参数不影响查找
+	if part := parsed.Part("application/isup"); part == nil || !bytes.Equal(part.Body, isup) {
+		t.Errorf("ISUP part = %v", part)
+	}
+	// 重新序列化的结果相同
+	if !bytes.Equal(parsed.Bytes(), mb.Bytes()) {
+		t.Errorf("serialized as\n%s\nwant\n%s", parsed.Bytes(), mb.Bytes())
+	}
+}
+
+func TestParseNestedMultipart(t *testing.T) {
+	mb, err := ParseMultipart("multipart/mixed;boundary=outer", []byte(nestedMultipart))
+	if err != nil {
+		t.Fatalf("parse multipart failed: %s", err)
+	}
+	if len(mb.Parts) != 2 {
+		t.Fatalf("%d parts, want 2", len(mb.Parts))
+	}
+
+	nested, err := mb.Parts[0].Multipart()
+	if err != nil || nested == nil || nested.ContentType != "multipart/alternative" || len(nested.Parts) != 2 {
+		t.Fatalf("nested = %+v, %v", nested, err)
+	}
+	if plain, err := mb.Parts[1].Multipart(); plain != nil || err != nil {
+		t.Errorf("multipart of xml part = %+v, %v", plain, err)
+	}
+
+	sdp := mb.Part("application/sdp")
+	if sdp == nil || string(sdp.Body) != multipartSdp || sdp.ContentDisposition() != "session" {
+		t.Errorf("SDP part = %+v", sdp)
+	}
+	if xml := mb.Part("application/resource-lists+xml"); xml == nil || string(xml.Body) != multipartXml {
+		t.Errorf("xml part = %+v", xml)
+	}
+	if part := mb.Part("application/isup"); part != nil {
+		t.Errorf("found %s part", part.ContentType())
+	}
+}
+
+func TestParseMultipartError(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"not multipart", "application/sdp", multipartSdp},
+		{"without boundary", "multipart/mixed", nestedMultipart},
+		{"invalid content type", "multipart/mixed;boundary=", nestedMultipart},
+		{"unterminated part", "multipart/mixed;boundary=outer", "--outer\r\nContent-Type: text/plain\r\n\r\nplain"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if mb, err := ParseMultipart(test.contentType, []byte(test.body)); err == nil {
+				t.Errorf("parsed as %+v", mb)
+			}
+		})
+	}
+
+	if !IsMultipart("Multipart/Related; boundary=x") || IsMultipart("application/sdp") || IsMultipart("") {
+		t.Error("IsMultipart mismatch")
+	}
+}
+
+func TestContentEncoding(t *testing.T) {
+	body := []byte(strings.Repeat(multipartSdp, 4))
+	for _, encoding := range []string{"", "identity", EncodingGzip, EncodingDeflate, "deflate, gzip"} {
+		t.Run(fmt.Sprintf("%q", encoding), func(t *testing.T) {
+			encoded := body
+			// 多个编码按顺序应用
+			for _, coding := range strings.Split(encoding, ",") {
+				var err error
+				if encoded, err = EncodeBody(coding, encoded); err != nil {
+					t.Fatalf("encode failed: %s", err)
+				}
+			}
+			decoded, err := DecodeBody(encoding, encoded)
+			if err != nil {
+				t.Fatalf("decode failed: %s", err)
+			}
+			if !bytes.Equal(decoded, body) {
+				t.Errorf("decoded = %q, want %q", decoded, body)
+			}
+
+			part := NewBodyPart("application/sdp", encoded)
+			if encoding != "" {
+				part.Header.Set("Content-Encoding", encoding)
+			}
+			if decoded, err := part.Decoded(); err != nil || !bytes.Equal(decoded, body) {
+				t.Errorf("decoded part = %q, %v", decoded, err)
+			}
+		})
+	}
+
+	if _, err := EncodeBody("br", body); err == nil {
+		t.Error("encoded with unsupported encoding")
+	}
+	if _, err := DecodeBody("br", body); err == nil {
+		t.Error("decoded with unsupported encoding")
+	}
+	if _, err := DecodeBody(EncodingGzip, body); err == nil {
+		t.Error("decoded invalid gzip body")
+	}
+}
+
+// 设置多部分消息体时更新 Content-Type 以及 Content-Length，解析消息时解析多部分消息体
+func TestMessageMultipart(t *testing.T) {
+	req := newAuthRequest(t)
+	if mb, err := req.Multipart(); mb != nil || err != nil {
+		t.Errorf("multipart of empty body = %+v, %v", mb, err)
+	}
+
+	mb := NewMultipartBody("multipart/alternative",
+		NewBodyPart("application/sdp", []byte(multipartSdp)),
+		NewBodyPart("application/resource-lists+xml", []byte(multipartXml)))
+	req.SetMultipart(mb)
+	if ct := req.ContentType(); ct == nil || string(*ct) != mb.Type() {
+		t.Errorf("Content-Type = %v, want %s", ct, mb.Type())
+	}
+	if cl := req.ContentLength(); cl == nil || int(*cl) != len(mb.Bytes()) {
+		t.Errorf("Content-Length = %v, want %d", cl, len(mb.Bytes()))
+	}
+
+	msg, err := ParseMessage([]byte(req.String()))
+	if err != nil {
+		t.Fatalf("parse message failed: %s", err)
+	}
+	if !bytes.Equal(msg.Body(), mb.Bytes()) {
+		t.Errorf("body = %q, want %q", msg.Body(), mb.Bytes())
+	}
+	parsed, err := msg.Multipart()
+	if err != nil || parsed == nil {
+		t.Fatalf("multipart = %+v, %v", parsed, err)
+	}
+	if parsed.Boundary != mb.Boundary || len(parsed.Parts) != 2 {
+		t.Errorf("parsed = %+v", parsed)
+	}
+	if xml := parsed.Part("application/resource-lists+xml"); xml == nil || string(xml.Body) != multipartXml {
+		t.Errorf("xml part = %+v", xml)
+	}
+
+	// 设置消息体后不再返回之前的多部分消息体
+	req.SetBody([]byte(multipartSdp), true)
+	if body, _ := req.Multipart(); body == mb {
+		t.Error("multipart not reset by SetBody")
+	}
+}
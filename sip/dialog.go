@@ -0,0 +1,527 @@
+package sip
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/zenghr0820/gsip/logger"
+)
+
+// 对话状态 RFC 3261 - 12
+type DialogState int
+
+const (
+	// 收到或发送带 To tag 的 1xx 响应
+	DialogStateEarly DialogState = iota + 1
+	// 收到或发送 2xx 响应
+	DialogStateConfirmed
+	DialogStateTerminated
+)
+
+func (state DialogState) String() string {
+	switch state {
+	case DialogStateEarly:
+		return "Early"
+	case DialogStateConfirmed:
+		return "Confirmed"
+	case DialogStateTerminated:
+		return "Terminated"
+	default:
+		return "Unknown"
+	}
+}
+
+// 对话中的角色
+type DialogRole int
+
+const (
+	DialogRoleUAC DialogRole = iota + 1
+	DialogRoleUAS
+)
+
+func (role DialogRole) String() string {
+	if role == DialogRoleUAS {
+		return "UAS"
+	}
+	return "UAC"
+}
+
+// 对话 ID：Call-ID、本地 tag、远端 tag RFC 3261 - 12
+func DialogID(callID string, localTag string, remoteTag string) string {
+	return fmt.Sprintf("%s#%s#%s", callID, localTag, remoteTag)
+}
+
+// 接收方的对话 ID：请求的本地 tag 为 To tag，响应的本地 tag 为 From tag
+func ReceivedDialogID(msg Message) string {
+	callID, fromTag, toTag := dialogFields(msg)
+	if _, ok := msg.(Request); ok {
+		return DialogID(callID, toTag, fromTag)
+	}
+	return DialogID(callID, fromTag, toTag)
+}
+
+// 发送方的对话 ID：请求的本地 tag 为 From tag，响应的本地 tag 为 To tag
+func SentDialogID(msg Message) string {
+	callID, fromTag, toTag := dialogFields(msg)
+	if _, ok := msg.(Request); ok {
+		return DialogID(callID, fromTag, toTag)
+	}
+	return DialogID(callID, toTag, fromTag)
+}
+
+func dialogFields(msg Message) (callID string, fromTag string, toTag string) {
+	if c := msg.CallID(); c != nil {
+		callID = string(*c)
+	}
+	if from := msg.From(); from != nil {
+		fromTag = headerTag(from.Params)
+	}
+	if to := msg.To(); to != nil {
+		toTag = headerTag(to.Params)
+	}
+
+	return
+}
+
+func headerTag(params Params) string {
+	if params == nil {
+		return ""
+	}
+	if tag, ok := params.Get("tag"); ok && tag != nil {
+		return tag.String()
+	}
+	return ""
+}
+
+// 对话，保存对话状态并生成对话内的请求
+type Dialog interface {
+	ID() string
+	Role() DialogRole
+	State() DialogState
+	CallID() CallID
+	LocalTag() string
+	RemoteTag() string
+	LocalUri() Uri
+	RemoteUri() Uri
+	// 远端目标，对话内请求的 Request-URI
+	RemoteTarget() Uri
+	// 路由集合
+	RouteSet() []Uri
+	LocalSeq() uint32
+	RemoteSeq() uint32
+	Secure() bool
+
+	// 生成对话内的请求 RFC 3261 - 12.2.1.1，除 ACK、CANCEL 之外本地 CSeq 自增
+	CreateRequest(method RequestMethod) Request
+	// 校验远端请求的 CSeq 并更新 RFC 3261 - 12.2.2，乱序时返回 false
+	ReceiveRequest(req Request) bool
+	// 更新对话：2xx 确认早期对话，目标刷新请求更新远端目标
+	Update(req Request, res Response)
+
+	GetAttribute(key string) interface{}
+	SetAttribute(key string, value interface{})
+	DelAttribute(key string)
+
+	// 终止对话
+	Close()
+	// 因异常终止对话，例如 2xx 没有收到 ACK，err 由 Err 返回
+	CloseWithError(err error)
+	// 对话是否已经终止
+	Done() <-chan struct{}
+	// 对话异常终止的原因，正常终止或者未终止时为 nil
+	Err() error
+	String() string
+}
+
+type dialog struct {
+	role         DialogRole
+	state        DialogState
+	callID       CallID
+	localTag     string
+	remoteTag    string
+	localUri     Uri
+	remoteUri    Uri
+	remoteTarget Uri
+	routeSet     []Uri
+	localSeq     uint32
+	remoteSeq    uint32
+	// 远端 CSeq 是否为空 RFC 3261 - 12.1.2
+	remoteSeqSet bool
+	secure       bool
+	store        map[string]interface{}
+
+	mu        sync.RWMutex
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+}
+
+/**
+UAC 根据请求以及带 To tag 的 1xx/2xx 响应创建对话 RFC 3261 - 12.1.2：
+	路由集合为响应 Record-Route 的逆序，远端目标为响应的 Contact
+*/
+func NewUACDialog(req Request, res Response) (Dialog, error) {
+	callID, fromTag, toTag := dialogFields(res)
+	if toTag == "" {
+		return nil, fmt.Errorf("[dialog] -> response %s has no To tag", res.Short())
+	}
+	if res.From() == nil || res.To() == nil || res.CSeq() == nil {
+		return nil, fmt.Errorf("[dialog] -> response %s missing required headers", res.Short())
+	}
+
+	d := &dialog{
+		role:      DialogRoleUAC,
+		state:     DialogStateEarly,
+		callID:    CallID(callID),
+		localTag:  fromTag,
+		remoteTag: toTag,
+		localUri:  res.From().Address.Copy(),
+		remoteUri: res.To().Address.Copy(),
+		localSeq:  res.CSeq().SeqNo,
+		store:     make(map[string]interface{}),
+		done:      make(chan struct{}),
+	}
+	if recipient := req.Recipient(); recipient != nil {
+		d.secure = recipient.IsEncrypted()
+	}
+	d.routeSet = reverseUris(recordRoutes(res))
+	d.remoteTarget = contactUri(res, d.remoteUri)
+	if res.IsSuccess() {
+		d.state = DialogStateConfirmed
+	}
+
+	return d, nil
+}
+
+/**
+UAS 根据请求以及带 To tag 的 1xx/2xx 响应创建对话 RFC 3261 - 12.1.1：
+	路由集合为请求的 Record-Route，远端目标为请求的 Contact，远端 CSeq 为请求的 CSeq
+*/
+func NewUASDialog(req Request, res Response) (Dialog, error) {
+	callID, fromTag, toTag := dialogFields(res)
+	if toTag == "" {
+		return nil, fmt.Errorf("[dialog] -> response %s has no To tag", res.Short())
+	}
+	if req.From() == nil || req.To() == nil || req.CSeq() == nil {
+		return nil, fmt.Errorf("[dialog] -> request %s missing required headers", req.Short())
+	}
+
+	d := &dialog{
+		role:         DialogRoleUAS,
+		state:        DialogStateEarly,
+		callID:       CallID(callID),
+		localTag:     toTag,
+		remoteTag:    fromTag,
+		localUri:     req.To().Address.Copy(),
+		remoteUri:    req.From().Address.Copy(),
+		remoteSeq:    req.CSeq().SeqNo,
+		remoteSeqSet: true,
+		store:        make(map[string]interface{}),
+		done:         make(chan struct{}),
+	}
+	if recipient := req.Recipient(); recipient != nil {
+		d.secure = recipient.IsEncrypted()
+	}
+	d.routeSet = recordRoutes(req)
+	d.remoteTarget = contactUri(req, d.remoteUri)
+	if res.IsSuccess() {
+		d.state = DialogStateConfirmed
+	}
+
+	return d, nil
+}
+
+/**
+订阅方在 SUBSCRIBE(或者 REFER)的 2xx 之前收到 NOTIFY 时根据 NOTIFY 创建对话 RFC 6665 - 4.1.2.4：
+	路由集合为 NOTIFY 的 Record-Route，远端目标为 NOTIFY 的 Contact，远端 CSeq 由之后的 ReceiveRequest 设置
+*/
+func NewSubscriberDialog(subscribe Request, notify Request) (Dialog, error) {
+	callID, fromTag, toTag := dialogFields(notify)
+	if fromTag == "" {
+		return nil, fmt.Errorf("[dialog] -> request %s has no From tag", notify.Short())
+	}
+	if subscribe.From() == nil || subscribe.To() == nil || subscribe.CSeq() == nil {
+		return nil, fmt.Errorf("[dialog] -> request %s missing required headers", subscribe.Short())
+	}
+
+	d := &dialog{
+		role:      DialogRoleUAC,
+		state:     DialogStateConfirmed,
+		callID:    CallID(callID),
+		localTag:  toTag,
+		remoteTag: fromTag,
+		localUri:  subscribe.From().Address.Copy(),
+		remoteUri: subscribe.To().Address.Copy(),
+		localSeq:  subscribe.CSeq().SeqNo,
+		store:     make(map[string]interface{}),
+		done:      make(chan struct{}),
+	}
+	if recipient := subscribe.Recipient(); recipient != nil {
+		d.secure = recipient.IsEncrypted()
+	}
+	d.routeSet = recordRoutes(notify)
+	d.remoteTarget = contactUri(notify, d.remoteUri)
+
+	return d, nil
+}
+
+func (d *dialog) ID() string {
+	return DialogID(string(d.callID), d.localTag, d.remoteTag)
+}
+
+func (d *dialog) Role() DialogRole {
+	return d.role
+}
+
+func (d *dialog) State() DialogState {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.state
+}
+
+func (d *dialog) CallID() CallID {
+	return d.callID
+}
+
+func (d *dialog) LocalTag() string {
+	return d.localTag
+}
+
+func (d *dialog) RemoteTag() string {
+	return d.remoteTag
+}
+
+func (d *dialog) LocalUri() Uri {
+	return d.localUri.Copy()
+}
+
+func (d *dialog) RemoteUri() Uri {
+	return d.remoteUri.Copy()
+}
+
+func (d *dialog) RemoteTarget() Uri {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.remoteTarget.Copy()
+}
+
+func (d *dialog) RouteSet() []Uri {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	routes := make([]Uri, 0, len(d.routeSet))
+	for _, uri := range d.routeSet {
+		routes = append(routes, uri.Copy())
+	}
+	return routes
+}
+
+func (d *dialog) LocalSeq() uint32 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.localSeq
+}
+
+func (d *dialog) RemoteSeq() uint32 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.remoteSeq
+}
+
+func (d *dialog) Secure() bool {
+	return d.secure
+}
+
+func (d *dialog) CreateRequest(method RequestMethod) Request {
+	d.mu.Lock()
+	// RFC 3261 - 12.2.1.1 本地 CSeq 为空时使用随机值
+	if d.localSeq == 0 {
+		d.localSeq = DefaultCSeq().SeqNo
+	} else if method != ACK && method != CANCEL {
+		d.localSeq++
+	}
+	seq := d.localSeq
+	remoteTarget := d.remoteTarget.Copy()
+	routes := make([]Uri, 0, len(d.routeSet))
+	for _, uri := range d.routeSet {
+		routes = append(routes, uri.Copy())
+	}
+	d.mu.Unlock()
+
+	req := CreateSimpleRequest(method, "")
+	req.AddHeader(DefaultViaHeader())
+	req.AddHeader(&FromHeader{
+		Address: d.localUri.Copy(),
+		Params:  NewParams().Add("tag", String{Str: d.localTag}),
+	})
+	to := &ToHeader{
+		Address: d.remoteUri.Copy(),
+		Params:  NewParams(),
+	}
+	if d.remoteTag != "" {
+		to.Params.Add("tag", String{Str: d.remoteTag})
+	}
+	req.AddHeader(to)
+	callID := d.callID
+	req.AddHeader(&callID)
+	req.AddHeader(&CSeq{SeqNo: seq, MethodName: method})
+	req.AddHeader(DefaultMaxForwards())
+
+	// RFC 3261 - 12.2.1.1 路由集合的第一个 URI 包含 lr 参数时为松散路由
+	recipient := remoteTarget
+	if len(routes) > 0 {
+		if params := routes[0].UriParams(); params == nil || !params.Has("lr") {
+			// 严格路由：Request-URI 为第一个路由，远端目标放在路由集合最后
+			recipient = routes[0].Copy()
+			recipient.SetHeaders(nil)
+			routes = append(routes[1:], remoteTarget)
+		}
+		req.AddHeader(&RouteHeader{Addresses: routes})
+	}
+	req.SetRecipient(recipient)
+
+	return req
+}
+
+func (d *dialog) ReceiveRequest(req Request) bool {
+	cseq := req.CSeq()
+	if cseq == nil {
+		return false
+	}
+	// ACK、CANCEL 的 CSeq 与对应的请求相同
+	if req.IsAck() || req.IsCancel() {
+		return true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.remoteSeqSet && cseq.SeqNo <= d.remoteSeq {
+		return false
+	}
+	d.remoteSeq = cseq.SeqNo
+	d.remoteSeqSet = true
+
+	return true
+}
+
+func (d *dialog) Update(req Request, res Response) {
+	if !res.IsSuccess() {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state == DialogStateTerminated {
+		return
+	}
+
+	// RFC 3261 - 12.1.2 早期对话收到 2xx 时重新计算路由集合
+	if d.state == DialogStateEarly && req.IsInvite() {
+		d.state = DialogStateConfirmed
+		if d.role == DialogRoleUAC {
+			d.routeSet = reverseUris(recordRoutes(res))
+		}
+	}
+
+	// RFC 3261 - 12.2 目标刷新请求更新远端目标：本端发送的请求使用响应的 Contact，远端发送的请求使用请求的 Contact
+	if isTargetRefresh(req.Method()) {
+		var msg Message = req
+		if _, fromTag, _ := dialogFields(req); fromTag == d.localTag {
+			msg = res
+		}
+		if contact := msg.Contact(); contact != nil && contact.Address != nil {
+			d.remoteTarget = contact.Address.Copy()
+		}
+	}
+}
+
+func (d *dialog) GetAttribute(key string) interface{} {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.store[key]
+}
+
+func (d *dialog) SetAttribute(key string, value interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.store[key] = value
+}
+
+func (d *dialog) DelAttribute(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.store, key)
+}
+
+func (d *dialog) Close() {
+	d.CloseWithError(nil)
+}
+
+func (d *dialog) CloseWithError(err error) {
+	d.closeOnce.Do(func() {
+		d.mu.Lock()
+		d.state = DialogStateTerminated
+		d.err = err
+		d.mu.Unlock()
+
+		close(d.done)
+		logger.Debugf("[dialog] -> dialog %s terminated", d.ID())
+	})
+}
+
+func (d *dialog) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *dialog) Err() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.err
+}
+
+func (d *dialog) String() string {
+	return fmt.Sprintf("sip.Dialog<%s %s %s>", d.role, d.ID(), d.State())
+}
+
+// 目标刷新请求 RFC 3261 - 12.2、RFC 3311、RFC 6665 - 4.1.2.4
+func isTargetRefresh(method RequestMethod) bool {
+	return method == INVITE || method == UPDATE || method == SUBSCRIBE || method == NOTIFY
+}
+
+// 按顺序返回所有 Record-Route 中的 URI
+func recordRoutes(msg Message) []Uri {
+	routes := make([]Uri, 0)
+	for _, hdr := range msg.GetHeaders("Record-Route") {
+		if rr, ok := hdr.(*RecordRouteHeader); ok {
+			for _, uri := range rr.Addresses {
+				routes = append(routes, uri.Copy())
+			}
+		}
+	}
+	return routes
+}
+
+func reverseUris(uris []Uri) []Uri {
+	for i, j := 0, len(uris)-1; i < j; i, j = i+1, j-1 {
+		uris[i], uris[j] = uris[j], uris[i]
+	}
+	return uris
+}
+
+// 消息 Contact 中的 URI，没有时使用 fallback
+func contactUri(msg Message, fallback Uri) Uri {
+	if contact := msg.Contact(); contact != nil && contact.Address != nil {
+		return contact.Address.Copy()
+	}
+	return fallback.Copy()
+}
@@ -0,0 +1,316 @@
+package sip
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// alice 经过代理 p1、p2 呼叫 bob，两个代理都记录路由
+const dialogRecordRoute = "Record-Route: <sip:p2.example.com;lr>, <sip:p1.example.com;lr>"
+
+func parseDialogMessage(t *testing.T, lines ...string) Message {
+	t.Helper()
+	data := strings.Join(append(lines, "Content-Length: 0", "", ""), "\r\n")
+	msg, err := ParseMessage([]byte(data))
+	if err != nil {
+		t.Fatalf("parse message failed: %s", err)
+	}
+
+	return msg
+}
+
+func dialogInvite(t *testing.T, headers ...string) Request {
+	t.Helper()
+	lines := append([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bKdialog",
+		"From: <sip:alice@example.com>;tag=alice",
+		"To: <sip:bob@example.com>",
+		"Call-ID: dialog-call",
+		"CSeq: 1 INVITE",
+		"Contact: <sip:alice@192.0.2.1>",
+		"Max-Forwards: 70",
+	}, headers...)
+
+	return parseDialogMessage(t, lines...).(Request)
+}
+
+// INVITE 的响应，toTag 为空时不带 To tag
+func dialogResponse(t *testing.T, status StatusCode, toTag string, headers ...string) Response {
+	t.Helper()
+	to := "To: <sip:bob@example.com>"
+	if toTag != "" {
+		to += ";tag=" + toTag
+	}
+	lines := append([]string{
+		fmt.Sprintf("SIP/2.0 %d Reason", status),
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bKdialog",
+		"From: <sip:alice@example.com>;tag=alice",
+		to,
+		"Call-ID: dialog-call",
+		"CSeq: 1 INVITE",
+		"Contact: <sip:bob@192.0.2.2:5070>",
+	}, headers...)
+
+	return parseDialogMessage(t, lines...).(Response)
+}
+
+// alice 在对话内发送给 bob 的请求
+func dialogRequest(t *testing.T, method RequestMethod, seq uint32) Request {
+	t.Helper()
+	return parseDialogMessage(t,
+		fmt.Sprintf("%s sip:bob@192.0.2.2:5070 SIP/2.0", method),
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch="+GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=alice",
+		"To: <sip:bob@example.com>;tag=bob",
+		"Call-ID: dialog-call",
+		fmt.Sprintf("CSeq: %d %s", seq, method),
+		"Max-Forwards: 70",
+	).(Request)
+}
+
+func uriStrings(uris []Uri) []string {
+	strs := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		strs = append(strs, uri.String())
+	}
+	return strs
+}
+
+func expectRoutes(t *testing.T, name string, routes []Uri, want ...string) {
+	t.Helper()
+	if got := strings.Join(uriStrings(routes), ", "); got != strings.Join(want, ", ") {
+		t.Errorf("%s = [%s], want %v", name, got, want)
+	}
+}
+
+func TestNewUACDialog(t *testing.T) {
+	invite := dialogInvite(t)
+
+	if _, err := NewUACDialog(invite, dialogResponse(t, StatusTrying, "")); err == nil {
+		t.Error("dialog created by response without To tag")
+	}
+
+	early, err := NewUACDialog(invite, dialogResponse(t, StatusRinging, "bob", dialogRecordRoute))
+	if err != nil {
+		t.Fatalf("create dialog failed: %s", err)
+	}
+	if early.State() != DialogStateEarly {
+		t.Errorf("state of 180 dialog = %s, want Early", early.State())
+	}
+
+	d, err := NewUACDialog(invite, dialogResponse(t, StatusOK, "bob", dialogRecordRoute))
+	if err != nil {
+		t.Fatalf("create dialog failed: %s", err)
+	}
+	if d.Role() != DialogRoleUAC || d.State() != DialogStateConfirmed {
+		t.Errorf("dialog = %s, want confirmed UAC dialog", d)
+	}
+	if d.ID() != DialogID("dialog-call", "alice", "bob") || d.LocalTag() != "alice" || d.RemoteTag() != "bob" {
+		t.Errorf("dialog ID = %s", d.ID())
+	}
+	if d.LocalSeq() != 1 || d.RemoteSeq() != 0 {
+		t.Errorf("local CSeq = %d, remote CSeq = %d, want 1 and empty", d.LocalSeq(), d.RemoteSeq())
+	}
+	if target := d.RemoteTarget().String(); target != "sip:bob@192.0.2.2:5070" {
+		t.Errorf("remote target = %s, want Contact of the response", target)
+	}
+	// 路由集合为 Record-Route 的逆序
+	expectRoutes(t, "route set", d.RouteSet(), "sip:p1.example.com;lr", "sip:p2.example.com;lr")
+}
+
+func TestNewUASDialog(t *testing.T) {
+	invite := dialogInvite(t, dialogRecordRoute)
+
+	if _, err := NewUASDialog(invite, dialogResponse(t, StatusTrying, "")); err == nil {
+		t.Error("dialog created by response without To tag")
+	}
+
+	d, err := NewUASDialog(invite, dialogResponse(t, StatusOK, "bob"))
+	if err != nil {
+		t.Fatalf("create dialog failed: %s", err)
+	}
+	if d.Role() != DialogRoleUAS || d.State() != DialogStateConfirmed {
+		t.Errorf("dialog = %s, want confirmed UAS dialog", d)
+	}
+	if d.ID() != DialogID("dialog-call", "bob", "alice") || d.ID() != ReceivedDialogID(dialogRequest(t, BYE, 2)) {
+		t.Errorf("dialog ID = %s", d.ID())
+	}
+	if d.LocalSeq() != 0 || d.RemoteSeq() != 1 {
+		t.Errorf("local CSeq = %d, remote CSeq = %d, want empty and 1", d.LocalSeq(), d.RemoteSeq())
+	}
+	if target := d.RemoteTarget().String(); target != "sip:alice@192.0.2.1" {
+		t.Errorf("remote target = %s, want Contact of the request", target)
+	}
+	// 路由集合与 Record-Route 顺序相同
+	expectRoutes(t, "route set", d.RouteSet(), "sip:p2.example.com;lr", "sip:p1.example.com;lr")
+}
+
+func TestDialogCreateRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		recordRoute string
+		recipient   string
+		routes      []string
+	}{
+		{
+			name:      "without route set",
+			recipient: "sip:bob@192.0.2.2:5070",
+		},
+		{
+			name:        "loose routing",
+			recordRoute: dialogRecordRoute,
+			recipient:   "sip:bob@192.0.2.2:5070",
+			routes:      []string{"sip:p1.example.com;lr", "sip:p2.example.com;lr"},
+		},
+		{
+			// 第一个路由不包含 lr 参数时，Request-URI 为第一个路由，远端目标放在路由集合最后
+			name:        "strict routing",
+			recordRoute: "Record-Route: <sip:p2.example.com;lr>, <sip:p1.example.com>",
+			recipient:   "sip:p1.example.com",
+			routes:      []string{"sip:p2.example.com;lr", "sip:bob@192.0.2.2:5070"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var headers []string
+			if test.recordRoute != "" {
+				headers = append(headers, test.recordRoute)
+			}
+			d, err := NewUACDialog(dialogInvite(t), dialogResponse(t, StatusOK, "bob", headers...))
+			if err != nil {
+				t.Fatalf("create dialog failed: %s", err)
+			}
+
+			bye := d.CreateRequest(BYE)
+			if recipient := bye.Recipient().String(); recipient != test.recipient {
+				t.Errorf("Request-URI = %s, want %s", recipient, test.recipient)
+			}
+			var routes []Uri
+			for _, hdr := range bye.GetHeaders("Route") {
+				routes = append(routes, hdr.(*RouteHeader).Addresses...)
+			}
+			expectRoutes(t, "Route", routes, test.routes...)
+			// 生成请求不修改对话的路由集合
+			if len(test.routes) > 0 && len(d.RouteSet()) != len(test.routes) {
+				t.Errorf("route set changed to %v", uriStrings(d.RouteSet()))
+			}
+		})
+	}
+}
+
+// 对话内请求的 From、To、Call-ID 以及 CSeq
+func TestDialogCreateRequestHeaders(t *testing.T) {
+	d, err := NewUACDialog(dialogInvite(t), dialogResponse(t, StatusOK, "bob"))
+	if err != nil {
+		t.Fatalf("create dialog failed: %s", err)
+	}
+
+	ack := d.CreateRequest(ACK)
+	if ack.CSeq().SeqNo != 1 {
+		t.Errorf("ACK CSeq = %d, want CSeq of the INVITE", ack.CSeq().SeqNo)
+	}
+	bye := d.CreateRequest(BYE)
+	if bye.CSeq().SeqNo != 2 || bye.CSeq().MethodName != BYE || d.LocalSeq() != 2 {
+		t.Errorf("BYE CSeq = %s, local CSeq = %d, want 2", bye.CSeq(), d.LocalSeq())
+	}
+	if SentDialogID(bye) != d.ID() {
+		t.Errorf("dialog ID of BYE = %s, want %s", SentDialogID(bye), d.ID())
+	}
+	if from := bye.From().Address.String(); from != "sip:alice@example.com" {
+		t.Errorf("From = %s, want local URI", from)
+	}
+	if to := bye.To().Address.String(); to != "sip:bob@example.com" {
+		t.Errorf("To = %s, want remote URI", to)
+	}
+
+	// UAS 对话的本地 CSeq 为空时使用随机值
+	uas, err := NewUASDialog(dialogInvite(t), dialogResponse(t, StatusOK, "bob"))
+	if err != nil {
+		t.Fatalf("create dialog failed: %s", err)
+	}
+	if seq := uas.CreateRequest(BYE).CSeq().SeqNo; seq == 0 || seq != uas.LocalSeq() {
+		t.Errorf("BYE CSeq = %d, local CSeq = %d", seq, uas.LocalSeq())
+	}
+}
+
+// 远端 CSeq 必须递增，ACK 以及 CANCEL 使用对应请求的 CSeq
+func TestDialogReceiveRequest(t *testing.T) {
+	d, err := NewUASDialog(dialogInvite(t), dialogResponse(t, StatusOK, "bob"))
+	if err != nil {
+		t.Fatalf("create dialog failed: %s", err)
+	}
+
+	steps := []struct {
+		method RequestMethod
+		seq    uint32
+		ok     bool
+	}{
+		{ACK, 1, true},
+		{INFO, 1, false},
+		{INFO, 3, true},
+		{BYE, 2, false},
+		{INFO, 3, false},
+		{CANCEL, 3, true},
+		{BYE, 4, true},
+	}
+	for _, step := range steps {
+		if ok := d.ReceiveRequest(dialogRequest(t, step.method, step.seq)); ok != step.ok {
+			t.Errorf("receive %s with CSeq %d = %t, want %t", step.method, step.seq, ok, step.ok)
+		}
+	}
+	if d.RemoteSeq() != 4 {
+		t.Errorf("remote CSeq = %d, want 4", d.RemoteSeq())
+	}
+
+	// UAC 对话的远端 CSeq 为空，第一个请求的 CSeq 可以为任意值
+	uac, err := NewUACDialog(dialogInvite(t), dialogResponse(t, StatusOK, "bob"))
+	if err != nil {
+		t.Fatalf("create dialog failed: %s", err)
+	}
+	if !uac.ReceiveRequest(dialogRequest(t, INFO, 0)) {
+		t.Error("first request with CSeq 0 rejected")
+	}
+	if uac.ReceiveRequest(dialogRequest(t, INFO, 0)) {
+		t.Error("request with the same CSeq accepted")
+	}
+}
+
+// 早期对话收到 2xx 时确认并重新计算路由集合，目标刷新请求更新远端目标
+func TestDialogUpdate(t *testing.T) {
+	invite := dialogInvite(t)
+	d, err := NewUACDialog(invite, dialogResponse(t, StatusRinging, "bob", "Record-Route: <sip:p1.example.com;lr>"))
+	if err != nil {
+		t.Fatalf("create dialog failed: %s", err)
+	}
+
+	d.Update(invite, dialogResponse(t, StatusOK, "bob", dialogRecordRoute))
+	if d.State() != DialogStateConfirmed {
+		t.Errorf("state = %s, want Confirmed", d.State())
+	}
+	expectRoutes(t, "route set", d.RouteSet(), "sip:p1.example.com;lr", "sip:p2.example.com;lr")
+
+	reinvite := d.CreateRequest(INVITE)
+	res := dialogResponse(t, StatusOK, "bob")
+	contact, err := ParseUri("sip:bob@192.0.2.3")
+	if err != nil {
+		t.Fatalf("parse contact failed: %s", err)
+	}
+	res.ReplaceHeader(&ContactHeader{Address: contact, Params: NewParams()})
+	d.Update(reinvite, res)
+	if target := d.RemoteTarget().String(); target != "sip:bob@192.0.2.3" {
+		t.Errorf("remote target = %s, want Contact of the re-INVITE response", target)
+	}
+
+	d.Close()
+	select {
+	case <-d.Done():
+	default:
+		t.Error("dialog not done")
+	}
+	if d.State() != DialogStateTerminated || d.Err() != nil {
+		t.Errorf("state = %s, err = %v, want terminated", d.State(), d.Err())
+	}
+}
@@ -0,0 +1,305 @@
+package sip
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// 扩展的 option tag RFC 3261 - 19.2
+const (
+	// 可靠的临时响应 RFC 3262
+	OptionTag100rel = "100rel"
+	// 会话定时器 RFC 4028
+	OptionTagTimer = "timer"
+	// 替换对话 RFC 3891
+	OptionTagReplaces = "replaces"
+)
+
+// Session-Expires 以及 Min-SE 的最小值(秒) RFC 4028 - 4
+const MinSessionExpires uint32 = 90
+
+// 消息的 Require 头部是否包含 option tag
+func RequiresOption(msg Message, tag string) bool {
+	return containsOption(optionTags(msg, "Require"), tag)
+}
+
+// 消息的 Supported 或者 Require 头部是否包含 option tag
+func SupportsOption(msg Message, tag string) bool {
+	return containsOption(optionTags(msg, "Supported"), tag) || RequiresOption(msg, tag)
+}
+
+// 返回请求 Require 头部中不在 supported 中的 option tag，用于生成 420 响应 RFC 3261 - 8.2.2.3
+func UnsupportedOptions(msg Message, supported []string) []string {
+	return unsupportedOptions(msg, "Require", supported)
+}
+
+// 返回请求 Proxy-Require 头部中不在 supported 中的 option tag，代理据此返回 420 RFC 3261 - 16.3
+func UnsupportedProxyOptions(msg Message, supported []string) []string {
+	return unsupportedOptions(msg, "Proxy-Require", supported)
+}
+
+func unsupportedOptions(msg Message, name string, supported []string) []string {
+	unsupported := make([]string, 0)
+	for _, tag := range optionTags(msg, name) {
+		if !containsOption(supported, tag) {
+			unsupported = append(unsupported, tag)
+		}
+	}
+
+	return unsupported
+}
+
+// 是否为可靠的临时响应：101-199 且 Require 包含 100rel RFC 3262 - 3
+func IsReliableProvisional(res Response) bool {
+	return res.IsProvisional() && res.StatusCode() > StatusTrying && RequiresOption(res, OptionTag100rel)
+}
+
+// 返回响应的 RSeq 头部
+func ResponseRSeq(res Response) (uint32, bool) {
+	for _, hdr := range res.GetHeaders("RSeq") {
+		if rseq, ok := hdr.(*RSeq); ok {
+			return uint32(*rseq), true
+		}
+	}
+
+	return 0, false
+}
+
+// 返回 PRACK 的 RAck 头部
+func RequestRAck(req Request) *RAckHeader {
+	for _, hdr := range req.GetHeaders("RAck") {
+		if rack, ok := hdr.(*RAckHeader); ok {
+			return rack
+		}
+	}
+
+	return nil
+}
+
+// 返回消息的 Session-Expires 头部
+func SessionExpires(msg Message) *SessionExpiresHeader {
+	for _, hdr := range msg.GetHeaders("Session-Expires") {
+		if se, ok := hdr.(*SessionExpiresHeader); ok {
+			return se
+		}
+	}
+
+	return nil
+}
+
+// 返回消息的 Min-SE，没有时为 RFC 4028 - 5 规定的最小值
+func MinSE(msg Message) uint32 {
+	for _, hdr := range msg.GetHeaders("Min-SE") {
+		if minSE, ok := hdr.(*MinSEHeader); ok && minSE.Delta > MinSessionExpires {
+			return minSE.Delta
+		}
+	}
+
+	return MinSessionExpires
+}
+
+// 返回消息的 Event 头部
+func Event(msg Message) *EventHeader {
+	for _, hdr := range msg.GetHeaders("Event") {
+		if event, ok := hdr.(*EventHeader); ok {
+			return event
+		}
+	}
+
+	return nil
+}
+
+// 返回 NOTIFY 的 Subscription-State 头部
+func SubscriptionState(msg Message) *SubscriptionStateHeader {
+	for _, hdr := range msg.GetHeaders("Subscription-State") {
+		if state, ok := hdr.(*SubscriptionStateHeader); ok {
+			return state
+		}
+	}
+
+	return nil
+}
+
+// 返回 INVITE 的 Replaces 头部
+func Replaces(msg Message) *ReplacesHeader {
+	for _, hdr := range msg.GetHeaders("Replaces") {
+		if replaces, ok := hdr.(*ReplacesHeader); ok {
+			return replaces
+		}
+	}
+
+	return nil
+}
+
+// 生成替换对话的 Replaces 头部 RFC 3891 - 3：
+// 接收方按本地 tag 匹配 to-tag，因此 to-tag 为对话的远端 tag，from-tag 为对话的本地 tag
+func DialogReplaces(dialog Dialog, earlyOnly bool) *ReplacesHeader {
+	return &ReplacesHeader{
+		CallID:    string(dialog.CallID()),
+		ToTag:     dialog.RemoteTag(),
+		FromTag:   dialog.LocalTag(),
+		EarlyOnly: earlyOnly,
+		Params:    NewParams(),
+	}
+}
+
+// 返回 REFER 的 Refer-To 头部
+func ReferTo(msg Message) *ReferToHeader {
+	for _, hdr := range msg.GetHeaders("Refer-To") {
+		if referTo, ok := hdr.(*ReferToHeader); ok {
+			return referTo
+		}
+	}
+
+	return nil
+}
+
+// 返回消息的 Referred-By 头部
+func ReferredBy(msg Message) *ReferredByHeader {
+	for _, hdr := range msg.GetHeaders("Referred-By") {
+		if referredBy, ok := hdr.(*ReferredByHeader); ok {
+			return referredBy
+		}
+	}
+
+	return nil
+}
+
+// 生成咨询转移的 Refer-To URI RFC 3515 - 2.2：转义之后的 Replaces 作为目标 URI 的头部
+func ReferTarget(target Uri, replaces *ReplacesHeader) Uri {
+	uri := target.Copy()
+	headers := NewParams()
+	if uri.Headers() != nil {
+		headers = uri.Headers().Copy()
+	}
+	if replaces != nil {
+		headers.Add("Replaces", String{Str: escapeHeaderValue(replaces.Value())})
+	}
+	uri.SetHeaders(headers)
+
+	return uri
+}
+
+// 解析 Refer-To URI 中的 Replaces 头部，没有时返回 nil
+func ReferReplaces(target Uri) (*ReplacesHeader, error) {
+	if target.Headers() == nil {
+		return nil, nil
+	}
+	for _, key := range target.Headers().Keys() {
+		if !strings.EqualFold(key, "Replaces") {
+			continue
+		}
+		value, _ := target.Headers().Get(key)
+		if value == nil {
+			return nil, fmt.Errorf("empty replaces in refer-to uri %s", target)
+		}
+		text, err := url.PathUnescape(value.String())
+		if err != nil {
+			return nil, err
+		}
+		headers, err := parseReplaces("replaces", text)
+		if err != nil {
+			return nil, err
+		}
+
+		return headers[0].(*ReplacesHeader), nil
+	}
+
+	return nil, nil
+}
+
+// 去掉 URI 头部之后的 Refer-To 目标，用作新请求的 Request-URI 以及 To RFC 3261 - 19.1.5
+func ReferRecipient(target Uri) Uri {
+	uri := target.Copy()
+	uri.SetHeaders(NewParams())
+
+	return uri
+}
+
+// URI 头部的值转义 hnv-unreserved 以及 unreserved 之外的字符 RFC 3261 - 25.1
+func escapeHeaderValue(value string) string {
+	var buffer strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("-_.!~*'()[]/?:+$", c) >= 0 {
+			buffer.WriteByte(c)
+			continue
+		}
+		buffer.WriteString(fmt.Sprintf("%%%02X", c))
+	}
+
+	return buffer.String()
+}
+
+// 消息的 Allow 头部是否包含 method
+func AllowsMethod(msg Message, method RequestMethod) bool {
+	for _, hdr := range msg.GetHeaders("Allow") {
+		if allow, ok := hdr.(AllowHeader); ok {
+			for _, m := range allow {
+				if m == method {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+/**
+在早期对话中为可靠的临时响应生成 PRACK RFC 3262 - 7.2：
+	dialog：临时响应创建的早期对话
+	res：收到的可靠临时响应
+RAck 为响应的 RSeq 以及 CSeq
+*/
+func CreatePrack(dialog Dialog, res Response) (Request, error) {
+	rseq, ok := ResponseRSeq(res)
+	if !ok {
+		return nil, fmt.Errorf("response %s has no RSeq header", res.Short())
+	}
+	cseq := res.CSeq()
+	if cseq == nil {
+		return nil, fmt.Errorf("response %s has no CSeq header", res.Short())
+	}
+
+	prack := dialog.CreateRequest(PRACK)
+	prack.AddHeader(&RAckHeader{
+		RSeq:   rseq,
+		CSeq:   cseq.SeqNo,
+		Method: cseq.MethodName,
+	})
+
+	return prack, nil
+}
+
+func optionTags(msg Message, name string) []string {
+	tags := make([]string, 0)
+	for _, hdr := range msg.GetHeaders(name) {
+		switch header := hdr.(type) {
+		case *RequireHeader:
+			tags = append(tags, header.Options...)
+		case *SupportedHeader:
+			tags = append(tags, header.Options...)
+		case *ProxyRequireHeader:
+			tags = append(tags, header.Options...)
+		case *GenericHeader:
+			for _, tag := range strings.Split(header.Contents, ",") {
+				tags = append(tags, strings.TrimSpace(tag))
+			}
+		}
+	}
+
+	return tags
+}
+
+func containsOption(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(strings.TrimSpace(t), tag) {
+			return true
+		}
+	}
+
+	return false
+}
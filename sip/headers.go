@@ -3,6 +3,7 @@ package sip
 import (
 	"bytes"
 	"fmt"
+	"mime"
 	"strings"
 
 	"github.com/zenghr0820/gsip/logger"
@@ -167,10 +168,10 @@ func (hs *headers) AddHeader(header Header) {
 // replace header
 func (hs *headers) ReplaceHeader(header Header) {
 	name := strings.ToLower(header.Name())
-	hs.headers[name] = []Header{header}
 	if _, ok := hs.headers[name]; !ok {
 		hs.headerOrder = append(hs.headerOrder, name)
 	}
+	hs.headers[name] = []Header{header}
 }
 
 // AddFrontHeader adds header to the front of header list
@@ -426,6 +427,30 @@ func (hs *headers) ContentType() *ContentType {
 	return contentType
 }
 
+func (hs *headers) ContentDisposition() *ContentDisposition {
+	hers := hs.GetHeaders("Content-Disposition")
+	if len(hers) == 0 {
+		return nil
+	}
+	contentDisposition, ok := hers[0].(*ContentDisposition)
+	if !ok {
+		return nil
+	}
+	return contentDisposition
+}
+
+func (hs *headers) ContentEncoding() *ContentEncoding {
+	hers := hs.GetHeaders("Content-Encoding")
+	if len(hers) == 0 {
+		return nil
+	}
+	contentEncoding, ok := hers[0].(*ContentEncoding)
+	if !ok {
+		return nil
+	}
+	return contentEncoding
+}
+
 func (hs *headers) Contact() *ContactHeader {
 	hers := hs.GetHeaders("Contact")
 	if len(hers) == 0 {
@@ -743,11 +768,16 @@ func (contact *ContactHeader) Name() string { return "Contact" }
 
 // Copy the header.
 func (contact *ContactHeader) Copy() Header {
-	return &ContactHeader{
+	newContact := &ContactHeader{
 		DisplayName: contact.DisplayName,
-		Address:     contact.Address.Copy(),
-		Params:      contact.Params.Copy(),
 	}
+	if contact.Address != nil {
+		newContact.Address = contact.Address.Copy()
+	}
+	if contact.Params != nil {
+		newContact.Params = contact.Params.Copy()
+	}
+	return newContact
 }
 
 func (contact *ContactHeader) Equals(other interface{}) bool {
@@ -806,6 +836,65 @@ func (ct *ContentType) Equals(other interface{}) bool {
 	return false
 }
 
+// ============================
+// 		ContentDisposition 实现 RFC 3261 - 20.11
+// ============================
+type ContentDisposition string
+
+func (cd ContentDisposition) String() string { return "Content-Disposition: " + string(cd) }
+
+func (cd *ContentDisposition) Name() string { return "Content-Disposition" }
+
+func (cd *ContentDisposition) Copy() Header { return cd }
+
+func (cd *ContentDisposition) Equals(other interface{}) bool {
+	if h, ok := other.(ContentDisposition); ok {
+		return *cd == h
+	}
+	if h, ok := other.(*ContentDisposition); ok {
+		return *cd == *h
+	}
+
+	return false
+}
+
+// 处理方式，例如 session、render、signal
+func (cd ContentDisposition) Type() string {
+	typ, _, _ := mime.ParseMediaType(string(cd))
+	if typ == "" {
+		typ = strings.ToLower(strings.TrimSpace(strings.SplitN(string(cd), ";", 2)[0]))
+	}
+	return typ
+}
+
+// 是否为可选内容，handling=optional 时无法处理的内容可以忽略 RFC 3204 - 3.1
+func (cd ContentDisposition) Optional() bool {
+	_, params, err := mime.ParseMediaType(string(cd))
+	return err == nil && strings.EqualFold(params["handling"], "optional")
+}
+
+// ============================
+// 		ContentEncoding 实现 RFC 3261 - 20.12
+// ============================
+type ContentEncoding string
+
+func (ce ContentEncoding) String() string { return "Content-Encoding: " + string(ce) }
+
+func (ce *ContentEncoding) Name() string { return "Content-Encoding" }
+
+func (ce *ContentEncoding) Copy() Header { return ce }
+
+func (ce *ContentEncoding) Equals(other interface{}) bool {
+	if h, ok := other.(ContentEncoding); ok {
+		return *ce == h
+	}
+	if h, ok := other.(*ContentEncoding); ok {
+		return *ce == *h
+	}
+
+	return false
+}
+
 // ============================
 // 		Expires 实现
 // ============================
@@ -1651,3 +1740,83 @@ func (uri WildcardUri) Equals(other interface{}) bool {
 		return false
 	}
 }
+
+// ============================
+// 		TelUri 实现 RFC 3966
+// ============================
+// 电话号码 URI，例如 P-Asserted-Identity 中的 tel:+8613800000000
+type TelUri struct {
+	// 号码，全局号码以 + 开头，可以包含 - . ( ) 等视觉分隔符
+	FNumber string
+	// phone-context、ext、isub 等参数
+	FUriParams Params
+}
+
+func (uri *TelUri) IsEncrypted() bool { return false }
+
+func (uri *TelUri) SetEncrypted(flag bool) {}
+
+// 号码作为用户部分
+func (uri *TelUri) User() MaybeString { return String{Str: uri.FNumber} }
+
+func (uri *TelUri) SetUser(user MaybeString) {
+	if user == nil {
+		uri.FNumber = ""
+		return
+	}
+	uri.FNumber = user.String()
+}
+
+func (uri *TelUri) Password() MaybeString { return nil }
+
+func (uri *TelUri) SetPassword(pass MaybeString) {}
+
+func (uri *TelUri) Domain() Addr { return Addr{} }
+
+func (uri *TelUri) SetDomain(domain Addr) {}
+
+func (uri *TelUri) UriParams() Params { return uri.FUriParams }
+
+func (uri *TelUri) SetUriParams(params Params) { uri.FUriParams = params }
+
+func (uri *TelUri) Headers() Params { return nil }
+
+func (uri *TelUri) SetHeaders(params Params) {}
+
+func (uri *TelUri) IsWildcard() bool { return false }
+
+// 比较时忽略号码中的视觉分隔符 RFC 3966 - 4
+func (uri *TelUri) Equals(other interface{}) bool {
+	h, ok := other.(*TelUri)
+	if !ok {
+		return false
+	}
+
+	return strings.EqualFold(telDigits(uri.FNumber), telDigits(h.FNumber)) &&
+		paramsEqual(uri.FUriParams, h.FUriParams)
+}
+
+func (uri *TelUri) String() string {
+	if uri.FUriParams == nil || uri.FUriParams.Length() == 0 {
+		return "tel:" + uri.FNumber
+	}
+
+	return "tel:" + uri.FNumber + ";" + uri.FUriParams.ToString(';')
+}
+
+func (uri *TelUri) Copy() Uri {
+	return &TelUri{
+		FNumber:    uri.FNumber,
+		FUriParams: CopyWithNil(uri.FUriParams),
+	}
+}
+
+// 去掉视觉分隔符之后的号码
+func telDigits(number string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune("-.()", r) {
+			return -1
+		}
+		return r
+	}, number)
+}
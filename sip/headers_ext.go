@@ -0,0 +1,1102 @@
+package sip
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 扩展头部：认证质询、事件通知、呼叫转移、会话定时器、可靠临时响应、身份标识等
+
+// Date 头部的格式 RFC 3261 - 20.17 SIP-date = rfc1123-date，只使用 GMT
+const SipDateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Subscription-State 的状态 RFC 6665 - 8.2.3
+const (
+	SubscriptionActive     = "active"
+	SubscriptionPending    = "pending"
+	SubscriptionTerminated = "terminated"
+)
+
+// Subscription-State 为 terminated 时的 reason 参数 RFC 6665 - 4.1.3
+const (
+	// 订阅方应当立即重新订阅
+	SubscriptionReasonDeactivated = "deactivated"
+	// 订阅方应当在 retry-after 之后重新订阅
+	SubscriptionReasonProbation = "probation"
+	// 订阅被拒绝
+	SubscriptionReasonRejected = "rejected"
+	// 订阅过期没有刷新，订阅方可以重新订阅
+	SubscriptionReasonTimeout = "timeout"
+	// 订阅方应当在 retry-after 之后重新订阅
+	SubscriptionReasonGiveup = "giveup"
+	// 订阅的资源不再存在
+	SubscriptionReasonNoResource = "noresource"
+	// 订阅的资源状态不会再变化
+	SubscriptionReasonInvariant = "invariant"
+)
+
+// Session-Expires 的 refresher 参数 RFC 4028 - 4
+const (
+	RefresherUAC = "uac"
+	RefresherUAS = "uas"
+)
+
+// ============================
+// 		WWW-Authenticate、Proxy-Authenticate 实现 RFC 3261 - 20.44、20.27
+// ============================
+type AuthenticateHeader struct {
+	// www-authenticate 或者 proxy-authenticate
+	name   string
+	Scheme string
+	Realm  string
+	Domain string
+	Nonce  string
+	Opaque string
+	// nonce 过期，客户端仅需使用新的 nonce 重新计算
+	Stale     bool
+	Algorithm string
+	// 可选的 qop 列表，例如 auth,auth-int
+	Qop   string
+	Other Params
+}
+
+func CreateAuthenticate() *AuthenticateHeader {
+	return &AuthenticateHeader{
+		Scheme: "Digest",
+		Other:  NewParams(),
+	}
+}
+
+func (challenge *AuthenticateHeader) Name() string {
+	if challenge.name == "proxy-authenticate" {
+		return "Proxy-Authenticate"
+	}
+	return "WWW-Authenticate"
+}
+
+func (challenge *AuthenticateHeader) SetName(name string) {
+	challenge.name = strings.ToLower(name)
+}
+
+func (challenge *AuthenticateHeader) String() string {
+	params := make([]string, 0, 8)
+	quoted := func(key string, value string) {
+		if value != "" {
+			params = append(params, fmt.Sprintf(`%s="%s"`, key, value))
+		}
+	}
+
+	quoted("realm", challenge.Realm)
+	quoted("domain", challenge.Domain)
+	quoted("nonce", challenge.Nonce)
+	quoted("opaque", challenge.Opaque)
+	if challenge.Stale {
+		params = append(params, "stale=true")
+	}
+	if challenge.Algorithm != "" {
+		params = append(params, "algorithm="+challenge.Algorithm)
+	}
+	quoted("qop", challenge.Qop)
+	if challenge.Other != nil {
+		for _, key := range challenge.Other.Keys() {
+			if value, ok := challenge.Other.Get(key); ok && value != nil {
+				quoted(key, value.String())
+			}
+		}
+	}
+
+	return fmt.Sprintf("%s: %s %s", challenge.Name(), challenge.Scheme, strings.Join(params, ", "))
+}
+
+func (challenge *AuthenticateHeader) Copy() Header {
+	dup := *challenge
+	dup.Other = CopyWithNil(challenge.Other)
+
+	return &dup
+}
+
+func (challenge *AuthenticateHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*AuthenticateHeader); ok {
+		return challenge.name == h.name &&
+			strings.EqualFold(challenge.Scheme, h.Scheme) &&
+			challenge.Realm == h.Realm &&
+			challenge.Domain == h.Domain &&
+			challenge.Nonce == h.Nonce &&
+			challenge.Opaque == h.Opaque &&
+			challenge.Stale == h.Stale &&
+			challenge.Algorithm == h.Algorithm &&
+			challenge.Qop == h.Qop &&
+			paramsEqual(challenge.Other, h.Other)
+	}
+
+	return false
+}
+
+// 解析质询的内容，例如 Digest realm="example.com", nonce="abc", qop="auth"
+func (challenge *AuthenticateHeader) ParseAuthenticate(value string) {
+	value = strings.TrimSpace(value)
+	if idx := strings.IndexAny(value, abnfWs); idx > 0 {
+		challenge.Scheme = value[:idx]
+	} else {
+		challenge.Scheme = value
+	}
+
+	auth := CreateAuthorization()
+	auth.Algorithm = ""
+	auth.ParseAuthorization(value)
+
+	challenge.Realm = auth.Realm
+	challenge.Nonce = auth.Nonce
+	challenge.Opaque = auth.Opaque
+	challenge.Stale = auth.Stale
+	challenge.Algorithm = auth.Algorithm
+	challenge.Qop = auth.Qop
+	challenge.Other = NewParams()
+	for _, key := range auth.Other.Keys() {
+		param, _ := auth.Other.Get(key)
+		if strings.EqualFold(key, "domain") {
+			challenge.Domain = param.String()
+			continue
+		}
+		challenge.Other.Add(key, param)
+	}
+}
+
+// 根据质询生成认证头部，用于计算摘要
+func (challenge *AuthenticateHeader) Authorization() *Authorization {
+	auth := CreateAuthorization()
+	auth.SetMode(challenge.Scheme)
+	auth.Realm = challenge.Realm
+	auth.Nonce = challenge.Nonce
+	auth.Opaque = challenge.Opaque
+	auth.Stale = challenge.Stale
+	auth.Qop = challenge.Qop
+	if challenge.Algorithm != "" {
+		auth.Algorithm = challenge.Algorithm
+	}
+	if challenge.Other != nil {
+		auth.Other = challenge.Other.Copy()
+	}
+	if challenge.Domain != "" {
+		auth.Other.Add("domain", String{Str: challenge.Domain})
+	}
+
+	return auth
+}
+
+// ============================
+// 		Event 实现 RFC 6665 - 8.2.1
+// ============================
+type EventHeader struct {
+	// 事件包，例如 presence、dialog、refer、Catalog
+	EventType string
+	Params    Params
+}
+
+func (event *EventHeader) String() string {
+	return "Event: " + valueWithParams(event.EventType, event.Params)
+}
+
+func (event *EventHeader) Name() string { return "Event" }
+
+func (event *EventHeader) Copy() Header {
+	return &EventHeader{
+		EventType: event.EventType,
+		Params:    CopyWithNil(event.Params),
+	}
+}
+
+func (event *EventHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*EventHeader); ok {
+		return strings.EqualFold(event.EventType, h.EventType) &&
+			paramsEqual(event.Params, h.Params)
+	}
+
+	return false
+}
+
+// id 参数，同一对话中区分多个订阅
+func (event *EventHeader) ID() string {
+	return paramString(event.Params, "id")
+}
+
+// ============================
+// 		Subscription-State 实现 RFC 6665 - 8.2.3
+// ============================
+type SubscriptionStateHeader struct {
+	// active、pending、terminated
+	State  string
+	Params Params
+}
+
+func (state *SubscriptionStateHeader) String() string {
+	return "Subscription-State: " + valueWithParams(state.State, state.Params)
+}
+
+func (state *SubscriptionStateHeader) Name() string { return "Subscription-State" }
+
+func (state *SubscriptionStateHeader) Copy() Header {
+	return &SubscriptionStateHeader{
+		State:  state.State,
+		Params: CopyWithNil(state.Params),
+	}
+}
+
+func (state *SubscriptionStateHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*SubscriptionStateHeader); ok {
+		return strings.EqualFold(state.State, h.State) &&
+			paramsEqual(state.Params, h.Params)
+	}
+
+	return false
+}
+
+// expires 参数，订阅剩余的有效期
+func (state *SubscriptionStateHeader) Expires() (uint32, bool) {
+	return paramUint(state.Params, "expires")
+}
+
+// terminated 时的 reason 参数，例如 timeout、noresource、rejected
+func (state *SubscriptionStateHeader) Reason() string {
+	return paramString(state.Params, "reason")
+}
+
+// retry-after 参数
+func (state *SubscriptionStateHeader) RetryAfter() (uint32, bool) {
+	return paramUint(state.Params, "retry-after")
+}
+
+// ============================
+// 		Allow-Events 实现 RFC 6665 - 8.2.2
+// ============================
+type AllowEventsHeader struct {
+	Events []string
+}
+
+func (allow *AllowEventsHeader) String() string {
+	return "Allow-Events: " + strings.Join(allow.Events, ", ")
+}
+
+func (allow *AllowEventsHeader) Name() string { return "Allow-Events" }
+
+func (allow *AllowEventsHeader) Copy() Header {
+	return &AllowEventsHeader{Events: append([]string(nil), allow.Events...)}
+}
+
+func (allow *AllowEventsHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*AllowEventsHeader); ok {
+		return stringsEqual(allow.Events, h.Events)
+	}
+
+	return false
+}
+
+// ============================
+// 		Refer-To 实现 RFC 3515 - 2.1
+// ============================
+type ReferToHeader struct {
+	DisplayName MaybeString
+	// 转移的目标，可以携带 Replaces 等 URI 头部
+	Address Uri
+	Params  Params
+}
+
+func (referTo *ReferToHeader) String() string {
+	return "Refer-To: " + nameAddr(referTo.DisplayName, referTo.Address, referTo.Params)
+}
+
+func (referTo *ReferToHeader) Name() string { return "Refer-To" }
+
+func (referTo *ReferToHeader) Copy() Header {
+	return &ReferToHeader{
+		DisplayName: referTo.DisplayName,
+		Address:     copyUri(referTo.Address),
+		Params:      CopyWithNil(referTo.Params),
+	}
+}
+
+func (referTo *ReferToHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ReferToHeader); ok {
+		return nameAddrEqual(referTo.DisplayName, referTo.Address, referTo.Params,
+			h.DisplayName, h.Address, h.Params)
+	}
+
+	return false
+}
+
+// ============================
+// 		Referred-By 实现 RFC 3892 - 3
+// ============================
+type ReferredByHeader struct {
+	DisplayName MaybeString
+	Address     Uri
+	Params      Params
+}
+
+func (referredBy *ReferredByHeader) String() string {
+	return "Referred-By: " + nameAddr(referredBy.DisplayName, referredBy.Address, referredBy.Params)
+}
+
+func (referredBy *ReferredByHeader) Name() string { return "Referred-By" }
+
+func (referredBy *ReferredByHeader) Copy() Header {
+	return &ReferredByHeader{
+		DisplayName: referredBy.DisplayName,
+		Address:     copyUri(referredBy.Address),
+		Params:      CopyWithNil(referredBy.Params),
+	}
+}
+
+func (referredBy *ReferredByHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ReferredByHeader); ok {
+		return nameAddrEqual(referredBy.DisplayName, referredBy.Address, referredBy.Params,
+			h.DisplayName, h.Address, h.Params)
+	}
+
+	return false
+}
+
+// ============================
+// 		Replaces 实现 RFC 3891 - 6.1
+// ============================
+type ReplacesHeader struct {
+	CallID string
+	// 被替换对话的 To tag 以及 From tag，以发送 INVITE 的一方的视角
+	ToTag     string
+	FromTag   string
+	EarlyOnly bool
+	// 其他参数
+	Params Params
+}
+
+func (replaces *ReplacesHeader) String() string {
+	return "Replaces: " + replaces.Value()
+}
+
+// 头部的值，也用于 Refer-To URI 中的 Replaces 头部(需要转义)
+func (replaces *ReplacesHeader) Value() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(replaces.CallID)
+	buffer.WriteString(";to-tag=" + replaces.ToTag)
+	buffer.WriteString(";from-tag=" + replaces.FromTag)
+	if replaces.EarlyOnly {
+		buffer.WriteString(";early-only")
+	}
+	if replaces.Params != nil && replaces.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(paramsString(replaces.Params))
+	}
+
+	return buffer.String()
+}
+
+func (replaces *ReplacesHeader) Name() string { return "Replaces" }
+
+func (replaces *ReplacesHeader) Copy() Header {
+	dup := *replaces
+	dup.Params = CopyWithNil(replaces.Params)
+
+	return &dup
+}
+
+func (replaces *ReplacesHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ReplacesHeader); ok {
+		return replaces.CallID == h.CallID &&
+			replaces.ToTag == h.ToTag &&
+			replaces.FromTag == h.FromTag &&
+			replaces.EarlyOnly == h.EarlyOnly &&
+			paramsEqual(replaces.Params, h.Params)
+	}
+
+	return false
+}
+
+// ============================
+// 		Session-Expires 实现 RFC 4028 - 4
+// ============================
+type SessionExpiresHeader struct {
+	// 会话间隔(秒)
+	Delta uint32
+	// uac 或者 uas，为空时未指定
+	Refresher string
+	// 其他参数
+	Params Params
+}
+
+func (se *SessionExpiresHeader) String() string {
+	value := strconv.FormatUint(uint64(se.Delta), 10)
+	if se.Refresher != "" {
+		value += ";refresher=" + se.Refresher
+	}
+	if se.Params != nil && se.Params.Length() > 0 {
+		value += ";" + paramsString(se.Params)
+	}
+
+	return "Session-Expires: " + value
+}
+
+func (se *SessionExpiresHeader) Name() string { return "Session-Expires" }
+
+func (se *SessionExpiresHeader) Copy() Header {
+	return &SessionExpiresHeader{
+		Delta:     se.Delta,
+		Refresher: se.Refresher,
+		Params:    CopyWithNil(se.Params),
+	}
+}
+
+func (se *SessionExpiresHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*SessionExpiresHeader); ok {
+		return se.Delta == h.Delta &&
+			strings.EqualFold(se.Refresher, h.Refresher) &&
+			paramsEqual(se.Params, h.Params)
+	}
+
+	return false
+}
+
+// ============================
+// 		Min-SE 实现 RFC 4028 - 5
+// ============================
+type MinSEHeader struct {
+	Delta  uint32
+	Params Params
+}
+
+func (minSE *MinSEHeader) String() string {
+	return "Min-SE: " + valueWithParams(strconv.FormatUint(uint64(minSE.Delta), 10), minSE.Params)
+}
+
+func (minSE *MinSEHeader) Name() string { return "Min-SE" }
+
+func (minSE *MinSEHeader) Copy() Header {
+	return &MinSEHeader{
+		Delta:  minSE.Delta,
+		Params: CopyWithNil(minSE.Params),
+	}
+}
+
+func (minSE *MinSEHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*MinSEHeader); ok {
+		return minSE.Delta == h.Delta && paramsEqual(minSE.Params, h.Params)
+	}
+
+	return false
+}
+
+// ============================
+// 		RSeq 实现 RFC 3262 - 7.1
+// ============================
+type RSeq uint32
+
+func (rseq RSeq) String() string {
+	return fmt.Sprintf("RSeq: %d", int(rseq))
+}
+
+func (rseq *RSeq) Name() string { return "RSeq" }
+
+func (rseq *RSeq) Copy() Header { return rseq }
+
+func (rseq *RSeq) Equals(other interface{}) bool {
+	if h, ok := other.(RSeq); ok {
+		return *rseq == h
+	}
+	if h, ok := other.(*RSeq); ok {
+		return *rseq == *h
+	}
+
+	return false
+}
+
+// ============================
+// 		RAck 实现 RFC 3262 - 7.2
+// ============================
+type RAckHeader struct {
+	// 确认的可靠临时响应的 RSeq
+	RSeq uint32
+	// 临时响应的 CSeq
+	CSeq   uint32
+	Method RequestMethod
+}
+
+func (rack *RAckHeader) String() string {
+	return fmt.Sprintf("RAck: %d %d %s", rack.RSeq, rack.CSeq, rack.Method)
+}
+
+func (rack *RAckHeader) Name() string { return "RAck" }
+
+func (rack *RAckHeader) Copy() Header {
+	dup := *rack
+	return &dup
+}
+
+func (rack *RAckHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*RAckHeader); ok {
+		return rack.RSeq == h.RSeq && rack.CSeq == h.CSeq && rack.Method == h.Method
+	}
+
+	return false
+}
+
+// ============================
+// 		P-Asserted-Identity 实现 RFC 3325 - 9.1
+// ============================
+type PAssertedIdentityHeader struct {
+	DisplayName MaybeString
+	Address     Uri
+	Params      Params
+}
+
+func (identity *PAssertedIdentityHeader) String() string {
+	return "P-Asserted-Identity: " + nameAddr(identity.DisplayName, identity.Address, identity.Params)
+}
+
+func (identity *PAssertedIdentityHeader) Name() string { return "P-Asserted-Identity" }
+
+func (identity *PAssertedIdentityHeader) Copy() Header {
+	return &PAssertedIdentityHeader{
+		DisplayName: identity.DisplayName,
+		Address:     copyUri(identity.Address),
+		Params:      CopyWithNil(identity.Params),
+	}
+}
+
+func (identity *PAssertedIdentityHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*PAssertedIdentityHeader); ok {
+		return nameAddrEqual(identity.DisplayName, identity.Address, identity.Params,
+			h.DisplayName, h.Address, h.Params)
+	}
+
+	return false
+}
+
+// ============================
+// 		P-Preferred-Identity 实现 RFC 3325 - 9.2
+// ============================
+type PPreferredIdentityHeader struct {
+	DisplayName MaybeString
+	Address     Uri
+	Params      Params
+}
+
+func (identity *PPreferredIdentityHeader) String() string {
+	return "P-Preferred-Identity: " + nameAddr(identity.DisplayName, identity.Address, identity.Params)
+}
+
+func (identity *PPreferredIdentityHeader) Name() string { return "P-Preferred-Identity" }
+
+func (identity *PPreferredIdentityHeader) Copy() Header {
+	return &PPreferredIdentityHeader{
+		DisplayName: identity.DisplayName,
+		Address:     copyUri(identity.Address),
+		Params:      CopyWithNil(identity.Params),
+	}
+}
+
+func (identity *PPreferredIdentityHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*PPreferredIdentityHeader); ok {
+		return nameAddrEqual(identity.DisplayName, identity.Address, identity.Params,
+			h.DisplayName, h.Address, h.Params)
+	}
+
+	return false
+}
+
+// ============================
+// 		Privacy 实现 RFC 3323 - 4.2
+// ============================
+type PrivacyHeader struct {
+	// none、header、session、user、id、critical
+	Values []string
+}
+
+func (privacy *PrivacyHeader) String() string {
+	return "Privacy: " + strings.Join(privacy.Values, ";")
+}
+
+func (privacy *PrivacyHeader) Name() string { return "Privacy" }
+
+func (privacy *PrivacyHeader) Copy() Header {
+	return &PrivacyHeader{Values: append([]string(nil), privacy.Values...)}
+}
+
+func (privacy *PrivacyHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*PrivacyHeader); ok {
+		return stringsEqual(privacy.Values, h.Values)
+	}
+
+	return false
+}
+
+// 是否包含指定的隐私类型
+func (privacy *PrivacyHeader) Has(value string) bool {
+	for _, v := range privacy.Values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ============================
+// 		Path 实现 RFC 3327 - 4
+// ============================
+type PathHeader struct {
+	Addresses []Uri
+}
+
+func (path *PathHeader) Name() string { return "Path" }
+
+func (path *PathHeader) String() string {
+	return "Path: " + joinUris(path.Addresses)
+}
+
+func (path *PathHeader) Copy() Header {
+	return &PathHeader{Addresses: copyUris(path.Addresses)}
+}
+
+func (path *PathHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*PathHeader); ok {
+		return urisEqual(path.Addresses, h.Addresses)
+	}
+
+	return false
+}
+
+// ============================
+// 		Service-Route 实现 RFC 3608 - 5
+// ============================
+type ServiceRouteHeader struct {
+	Addresses []Uri
+}
+
+func (route *ServiceRouteHeader) Name() string { return "Service-Route" }
+
+func (route *ServiceRouteHeader) String() string {
+	return "Service-Route: " + joinUris(route.Addresses)
+}
+
+func (route *ServiceRouteHeader) Copy() Header {
+	return &ServiceRouteHeader{Addresses: copyUris(route.Addresses)}
+}
+
+func (route *ServiceRouteHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ServiceRouteHeader); ok {
+		return urisEqual(route.Addresses, h.Addresses)
+	}
+
+	return false
+}
+
+// ============================
+// 		Reason 实现 RFC 3326 - 2
+// ============================
+type ReasonHeader struct {
+	// SIP 或者 Q.850
+	Protocol string
+	Cause    uint16
+	Text     string
+	// 其他参数
+	Params Params
+}
+
+func (reason *ReasonHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Reason: " + reason.Protocol)
+	buffer.WriteString(fmt.Sprintf(";cause=%d", reason.Cause))
+	if reason.Text != "" {
+		buffer.WriteString(fmt.Sprintf(";text=\"%s\"", reason.Text))
+	}
+	if reason.Params != nil && reason.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(paramsString(reason.Params))
+	}
+
+	return buffer.String()
+}
+
+func (reason *ReasonHeader) Name() string { return "Reason" }
+
+func (reason *ReasonHeader) Copy() Header {
+	dup := *reason
+	dup.Params = CopyWithNil(reason.Params)
+
+	return &dup
+}
+
+func (reason *ReasonHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*ReasonHeader); ok {
+		return strings.EqualFold(reason.Protocol, h.Protocol) &&
+			reason.Cause == h.Cause &&
+			reason.Text == h.Text &&
+			paramsEqual(reason.Params, h.Params)
+	}
+
+	return false
+}
+
+// ============================
+// 		Warning 实现 RFC 3261 - 20.43
+// ============================
+type WarningHeader struct {
+	// 三位数的警告码，例如 399
+	Code uint16
+	// 添加警告的主机名或者 "-"
+	Agent string
+	Text  string
+}
+
+func (warning *WarningHeader) String() string {
+	return fmt.Sprintf("Warning: %03d %s \"%s\"", warning.Code, warning.Agent, warning.Text)
+}
+
+func (warning *WarningHeader) Name() string { return "Warning" }
+
+func (warning *WarningHeader) Copy() Header {
+	dup := *warning
+	return &dup
+}
+
+func (warning *WarningHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*WarningHeader); ok {
+		return *warning == *h
+	}
+
+	return false
+}
+
+// ============================
+// 		Retry-After 实现 RFC 3261 - 20.33
+// ============================
+type RetryAfterHeader struct {
+	// 重试的等待时间(秒)
+	Delay uint32
+	// 括号中的注释，不包含括号
+	Comment string
+	// duration 等参数
+	Params Params
+}
+
+func (retry *RetryAfterHeader) String() string {
+	value := strconv.FormatUint(uint64(retry.Delay), 10)
+	if retry.Comment != "" {
+		value += " (" + retry.Comment + ")"
+	}
+
+	return "Retry-After: " + valueWithParams(value, retry.Params)
+}
+
+func (retry *RetryAfterHeader) Name() string { return "Retry-After" }
+
+func (retry *RetryAfterHeader) Copy() Header {
+	return &RetryAfterHeader{
+		Delay:   retry.Delay,
+		Comment: retry.Comment,
+		Params:  CopyWithNil(retry.Params),
+	}
+}
+
+func (retry *RetryAfterHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*RetryAfterHeader); ok {
+		return retry.Delay == h.Delay &&
+			retry.Comment == h.Comment &&
+			paramsEqual(retry.Params, h.Params)
+	}
+
+	return false
+}
+
+// duration 参数，可以使用的时长(秒)
+func (retry *RetryAfterHeader) Duration() (uint32, bool) {
+	return paramUint(retry.Params, "duration")
+}
+
+// ============================
+// 		Date 实现 RFC 3261 - 20.17
+// ============================
+type DateHeader struct {
+	Time time.Time
+}
+
+func NewDateHeader(t time.Time) *DateHeader {
+	return &DateHeader{Time: t.UTC().Truncate(time.Second)}
+}
+
+func (date *DateHeader) String() string {
+	return "Date: " + date.Time.UTC().Format(SipDateFormat)
+}
+
+func (date *DateHeader) Name() string { return "Date" }
+
+func (date *DateHeader) Copy() Header {
+	return &DateHeader{Time: date.Time}
+}
+
+func (date *DateHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*DateHeader); ok {
+		return date.Time.Equal(h.Time)
+	}
+
+	return false
+}
+
+// ============================
+// 		Server 实现 RFC 3261 - 20.35
+// ============================
+type ServerHeader string
+
+func (server ServerHeader) String() string {
+	return "Server: " + string(server)
+}
+
+func (server *ServerHeader) Name() string { return "Server" }
+
+func (server *ServerHeader) Copy() Header { return server }
+
+func (server *ServerHeader) Equals(other interface{}) bool {
+	if h, ok := other.(ServerHeader); ok {
+		return *server == h
+	}
+	if h, ok := other.(*ServerHeader); ok {
+		return *server == *h
+	}
+
+	return false
+}
+
+// ============================
+// 		Timestamp 实现 RFC 3261 - 20.38
+// ============================
+type TimestampHeader struct {
+	// 客户端发送请求的时间，格式由客户端决定，例如 54.32
+	Value string
+	// 服务端收到请求到发送响应的延迟，可以为空
+	Delay string
+}
+
+func (timestamp *TimestampHeader) String() string {
+	if timestamp.Delay == "" {
+		return "Timestamp: " + timestamp.Value
+	}
+
+	return "Timestamp: " + timestamp.Value + " " + timestamp.Delay
+}
+
+func (timestamp *TimestampHeader) Name() string { return "Timestamp" }
+
+func (timestamp *TimestampHeader) Copy() Header {
+	dup := *timestamp
+	return &dup
+}
+
+func (timestamp *TimestampHeader) Equals(other interface{}) bool {
+	if h, ok := other.(*TimestampHeader); ok {
+		return *timestamp == *h
+	}
+
+	return false
+}
+
+// ============================
+// 		Min-Expires 实现 RFC 3261 - 20.23
+// ============================
+type MinExpires uint32
+
+func (minExpires MinExpires) String() string {
+	return fmt.Sprintf("Min-Expires: %d", int(minExpires))
+}
+
+func (minExpires *MinExpires) Name() string { return "Min-Expires" }
+
+func (minExpires *MinExpires) Copy() Header { return minExpires }
+
+func (minExpires *MinExpires) Equals(other interface{}) bool {
+	if h, ok := other.(MinExpires); ok {
+		return *minExpires == h
+	}
+	if h, ok := other.(*MinExpires); ok {
+		return *minExpires == *h
+	}
+
+	return false
+}
+
+// ============================
+// 		Organization 实现 RFC 3261 - 20.25
+// ============================
+type OrganizationHeader string
+
+func (organization OrganizationHeader) String() string {
+	return "Organization: " + string(organization)
+}
+
+func (organization *OrganizationHeader) Name() string { return "Organization" }
+
+func (organization *OrganizationHeader) Copy() Header { return organization }
+
+func (organization *OrganizationHeader) Equals(other interface{}) bool {
+	if h, ok := other.(OrganizationHeader); ok {
+		return *organization == h
+	}
+	if h, ok := other.(*OrganizationHeader); ok {
+		return *organization == *h
+	}
+
+	return false
+}
+
+// value *(;param)
+func valueWithParams(value string, params Params) string {
+	if params == nil || params.Length() == 0 {
+		return value
+	}
+
+	return value + ";" + paramsString(params)
+}
+
+// name-addr *(;param)，与 To、From 的格式相同
+func nameAddr(displayName MaybeString, address Uri, params Params) string {
+	var buffer bytes.Buffer
+	if displayName, ok := displayName.(String); ok && displayName.String() != "" {
+		buffer.WriteString(fmt.Sprintf("\"%s\" ", displayName))
+	}
+	buffer.WriteString(fmt.Sprintf("<%s>", address))
+	if params != nil && params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(paramsString(params))
+	}
+
+	return buffer.String()
+}
+
+// 以 ; 分隔的参数，值不是 token 时(例如 Referred-By 的 cid)使用引号 RFC 3261 - 25.1 gen-value
+func paramsString(params Params) string {
+	var buffer bytes.Buffer
+	for i, key := range params.Keys() {
+		if i > 0 {
+			buffer.WriteString(";")
+		}
+		buffer.WriteString(key)
+		if value, ok := params.Get(key); ok && value != nil {
+			if isToken(value.String()) {
+				buffer.WriteString("=" + value.String())
+			} else {
+				buffer.WriteString(fmt.Sprintf("=\"%s\"", value))
+			}
+		}
+	}
+
+	return buffer.String()
+}
+
+// RFC 3261 - 25.1 token = 1*(alphanum / "-" / "." / "!" / "%" / "*" / "_" / "+" / "`" / "'" / "~" )
+func isToken(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-.!%*_+`'~", c):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func nameAddrEqual(name1 MaybeString, address1 Uri, params1 Params, name2 MaybeString, address2 Uri, params2 Params) bool {
+	if (name1 == nil) != (name2 == nil) || (name1 != nil && !name1.Equals(name2)) {
+		return false
+	}
+	if (address1 == nil) != (address2 == nil) || (address1 != nil && !address1.Equals(address2)) {
+		return false
+	}
+
+	return paramsEqual(params1, params2)
+}
+
+func joinUris(uris []Uri) string {
+	addrs := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		addrs = append(addrs, "<"+uri.String()+">")
+	}
+
+	return strings.Join(addrs, ", ")
+}
+
+func copyUri(uri Uri) Uri {
+	if uri == nil {
+		return nil
+	}
+	return uri.Copy()
+}
+
+func copyUris(uris []Uri) []Uri {
+	dup := make([]Uri, len(uris))
+	for i, uri := range uris {
+		dup[i] = uri.Copy()
+	}
+
+	return dup
+}
+
+func urisEqual(uris1 []Uri, uris2 []Uri) bool {
+	if len(uris1) != len(uris2) {
+		return false
+	}
+	for i, uri := range uris1 {
+		if !uri.Equals(uris2[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func stringsEqual(values1 []string, values2 []string) bool {
+	if len(values1) != len(values2) {
+		return false
+	}
+	for i, value := range values1 {
+		if !strings.EqualFold(value, values2[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// 参数为 nil 与没有参数相同
+func paramsEqual(params1 Params, params2 Params) bool {
+	if params1 == nil || params2 == nil {
+		return (params1 == nil || params1.Length() == 0) && (params2 == nil || params2.Length() == 0)
+	}
+
+	return params1.Equals(params2)
+}
+
+func paramString(params Params, key string) string {
+	if params == nil {
+		return ""
+	}
+	if value, ok := params.Get(key); ok && value != nil {
+		return value.String()
+	}
+
+	return ""
+}
+
+func paramUint(params Params, key string) (uint32, bool) {
+	value, err := strconv.ParseUint(paramString(params, key), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint32(value), true
+}
@@ -41,9 +41,13 @@ type Message interface {
 	DelHeader(name ...string)
 
 	// Body returns message body.
-	Body() string
+	Body() []byte
 	// SetBody sets message body.
-	SetBody(body string, setContentLength bool)
+	SetBody(body []byte, setContentLength bool)
+	// 多部分消息体，Content-Type 不是 multipart/* 时返回 nil
+	Multipart() (*MultipartBody, error)
+	// 设置多部分消息体，同时设置 Content-Type 以及 Content-Length
+	SetMultipart(body *MultipartBody)
 
 	// CallID returns 'Call-ID' header.
 	// CallID() (*CallID, bool)
@@ -65,6 +69,8 @@ type Message interface {
 
 	ContentLength() *ContentLength
 	ContentType() *ContentType
+	ContentDisposition() *ContentDisposition
+	ContentEncoding() *ContentEncoding
 	Contact() *ContactHeader
 
 	Transaction() Transaction      // 返回事务层指针
@@ -87,7 +93,9 @@ type message struct {
 	tx         Transaction
 	messID     MessageID
 	sipVersion string
-	body       string
+	body       []byte
+	// 解析得到的多部分消息体
+	multipart *MultipartBody
 	startLine  func() string
 	src        string
 	dest       string
@@ -109,7 +117,8 @@ func (msg *message) String() string {
 	// Write the headers.
 	buffer.WriteString(msg.headers.String())
 	// message body
-	buffer.WriteString("\r\n" + msg.Body())
+	buffer.WriteString("\r\n")
+	buffer.Write(msg.Body())
 
 	return buffer.String()
 }
@@ -122,13 +131,14 @@ func (msg *message) SetSipVersion(version string) {
 	msg.sipVersion = version
 }
 
-func (msg *message) Body() string {
+func (msg *message) Body() []byte {
 	return msg.body
 }
 
 // SetBody sets message body, calculates it length and add 'Content-Length' header.
-func (msg *message) SetBody(body string, setContentLength bool) {
+func (msg *message) SetBody(body []byte, setContentLength bool) {
 	msg.body = body
+	msg.multipart = nil
 	if setContentLength {
 		hers := msg.GetHeaders("Content-Length")
 		if len(hers) == 0 {
@@ -141,6 +151,29 @@ func (msg *message) SetBody(body string, setContentLength bool) {
 	}
 }
 
+func (msg *message) Multipart() (*MultipartBody, error) {
+	if msg.multipart != nil {
+		return msg.multipart, nil
+	}
+	ct := msg.ContentType()
+	if ct == nil || !IsMultipart(string(*ct)) {
+		return nil, nil
+	}
+
+	return ParseMultipart(string(*ct), msg.body)
+}
+
+func (msg *message) SetMultipart(body *MultipartBody) {
+	ct := ContentType(body.Type())
+	msg.ReplaceHeader(&ct)
+	msg.SetBody(body.Bytes(), true)
+	msg.multipart = body
+}
+
+func (msg *message) setMultipart(body *MultipartBody) {
+	msg.multipart = body
+}
+
 func (msg *message) Transport() string {
 	if viaHop, ok := msg.ViaHop(); ok {
 		return viaHop.Transport
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -56,31 +57,64 @@ type HeaderParser func(headerName string, headerData string) ([]Header, error)
 
 func defaultHeaderParsers() map[string]HeaderParser {
 	return map[string]HeaderParser{
-		"to":                  parseAddressHeader,
-		"t":                   parseAddressHeader,
-		"from":                parseAddressHeader,
-		"f":                   parseAddressHeader,
-		"contact":             parseAddressHeader,
-		"m":                   parseAddressHeader,
-		"Call-ID":             parseCallId,
-		"cseq":                parseCSeq,
-		"via":                 parseViaHeader,
-		"v":                   parseViaHeader,
-		"max-forwards":        parseMaxForwards,
-		"content-length":      parseContentLength,
-		"l":                   parseContentLength,
-		"expires":             parseExpires,
-		"user-agent":          parseUserAgent,
-		"allow":               parseAllow,
-		"content-type":        parseContentType,
-		"accept":              parseAccept,
-		"c":                   parseContentType,
-		"require":             parseRequire,
-		"supported":           parseSupported,
-		"route":               parseRouteHeader,
-		"record-route":        parseRecordRouteHeader,
-		"Authorization":       parseAuthorization,
-		"Proxy-Authorization": parseAuthorization,
+		"to":                   parseAddressHeader,
+		"t":                    parseAddressHeader,
+		"from":                 parseAddressHeader,
+		"f":                    parseAddressHeader,
+		"contact":              parseAddressHeader,
+		"m":                    parseAddressHeader,
+		"Call-ID":              parseCallId,
+		"cseq":                 parseCSeq,
+		"via":                  parseViaHeader,
+		"v":                    parseViaHeader,
+		"max-forwards":         parseMaxForwards,
+		"content-length":       parseContentLength,
+		"l":                    parseContentLength,
+		"expires":              parseExpires,
+		"user-agent":           parseUserAgent,
+		"allow":                parseAllow,
+		"content-type":         parseContentType,
+		"accept":               parseAccept,
+		"c":                    parseContentType,
+		"content-disposition":  parseContentDisposition,
+		"content-encoding":     parseContentEncoding,
+		"e":                    parseContentEncoding,
+		"require":              parseRequire,
+		"supported":            parseSupported,
+		"route":                parseRouteHeader,
+		"record-route":         parseRecordRouteHeader,
+		"authorization":        parseAuthorization,
+		"proxy-authorization":  parseAuthorization,
+		"www-authenticate":     parseAuthenticate,
+		"proxy-authenticate":   parseAuthenticate,
+		"event":                parseEvent,
+		"o":                    parseEvent,
+		"subscription-state":   parseSubscriptionState,
+		"allow-events":         parseAllowEvents,
+		"u":                    parseAllowEvents,
+		"refer-to":             parseNameAddrHeader,
+		"r":                    parseNameAddrHeader,
+		"referred-by":          parseNameAddrHeader,
+		"b":                    parseNameAddrHeader,
+		"replaces":             parseReplaces,
+		"session-expires":      parseSessionExpires,
+		"x":                    parseSessionExpires,
+		"min-se":               parseMinSE,
+		"rseq":                 parseRSeq,
+		"rack":                 parseRAck,
+		"p-asserted-identity":  parseNameAddrHeader,
+		"p-preferred-identity": parseNameAddrHeader,
+		"privacy":              parsePrivacy,
+		"path":                 parsePath,
+		"service-route":        parsePath,
+		"reason":               parseReason,
+		"warning":              parseWarning,
+		"retry-after":          parseRetryAfter,
+		"date":                 parseDate,
+		"server":               parseServer,
+		"timestamp":            parseTimestamp,
+		"min-expires":          parseMinExpires,
+		"organization":         parseOrganization,
 	}
 }
 
@@ -300,15 +334,17 @@ func (p *parser) parse(requireContentLength bool) {
 		if isRequest(startLine) {
 			method, recipient, sipVersion, err := ParseRequestLine(startLine)
 			if err == nil {
-				msg = CreateSimpleRequest(method, recipient.Domain().String())
-				msg.SetSipVersion(sipVersion)
+				req := CreateSimpleRequest(method, recipient.Domain().String())
+				req.SetRecipient(recipient)
+				req.SetSipVersion(sipVersion)
+				msg = req
 			} else {
 				termErr = err
 			}
 		} else if isResponse(startLine) {
 			sipVersion, statusCode, reason, err := ParseStatusLine(startLine)
 			if err == nil {
-				msg = NewResponse("", sipVersion, statusCode, reason, []Header{}, "")
+				msg = NewResponse("", sipVersion, statusCode, reason, []Header{}, nil)
 			} else {
 				termErr = err
 			}
@@ -477,8 +513,19 @@ func (p *parser) parse(requireContentLength bool) {
 			continue
 		}
 
-		if strings.TrimSpace(body) != "" {
+		if len(bytes.TrimSpace(body)) > 0 {
 			msg.SetBody(body, false)
+			// RFC 5621 多部分消息体
+			if ct := msg.ContentType(); ct != nil && IsMultipart(string(*ct)) {
+				if mb, err := ParseMultipart(string(*ct), body); err == nil {
+					// 保留原始消息体，仅保存解析结果
+					if m, ok := msg.(interface{ setMultipart(body *MultipartBody) }); ok {
+						m.setMultipart(mb)
+					}
+				} else {
+					logger.Warnf("%s parse multipart body of %s failed: %s", p, msg.Short(), err)
+				}
+			}
 		}
 
 		p.output <- msg
@@ -617,6 +664,8 @@ func ParseUri(uriStr string) (uri Uri, err error) {
 		var sipUri SipUri
 		sipUri, err = ParseSipUri(uriStr)
 		uri = &sipUri
+	case "tel":
+		uri, err = ParseTelUri(uriStr)
 	default:
 		err = fmt.Errorf("unsupported URI schema %s", uriStr[:colonIdx])
 	}
@@ -624,6 +673,30 @@ func ParseUri(uriStr string) (uri Uri, err error) {
 	return
 }
 
+// 解析 tel URI RFC 3966 - 3：tel:号码 *(;参数)
+func ParseTelUri(uriStr string) (uri *TelUri, err error) {
+	if len(uriStr) < 4 || strings.ToLower(uriStr[:4]) != "tel:" {
+		err = fmt.Errorf("invalid tel uri '%s'", uriStr)
+		return
+	}
+
+	uri = &TelUri{FUriParams: NewParams()}
+	number := uriStr[4:]
+	if idx := strings.Index(number, ";"); idx != -1 {
+		if uri.FUriParams, _, err = ParseParams(number[idx:], ';', ';', 0, true, true); err != nil {
+			return nil, err
+		}
+		number = number[:idx]
+	}
+	uri.FNumber = strings.TrimSpace(number)
+	if uri.FNumber == "" {
+		err = fmt.Errorf("empty number in tel uri '%s'", uriStr)
+		return nil, err
+	}
+
+	return
+}
+
 // ParseSipUri converts a string representation of a SIP or SIPS URI into a SipUri object.
 func ParseSipUri(uriStr string) (uri SipUri, err error) {
 	// Store off the original URI in case we need to print it in an error.
@@ -1251,6 +1324,20 @@ func parseContentType(headerName string, headerText string) (headers []Header, e
 	return
 }
 
+func parseContentDisposition(headerName string, headerText string) (headers []Header, err error) {
+	contentDisposition := ContentDisposition(strings.TrimSpace(headerText))
+	headers = []Header{&contentDisposition}
+
+	return
+}
+
+func parseContentEncoding(headerName string, headerText string) (headers []Header, err error) {
+	contentEncoding := ContentEncoding(strings.TrimSpace(headerText))
+	headers = []Header{&contentEncoding}
+
+	return
+}
+
 func parseAccept(headerName string, headerText string) (headers []Header, err error) {
 	var accept Accept
 	headerText = strings.TrimSpace(headerText)
@@ -1487,6 +1574,392 @@ func parseRecordRouteHeader(headerName string, headerText string) (headers []Hea
 	return []Header{&routeHeader}, nil
 }
 
+func parseAuthenticate(headerName string, headerText string) (headers []Header, err error) {
+	challenge := CreateAuthenticate()
+	challenge.SetName(headerName)
+	challenge.ParseAuthenticate(headerText)
+	headers = []Header{challenge}
+
+	return
+}
+
+func parseEvent(headerName string, headerText string) (headers []Header, err error) {
+	var event EventHeader
+	event.EventType, event.Params, err = parseValueParams(headerText)
+	if err != nil {
+		return
+	}
+	if event.EventType == "" {
+		err = fmt.Errorf("empty event type")
+		return
+	}
+	headers = []Header{&event}
+
+	return
+}
+
+func parseSubscriptionState(headerName string, headerText string) (headers []Header, err error) {
+	var state SubscriptionStateHeader
+	state.State, state.Params, err = parseValueParams(headerText)
+	if err != nil {
+		return
+	}
+	if state.State == "" {
+		err = fmt.Errorf("empty subscription state")
+		return
+	}
+	headers = []Header{&state}
+
+	return
+}
+
+func parseAllowEvents(headerName string, headerText string) (headers []Header, err error) {
+	var allow AllowEventsHeader
+	allow.Events = make([]string, 0)
+	for _, event := range strings.Split(headerText, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			allow.Events = append(allow.Events, event)
+		}
+	}
+	headers = []Header{&allow}
+
+	return
+}
+
+// Refer-To、Referred-By、P-Asserted-Identity、P-Preferred-Identity 等 name-addr 形式的头部，
+// URI 可以为 sip/sips 以及 tel，其他 scheme 的 URI 无法解析时保留为 GenericHeader
+func parseNameAddrHeader(headerName string, headerText string) (headers []Header, err error) {
+	displayNames, uris, paramSets, err := ParseAddressValues(headerText)
+	if err != nil {
+		logger.Debugf("[ParseHeader] -> keep %s as generic header: %s", headerName, err)
+		return []Header{&GenericHeader{
+			HeaderName: canonicalHeaderName(headerName),
+			Contents:   headerText,
+		}}, nil
+	}
+
+	for idx := range uris {
+		switch headerName {
+		case "refer-to", "r":
+			if idx > 0 {
+				return nil, fmt.Errorf("multiple uris in refer-to header: %s", headerText)
+			}
+			headers = append(headers, &ReferToHeader{displayNames[idx], uris[idx], paramSets[idx]})
+		case "referred-by", "b":
+			if idx > 0 {
+				return nil, fmt.Errorf("multiple uris in referred-by header: %s", headerText)
+			}
+			headers = append(headers, &ReferredByHeader{displayNames[idx], uris[idx], paramSets[idx]})
+		case "p-asserted-identity":
+			headers = append(headers, &PAssertedIdentityHeader{displayNames[idx], uris[idx], paramSets[idx]})
+		case "p-preferred-identity":
+			headers = append(headers, &PPreferredIdentityHeader{displayNames[idx], uris[idx], paramSets[idx]})
+		}
+	}
+
+	return
+}
+
+func parseReplaces(headerName string, headerText string) (headers []Header, err error) {
+	var replaces ReplacesHeader
+	var params Params
+	replaces.CallID, params, err = parseValueParams(headerText)
+	if err != nil {
+		return
+	}
+	if replaces.CallID == "" {
+		err = fmt.Errorf("empty call-id in replaces header")
+		return
+	}
+
+	replaces.Params = NewParams()
+	for _, key := range params.Keys() {
+		value, _ := params.Get(key)
+		switch strings.ToLower(key) {
+		case "to-tag":
+			replaces.ToTag = paramString(params, key)
+		case "from-tag":
+			replaces.FromTag = paramString(params, key)
+		case "early-only":
+			replaces.EarlyOnly = true
+		default:
+			replaces.Params.Add(key, value)
+		}
+	}
+	if replaces.ToTag == "" || replaces.FromTag == "" {
+		err = fmt.Errorf("replaces header requires to-tag and from-tag: %s", headerText)
+		return
+	}
+	headers = []Header{&replaces}
+
+	return
+}
+
+func parseSessionExpires(headerName string, headerText string) (headers []Header, err error) {
+	var se SessionExpiresHeader
+	var delta string
+	var params Params
+	if delta, params, err = parseValueParams(headerText); err != nil {
+		return
+	}
+	if se.Delta, err = parseUint32(delta); err != nil {
+		return
+	}
+
+	se.Params = NewParams()
+	for _, key := range params.Keys() {
+		value, _ := params.Get(key)
+		if strings.EqualFold(key, "refresher") {
+			se.Refresher = strings.ToLower(paramString(params, key))
+			continue
+		}
+		se.Params.Add(key, value)
+	}
+	headers = []Header{&se}
+
+	return
+}
+
+func parseMinSE(headerName string, headerText string) (headers []Header, err error) {
+	var minSE MinSEHeader
+	var delta string
+	if delta, minSE.Params, err = parseValueParams(headerText); err != nil {
+		return
+	}
+	if minSE.Delta, err = parseUint32(delta); err != nil {
+		return
+	}
+	headers = []Header{&minSE}
+
+	return
+}
+
+func parseRSeq(headerName string, headerText string) (headers []Header, err error) {
+	var value uint32
+	if value, err = parseUint32(headerText); err != nil {
+		return
+	}
+	rseq := RSeq(value)
+	headers = []Header{&rseq}
+
+	return
+}
+
+func parseRAck(headerName string, headerText string) (headers []Header, err error) {
+	parts := SplitByWhitespace(strings.TrimSpace(headerText))
+	if len(parts) != 3 {
+		err = fmt.Errorf("rack header should have 3 fields: %s", headerText)
+		return
+	}
+
+	var rack RAckHeader
+	if rack.RSeq, err = parseUint32(parts[0]); err != nil {
+		return
+	}
+	if rack.CSeq, err = parseUint32(parts[1]); err != nil {
+		return
+	}
+	rack.Method = RequestMethod(strings.ToUpper(parts[2]))
+	headers = []Header{&rack}
+
+	return
+}
+
+func parsePrivacy(headerName string, headerText string) (headers []Header, err error) {
+	var privacy PrivacyHeader
+	privacy.Values = make([]string, 0)
+	for _, value := range strings.Split(headerText, ";") {
+		if value = strings.TrimSpace(value); value != "" {
+			privacy.Values = append(privacy.Values, value)
+		}
+	}
+	headers = []Header{&privacy}
+
+	return
+}
+
+func parsePath(headerName string, headerText string) (headers []Header, err error) {
+	_, uris, _, err := ParseAddressValues(headerText)
+	if err != nil {
+		return nil, err
+	}
+	if headerName == "service-route" {
+		return []Header{&ServiceRouteHeader{Addresses: uris}}, nil
+	}
+
+	return []Header{&PathHeader{Addresses: uris}}, nil
+}
+
+// 一行中可以有多个 Reason，每个生成一个头部
+func parseReason(headerName string, headerText string) (headers []Header, err error) {
+	for _, section := range splitUnescaped(headerText, ',', quotesDelim) {
+		var reason ReasonHeader
+		var params Params
+		if reason.Protocol, params, err = parseValueParams(section); err != nil {
+			return nil, err
+		}
+
+		reason.Params = NewParams()
+		for _, key := range params.Keys() {
+			value, _ := params.Get(key)
+			switch strings.ToLower(key) {
+			case "cause":
+				var cause uint64
+				if cause, err = strconv.ParseUint(paramString(params, key), 10, 16); err != nil {
+					return nil, err
+				}
+				reason.Cause = uint16(cause)
+			case "text":
+				reason.Text = paramString(params, key)
+			default:
+				reason.Params.Add(key, value)
+			}
+		}
+		headers = append(headers, &reason)
+	}
+
+	return
+}
+
+// 一行中可以有多个 Warning，每个生成一个头部
+func parseWarning(headerName string, headerText string) (headers []Header, err error) {
+	for _, section := range splitUnescaped(headerText, ',', quotesDelim) {
+		section = strings.TrimSpace(section)
+		textIdx := strings.Index(section, "\"")
+		if textIdx == -1 || !strings.HasSuffix(section, "\"") || textIdx == len(section)-1 {
+			return nil, fmt.Errorf("warning text should be quoted: %s", section)
+		}
+
+		parts := SplitByWhitespace(strings.TrimSpace(section[:textIdx]))
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("warning should have code and agent: %s", section)
+		}
+		var code uint64
+		if code, err = strconv.ParseUint(parts[0], 10, 16); err != nil {
+			return nil, err
+		}
+
+		headers = append(headers, &WarningHeader{
+			Code:  uint16(code),
+			Agent: parts[1],
+			Text:  section[textIdx+1 : len(section)-1],
+		})
+	}
+
+	return
+}
+
+func parseRetryAfter(headerName string, headerText string) (headers []Header, err error) {
+	var retry RetryAfterHeader
+	headerText = strings.TrimSpace(headerText)
+
+	// 注释中可以包含分号
+	if start := strings.Index(headerText, "("); start != -1 {
+		end := strings.LastIndex(headerText, ")")
+		if end < start {
+			err = fmt.Errorf("unclosed comment in retry-after header: %s", headerText)
+			return
+		}
+		retry.Comment = headerText[start+1 : end]
+		headerText = headerText[:start] + headerText[end+1:]
+	}
+
+	var delay string
+	if delay, retry.Params, err = parseValueParams(headerText); err != nil {
+		return
+	}
+	if retry.Delay, err = parseUint32(delay); err != nil {
+		return
+	}
+	headers = []Header{&retry}
+
+	return
+}
+
+func parseDate(headerName string, headerText string) (headers []Header, err error) {
+	var t time.Time
+	if t, err = time.Parse(SipDateFormat, strings.TrimSpace(headerText)); err != nil {
+		return
+	}
+	headers = []Header{&DateHeader{Time: t}}
+
+	return
+}
+
+func parseServer(headerName string, headerText string) (headers []Header, err error) {
+	server := ServerHeader(strings.TrimSpace(headerText))
+	headers = []Header{&server}
+
+	return
+}
+
+func parseTimestamp(headerName string, headerText string) (headers []Header, err error) {
+	parts := SplitByWhitespace(strings.TrimSpace(headerText))
+	if len(parts) == 0 || len(parts) > 2 {
+		err = fmt.Errorf("invalid timestamp header: %s", headerText)
+		return
+	}
+
+	timestamp := TimestampHeader{Value: parts[0]}
+	if len(parts) == 2 {
+		timestamp.Delay = parts[1]
+	}
+	headers = []Header{&timestamp}
+
+	return
+}
+
+func parseMinExpires(headerName string, headerText string) (headers []Header, err error) {
+	var value uint32
+	if value, err = parseUint32(headerText); err != nil {
+		return
+	}
+	minExpires := MinExpires(value)
+	headers = []Header{&minExpires}
+
+	return
+}
+
+func parseOrganization(headerName string, headerText string) (headers []Header, err error) {
+	organization := OrganizationHeader(strings.TrimSpace(headerText))
+	headers = []Header{&organization}
+
+	return
+}
+
+// 解析 value *(;param) 形式的头部值
+func parseValueParams(text string) (value string, params Params, err error) {
+	text = strings.TrimSpace(text)
+	idx := strings.Index(text, ";")
+	if idx == -1 {
+		return text, NewParams(), nil
+	}
+
+	params, _, err = ParseParams(text[idx:], ';', ';', 0, true, true)
+	return strings.TrimSpace(text[:idx]), params, err
+}
+
+func parseUint32(text string) (uint32, error) {
+	value, err := strconv.ParseUint(strings.TrimSpace(text), 10, 32)
+	return uint32(value), err
+}
+
+// 紧凑形式以及小写的头部名称对应的标准名称，用于保留为 GenericHeader 的头部
+func canonicalHeaderName(headerName string) string {
+	switch headerName {
+	case "r", "refer-to":
+		return "Refer-To"
+	case "b", "referred-by":
+		return "Referred-By"
+	case "p-asserted-identity":
+		return "P-Asserted-Identity"
+	case "p-preferred-identity":
+		return "P-Preferred-Identity"
+	default:
+		return headerName
+	}
+}
+
 // Extract the next logical header line from the message.
 // This may run over several actual lines; lines that start with whitespace are
 // a continuation of the previous line.
@@ -1562,6 +2035,22 @@ func findAnyUnescaped(text string, targets string, delims ...delimiter) int {
 	return -1
 }
 
+// Splits the given string on the separator, ignoring separators enclosed in any delimiters
+// from the list provided.
+func splitUnescaped(text string, sep uint8, delims ...delimiter) []string {
+	sections := make([]string, 0)
+	for {
+		idx := findUnescaped(text, sep, delims...)
+		if idx == -1 {
+			break
+		}
+		sections = append(sections, text[:idx])
+		text = text[idx+1:]
+	}
+
+	return append(sections, text)
+}
+
 // Splits the given string into sections, separated by one or more characters
 // from c_ABNF_WS.
 func SplitByWhitespace(text string) []string {
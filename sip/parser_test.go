@@ -0,0 +1,259 @@
+package sip
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 解析携带 line 头部的请求，返回名称为 name 的头部
+func parseTestHeaders(t *testing.T, line string, name string) []Header {
+	t.Helper()
+	data := strings.Join([]string{
+		"OPTIONS sip:bob@192.0.2.2 SIP/2.0",
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bKparser",
+		"From: <sip:alice@192.0.2.1>;tag=alice",
+		"To: <sip:bob@192.0.2.2>",
+		"Call-ID: parser-test",
+		"CSeq: 1 OPTIONS",
+		line,
+		"Content-Length: 0",
+		"",
+		"",
+	}, "\r\n")
+	msg, err := ParseMessage([]byte(data))
+	if err != nil {
+		t.Fatalf("parse %q failed: %s", line, err)
+	}
+
+	return msg.GetHeaders(name)
+}
+
+func TestParseExtensionHeaders(t *testing.T) {
+	tests := []struct {
+		line string
+		typ  string
+		want []string
+	}{
+		{
+			line: `WWW-Authenticate: Digest realm="gsip", nonce="abc", algorithm=MD5, qop="auth"`,
+			typ:  "*sip.AuthenticateHeader",
+			want: []string{`WWW-Authenticate: Digest realm="gsip", nonce="abc", algorithm=MD5, qop="auth"`},
+		},
+		{
+			line: `Proxy-Authenticate: Digest realm="gsip", domain="sip:192.0.2.2", nonce="abc", stale=true`,
+			typ:  "*sip.AuthenticateHeader",
+			want: []string{`Proxy-Authenticate: Digest realm="gsip", domain="sip:192.0.2.2", nonce="abc", stale=true`},
+		},
+		{
+			line: "Event: presence;id=1",
+			typ:  "*sip.EventHeader",
+			want: []string{"Event: presence;id=1"},
+		},
+		{
+			line: "o: dialog",
+			typ:  "*sip.EventHeader",
+			want: []string{"Event: dialog"},
+		},
+		{
+			line: "Subscription-State: terminated;reason=timeout",
+			typ:  "*sip.SubscriptionStateHeader",
+			want: []string{"Subscription-State: terminated;reason=timeout"},
+		},
+		{
+			line: "Allow-Events: presence, dialog",
+			typ:  "*sip.AllowEventsHeader",
+			want: []string{"Allow-Events: presence, dialog"},
+		},
+		{
+			line: `Refer-To: "Carol" <sip:carol@192.0.2.3>`,
+			typ:  "*sip.ReferToHeader",
+			want: []string{`Refer-To: "Carol" <sip:carol@192.0.2.3>`},
+		},
+		{
+			line: `b: <sip:alice@192.0.2.1>;cid="20398823.2UWQFN309shb3@referrer.example"`,
+			typ:  "*sip.ReferredByHeader",
+			want: []string{`Referred-By: <sip:alice@192.0.2.1>;cid="20398823.2UWQFN309shb3@referrer.example"`},
+		},
+		{
+			line: "Replaces: 98732@192.0.2.1;to-tag=r33th4x0r;from-tag=ff87ff;early-only",
+			typ:  "*sip.ReplacesHeader",
+			want: []string{"Replaces: 98732@192.0.2.1;to-tag=r33th4x0r;from-tag=ff87ff;early-only"},
+		},
+		{
+			line: "x: 1800;refresher=UAC",
+			typ:  "*sip.SessionExpiresHeader",
+			want: []string{"Session-Expires: 1800;refresher=uac"},
+		},
+		{
+			line: "Min-SE: 90",
+			typ:  "*sip.MinSEHeader",
+			want: []string{"Min-SE: 90"},
+		},
+		{
+			line: "RSeq: 988789",
+			typ:  "*sip.RSeq",
+			want: []string{"RSeq: 988789"},
+		},
+		{
+			line: "RAck: 776656 1 INVITE",
+			typ:  "*sip.RAckHeader",
+			want: []string{"RAck: 776656 1 INVITE"},
+		},
+		{
+			line: `P-Asserted-Identity: "Alice" <sip:alice@192.0.2.1>, <tel:+1-201-555-0123>`,
+			typ:  "*sip.PAssertedIdentityHeader",
+			want: []string{
+				`P-Asserted-Identity: "Alice" <sip:alice@192.0.2.1>`,
+				"P-Asserted-Identity: <tel:+1-201-555-0123>",
+			},
+		},
+		{
+			line: "P-Preferred-Identity: <tel:7042;phone-context=example.com>",
+			typ:  "*sip.PPreferredIdentityHeader",
+			want: []string{"P-Preferred-Identity: <tel:7042;phone-context=example.com>"},
+		},
+		{
+			line: "P-Asserted-Identity: <urn:service:sos>",
+			typ:  "*sip.GenericHeader",
+			want: []string{"P-Asserted-Identity: <urn:service:sos>"},
+		},
+		{
+			line: "Privacy: id;header",
+			typ:  "*sip.PrivacyHeader",
+			want: []string{"Privacy: id;header"},
+		},
+		{
+			line: "Path: <sip:p3.example.com;lr>, <sip:p1.example.com;lr>",
+			typ:  "*sip.PathHeader",
+			want: []string{"Path: <sip:p3.example.com;lr>, <sip:p1.example.com;lr>"},
+		},
+		{
+			line: "Service-Route: <sip:192.0.2.5;lr>",
+			typ:  "*sip.ServiceRouteHeader",
+			want: []string{"Service-Route: <sip:192.0.2.5;lr>"},
+		},
+		{
+			line: `Reason: Q.850;cause=16;text="Terminated"`,
+			typ:  "*sip.ReasonHeader",
+			want: []string{`Reason: Q.850;cause=16;text="Terminated"`},
+		},
+		{
+			line: `Warning: 370 192.0.2.1 "Insufficient bandwidth"`,
+			typ:  "*sip.WarningHeader",
+			want: []string{`Warning: 370 192.0.2.1 "Insufficient bandwidth"`},
+		},
+		{
+			line: "Retry-After: 18000;duration=3600",
+			typ:  "*sip.RetryAfterHeader",
+			want: []string{"Retry-After: 18000;duration=3600"},
+		},
+		{
+			line: "Retry-After: 120 (I'm in a meeting)",
+			typ:  "*sip.RetryAfterHeader",
+			want: []string{"Retry-After: 120 (I'm in a meeting)"},
+		},
+		{
+			line: "Date: Sat, 13 Nov 2010 23:29:00 GMT",
+			typ:  "*sip.DateHeader",
+			want: []string{"Date: Sat, 13 Nov 2010 23:29:00 GMT"},
+		},
+		{
+			line: "Server: HomeServer v2",
+			typ:  "*sip.ServerHeader",
+			want: []string{"Server: HomeServer v2"},
+		},
+		{
+			line: "Timestamp: 54.32 0.5",
+			typ:  "*sip.TimestampHeader",
+			want: []string{"Timestamp: 54.32 0.5"},
+		},
+		{
+			line: "Min-Expires: 60",
+			typ:  "*sip.MinExpires",
+			want: []string{"Min-Expires: 60"},
+		},
+		{
+			line: "Organization: Boxes by Bob",
+			typ:  "*sip.OrganizationHeader",
+			want: []string{"Organization: Boxes by Bob"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			name := test.want[0][:strings.Index(test.want[0], ":")]
+			headers := parseTestHeaders(t, test.line, name)
+			if len(headers) != len(test.want) {
+				t.Fatalf("parsed %d %s headers, want %d", len(headers), name, len(test.want))
+			}
+			for i, header := range headers {
+				if typ := fmt.Sprintf("%T", header); typ != test.typ {
+					t.Errorf("type = %s, want %s", typ, test.typ)
+				}
+				if header.String() != test.want[i] {
+					t.Errorf("String() = %q, want %q", header.String(), test.want[i])
+				}
+
+				// 输出的头部重新解析之后相同
+				reparsed := parseTestHeaders(t, header.String(), name)
+				if len(reparsed) != 1 || !header.Equals(reparsed[0]) {
+					t.Errorf("round-trip of %q = %v", header.String(), reparsed)
+				}
+				if copied := header.Copy(); !header.Equals(copied) {
+					t.Errorf("copy %q not equal", copied)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTelUri(t *testing.T) {
+	tests := []struct {
+		uri    string
+		number string
+		params string
+		err    bool
+	}{
+		{uri: "tel:+1-201-555-0123", number: "+1-201-555-0123"},
+		{uri: "TEL:7042;phone-context=example.com", number: "7042", params: "phone-context=example.com"},
+		{uri: "tel:863-1234;phone-context=+1-914-555;ext=22", number: "863-1234", params: "phone-context=+1-914-555;ext=22"},
+		{uri: "tel:", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.uri, func(t *testing.T) {
+			uri, err := ParseUri(test.uri)
+			if test.err {
+				if err == nil {
+					t.Fatalf("parsed %s, want error", uri)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse failed: %s", err)
+			}
+			tel, ok := uri.(*TelUri)
+			if !ok {
+				t.Fatalf("type = %T, want *sip.TelUri", uri)
+			}
+			if tel.FNumber != test.number || tel.FUriParams.ToString(';') != test.params {
+				t.Errorf("number = %s, params = %s", tel.FNumber, tel.FUriParams.ToString(';'))
+			}
+			if tel.User().String() != test.number {
+				t.Errorf("user = %s, want %s", tel.User(), test.number)
+			}
+		})
+	}
+
+	// 比较时忽略视觉分隔符
+	a, _ := ParseUri("tel:+1-201-555-0123")
+	b, _ := ParseUri("tel:+1.201.555.0123")
+	c, _ := ParseUri("sip:+12015550123@192.0.2.1")
+	if !a.Equals(b) {
+		t.Errorf("%s != %s", a, b)
+	}
+	if a.Equals(c) {
+		t.Errorf("%s == %s", a, c)
+	}
+}
@@ -79,7 +79,7 @@ func (pb *parserBuffer) NextLine() (response string, err error) {
 // 直到缓冲区至少包含n个字符
 // Return precisely those n characters, then delete them from the buffer.
 // 精确返回这n个字符，然后从缓冲区中删除它们
-func (pb *parserBuffer) NextChunk(n int) (response string, err error) {
+func (pb *parserBuffer) NextChunk(n int) (response []byte, err error) {
 	var data = make([]byte, n)
 
 	var read int
@@ -91,7 +91,7 @@ func (pb *parserBuffer) NextChunk(n int) (response string, err error) {
 		}
 	}
 
-	response = string(data)
+	response = data
 
 	logger.Debugf("return content:\n%s", response)
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
 
 	uuid "github.com/satori/go.uuid"
 	"github.com/zenghr0820/gsip/logger"
@@ -17,6 +18,8 @@ type Request interface {
 	SetMethod(method RequestMethod)
 	Recipient() Uri
 	SetRecipient(recipient Uri)
+	// 显式设置的目的地址，为空时由传输层根据 Route 或 Request-URI 解析 RFC 3263
+	ExplicitDestination() string
 	/* Common Helpers */
 	IsInvite() bool
 	// 创建响应函数
@@ -31,6 +34,9 @@ type request struct {
 	message
 	method    RequestMethod
 	recipient Uri
+	// 响应的 To tag，同一请求的所有响应使用相同的 tag RFC 3261 - 8.2.6.2
+	toTag   string
+	tagOnce sync.Once
 }
 
 func CreateRequest(method RequestMethod, remoteAddr string, from, to Uri) Request {
@@ -77,6 +83,32 @@ func CreateSimpleRequest(method RequestMethod, remoteAddr string) Request {
 	return req
 }
 
+// 创建请求对应的 CANCEL 请求 RFC 3261 - 9.1
+// Request-URI、Call-ID、To、From 以及 CSeq 序号与原请求相同，Via 仅包含原请求的第一个 Via
+func CreateCancel(req Request) Request {
+	cancelRequest := CreateSimpleRequest(CANCEL, req.ExplicitDestination())
+	cancelRequest.SetSipVersion(req.SipVersion())
+
+	if viaHop, ok := req.ViaHop(); ok {
+		cancelRequest.AddHeader(ViaHeader{viaHop.Copy()})
+	}
+	if recipient := req.Recipient(); recipient != nil {
+		cancelRequest.SetRecipient(recipient.Copy())
+	}
+	CopyHeaders("Route", req, cancelRequest)
+	CopyHeaders("From", req, cancelRequest)
+	CopyHeaders("To", req, cancelRequest)
+	CopyHeaders("Call-ID", req, cancelRequest)
+	CopyHeaders("CSeq", req, cancelRequest)
+	if cSeq := cancelRequest.CSeq(); cSeq != nil {
+		cSeq.MethodName = CANCEL
+	}
+	CopyHeaders("Max-Forwards", req, cancelRequest)
+	cancelRequest.SetBody(nil, true)
+
+	return cancelRequest
+}
+
 // func newRequest() Request {
 // 	req := new(request)
 // 	req.messID = MessageID(uuid.Must(uuid.NewV4(), nil).String())
@@ -103,18 +135,12 @@ func (req *request) SetMethod(method RequestMethod) {
 	}
 }
 
+// Request-URI：优先使用显式设置的值，否则根据 To 生成
 func (req *request) Recipient() Uri {
-	return req.recipient
-}
-func (req *request) SetRecipient(recipient Uri) {
-	req.recipient = recipient
-}
-
-// StartLine returns Request Line - RFC 2361 7.1.
-func (req *request) StartLine() string {
-	var buffer bytes.Buffer
+	if req.recipient != nil {
+		return req.recipient
+	}
 
-	// Every SIP request starts with a Request Line - RFC 2361 7.1.
 	var recipient Uri = &SipUri{
 		FIsEncrypted: false,
 		FUser:        nil,
@@ -140,21 +166,28 @@ func (req *request) StartLine() string {
 		recipient.SetDomain(domain)
 	} else {
 		to := req.To()
-		if to != nil {
+		if to != nil && to.Address != nil {
 			recipient = to.Address.Copy()
-			recipient.SetUriParams(nil)
 			recipient.SetHeaders(nil)
 		}
 	}
-	req.SetRecipient(recipient)
 
-	// logger.Info("recipient = ", recipient)
+	return recipient
+}
+func (req *request) SetRecipient(recipient Uri) {
+	req.recipient = recipient
+}
 
+// StartLine returns Request Line - RFC 2361 7.1.
+func (req *request) StartLine() string {
+	var buffer bytes.Buffer
+
+	// Every SIP request starts with a Request Line - RFC 2361 7.1.
 	buffer.WriteString(
 		fmt.Sprintf(
 			"%s %s %s",
 			string(req.method),
-			recipient,
+			req.Recipient(),
 			req.SipVersion(),
 		),
 	)
@@ -163,9 +196,11 @@ func (req *request) StartLine() string {
 }
 
 func (req *request) Copy() Message {
-	newReq := CreateSimpleRequest(req.method, req.Destination())
+	newReq := CreateSimpleRequest(req.method, req.dest)
 	newReq.SetSipVersion(req.SipVersion())
-	newReq.SetRecipient(req.Recipient().Copy())
+	if req.recipient != nil {
+		newReq.SetRecipient(req.recipient.Copy())
+	}
 	for _, header := range req.headers.CloneHeaders() {
 		newReq.AddHeader(header)
 	}
@@ -174,6 +209,21 @@ func (req *request) Copy() Message {
 	return newReq
 }
 
+// 复制请求，目的地址根据 Route 以及 Request-URI 重新计算
+func CopyRequest(req Request) Request {
+	newReq := CreateSimpleRequest(req.Method(), "")
+	newReq.SetSipVersion(req.SipVersion())
+	if req.Recipient() != nil {
+		newReq.SetRecipient(req.Recipient().Copy())
+	}
+	for _, header := range req.Headers() {
+		newReq.AddHeader(header.Copy())
+	}
+
+	newReq.SetBody(req.Body(), true)
+	return newReq
+}
+
 func (req *request) IsInvite() bool {
 	return req.Method() == INVITE
 }
@@ -228,7 +278,12 @@ func (req *request) Destination() string {
 	if hdrs := req.GetHeaders("Route"); len(hdrs) > 0 {
 		routeHeader := hdrs[0].(*RouteHeader)
 		if len(routeHeader.Addresses) > 0 {
-			uri = routeHeader.Addresses[0].(*SipUri)
+			// 下一跳不是 sip/sips URI(例如 tel:)时无法确定目的地址
+			u, ok := routeHeader.Addresses[0].(*SipUri)
+			if !ok {
+				return ""
+			}
+			uri = u
 		}
 	}
 	if uri == nil {
@@ -250,6 +305,10 @@ func (req *request) Destination() string {
 	return fmt.Sprintf("%v:%v", host, port)
 }
 
+func (req *request) ExplicitDestination() string {
+	return req.dest
+}
+
 // 创建请求对应的响应 RFC 3261 - 8.2.6
 func (req *request) CreateResponse(statusCode StatusCode) Response {
 	res := new(response)
@@ -260,7 +319,6 @@ func (req *request) CreateResponse(statusCode StatusCode) Response {
 	res.SetStatusCode(statusCode)                                 // 响应状态码
 	// 复制头部参数
 	res.headers = newHeaders([]Header{})
-	res.body = req.body
 
 	CopyHeaders("Record-Route", req, res)
 	CopyHeaders("Via", req, res)
@@ -275,7 +333,7 @@ func (req *request) CreateResponse(statusCode StatusCode) Response {
 	if statusCode == 100 {
 		CopyHeaders("Timestamp", req, res)
 	} else if to := res.To(); to != nil && !to.Params.Has("tag") {
-		to.Params.Add("tag", &String{utils.RandString(10, true)})
+		to.Params.Add("tag", &String{req.responseTag()})
 	}
 
 	// 交换来源和目的地地址
@@ -300,7 +358,6 @@ func (req *request) CreateResponseReason(statusCode StatusCode, reason string) R
 	res.SetStatusCode(statusCode)                                 // 响应状态码
 	// 复制头部参数
 	res.headers = newHeaders([]Header{})
-	res.body = req.body
 
 	CopyHeaders("Record-Route", req, res)
 	CopyHeaders("Via", req, res)
@@ -315,7 +372,7 @@ func (req *request) CreateResponseReason(statusCode StatusCode, reason string) R
 	if statusCode == 100 {
 		CopyHeaders("Timestamp", req, res)
 	} else if to := res.To(); to != nil && !to.Params.Has("tag") {
-		to.Params.Add("tag", &String{utils.RandString(10, true)})
+		to.Params.Add("tag", &String{req.responseTag()})
 	}
 
 	// 交换来源和目的地地址
@@ -337,3 +394,12 @@ func (req *request) AddAuthHeader(response Response, username, password string)
 
 // NewAckForInvite creates ACK request for 2xx INVITE
 // https://tools.ietf.org/html/rfc3261#section-13.2.2.4
+
+// 同一请求的所有响应使用相同的 To tag，否则会产生多个对话
+func (req *request) responseTag() string {
+	req.tagOnce.Do(func() {
+		req.toTag = utils.RandString(10, true)
+	})
+
+	return req.toTag
+}
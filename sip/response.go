@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
-	"strings"
 
 	uuid "github.com/satori/go.uuid"
 )
@@ -45,7 +44,7 @@ func NewResponse(
 	statusCode StatusCode,
 	reason string,
 	hdrs []Header,
-	body string,
+	body []byte,
 ) Response {
 	res := new(response)
 	if messID == "" {
@@ -59,7 +58,7 @@ func NewResponse(
 	res.SetStatusCode(statusCode)
 	res.SetReason(reason)
 
-	if strings.TrimSpace(body) != "" {
+	if len(bytes.TrimSpace(body)) > 0 {
 		res.SetBody(body, true)
 	}
 
@@ -176,7 +175,7 @@ func NewResponseFromRequest(
 	req Request,
 	statusCode StatusCode,
 	reason string,
-	body string,
+	body []byte,
 ) Response {
 	res := NewResponse(
 		resID,
@@ -184,7 +183,7 @@ func NewResponseFromRequest(
 		statusCode,
 		reason,
 		[]Header{},
-		"",
+		nil,
 	)
 
 	CopyHeaders("Record-Route", req, res)
@@ -227,13 +226,13 @@ func (res *response) Destination() string {
 		port Port
 	)
 
-	if received, ok := viaHop.Params.Get("received"); ok && received.String() != "" {
+	if received, ok := viaHop.Params.Get("received"); ok && received != nil && received.String() != "" {
 		host = received.String()
 	} else {
 		host = viaHop.Host
 	}
 
-	if rport, ok := viaHop.Params.Get("rport"); ok && rport.String() != "" {
+	if rport, ok := viaHop.Params.Get("rport"); ok && rport != nil && rport.String() != "" {
 		p, _ := strconv.Atoi(rport.String())
 		port = Port(uint16(p))
 	} else if viaHop.Port != nil {
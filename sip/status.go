@@ -11,7 +11,8 @@ const (
 	StatusQueued               StatusCode = 182
 	StatusSessionProgress      StatusCode = 183
 
-	StatusOK StatusCode = 200
+	StatusOK       StatusCode = 200
+	StatusAccepted StatusCode = 202
 
 	StatusMultipleChoices    StatusCode = 300
 	StatusMovedPermanently   StatusCode = 301
@@ -35,6 +36,7 @@ const (
 	StatusUnsupportedURIScheme        StatusCode = 416
 	StatusBadExtension                StatusCode = 420
 	StatusExtensionRequired           StatusCode = 421
+	StatusSessionIntervalTooSmall     StatusCode = 422
 	StatusIntervalTooBrief            StatusCode = 423
 	StatusNoResponse                  StatusCode = 480
 	StatusCallTransactionDoesNotExist StatusCode = 481
@@ -45,6 +47,7 @@ const (
 	StatusBusyHere                    StatusCode = 486
 	StatusRequestTerminated           StatusCode = 487
 	StatusNotAcceptableHere           StatusCode = 488
+	StatusBadEvent                    StatusCode = 489
 	StatusRequestPending              StatusCode = 491
 	StatusUndecipherable              StatusCode = 493
 
@@ -69,6 +72,7 @@ var statusTexts = map[StatusCode]string{
 	StatusQueued:                      "Queued",
 	StatusSessionProgress:             "Session Progress",
 	StatusOK:                          "OK",
+	StatusAccepted:                    "Accepted",
 	StatusMultipleChoices:             "Multiple Choices",
 	StatusMovedPermanently:            "Moved Permanently",
 	StatusMovedTemporarily:            "Moved Temporarily",
@@ -90,6 +94,7 @@ var statusTexts = map[StatusCode]string{
 	StatusUnsupportedURIScheme:        "Unsupported URI Scheme",
 	StatusBadExtension:                "Bad Extension",
 	StatusExtensionRequired:           "Extension Required",
+	StatusSessionIntervalTooSmall:     "Session Interval Too Small",
 	StatusIntervalTooBrief:            "Interval Too Brief",
 	StatusNoResponse:                  "No Response",
 	StatusCallTransactionDoesNotExist: "Call/Transaction Does Not Exist",
@@ -100,6 +105,7 @@ var statusTexts = map[StatusCode]string{
 	StatusBusyHere:                    "Busy Here",
 	StatusRequestTerminated:           "Request Terminated",
 	StatusNotAcceptableHere:           "Not Acceptable Here",
+	StatusBadEvent:                    "Bad Event",
 	StatusRequestPending:              "Request Pending",
 	StatusUndecipherable:              "Undecipherable",
 	StatusServerInternalError:         "Server Internal Error",
@@ -17,4 +17,6 @@ type ServerTransaction interface {
 type ClientTransaction interface {
 	Transaction
 	Responses() <-chan Response
+	// 取消 INVITE 请求 RFC 3261 - 9.1
+	Cancel() error
 }
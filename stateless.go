@@ -0,0 +1,131 @@
+package gsip
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 无状态转发请求 RFC 3261 - 16.11：校验以及路由处理与有状态代理相同，只转发到 Router 返回的第一个目标，
+// 重发的请求、对应的 CANCEL 以及非 2xx 的 ACK 使用相同的 branch，因此 Router 对同一请求需要返回相同的目标
+func (s *service) forwardStateless(req sip.Request) {
+	if res := s.validateProxyRequest(req); res != nil {
+		logger.Warnf("[G.SIP] -> stateless proxy reject %s: %d %s", req.Short(), res.StatusCode(), res.Reason())
+		s.replyStateless(req, res)
+		return
+	}
+
+	forward := sip.CopyRequest(req)
+	s.preprocessRoute(forward)
+
+	targets, res := s.proxyTargets(forward)
+	if res != nil {
+		s.replyStateless(req, res)
+		return
+	}
+
+	markReceived(forward, req.Source())
+	out := s.proxyForward(forward, targets[0], statelessBranch(req), s.opts.proxy.recordRoute)
+	if err := s.opts.tp.Send(out); err != nil {
+		logger.Errorf("[G.SIP] -> stateless forward %s to %s failed: %s", req.Short(), targets[0], err)
+		s.replyStateless(req, req.CreateResponseReason(sip.StatusServiceUnavailable, "Service Unavailable"))
+	}
+}
+
+// 无状态转发响应 RFC 3261 - 16.11：第一个 Via 的 sent-by 以及 branch 为本代理无状态转发时移除，按第二个 Via 返回，返回是否已处理
+func (s *service) forwardStatelessResponse(res sip.Response) bool {
+	hops := viaHops(res)
+	// 本代理转发的请求至少有两个 Via，只有一个 Via 的为本端发送的请求的响应
+	if len(hops) < 2 || !s.isLocalSentBy(hops[0]) {
+		return false
+	}
+	// 第二个 Via 为收到的请求的第一个 Via，按其重新计算 branch，不一致时不是本代理无状态转发的请求
+	var branch sip.MaybeString
+	if hops[0].Params != nil {
+		branch, _ = hops[0].Params.Get("branch")
+	}
+	if branch == nil || branch.String() != statelessBranchOf(hops[1], res) {
+		return false
+	}
+
+	forward := sip.CopyResponse(res)
+	forward.DelHeader("Via")
+	forward.PrependHeader(sip.ViaHeader(hops[1:]))
+	if err := s.opts.tp.Send(forward); err != nil {
+		logger.Errorf("[G.SIP] -> stateless forward %s failed: %s", res.Short(), err)
+	}
+
+	return true
+}
+
+// 直接通过传输层响应，ACK 没有响应
+func (s *service) replyStateless(req sip.Request, res sip.Response) {
+	if req.IsAck() {
+		return
+	}
+	if err := s.opts.tp.Send(res); err != nil {
+		logger.Errorf("[G.SIP] -> send %d for %s failed: %s", res.StatusCode(), req.Short(), err)
+	}
+}
+
+// 无状态转发的 branch RFC 3261 - 16.11：收到的请求的第一个 Via 的 branch、sent-by 以及 Call-ID、CSeq 序号的 hash，
+// INVITE 与对应的 CANCEL、非 2xx 的 ACK 相同，重发的请求也相同
+func statelessBranch(req sip.Request) string {
+	hop, _ := req.ViaHop()
+	return statelessBranchOf(hop, req)
+}
+
+// 按收到的请求的第一个 Via 以及消息的 Call-ID、CSeq 计算 branch，响应的第二个 Via 与请求的第一个 Via 相同
+func statelessBranchOf(hop *sip.ViaHop, msg sip.Message) string {
+	var key string
+	if hop != nil {
+		key = viaKey(hop)
+	}
+	if callID := msg.CallID(); callID != nil {
+		key += "|" + string(*callID)
+	}
+	if cseq := msg.CSeq(); cseq != nil {
+		key += fmt.Sprintf("|%d", cseq.SeqNo)
+	}
+
+	return fmt.Sprintf("%s%x", sip.RFC3261BranchMagicCookie, md5.Sum([]byte(key)))
+}
+
+// Via 的 sent-by 是否为本代理：传输层发送时 sent-by 为本地 IP、传输协议以及协议的默认端口
+func (s *service) isLocalSentBy(hop *sip.ViaHop) bool {
+	if hop.Host != s.opts.tp.LocalIP().String() {
+		return false
+	}
+	switch strings.ToLower(hop.Transport) {
+	case "udp", "tcp", "tls", "ws", "wss":
+	default:
+		return false
+	}
+
+	return hop.Port == nil || *hop.Port == sip.DefaultPort(hop.Transport)
+}
+
+// 在第一个 Via 记录请求的来源地址 RFC 3261 - 18.2.1、RFC 3581 - 4，无状态代理不保存来源，响应按 Via 返回
+func markReceived(req sip.Request, source string) {
+	hop, ok := req.ViaHop()
+	if !ok {
+		return
+	}
+	host, port, err := net.SplitHostPort(source)
+	if err != nil {
+		return
+	}
+	if hop.Params == nil {
+		hop.Params = sip.NewParams()
+	}
+	if hop.Host != host {
+		hop.Params.Add("received", sip.String{Str: host})
+	}
+	if hop.Params.Has("rport") {
+		hop.Params.Add("rport", sip.String{Str: port})
+	}
+}
@@ -0,0 +1,101 @@
+package gsip
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 无状态代理转发请求时添加 Via，响应按第二个 Via 返回给 UAC
+func TestStatelessProxyRoundTrip(t *testing.T) {
+	var vias int32
+	_, uasCallback := newTestService(t, "127.0.3.3")
+	uasCallback.AddRequestHandle(sip.OPTIONS, func(req sip.Request, tx sip.ServerTransaction) {
+		hops := 0
+		for _, hdr := range req.GetHeaders("Via") {
+			if via, ok := hdr.(sip.ViaHeader); ok {
+				hops += len(via)
+			}
+		}
+		atomic.StoreInt32(&vias, int32(hops))
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusOK))
+	})
+
+	router := RouterFunc(func(req sip.Request) ([]sip.Uri, error) {
+		return []sip.Uri{testUri("bob", "127.0.3.3")}, nil
+	})
+	newTestService(t, "127.0.3.2", StatelessProxyConfig(router))
+	uac, _ := newTestService(t, "127.0.3.1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	options := uac.CreateRequest(sip.OPTIONS, "127.0.3.2:5060", testUri("alice", "127.0.3.1"), testUri("bob", "127.0.3.2"))
+	res, err := uac.Request(ctx, options)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	if res.StatusCode() != sip.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode())
+	}
+	if n := atomic.LoadInt32(&vias); n != 2 {
+		t.Errorf("UAS received %d Via, want 2", n)
+	}
+	if hops := res.GetHeaders("Via"); len(hops) != 1 || len(hops[0].(sip.ViaHeader)) != 1 {
+		t.Errorf("UAC received Via %v, want only its own", hops)
+	}
+}
+
+// 第一个 Via 的 sent-by 或者 branch 不是本代理无状态转发时不转发响应
+func TestStatelessResponseNotForwarded(t *testing.T) {
+	router := RouterFunc(func(req sip.Request) ([]sip.Uri, error) { return nil, nil })
+	s := NewService(Transport("127.0.3.4"), StatelessProxyConfig(router)).(*service)
+	defer s.Close()
+
+	req := sip.CreateRequest(sip.OPTIONS, "", testUri("alice", "127.0.3.5"), testUri("bob", "127.0.3.4"))
+	received := sip.CopyRequest(req)
+	received.DelHeader("Via")
+	received.AddHeader(sip.ViaHeader{viaHop("UDP", "127.0.3.5", nil, "z9hG4bK-uac")})
+	branch := statelessBranch(received)
+
+	port := sip.Port(5070)
+	tests := []struct {
+		name string
+		hop  *sip.ViaHop
+		want bool
+	}{
+		{name: "stateless branch", hop: viaHop("UDP", "127.0.3.4", nil, branch), want: true},
+		{name: "other host", hop: viaHop("UDP", "127.0.3.6", nil, branch)},
+		{name: "other port", hop: viaHop("UDP", "127.0.3.4", &port, branch)},
+		{name: "other transport", hop: viaHop("SCTP", "127.0.3.4", nil, branch)},
+		{name: "other branch", hop: viaHop("UDP", "127.0.3.4", nil, sip.GenerateBranch())},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hops := []*sip.ViaHop{test.hop}
+			res := received.CreateResponse(sip.StatusOK)
+			res.DelHeader("Via")
+			res.AddHeader(sip.ViaHeader(append(hops, viaHop("UDP", "127.0.3.5", nil, "z9hG4bK-uac"))))
+			if got := s.forwardStatelessResponse(res); got != test.want {
+				t.Errorf("forwarded = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func viaHop(transport string, host string, port *sip.Port, branch string) *sip.ViaHop {
+	params := sip.NewParams()
+	params.Add("branch", sip.String{Str: branch})
+
+	return &sip.ViaHop{
+		ProtocolName:    "SIP",
+		ProtocolVersion: "2.0",
+		Transport:       transport,
+		Host:            host,
+		Port:            port,
+		Params:          params,
+	}
+}
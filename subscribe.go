@@ -0,0 +1,690 @@
+package gsip
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+	"github.com/zenghr0820/gsip/transaction"
+	"github.com/zenghr0820/gsip/utils"
+)
+
+// 订阅默认配置
+const (
+	// probation、giveup 没有 retry-after 时重新订阅的间隔
+	DefaultSubscribeRetry = 5 * time.Second
+	// 单次 SUBSCRIBE 的超时时间
+	subscribeTimeout = transaction.TimeF
+)
+
+// 订阅状态
+type SubscribeState int
+
+const (
+	SubscribeStateNone SubscribeState = iota
+	// 通知方尚未授权订阅
+	SubscribeStatePending
+	// 订阅已授权
+	SubscribeStateActive
+	// 订阅已终止，reason 允许时自动重新订阅
+	SubscribeStateTerminated
+)
+
+func (state SubscribeState) String() string {
+	switch state {
+	case SubscribeStatePending:
+		return "Pending"
+	case SubscribeStateActive:
+		return "Active"
+	case SubscribeStateTerminated:
+		return "Terminated"
+	default:
+		return "None"
+	}
+}
+
+// 订阅状态变化以及收到通知的事件
+type SubscribeEvent struct {
+	State SubscribeState
+	// 订阅剩余的有效期(秒)
+	Expires uint32
+	// 收到的 NOTIFY，消息体为事件包的状态，可能为 nil
+	Notify sip.Request
+	// 最后一次 SUBSCRIBE 的最终响应，可能为 nil
+	Response sip.Response
+	// 终止的原因，Subscription-State 的 reason 参数
+	Reason string
+	Err    error
+}
+
+// 订阅的配置选项
+type SubscribeOptions struct {
+	expires     uint32
+	contact     sip.Uri
+	auth        sip.Authorized
+	handler     func(event SubscribeEvent)
+	id          string
+	contentType string
+	body        []byte
+}
+
+type SubscribeOption func(*SubscribeOptions)
+
+func newSubscribeOptions(pkg EventPackage, opts ...SubscribeOption) SubscribeOptions {
+	opt := SubscribeOptions{
+		expires: pkg.DefaultExpires(),
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
+// 请求的有效期(秒)，默认为事件包的默认有效期，通知方可能返回更小的值
+func SubscribeExpires(expires uint32) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.expires = expires
+	}
+}
+
+// 订阅的联系地址，默认为 From 用户名@本地 IP
+func SubscribeContact(contact sip.Uri) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.contact = contact
+	}
+}
+
+// 订阅使用的认证信息，覆盖 AuthConfig 的配置
+func SubscribeAuth(auth sip.Authorized) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.auth = auth
+	}
+}
+
+// 订阅状态变化以及收到通知的回调函数，也可以通过 Subscription.Events 接收
+func SubscribeCallback(handler func(event SubscribeEvent)) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.handler = handler
+	}
+}
+
+// Event 头部的 id 参数，同一对话中区分多个订阅
+func SubscribeID(id string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.id = id
+	}
+}
+
+// SUBSCRIBE 的消息体，例如 GB28181 目录订阅的查询条件
+func SubscribeBody(contentType string, body []byte) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.contentType = contentType
+		o.body = body
+	}
+}
+
+// 订阅方 RFC 6665 - 4.1，在有效期到达之前通过对话内的 SUBSCRIBE 刷新
+type Subscription struct {
+	service *service
+	target  string
+	from    sip.Uri
+	to      sip.Uri
+	pkg     EventPackage
+	contact sip.Uri
+	opts    SubscribeOptions
+	// 每次重新订阅使用新的 Call-ID 以及 From tag
+	callID  sip.CallID
+	fromTag string
+	key     string
+	// 请求的有效期，收到 423 时更新为 Min-Expires
+	expires uint32
+	// 订阅有效期到达的时间
+	expiresAt time.Time
+	dialog    sip.Dialog
+	state     SubscribeState
+
+	notifies chan sip.Request
+	events   chan SubscribeEvent
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	mu       sync.RWMutex
+}
+
+func newSubscription(s *service, target string, from sip.Uri, to sip.Uri, pkg EventPackage, opts ...SubscribeOption) *Subscription {
+	sub := &Subscription{
+		service:  s,
+		target:   target,
+		from:     from,
+		to:       to,
+		pkg:      pkg,
+		opts:     newSubscribeOptions(pkg, opts...),
+		notifies: make(chan sip.Request, 16),
+		events:   make(chan SubscribeEvent, 16),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	sub.expires = sub.opts.expires
+	sub.contact = sub.opts.contact
+	if sub.contact == nil {
+		contact := from.Copy()
+		contact.SetDomain(sip.Addr{Host: s.opts.tp.LocalIP().String()})
+		contact.SetUriParams(sip.NewParams())
+		sub.contact = contact
+	}
+
+	return sub
+}
+
+// 订阅状态变化以及通知事件，未及时接收的事件会被丢弃
+func (sub *Subscription) Events() <-chan SubscribeEvent {
+	return sub.events
+}
+
+// 当前订阅状态
+func (sub *Subscription) State() SubscribeState {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	return sub.state
+}
+
+// 事件包
+func (sub *Subscription) Event() EventPackage {
+	return sub.pkg
+}
+
+// 订阅对话，订阅尚未建立时为 nil
+func (sub *Subscription) Dialog() sip.Dialog {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	return sub.dialog
+}
+
+// 停止刷新并取消订阅(Expires: 0)，等待通知方的最后一个 NOTIFY 直到 ctx 结束
+func (sub *Subscription) Unsubscribe(ctx context.Context) error {
+	stopped := false
+	sub.once.Do(func() {
+		close(sub.stop)
+		stopped = true
+	})
+	<-sub.done
+	if !stopped {
+		return nil
+	}
+	defer sub.service.removeSubscription(sub)
+
+	sub.mu.RLock()
+	dialog := sub.dialog
+	active := sub.state != SubscribeStateTerminated && dialog != nil && dialog.State() != sip.DialogStateTerminated
+	sub.mu.RUnlock()
+	if !active {
+		sub.setState(SubscribeStateTerminated, 0, nil, nil, "", nil)
+		return nil
+	}
+	defer dialog.Close()
+
+	res, err := sub.request(ctx, dialog, 0)
+	if err == nil && !res.IsSuccess() {
+		err = fmt.Errorf("[G.SIP] -> unsubscribe %s failed: %d %s", sub.to, res.StatusCode(), res.Reason())
+	}
+	if err != nil {
+		sub.setState(SubscribeStateTerminated, 0, nil, res, "", err)
+		return err
+	}
+
+	// RFC 6665 - 4.1.2.3 通知方以 terminated 的 NOTIFY 确认取消订阅
+	for {
+		select {
+		case <-ctx.Done():
+			sub.setState(SubscribeStateTerminated, 0, nil, res, "", nil)
+			return nil
+		case notify := <-sub.notifies:
+			state := sip.SubscriptionState(notify)
+			if state == nil || state.State != sip.SubscriptionTerminated {
+				continue
+			}
+			sub.setState(SubscribeStateTerminated, 0, notify, res, state.Reason(), nil)
+			return nil
+		}
+	}
+}
+
+// 订阅、刷新以及按终止原因重新订阅
+func (sub *Subscription) run() {
+	defer close(sub.done)
+	defer func() {
+		// 订阅终止且不再重新订阅，Unsubscribe 时由 Unsubscribe 移除
+		select {
+		case <-sub.stop:
+		default:
+			sub.service.removeSubscription(sub)
+		}
+	}()
+
+	var wait time.Duration
+	for {
+		if !sub.sleep(wait) {
+			return
+		}
+		res, ok := sub.subscribe()
+		if !ok {
+			return
+		}
+
+		var resubscribe bool
+		if wait, resubscribe = sub.serve(res); !resubscribe {
+			return
+		}
+	}
+}
+
+func (sub *Subscription) sleep(wait time.Duration) bool {
+	if wait <= 0 {
+		select {
+		case <-sub.stop:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-sub.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// 对话外的 SUBSCRIBE 建立新的订阅，失败时终止订阅
+func (sub *Subscription) subscribe() (sip.Response, bool) {
+	sub.mu.Lock()
+	sub.callID = *sip.DefaultCallID()
+	sub.fromTag = utils.RandString(10, true)
+	sub.dialog = nil
+	sub.mu.Unlock()
+	// 发送之前注册，NOTIFY 可能先于 2xx 到达
+	sub.service.addSubscription(sub)
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+		res, err := sub.request(ctx, nil, sub.expires)
+		cancel()
+
+		// 423 Interval Too Brief 使用 Min-Expires 立即重试
+		if err == nil && res.StatusCode() == sip.StatusIntervalTooBrief {
+			if value, ok := minExpires(res); ok && value > sub.expires {
+				sub.expires = value
+				continue
+			}
+		}
+		if err == nil && !res.IsSuccess() {
+			err = fmt.Errorf("[G.SIP] -> subscribe %s to %s failed: %d %s", sub.pkg.Name(), sub.to, res.StatusCode(), res.Reason())
+		}
+		if err != nil {
+			logger.Warn(err)
+			sub.setState(SubscribeStateTerminated, 0, nil, res, "", err)
+			return res, false
+		}
+
+		sub.mu.Lock()
+		if sub.dialog == nil {
+			sub.dialog = sub.service.DialogOf(res)
+		}
+		sub.expiresAt = time.Now().Add(time.Duration(grantedExpires(res, sub.expires)) * time.Second)
+		sub.mu.Unlock()
+
+		return res, true
+	}
+}
+
+// 处理通知以及刷新订阅，订阅终止时返回重新订阅之前等待的时间以及是否重新订阅 RFC 6665 - 4.1.3：
+// deactivated、timeout 以及对话内的 SUBSCRIBE 收到 481 时立即重新订阅
+// probation、giveup 在 retry-after 之后重新订阅
+// 其他原因终止订阅
+func (sub *Subscription) serve(res sip.Response) (time.Duration, bool) {
+	sub.mu.RLock()
+	dialog := sub.dialog
+	sub.mu.RUnlock()
+	if dialog == nil {
+		err := fmt.Errorf("[G.SIP] -> subscribe %s to %s: no dialog created by %s", sub.pkg.Name(), sub.to, res.Short())
+		logger.Warn(err)
+		sub.setState(SubscribeStateTerminated, 0, nil, res, "", err)
+		return 0, false
+	}
+
+	timer := time.NewTimer(sub.refreshIn())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-sub.stop:
+			return 0, false
+		case <-dialog.Done():
+			err := fmt.Errorf("[G.SIP] -> dialog %s of subscription terminated", dialog.ID())
+			sub.setState(SubscribeStateTerminated, 0, nil, res, "", err)
+			return 0, true
+		case notify := <-sub.notifies:
+			state := sip.SubscriptionState(notify)
+			if state.State != sip.SubscriptionTerminated {
+				sub.mu.Lock()
+				if expires, ok := state.Expires(); ok {
+					if at := time.Now().Add(time.Duration(expires) * time.Second); at.Before(sub.expiresAt) {
+						sub.expiresAt = at
+					}
+				}
+				sub.mu.Unlock()
+
+				subState := SubscribeStateActive
+				if state.State == sip.SubscriptionPending {
+					subState = SubscribeStatePending
+				}
+				sub.setState(subState, sub.remaining(), notify, nil, "", nil)
+				resetTimer(timer, sub.refreshIn())
+				continue
+			}
+
+			dialog.Close()
+			reason := state.Reason()
+			sub.setState(SubscribeStateTerminated, 0, notify, nil, reason, nil)
+			switch reason {
+			case sip.SubscriptionReasonDeactivated, sip.SubscriptionReasonTimeout:
+				return 0, true
+			case sip.SubscriptionReasonProbation, sip.SubscriptionReasonGiveup:
+				if retryAfter, ok := state.RetryAfter(); ok {
+					return time.Duration(retryAfter) * time.Second, true
+				}
+				return DefaultSubscribeRetry, true
+			default:
+				return 0, false
+			}
+		case <-timer.C:
+			if sub.remaining() == 0 {
+				// 刷新失败且已超过有效期
+				err := fmt.Errorf("[G.SIP] -> subscription %s to %s expired", sub.pkg.Name(), sub.to)
+				logger.Warn(err)
+				dialog.Close()
+				sub.setState(SubscribeStateTerminated, 0, nil, nil, sip.SubscriptionReasonTimeout, err)
+				return 0, true
+			}
+
+			res = sub.refresh(dialog)
+			if res != nil && res.StatusCode() == sip.StatusCallTransactionDoesNotExist {
+				// RFC 6665 - 4.1.2.2 通知方不存在该订阅
+				dialog.Close()
+				sub.setState(SubscribeStateTerminated, 0, nil, res, "", fmt.Errorf("[G.SIP] -> subscription %s to %s does not exist", sub.pkg.Name(), sub.to))
+				return 0, true
+			}
+			resetTimer(timer, sub.refreshIn())
+		}
+	}
+}
+
+// 对话内的 SUBSCRIBE 刷新订阅，失败时订阅在有效期内仍然有效 RFC 6665 - 4.1.2.2
+func (sub *Subscription) refresh(dialog sip.Dialog) sip.Response {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+		res, err := sub.request(ctx, dialog, sub.expires)
+		cancel()
+
+		if err != nil {
+			logger.Warnf("[G.SIP] -> refresh subscription %s to %s failed: %s", sub.pkg.Name(), sub.to, err)
+			return nil
+		}
+		if res.StatusCode() == sip.StatusIntervalTooBrief {
+			if value, ok := minExpires(res); ok && value > sub.expires {
+				sub.expires = value
+				continue
+			}
+		}
+		if !res.IsSuccess() {
+			logger.Warnf("[G.SIP] -> refresh subscription %s to %s failed: %d %s", sub.pkg.Name(), sub.to, res.StatusCode(), res.Reason())
+			return res
+		}
+
+		sub.mu.Lock()
+		sub.expiresAt = time.Now().Add(time.Duration(grantedExpires(res, sub.expires)) * time.Second)
+		sub.mu.Unlock()
+
+		return res
+	}
+}
+
+// 发送 SUBSCRIBE，dialog 为 nil 时为对话外的请求，expires 为 0 时取消订阅
+func (sub *Subscription) request(ctx context.Context, dialog sip.Dialog, expires uint32) (sip.Response, error) {
+	var req sip.Request
+	if dialog != nil {
+		req = dialog.CreateRequest(sip.SUBSCRIBE)
+	} else {
+		sub.mu.RLock()
+		req = sub.service.CreateRequest(sip.SUBSCRIBE, sub.target, sub.from, sub.to)
+		req.From().Params.Add("tag", sip.String{Str: sub.fromTag})
+		callID := sub.callID
+		req.ReplaceHeader(&callID)
+		req.CSeq().MethodName = sip.SUBSCRIBE
+		sub.mu.RUnlock()
+	}
+	req.AddHeader(eventHeader(sub.pkg, sub.opts.id))
+	expiresHeader := sip.Expires(expires)
+	req.AddHeader(&expiresHeader)
+	req.AddHeader(&sip.ContactHeader{
+		Address: sub.contact.Copy(),
+		Params:  sip.NewParams(),
+	})
+	if contentType := sub.pkg.ContentType(); contentType != "" {
+		accept := sip.Accept(contentType)
+		req.AddHeader(&accept)
+	}
+	if len(sub.opts.body) > 0 {
+		contentType := sip.ContentType(sub.opts.contentType)
+		req.AddHeader(&contentType)
+		req.SetBody(sub.opts.body, true)
+	}
+
+	opts := make([]RequestOption, 0, 1)
+	if sub.opts.auth != nil {
+		opts = append(opts, RequestAuth(sub.opts.auth))
+	}
+
+	return sub.service.Request(ctx, req, opts...)
+}
+
+// 校验收到的 NOTIFY，返回响应的状态码：
+// 没有 Subscription-State 时返回 400
+// 订阅已经建立时其他分叉的 NOTIFY 返回 481 并终止该分叉的对话 RFC 6665 - 4.1.2.4
+func (sub *Subscription) receiveNotify(notify sip.Request) sip.StatusCode {
+	if sip.SubscriptionState(notify) == nil {
+		return sip.StatusBadRequest
+	}
+
+	dialog := sub.service.DialogOf(notify)
+	sub.mu.Lock()
+	if sub.dialog == nil {
+		sub.dialog = dialog
+	}
+	fork := dialog != nil && sub.dialog != nil && sub.dialog.ID() != dialog.ID()
+	sub.mu.Unlock()
+	if fork {
+		logger.Warnf("[G.SIP] -> reject forked %s of subscription %s", notify.Short(), sub.pkg.Name())
+		dialog.Close()
+		return sip.StatusCallTransactionDoesNotExist
+	}
+
+	select {
+	case sub.notifies <- notify:
+	default:
+		logger.Warnf("[G.SIP] -> drop %s, subscription %s is not receiving", notify.Short(), sub.pkg.Name())
+	}
+
+	return sip.StatusOK
+}
+
+// 订阅剩余的有效期(秒)
+func (sub *Subscription) remaining() uint32 {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	if left := time.Until(sub.expiresAt); left > 0 {
+		return uint32((left + time.Second/2) / time.Second)
+	}
+
+	return 0
+}
+
+// 下一次刷新之前等待的时间
+func (sub *Subscription) refreshIn() time.Duration {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	left := time.Until(sub.expiresAt)
+	if left <= 0 {
+		return 0
+	}
+	if wait := refreshInterval(uint32(left / time.Second)); wait > 0 {
+		return wait
+	}
+
+	return left
+}
+
+func (sub *Subscription) setState(state SubscribeState, expires uint32, notify sip.Request, res sip.Response, reason string, err error) {
+	sub.mu.Lock()
+	sub.state = state
+	sub.mu.Unlock()
+
+	event := SubscribeEvent{
+		State:    state,
+		Expires:  expires,
+		Notify:   notify,
+		Response: res,
+		Reason:   reason,
+		Err:      err,
+	}
+	select {
+	case sub.events <- event:
+	default:
+	}
+	if sub.opts.handler != nil {
+		sub.opts.handler(event)
+	}
+}
+
+// 订阅通知方的事件包，在有效期到达之前自动刷新，关闭服务时取消订阅
+func (s *service) Subscribe(target string, from sip.Uri, to sip.Uri, pkg EventPackage, opts ...SubscribeOption) *Subscription {
+	sub := newSubscription(s, target, from, to, pkg, opts...)
+
+	go sub.run()
+	return sub
+}
+
+// 按 Call-ID、From tag 以及 Event 注册订阅，用于匹配 NOTIFY
+func (s *service) addSubscription(sub *Subscription) {
+	sub.mu.Lock()
+	key := subscriptionKey(sip.DialogID(string(sub.callID), sub.fromTag, ""), sub.pkg.Name(), sub.opts.id)
+	oldKey := sub.key
+	sub.key = key
+	sub.mu.Unlock()
+
+	s.smu.Lock()
+	delete(s.subscriptions, oldKey)
+	s.subscriptions[key] = sub
+	s.smu.Unlock()
+}
+
+func (s *service) removeSubscription(sub *Subscription) {
+	sub.mu.RLock()
+	key := sub.key
+	sub.mu.RUnlock()
+
+	s.smu.Lock()
+	if s.subscriptions[key] == sub {
+		delete(s.subscriptions, key)
+	}
+	s.smu.Unlock()
+}
+
+// 将 NOTIFY 交给对应的订阅，返回是否已处理
+func (s *service) handleNotify(notify sip.Request) bool {
+	event, id, ok := eventOf(notify)
+	if !ok {
+		return false
+	}
+	callID, localTag := "", ""
+	if c := notify.CallID(); c != nil {
+		callID = string(*c)
+	}
+	if to := notify.To(); to != nil && to.Params != nil {
+		if tag, ok := to.Params.Get("tag"); ok && tag != nil {
+			localTag = tag.String()
+		}
+	}
+
+	s.smu.RLock()
+	sub, ok := s.subscriptions[subscriptionKey(sip.DialogID(callID, localTag, ""), event, id)]
+	s.smu.RUnlock()
+	if !ok {
+		return strings.EqualFold(event, ReferPackage.Name()) && s.handleTransferNotify(notify, id)
+	}
+
+	status := sub.receiveNotify(notify)
+	if _, err := s.Send(notify.CreateResponse(status)); err != nil {
+		logger.Errorf("[G.SIP] -> send %d for %s failed: %s", status, notify.Short(), err)
+	}
+
+	return true
+}
+
+// 取消所有订阅
+func (s *service) unsubscribeAll() {
+	s.smu.RLock()
+	subscriptions := make([]*Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	s.smu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), unregisterTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, sub := range subscriptions {
+		wg.Add(1)
+		go func(sub *Subscription) {
+			defer wg.Done()
+			if err := sub.Unsubscribe(ctx); err != nil {
+				logger.Warnf("[G.SIP] -> unsubscribe %s from %s failed: %s", sub.pkg.Name(), sub.to, err)
+			}
+		}(sub)
+	}
+	wg.Wait()
+}
+
+// 2xx 中通知方允许的有效期，没有 Expires 头部时为请求的有效期
+func grantedExpires(res sip.Response, requested uint32) uint32 {
+	if expires := res.Expires(); expires != nil {
+		return uint32(*expires)
+	}
+
+	return requested
+}
+
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
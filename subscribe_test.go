@@ -0,0 +1,195 @@
+package gsip
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 通知方 127.0.6.2 支持 presence 事件包，处理函数设置第一个 NOTIFY 的消息体，接受的订阅通过 subscribers 返回
+func newNotifier(t *testing.T, opts ...Option) chan *Subscriber {
+	subscribers := make(chan *Subscriber, 4)
+	handler := func(subscriber *Subscriber) error {
+		if string(subscriber.Request().Body()) == "reject" {
+			return errors.New("rejected")
+		}
+		subscriber.SetContent([]byte("open"))
+		subscribers <- subscriber
+		return nil
+	}
+	newTestService(t, "127.0.6.2", append([]Option{AddEventPackage(PresencePackage, handler)}, opts...)...)
+
+	return subscribers
+}
+
+// 订阅方 127.0.6.1 订阅 127.0.6.2 的事件包
+func subscribe(t *testing.T, pkg EventPackage, opts ...SubscribeOption) *Subscription {
+	s, _ := newTestService(t, "127.0.6.1")
+
+	return s.Subscribe("127.0.6.2:5060", testUri("alice", "127.0.6.1"), testUri("bob", "127.0.6.2"), pkg, opts...)
+}
+
+func nextSubscribeEvent(t *testing.T, sub *Subscription) SubscribeEvent {
+	t.Helper()
+	select {
+	case event := <-sub.Events():
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event of subscription %s", sub.Event().Name())
+	}
+
+	return SubscribeEvent{}
+}
+
+func nextSubscriber(t *testing.T, subscribers chan *Subscriber) *Subscriber {
+	t.Helper()
+	select {
+	case subscriber := <-subscribers:
+		return subscriber
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not accepted")
+	}
+
+	return nil
+}
+
+// 接受订阅之后立即通知，通知方的通知传递给订阅方，取消订阅时通知方以 terminated 的 NOTIFY 确认
+func TestSubscribe(t *testing.T) {
+	subscribers := newNotifier(t, NotifierExpires(60, 120))
+	sub := subscribe(t, PresencePackage)
+
+	event := nextSubscribeEvent(t, sub)
+	if event.State != SubscribeStateActive || string(event.Notify.Body()) != "open" {
+		t.Fatalf("event = %s with body %q, want Active with open", event.State, event.Notify.Body())
+	}
+	// 请求的 3600 秒大于通知方的最大值
+	if event.Expires == 0 || event.Expires > 120 {
+		t.Errorf("expires = %d, want at most 120", event.Expires)
+	}
+	if contentType := event.Notify.GetHeaders("Content-Type"); len(contentType) == 0 {
+		t.Error("NOTIFY without Content-Type")
+	}
+
+	subscriber := nextSubscriber(t, subscribers)
+	if subscriber.State() != sip.SubscriptionActive || subscriber.Dialog() == nil {
+		t.Fatalf("subscriber state = %s", subscriber.State())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := subscriber.Notify(ctx, []byte("closed")); err != nil {
+		t.Fatalf("notify failed: %s", err)
+	}
+	if event := nextSubscribeEvent(t, sub); string(event.Notify.Body()) != "closed" {
+		t.Errorf("notified body %q, want closed", event.Notify.Body())
+	}
+
+	if err := sub.Unsubscribe(ctx); err != nil {
+		t.Fatalf("unsubscribe failed: %s", err)
+	}
+	if event := nextSubscribeEvent(t, sub); event.State != SubscribeStateTerminated || event.Notify == nil {
+		t.Errorf("event = %s, want Terminated by NOTIFY", event.State)
+	}
+	select {
+	case <-subscriber.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("subscriber not removed")
+	}
+}
+
+// 有效期小于通知方的最小值时使用 423 的 Min-Expires 重新订阅
+func TestSubscribeIntervalTooBrief(t *testing.T) {
+	newNotifier(t, NotifierExpires(90, 0))
+	sub := subscribe(t, PresencePackage, SubscribeExpires(30))
+
+	event := nextSubscribeEvent(t, sub)
+	if event.State != SubscribeStateActive {
+		t.Fatalf("event = %s: %v, want Active", event.State, event.Err)
+	}
+	if event.Expires < 60 || event.Expires > 90 {
+		t.Errorf("expires = %d, want Min-Expires 90", event.Expires)
+	}
+}
+
+// 不支持的事件包返回 489 以及 Allow-Events，处理函数返回错误时返回 403，订阅终止
+func TestSubscribeRejected(t *testing.T) {
+	newNotifier(t)
+	tests := []struct {
+		name   string
+		pkg    EventPackage
+		opts   []SubscribeOption
+		status sip.StatusCode
+	}{
+		{name: "bad event", pkg: DialogPackage, status: sip.StatusBadEvent},
+		{name: "handler error", pkg: PresencePackage, opts: []SubscribeOption{SubscribeBody("text/plain", []byte("reject"))}, status: sip.StatusForbidden},
+	}
+
+	s, _ := newTestService(t, "127.0.6.1")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sub := s.Subscribe("127.0.6.2:5060", testUri("alice", "127.0.6.1"), testUri("bob", "127.0.6.2"), test.pkg, test.opts...)
+			event := nextSubscribeEvent(t, sub)
+			if event.State != SubscribeStateTerminated || event.Response == nil || event.Response.StatusCode() != test.status {
+				t.Fatalf("event = %s with %v, want Terminated with %d", event.State, event.Response, test.status)
+			}
+			if test.status == sip.StatusBadEvent && len(event.Response.GetHeaders("Allow-Events")) == 0 {
+				t.Error("489 without Allow-Events")
+			}
+		})
+	}
+}
+
+// 通知方以 deactivated 终止订阅时立即重新订阅，以 noresource 终止时不再订阅
+func TestSubscriptionTerminated(t *testing.T) {
+	subscribers := newNotifier(t)
+	sub := subscribe(t, PresencePackage)
+	nextSubscribeEvent(t, sub)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first := nextSubscriber(t, subscribers)
+	if err := first.Terminate(ctx, sip.SubscriptionReasonDeactivated); err != nil {
+		t.Fatalf("terminate failed: %s", err)
+	}
+	if event := nextSubscribeEvent(t, sub); event.State != SubscribeStateTerminated || event.Reason != sip.SubscriptionReasonDeactivated {
+		t.Fatalf("event = %s;reason=%s, want Terminated;reason=deactivated", event.State, event.Reason)
+	}
+	if event := nextSubscribeEvent(t, sub); event.State != SubscribeStateActive {
+		t.Fatalf("event = %s, want Active after resubscribe", event.State)
+	}
+	second := nextSubscriber(t, subscribers)
+	if second.Dialog().ID() == first.Dialog().ID() {
+		t.Error("resubscribed in the terminated dialog")
+	}
+
+	if err := second.Terminate(ctx, sip.SubscriptionReasonNoResource); err != nil {
+		t.Fatalf("terminate failed: %s", err)
+	}
+	if event := nextSubscribeEvent(t, sub); event.State != SubscribeStateTerminated || event.Reason != sip.SubscriptionReasonNoResource {
+		t.Fatalf("event = %s;reason=%s, want Terminated;reason=noresource", event.State, event.Reason)
+	}
+	select {
+	case subscriber := <-subscribers:
+		t.Errorf("resubscribed %s after noresource", subscriber.Dialog().ID())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSubscriptionKey(t *testing.T) {
+	if subscriptionKey("dialog", "Presence", "1") != subscriptionKey("dialog", "presence", "1") {
+		t.Error("event name of subscription key is case sensitive")
+	}
+	if subscriptionKey("dialog", "presence", "1") == subscriptionKey("dialog", "presence", "2") {
+		t.Error("subscriptions with different id share a key")
+	}
+
+	header := eventHeader(CatalogPackage, "")
+	if header.EventType != "Catalog" || header.ID() != "" {
+		t.Errorf("Event = %s, want Catalog without id", header)
+	}
+	if header := eventHeader(ReferPackage, "7"); header.ID() != "7" {
+		t.Errorf("Event = %s, want id 7", header)
+	}
+}
@@ -0,0 +1,87 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 定时器 L、M 为 64*T1
+const acceptedT1 = 10 * time.Millisecond
+
+// 等待事务在 Accepted 状态持续 64*T1 之后结束
+func expectAcceptedDone(t *testing.T, done <-chan bool, start time.Time) {
+	t.Helper()
+	select {
+	case <-done:
+		if elapsed := time.Since(start); elapsed < 64*acceptedT1 {
+			t.Errorf("terminated after %s, want 64*T1", elapsed)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("transaction not terminated")
+	}
+}
+
+// 客户端事务收到 2xx 之后在 Accepted 状态吸收重发的 2xx：重发 ACK，不再传递给上层，定时器 M 触发后结束
+func TestClientAccepted(t *testing.T) {
+	txl, tp := createTestLayer(t, false, Timers(TimerT1(acceptedT1)))
+
+	tx, err := txl.SendRequest(localRequest(t, sip.INVITE, ""))
+	if err != nil {
+		t.Fatalf("send INVITE failed: %s", err)
+	}
+	invite := tp.nextRequest(t, sip.INVITE)
+	res := answer(invite, sip.StatusOK, "alice")
+	start := time.Now()
+	tp.receive(t, res)
+	if up := nextUpResponse(t, txl); up.StatusCode() != sip.StatusOK {
+		t.Fatalf("passed up %s", up.Short())
+	}
+	tp.nextRequest(t, sip.ACK)
+
+	tp.receive(t, res)
+	tp.nextRequest(t, sip.ACK)
+	select {
+	case up := <-txl.Responses():
+		t.Fatalf("retransmitted %s passed up", up.Short())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	expectAcceptedDone(t, tx.Done(), start)
+}
+
+// 服务端事务发送 2xx 之后在 Accepted 状态吸收重发的 INVITE，ACK 传递给上层，定时器 L 触发后结束
+func TestServerAccepted(t *testing.T) {
+	txl, tp := createTestLayer(t, false, Timers(TimerT1(acceptedT1)))
+
+	invite := remoteRequest(t, sip.INVITE, "accepted", 1, "")
+	tp.receive(t, invite)
+	req := nextUpRequest(t, txl)
+	tx := req.Transaction().(sip.ServerTransaction)
+	start := time.Now()
+	if err := tx.SendResponse(answer(req, sip.StatusOK, "bob")); err != nil {
+		t.Fatalf("send 200 failed: %s", err)
+	}
+	res := tp.nextResponse(t, sip.StatusOK)
+
+	ack := remoteRequest(t, sip.ACK, "accepted-ack", 1, headerTag(res.To()))
+	tp.receive(t, ack)
+	if up := nextUpRequest(t, txl); !up.IsAck() {
+		t.Fatalf("passed up %s, want ACK", up.Short())
+	}
+	// 收到 ACK 之前重发的 2xx
+	for len(tp.sent) > 0 {
+		tp.nextResponse(t, sip.StatusOK)
+	}
+
+	tp.receive(t, invite)
+	select {
+	case up := <-txl.Requests():
+		t.Fatalf("retransmitted %s passed up", up.Short())
+	case <-time.After(100 * time.Millisecond):
+	}
+	tp.expectNone(t, 0)
+
+	expectAcceptedDone(t, tx.Done(), start)
+}
@@ -6,7 +6,7 @@ import (
 	"github.com/discoviking/fsm"
 )
 
-// 基础常量、方法等定义
+// 基础常量、方法等定义，定时器为默认值，事务层通过 Timers 配置
 const (
 	T1      = 500 * time.Millisecond
 	T2      = 4 * time.Second
@@ -24,11 +24,19 @@ const (
 	Time1xx = 200 * time.Millisecond
 )
 
+// 对话属性：最后收到的可靠临时响应的 RSeq，后接 INVITE 的 CSeq
+const rseqAttribute = "gsip.rseq."
+
+// 对话属性：会话定时器
+const sessionTimerAttribute = "gsip.session-timer"
+
 // 客户端事务状态机状态 FSM States
 const (
 	clientStateCalling = iota
 	clientStateProceeding
 	clientStateCompleted
+	// 收到 2xx 之后吸收重发以及分叉的 2xx RFC 6026
+	clientStateAccepted
 	clientStateTerminated
 )
 
@@ -41,6 +49,9 @@ const (
 	clientInputTimerA
 	clientInputTimerB
 	clientInputTimerD
+	// CANCEL 之后 64*T1 内没有收到最终响应
+	clientInputTimerCancel
+	clientInputTimerM
 	clientInputTransportErr
 	clientInputDelete
 )
@@ -51,6 +62,8 @@ const (
 	serverStateProceeding
 	serverStateCompleted
 	serverStateConfirmed
+	// 发送 2xx 之后吸收重发的 INVITE RFC 6026
+	serverStateAccepted
 	serverStateTerminated
 )
 
@@ -67,6 +80,7 @@ const (
 	serverInputTimerH
 	serverInputTimerI
 	serverInputTimerJ
+	serverInputTimerL
 	serverInputTransportErr
 	serverInputDelete
 )
@@ -0,0 +1,142 @@
+package transaction
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 发送 INVITE，返回客户端事务以及发送的 INVITE
+func sendInvite(t *testing.T, txl *layer, tp *testTransport) (sip.ClientTransaction, sip.Request) {
+	t.Helper()
+	tx, err := txl.SendRequest(localRequest(t, sip.INVITE, ""))
+	if err != nil {
+		t.Fatalf("send INVITE failed: %s", err)
+	}
+
+	return tx, tp.nextRequest(t, sip.INVITE)
+}
+
+// CANCEL 与 INVITE 的 branch、Request-URI、Call-ID、From、To 以及 CSeq 序号相同 RFC 3261 - 9.1
+func checkCancel(t *testing.T, cancel sip.Request, invite sip.Request) {
+	t.Helper()
+	cancelVia, _ := cancel.ViaHop()
+	inviteVia, _ := invite.ViaHop()
+	if viaBranch(cancelVia) != viaBranch(inviteVia) {
+		t.Errorf("branch = %s, want %s", viaBranch(cancelVia), viaBranch(inviteVia))
+	}
+	if cancel.Recipient().String() != invite.Recipient().String() {
+		t.Errorf("Request-URI = %s, want %s", cancel.Recipient(), invite.Recipient())
+	}
+	if cancel.CallID().String() != invite.CallID().String() ||
+		cancel.From().String() != invite.From().String() ||
+		cancel.To().String() != invite.To().String() {
+		t.Errorf("dialog headers of %s differ from %s", cancel, invite)
+	}
+	if cseq := cancel.CSeq(); cseq.SeqNo != invite.CSeq().SeqNo || cseq.MethodName != sip.CANCEL {
+		t.Errorf("CSeq = %s, want %d CANCEL", cseq, invite.CSeq().SeqNo)
+	}
+}
+
+func viaBranch(hop *sip.ViaHop) string {
+	if hop == nil || hop.Params == nil {
+		return ""
+	}
+	branch, _ := hop.Params.Get("branch")
+	if branch == nil {
+		return ""
+	}
+
+	return branch.String()
+}
+
+// 收到临时响应之前取消时推迟发送 CANCEL，收到临时响应之后发送；INVITE 以 487 结束并发送 ACK
+func TestCancelBeforeProvisional(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+	tx, invite := sendInvite(t, txl, tp)
+
+	if err := tx.Cancel(); err != nil {
+		t.Fatalf("cancel failed: %s", err)
+	}
+	tp.expectNone(t, 100*time.Millisecond)
+
+	tp.receive(t, answer(invite, sip.StatusTrying, ""))
+	nextUpResponse(t, txl)
+	cancel := tp.nextRequest(t, sip.CANCEL)
+	checkCancel(t, cancel, invite)
+	tp.receive(t, answer(cancel, sip.StatusOK, ""))
+
+	tp.receive(t, answer(invite, sip.StatusRequestTerminated, "alice"))
+	if res := nextUpResponse(t, txl); res.StatusCode() != sip.StatusRequestTerminated {
+		t.Errorf("passed up %s, want 487", res.Short())
+	}
+	if ack := tp.nextRequest(t, sip.ACK); ack.CSeq().SeqNo != invite.CSeq().SeqNo {
+		t.Errorf("ACK CSeq = %d, want %d", ack.CSeq().SeqNo, invite.CSeq().SeqNo)
+	}
+}
+
+// 收到临时响应之后立即发送 CANCEL
+func TestCancelProceeding(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+	tx, invite := sendInvite(t, txl, tp)
+
+	tp.receive(t, answer(invite, sip.StatusRinging, "alice"))
+	nextUpResponse(t, txl)
+	if err := tx.Cancel(); err != nil {
+		t.Fatalf("cancel failed: %s", err)
+	}
+	checkCancel(t, tp.nextRequest(t, sip.CANCEL), invite)
+	// 重复取消不再发送 CANCEL
+	if err := tx.Cancel(); err != nil {
+		t.Errorf("cancel again failed: %s", err)
+	}
+	tp.expectNone(t, 100*time.Millisecond)
+}
+
+// CANCEL 之后 64*T1 内没有收到最终响应时事务超时
+func TestCancelTimeout(t *testing.T) {
+	txl, tp := createTestLayer(t, true, Timers(TimerT1(10*time.Millisecond)))
+	tx, invite := sendInvite(t, txl, tp)
+
+	tp.receive(t, answer(invite, sip.StatusRinging, "alice"))
+	nextUpResponse(t, txl)
+	if err := tx.Cancel(); err != nil {
+		t.Fatalf("cancel failed: %s", err)
+	}
+	tp.nextRequest(t, sip.CANCEL)
+
+	select {
+	case err := <-tx.Errors():
+		var timeoutErr *TxTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Errorf("error = %v, want timeout", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("canceled transaction not timed out")
+	}
+}
+
+// 非 INVITE 以及已收到最终响应的事务不能取消
+func TestCancelRejected(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+
+	tx, err := txl.SendRequest(localRequest(t, sip.OPTIONS, ""))
+	if err != nil {
+		t.Fatalf("send OPTIONS failed: %s", err)
+	}
+	tp.nextRequest(t, sip.OPTIONS)
+	if err := tx.Cancel(); err == nil {
+		t.Error("canceled OPTIONS")
+	}
+
+	tx, invite := sendInvite(t, txl, tp)
+	tp.receive(t, answer(invite, sip.StatusBusyHere, "alice"))
+	nextUpResponse(t, txl)
+	tp.nextRequest(t, sip.ACK)
+	if err := tx.Cancel(); err == nil {
+		t.Error("canceled INVITE with final response")
+	}
+	tp.expectNone(t, 100*time.Millisecond)
+}
@@ -14,17 +14,36 @@ import (
 type ClientTx interface {
 	Tx
 	Responses() <-chan sip.Response
+	Cancel() error
 }
 
 type clientTx struct {
 	commonTx
+	// 上层发送的请求，故障转移之后 origin 为使用新 branch 的副本
+	request   sip.Request
 	responses chan sip.Response
 	timeATime time.Duration // Current duration of timer A.
 	timerA    *time.Timer
 	timerB    *time.Timer
 	timeDTime time.Duration // Current duration of timer D.
 	timerD    *time.Timer
-	reliable  bool
+	// Accepted 状态的持续时间 RFC 6026
+	timerM   *time.Timer
+	reliable bool
+	// 事务定时器
+	timers timerConfig
+	// 已请求取消，收到临时响应之后发送 CANCEL
+	canceled   bool
+	cancelSent bool
+	// CANCEL 之后等待最终响应
+	timerCancel *time.Timer
+	// RFC 3263 解析得到的目标以及当前使用的目标
+	targets   []transport.Target
+	targetIdx int
+	// 切换目标后事务 key 改变时通知事务层
+	rekey func(oldKey, newKey TxKey)
+	// 由事务层为 CANCEL 创建单独的非 INVITE 客户端事务，target 为原请求使用的目标
+	sendCancel func(cancel sip.Request, target transport.Target, ok bool) error
 
 	mu        sync.RWMutex
 	closeOnce sync.Once
@@ -41,23 +60,53 @@ func NewClientTx(origin sip.Request, tpl transport.Layer) (ClientTx, error) {
 	tx.tpl = tpl
 	// tx.session = sip.CreateSession()
 	tx.origin = origin
+	tx.request = origin
+	tx.timers = defaultTimerConfig()
 	// buffer chan - about ~10 retransmit responses
 	tx.responses = make(chan sip.Response, 64)
 	tx.errs = make(chan error, 64)
 	tx.done = make(chan bool)
 
-	if viaHop, ok := origin.ViaHop(); ok {
-		tx.reliable = tx.tpl.IsReliable(viaHop.Transport)
-	}
-
 	return tx, nil
 }
 
 func (tx *clientTx) Init() error {
 	tx.initFSM()
+
+	// 已指定目标(例如 CANCEL 使用原请求的目标)时不再解析
+	tx.mu.RLock()
+	resolved := len(tx.targets) > 0
+	tx.mu.RUnlock()
+	if resolved {
+		return tx.start()
+	}
+
+	targets, err := tx.tpl.Resolve(tx.Origin())
+	if err != nil {
+		tx.mu.Lock()
+		tx.lastErr = err
+		tx.mu.Unlock()
+
+		if err := tx.fsm.Spin(clientInputTransportErr); err != nil {
+			logger.Errorf("[clientTx] -> spin FSM to clientInputTransportErr failed: %s", err)
+		}
+
+		return err
+	}
+
+	tx.mu.Lock()
+	tx.targets = targets
+	tx.targetIdx = 0
+	tx.mu.Unlock()
+
+	return tx.start()
+}
+
+// 发送请求并启动定时器
+func (tx *clientTx) start() error {
 	logger.Infof("[clientTx] -> sending SIP request: %s", tx.Key())
 
-	if err := tx.tpl.Send(tx.Origin()); err != nil {
+	if err := tx.send(); err != nil {
 		tx.mu.Lock()
 		tx.lastErr = err
 		tx.mu.Unlock()
@@ -69,6 +118,12 @@ func (tx *clientTx) Init() error {
 		return err
 	}
 
+	if viaHop, ok := tx.Origin().ViaHop(); ok {
+		tx.mu.Lock()
+		tx.reliable = tx.tpl.IsReliable(viaHop.Transport)
+		tx.mu.Unlock()
+	}
+
 	if tx.reliable {
 		tx.mu.Lock()
 		tx.timeDTime = 0
@@ -80,16 +135,16 @@ func (tx *clientTx) Init() error {
 		// start timer A (Timer A controls request retransmissions).
 		// Timer A - retransmission
 		// 对于非可靠传输(比如 UDP)，客户端事务每隔 T1 重发请求，每次重发后间隔时间加倍
-		logger.Debugf("[clientTx] -> timerA on E set to %v", T1)
+		logger.Debugf("[clientTx] -> timerA on E set to %v", tx.timers.t1)
 
 		tx.mu.Lock()
 		// Timer D is set to 32 seconds for unreliable transports
-		if tx.Origin().IsInvite() {
-			tx.timeATime = TimeA
-			tx.timeDTime = TimeD
+		if tx.origin.IsInvite() {
+			tx.timeATime = tx.timers.timeA()
+			tx.timeDTime = tx.timers.timeD()
 		} else {
-			tx.timeATime = TimeE
-			tx.timeDTime = TimeK
+			tx.timeATime = tx.timers.timeE()
+			tx.timeDTime = tx.timers.timeK()
 		}
 
 		tx.timerA = time.AfterFunc(tx.timeATime, func() {
@@ -104,12 +159,22 @@ func (tx *clientTx) Init() error {
 	}
 
 	// Timer B on F - timeout
-	logger.Debugf("[clientTx] -> timerB On F set to %v", TimeB)
-
 	tx.mu.Lock()
-	tx.timerB = time.AfterFunc(TimeB, func() {
+	timeB := tx.timers.timeB()
+	if !tx.origin.IsInvite() {
+		timeB = tx.timers.timeF()
+	}
+
+	logger.Debugf("[clientTx] -> timerB On F set to %v", timeB)
+
+	tx.timerB = time.AfterFunc(timeB, func() {
 		logger.Debug("[clientTx] -> timerB On F fired")
 
+		// RFC 3263 - 4.3 超时后尝试下一个目标
+		if tx.failover() {
+			return
+		}
+
 		if err := tx.fsm.Spin(clientInputTimerB); err != nil {
 			logger.Errorf("[clientTx] -> spin FSM to clientInputTimerB On F failed: %s", err)
 		}
@@ -150,10 +215,92 @@ func (tx *clientTx) Receive(msg sip.Message) error {
 	return tx.fsm.Spin(input)
 }
 
+// 事务当前发送的请求，故障转移之后为使用新 branch 的副本
+func (tx *clientTx) Origin() sip.Request {
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
+
+	return tx.origin
+}
+
+// 上层发送的请求，不随故障转移改变
+func (tx *clientTx) Request() sip.Request {
+	return tx.request
+}
+
 func (tx *clientTx) Responses() <-chan sip.Response {
 	return tx.responses
 }
 
+// 取消 INVITE 请求 RFC 3261 - 9.1：已收到临时响应时立即发送 CANCEL，否则在收到第一个临时响应之后发送；
+// CANCEL 与 INVITE 的 branch、Request-URI、Call-ID、To、From 以及 CSeq 序号相同，使用单独的非 INVITE 事务，
+// INVITE 事务以 487 结束，CANCEL 之后 64*T1 内没有收到最终响应时超时
+func (tx *clientTx) Cancel() error {
+	if !tx.Origin().IsInvite() {
+		return fmt.Errorf("[clientTx] -> %s is not INVITE request, can not be canceled", tx.Origin().Short())
+	}
+
+	select {
+	case <-tx.done:
+		return fmt.Errorf("[clientTx] -> transaction %s is terminated", tx.Key())
+	default:
+	}
+
+	tx.mu.Lock()
+	if tx.lastResp != nil && !tx.lastResp.IsProvisional() {
+		tx.mu.Unlock()
+		return fmt.Errorf("[clientTx] -> transaction %s has received final response", tx.Key())
+	}
+	tx.canceled = true
+	proceeding := tx.lastResp != nil
+	tx.mu.Unlock()
+
+	if proceeding {
+		tx.cancel()
+	}
+
+	return nil
+}
+
+// 发送 CANCEL 并启动等待最终响应的定时器
+func (tx *clientTx) cancel() {
+	tx.mu.Lock()
+	if !tx.canceled || tx.cancelSent {
+		tx.mu.Unlock()
+		return
+	}
+	tx.cancelSent = true
+	sendCancel := tx.sendCancel
+
+	logger.Debugf("[clientTx] -> timerCancel set to %v", tx.timers.timeB())
+
+	tx.timerCancel = time.AfterFunc(tx.timers.timeB(), func() {
+		logger.Debug("[clientTx] -> timerCancel fired")
+
+		if err := tx.fsm.Spin(clientInputTimerCancel); err != nil {
+			logger.Errorf("[clientTx] -> spin FSM to clientInputTimerCancel failed: %s", err)
+		}
+	})
+	tx.mu.Unlock()
+
+	cancelRequest := sip.CreateCancel(tx.Origin())
+
+	// RFC 3261 - 9.1 CANCEL 必须发送到原请求发送的目标
+	target, ok := tx.target()
+	var err error
+	switch {
+	case sendCancel != nil:
+		err = sendCancel(cancelRequest, target, ok)
+	case ok:
+		err = tx.tpl.SendTo(cancelRequest, target)
+	default:
+		err = tx.tpl.Send(cancelRequest)
+	}
+	if err != nil {
+		logger.Warnf("[clientTx] -> send CANCEL for %s failed: %s", tx.Origin().Short(), err)
+	}
+}
+
 // 关闭、释放资源
 func (tx *clientTx) Close() {
 	select {
@@ -175,7 +322,13 @@ func (tx clientTx) ack() {
 	ackRequest := lastResp.CreateAck()
 
 	// Send the ACK.
-	err := tx.tpl.Send(ackRequest)
+	// RFC 3261 - 17.1.1.3 ACK 必须发送到原请求发送的目标
+	var err error
+	if target, ok := tx.target(); ok {
+		err = tx.tpl.SendTo(ackRequest, target)
+	} else {
+		err = tx.tpl.Send(ackRequest)
+	}
 	if err != nil {
 		logger.Warnf("[clientTx] -> send ACK request failed: %s", err)
 
@@ -207,10 +360,11 @@ func (tx *clientTx) initInviteFSM() {
 		Index: clientStateCalling,
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			clientInput1xx:          {clientStateProceeding, tx.actionPassUp},
-			clientInput2xx:          {clientStateTerminated, tx.actionPassUpDelete},
+			clientInput2xx:          {clientStateAccepted, tx.actionAccept},
 			clientInput300Plus:      {clientStateCompleted, tx.actionInviteFinal},
 			clientInputTimerA:       {clientStateCalling, tx.actionInviteResend},
 			clientInputTimerB:       {clientStateTerminated, tx.actionTimeout},
+			clientInputTimerCancel:  {clientStateCalling, fsm.NO_ACTION},
 			clientInputTransportErr: {clientStateTerminated, tx.actionTransErr},
 		},
 	}
@@ -220,10 +374,12 @@ func (tx *clientTx) initInviteFSM() {
 		Index: clientStateProceeding,
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			clientInput1xx:     {clientStateProceeding, tx.actionPassUp},
-			clientInput2xx:     {clientStateTerminated, tx.actionPassUpDelete},
+			clientInput2xx:     {clientStateAccepted, tx.actionAccept},
 			clientInput300Plus: {clientStateCompleted, tx.actionInviteFinal},
 			clientInputTimerA:  {clientStateProceeding, fsm.NO_ACTION},
 			clientInputTimerB:  {clientStateProceeding, fsm.NO_ACTION},
+			// RFC 3261 - 9.1 CANCEL 之后没有收到最终响应
+			clientInputTimerCancel: {clientStateTerminated, tx.actionTimeout},
 		},
 	}
 
@@ -238,6 +394,22 @@ func (tx *clientTx) initInviteFSM() {
 			clientInputTimerA:       {clientStateCompleted, fsm.NO_ACTION},
 			clientInputTimerB:       {clientStateCompleted, fsm.NO_ACTION},
 			clientInputTimerD:       {clientStateTerminated, tx.actionDelete},
+			clientInputTimerCancel:  {clientStateCompleted, fsm.NO_ACTION},
+		},
+	}
+
+	// Accepted RFC 6026：重发以及分叉的 2xx 传递给上层，由 TU 发送 ACK，定时器 M 触发后终止
+	clientStateDefAccepted := fsm.State{
+		Index: clientStateAccepted,
+		Outcomes: map[fsm.Input]fsm.Outcome{
+			clientInput1xx:         {clientStateAccepted, fsm.NO_ACTION},
+			clientInput2xx:         {clientStateAccepted, tx.actionPassUpAccepted},
+			clientInput300Plus:     {clientStateAccepted, fsm.NO_ACTION},
+			clientInputTimerA:      {clientStateAccepted, fsm.NO_ACTION},
+			clientInputTimerB:      {clientStateAccepted, fsm.NO_ACTION},
+			clientInputTimerD:      {clientStateAccepted, fsm.NO_ACTION},
+			clientInputTimerCancel: {clientStateAccepted, fsm.NO_ACTION},
+			clientInputTimerM:      {clientStateTerminated, tx.actionDelete},
 		},
 	}
 
@@ -251,7 +423,10 @@ func (tx *clientTx) initInviteFSM() {
 			clientInputTimerA:  {clientStateTerminated, fsm.NO_ACTION},
 			clientInputTimerB:  {clientStateTerminated, fsm.NO_ACTION},
 			clientInputTimerD:  {clientStateTerminated, fsm.NO_ACTION},
+			clientInputTimerM:  {clientStateTerminated, fsm.NO_ACTION},
 			clientInputDelete:  {clientStateTerminated, tx.actionDelete},
+			// CANCEL 定时器
+			clientInputTimerCancel: {clientStateTerminated, fsm.NO_ACTION},
 		},
 	}
 
@@ -259,6 +434,7 @@ func (tx *clientTx) initInviteFSM() {
 		clientStateDefCalling,
 		clientStateDefProceeding,
 		clientStateDefCompleted,
+		clientStateDefAccepted,
 		clientStateDefTerminated,
 	)
 
@@ -344,11 +520,93 @@ func (tx *clientTx) initNonInviteFSM() {
 	tx.fsm = fsm_
 }
 
+// 发送请求到当前目标，失败时依次尝试后续目标 RFC 3263 - 4.3
+func (tx *clientTx) send() error {
+	var err error
+	for {
+		target, ok := tx.target()
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("[clientTx] -> no target available")
+			}
+			return err
+		}
+
+		if err = tx.tpl.SendTo(tx.Origin(), target); err == nil {
+			return nil
+		}
+		logger.Warnf("[clientTx] -> send SIP request to %s failed: %s", target, err)
+
+		tx.mu.Lock()
+		tx.targetIdx++
+		tx.mu.Unlock()
+	}
+}
+
+// 返回当前使用的目标
+func (tx *clientTx) target() (transport.Target, bool) {
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
+
+	if tx.targetIdx >= len(tx.targets) {
+		return transport.Target{}, false
+	}
+
+	return tx.targets[tx.targetIdx], true
+}
+
+// 超时且未收到任何响应时，使用新的 branch 将请求发送到下一个目标
+func (tx *clientTx) failover() bool {
+	tx.mu.Lock()
+	if tx.lastResp != nil || tx.targetIdx+1 >= len(tx.targets) {
+		tx.mu.Unlock()
+		return false
+	}
+	tx.targetIdx++
+
+	if tx.timerA != nil {
+		tx.timerA.Stop()
+		tx.timerA = nil
+	}
+
+	// RFC 3263 - 4.3 新的请求必须使用新的 branch，即新的事务；修改副本，上层的请求保持不变
+	oldKey := tx.key
+	origin := sip.CopyRequest(tx.origin)
+	if dest := tx.origin.ExplicitDestination(); dest != "" {
+		origin.SetDestination(dest)
+	}
+	if viaHop, ok := origin.ViaHop(); ok {
+		viaHop.Params.Add("branch", sip.String{Str: sip.GenerateBranch()})
+	}
+	if key, err := MakeClientTxKey(origin); err == nil {
+		tx.origin = origin
+		tx.key = key
+	}
+	rekey := tx.rekey
+	newKey := tx.key
+	tx.mu.Unlock()
+
+	logger.Infof("[clientTx] -> transaction %s timed out, failover to next target", oldKey)
+
+	if rekey != nil && oldKey != newKey {
+		rekey(oldKey, newKey)
+	}
+
+	_ = tx.start()
+
+	return true
+}
+
 // 重发请求
 func (tx *clientTx) resend() {
 	logger.Debug("[clientTx] -> resend origin request")
 
-	err := tx.tpl.Send(tx.Origin())
+	var err error
+	if target, ok := tx.target(); ok {
+		err = tx.tpl.SendTo(tx.Origin(), target)
+	} else {
+		err = tx.tpl.Send(tx.Origin())
+	}
 
 	tx.mu.Lock()
 	tx.lastErr = err
@@ -436,6 +694,14 @@ func (tx *clientTx) delete() {
 		tx.timerD.Stop()
 		tx.timerD = nil
 	}
+	if tx.timerCancel != nil {
+		tx.timerCancel.Stop()
+		tx.timerCancel = nil
+	}
+	if tx.timerM != nil {
+		tx.timerM.Stop()
+		tx.timerM = nil
+	}
 	tx.mu.Unlock()
 
 	time.Sleep(time.Microsecond)
@@ -476,8 +742,8 @@ func (tx *clientTx) actionNonInviteResend() fsm.Input {
 
 	tx.timeATime *= 2
 	// For non-INVITE, cap timer A at T2 seconds.
-	if tx.timeATime > T2 {
-		tx.timeATime = T2
+	if tx.timeATime > tx.timers.t2 {
+		tx.timeATime = tx.timers.t2
 	}
 	tx.timerA.Reset(tx.timeATime)
 
@@ -492,6 +758,8 @@ func (tx *clientTx) actionPassUp() fsm.Input {
 	logger.Debug("[clientTx] -> actionPassUp")
 
 	tx.passUp()
+	// RFC 3261 - 9.1 收到临时响应之后才能发送 CANCEL
+	tx.cancel()
 
 	tx.mu.Lock()
 
@@ -611,17 +879,49 @@ func (tx *clientTx) actionTimeout() fsm.Input {
 	return clientInputDelete
 }
 
-func (tx *clientTx) actionPassUpDelete() fsm.Input {
-	logger.Debug("[clientTx] -> actionPassUpDelete")
+// 收到第一个 2xx，转到 Accepted 状态并启动定时器 M RFC 6026
+func (tx *clientTx) actionAccept() fsm.Input {
+	logger.Debug("[clientTx] -> actionAccept")
+
 	tx.passUp()
 
 	tx.mu.Lock()
+
 	if tx.timerA != nil {
+		tx.timerA.Stop()
 		tx.timerA = nil
 	}
+	if tx.timerB != nil {
+		tx.timerB.Stop()
+		tx.timerB = nil
+	}
+	if tx.timerCancel != nil {
+		tx.timerCancel.Stop()
+		tx.timerCancel = nil
+	}
+
+	logger.Debugf("[clientTx] -> timerM set to %v", tx.timers.timeM())
+
+	tx.timerM = time.AfterFunc(tx.timers.timeM(), func() {
+		logger.Debug("[clientTx] -> timerM fired")
+
+		if err := tx.fsm.Spin(clientInputTimerM); err != nil {
+			logger.Errorf("[clientTx] -> spin FSM to clientInputTimerM failed: %s", err)
+		}
+	})
+
 	tx.mu.Unlock()
 
-	return clientInputDelete
+	return fsm.NO_INPUT
+}
+
+// Accepted 状态收到的 2xx 传递给上层，由 UAC 核心区分重发以及分叉
+func (tx *clientTx) actionPassUpAccepted() fsm.Input {
+	logger.Debug("[clientTx] -> actionPassUpAccepted")
+
+	tx.passUp()
+
+	return fsm.NO_INPUT
 }
 
 func (tx *clientTx) actionDelete() fsm.Input {
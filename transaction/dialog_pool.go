@@ -0,0 +1,68 @@
+package transaction
+
+import (
+	"sync"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// Thread-safe dialog pool.
+// 线程安全对话池
+type dialogPool struct {
+	dialogList map[string]sip.Dialog
+
+	mu sync.RWMutex
+}
+
+func createDialogPool() *dialogPool {
+	return &dialogPool{
+		dialogList: make(map[string]sip.Dialog),
+	}
+}
+
+// 监听对话终止
+func (store *dialogPool) listenDialog(dialog sip.Dialog) {
+	<-dialog.Done()
+
+	store.mu.Lock()
+	// 可能已被同 ID 的新对话替换
+	if store.dialogList[dialog.ID()] == dialog {
+		delete(store.dialogList, dialog.ID())
+	}
+	store.mu.Unlock()
+
+	logger.Debugf("[dialogPool] -> dialog[%s] deleted", dialog.ID())
+}
+
+func (store *dialogPool) put(dialog sip.Dialog) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.dialogList[dialog.ID()] = dialog
+	logger.Debugf("[dialogPool] -> dialog[%s] added", dialog.ID())
+	// 监听
+	go store.listenDialog(dialog)
+}
+
+func (store *dialogPool) get(id string) sip.Dialog {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.dialogList[id]
+}
+
+func (store *dialogPool) drop(id string) {
+	if dialog := store.get(id); dialog != nil {
+		dialog.Close()
+	}
+}
+
+func (store *dialogPool) all() []sip.Dialog {
+	all := make([]sip.Dialog, 0)
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	for _, dialog := range store.dialogList {
+		all = append(all, dialog)
+	}
+
+	return all
+}
@@ -0,0 +1,63 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+	"github.com/zenghr0820/gsip/transport"
+)
+
+// 解析得到两个目标的测试传输层
+type failoverTransport struct {
+	*testTransport
+}
+
+func (tp *failoverTransport) Resolve(req sip.Request) ([]transport.Target, error) {
+	return []transport.Target{
+		{Network: "udp", Addr: &sip.Addr{Host: "192.0.2.2"}},
+		{Network: "udp", Addr: &sip.Addr{Host: "192.0.2.3"}},
+	}, nil
+}
+
+// 超时且没有收到响应时使用新的 branch 发送到下一个目标，上层的请求保持不变 RFC 3263 - 4.3
+func TestFailover(t *testing.T) {
+	tp := &failoverTransport{newTestTransport(true)}
+	txl := CreateLayer(tp, Timers(TimerT1(10*time.Millisecond))).(*layer)
+	t.Cleanup(txl.Close)
+
+	req := localRequest(t, sip.OPTIONS, "")
+	via, _ := req.ViaHop()
+	branch := viaBranch(via)
+	tx, err := txl.SendRequest(req)
+	if err != nil {
+		t.Fatalf("send OPTIONS failed: %s", err)
+	}
+	first := tp.nextRequest(t, sip.OPTIONS)
+	firstVia, _ := first.ViaHop()
+	if viaBranch(firstVia) != branch {
+		t.Fatalf("branch = %s, want %s", viaBranch(firstVia), branch)
+	}
+
+	// 定时器 F 为 64*T1
+	second := tp.nextRequest(t, sip.OPTIONS)
+	secondVia, _ := second.ViaHop()
+	if viaBranch(secondVia) == branch {
+		t.Fatal("failover request uses the branch of the timed out transaction")
+	}
+	if via, _ := req.ViaHop(); viaBranch(via) != branch {
+		t.Errorf("branch of the request = %s, want unchanged %s", viaBranch(via), branch)
+	}
+	originVia, _ := tx.Origin().ViaHop()
+	if viaBranch(originVia) != viaBranch(secondVia) {
+		t.Errorf("branch of origin = %s, want %s", viaBranch(originVia), viaBranch(secondVia))
+	}
+	if request := tx.(*clientTx).Request(); request != req {
+		t.Error("transaction request is not the request sent by the TU")
+	}
+
+	tp.receive(t, answer(second, sip.StatusOK, "alice"))
+	if res := nextUpResponse(t, txl); res.StatusCode() != sip.StatusOK || res.Transaction() != tx {
+		t.Errorf("passed up %s of transaction %v, want 200 of %v", res.Short(), res.Transaction(), tx)
+	}
+}
@@ -0,0 +1,132 @@
+package transaction
+
+import (
+	"strings"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 事务层的配置选项
+type Options struct {
+	// 支持的扩展(option tag)，请求的 Require 包含其他扩展时返回 420
+	supported []string
+	// INVITE 的临时响应总是可靠发送，不支持 100rel 的 INVITE 返回 421
+	require100rel bool
+	// 发送的 INVITE 请求的会话间隔(秒)，为 0 时不主动请求会话定时器
+	sessionExpires uint32
+	// 接受的最小会话间隔(秒)，更小的 Session-Expires 返回 422
+	minSE uint32
+	// 自动为 INVITE 的 2xx 发送 ACK
+	autoAck bool
+	// 分叉的 2xx 传递给上层，否则发送 ACK 以及 BYE 终止分叉的对话
+	acceptForks bool
+	// 事务定时器
+	timers timerConfig
+	// 作为有状态代理使用，不执行 UA 核心的行为
+	proxy bool
+	// 无状态转发，不为收到的请求创建服务端事务
+	stateless bool
+}
+
+type Option func(o *Options)
+
+func newOptions(opts ...Option) Options {
+	opt := Options{
+		supported: []string{sip.OptionTag100rel, sip.OptionTagTimer, sip.OptionTagReplaces},
+		minSE:     sip.MinSessionExpires,
+		autoAck:   true,
+		timers:    defaultTimerConfig(),
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
+// 配置上层支持的扩展，100rel、timer 以及 replaces 默认支持
+func Supported(options ...string) Option {
+	return func(o *Options) {
+		for _, option := range options {
+			option = strings.TrimSpace(option)
+			if option != "" && !containsOption(o.supported, option) {
+				o.supported = append(o.supported, option)
+			}
+		}
+	}
+}
+
+// 要求可靠的临时响应 RFC 3262 - 3：
+// INVITE 的临时响应(100 除外)总是可靠发送，Supported 不包含 100rel 的 INVITE 返回 421
+func Require100rel() Option {
+	return func(o *Options) {
+		o.require100rel = true
+	}
+}
+
+// 会话定时器 RFC 4028：
+// expires 为发送的 INVITE 请求的会话间隔，minSE 为接受的最小会话间隔，均不小于 90 秒
+func SessionTimer(expires uint32, minSE uint32) Option {
+	return func(o *Options) {
+		if minSE < sip.MinSessionExpires {
+			minSE = sip.MinSessionExpires
+		}
+		if expires < minSE {
+			expires = minSE
+		}
+		o.sessionExpires = expires
+		o.minSE = minSE
+	}
+}
+
+// INVITE 的 2xx 的 ACK 由上层发送，例如 2xx 携带 offer 时 ACK 需要携带 answer；
+// 上层发送的 ACK 在 2xx 重发时自动重发 RFC 3261 - 13.2.2.4
+func ManualAck() Option {
+	return func(o *Options) {
+		o.autoAck = false
+	}
+}
+
+// 接受分叉的 2xx：INVITE 的后续不同 To tag 的 2xx 创建新的对话并传递给上层，由上层决定是否发送 BYE；
+// 默认发送 ACK 以及 BYE 终止分叉的对话
+func AcceptForks() Option {
+	return func(o *Options) {
+		o.acceptForks = true
+	}
+}
+
+// 事务层作为有状态代理使用 RFC 3261 - 16：只提供事务，不执行 UA 核心的行为，
+// 即不创建对话，不自动发送 ACK、PRACK 以及重发 2xx，不处理会话定时器，不校验 Require 以及 Replaces，
+// 这些由 UAC 与 UAS 端到端处理；客户端事务的所有响应(包括重发以及分叉的 2xx)以及 2xx 的 ACK 都传递给上层
+func Proxy() Option {
+	return func(o *Options) {
+		o.proxy = true
+	}
+}
+
+// 无状态转发 RFC 3261 - 16.11：收到的请求不创建服务端事务，包括 ACK 以及 CANCEL 在内直接传递给上层，
+// 没有匹配客户端事务的响应同样传递给上层；本端发送的请求仍然使用客户端事务
+func Stateless() Option {
+	return func(o *Options) {
+		o.stateless = true
+	}
+}
+
+// 配置事务定时器，例如卫星链路使用更大的 T1：
+// transaction.Timers(transaction.TimerT1(2*time.Second), transaction.TimerT2(16*time.Second))
+func Timers(opts ...TimerOption) Option {
+	return func(o *Options) {
+		o.timers = newTimerConfig(o.timers, opts...)
+	}
+}
+
+func containsOption(options []string, option string) bool {
+	for _, o := range options {
+		if strings.EqualFold(o, option) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,186 @@
+package transaction
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+var require100rel = &sip.RequireHeader{Options: []string{sip.OptionTag100rel}}
+
+func responseRSeq(t *testing.T, res sip.Response) uint32 {
+	t.Helper()
+	rseq, ok := sip.ResponseRSeq(res)
+	if !ok || !sip.RequiresOption(res, sip.OptionTag100rel) {
+		t.Fatalf("%s is not reliable", res.Short())
+	}
+
+	return rseq
+}
+
+// 接收对方的 INVITE，返回传递给上层的请求以及服务端事务
+func receiveInvite(t *testing.T, txl *layer, tp *testTransport, branch string, toTag string, headers ...string) (sip.Request, sip.ServerTransaction) {
+	t.Helper()
+	tp.receive(t, remoteRequest(t, sip.INVITE, branch, 1, toTag, headers...))
+	req := nextUpRequest(t, txl)
+
+	return req, req.Transaction().(sip.ServerTransaction)
+}
+
+// 可靠临时响应携带 RSeq 并重发，PRACK 确认之前不能发送新的可靠临时响应，RAck 不匹配的 PRACK 返回 481
+func TestReliableProvisional(t *testing.T) {
+	txl, tp := createTestLayer(t, false, Timers(TimerT1(20*time.Millisecond)))
+	req, tx := receiveInvite(t, txl, tp, "reliable", "", "Supported: 100rel")
+
+	if err := tx.SendResponse(answer(req, sip.StatusRinging, "bob", require100rel)); err != nil {
+		t.Fatalf("send 180 failed: %s", err)
+	}
+	ringing := tp.nextResponse(t, sip.StatusRinging)
+	rseq := responseRSeq(t, ringing)
+	if err := tx.SendResponse(answer(req, sip.StatusSessionProgress, "bob", require100rel)); err == nil {
+		t.Error("sent reliable 183 before PRACK of 180")
+	}
+	if retransmitted := tp.nextResponse(t, sip.StatusRinging); responseRSeq(t, retransmitted) != rseq {
+		t.Errorf("retransmitted RSeq = %d, want %d", responseRSeq(t, retransmitted), rseq)
+	}
+
+	toTag := headerTag(ringing.To())
+	tp.receive(t, remoteRequest(t, sip.PRACK, "prack-wrong", 2, toTag, fmt.Sprintf("RAck: %d 1 INVITE", rseq+1)))
+	for {
+		if res, ok := tp.next(t).(sip.Response); ok && res.StatusCode() == sip.StatusCallTransactionDoesNotExist {
+			break
+		}
+	}
+	tp.receive(t, remoteRequest(t, sip.PRACK, "prack", 3, toTag, fmt.Sprintf("RAck: %d 1 INVITE", rseq)))
+	if prack := nextUpRequest(t, txl); prack.Method() != sip.PRACK {
+		t.Fatalf("passed up %s, want PRACK", prack.Short())
+	}
+	// PRACK 之前重发的 180
+	time.Sleep(20 * time.Millisecond)
+	for len(tp.sent) > 0 {
+		tp.nextResponse(t, sip.StatusRinging)
+	}
+	tp.expectNone(t, 200*time.Millisecond)
+
+	if err := tx.SendResponse(answer(req, sip.StatusSessionProgress, "bob", require100rel)); err != nil {
+		t.Fatalf("send 183 failed: %s", err)
+	}
+	if progress := tp.nextResponse(t, sip.StatusSessionProgress); responseRSeq(t, progress) != rseq+1 {
+		t.Errorf("RSeq of 183 = %d, want %d", responseRSeq(t, progress), rseq+1)
+	}
+}
+
+// 64*T1 内没有收到 PRACK 时以 500 拒绝 INVITE
+func TestReliableProvisionalTimeout(t *testing.T) {
+	const t1 = 10 * time.Millisecond
+	txl, tp := createTestLayer(t, false, Timers(TimerT1(t1)))
+	req, tx := receiveInvite(t, txl, tp, "reliable-timeout", "", "Supported: 100rel")
+
+	if err := tx.SendResponse(answer(req, sip.StatusRinging, "bob", require100rel)); err != nil {
+		t.Fatalf("send 180 failed: %s", err)
+	}
+	start := time.Now()
+	retransmissions := -1
+	for {
+		res, ok := tp.next(t).(sip.Response)
+		if !ok {
+			t.Fatal("request sent, want response")
+		}
+		if res.StatusCode() == sip.StatusRinging {
+			retransmissions++
+			continue
+		}
+		if res.StatusCode() != sip.StatusServerInternalError {
+			t.Fatalf("sent %s, want 500", res.Short())
+		}
+		break
+	}
+	if elapsed := time.Since(start); elapsed < 64*t1 || elapsed > 96*t1 {
+		t.Errorf("rejected after %s, want 64*T1", elapsed)
+	}
+	// 间隔从 T1 开始加倍：T1、2T1、...、32T1
+	if retransmissions < 5 || retransmissions > 7 {
+		t.Errorf("%d retransmissions, want 6", retransmissions)
+	}
+}
+
+// 配置 Require100rel 时不支持 100rel 的 INVITE 返回 421；
+// 对话内不支持 100rel 的 re-INVITE 的临时响应作为普通临时响应发送
+func TestRequire100rel(t *testing.T) {
+	txl, tp := createTestLayer(t, true, Require100rel())
+
+	tp.receive(t, remoteRequest(t, sip.INVITE, "no-100rel", 1, ""))
+	if res := tp.nextResponse(t, sip.StatusExtensionRequired); !sip.RequiresOption(res, sip.OptionTag100rel) {
+		t.Errorf("421 without Require: 100rel")
+	}
+
+	req, tx := receiveInvite(t, txl, tp, "100rel", "", "Supported: 100rel")
+	if err := tx.SendResponse(answer(req, sip.StatusRinging, "bob")); err != nil {
+		t.Fatalf("send 180 failed: %s", err)
+	}
+	ringing := tp.nextResponse(t, sip.StatusRinging)
+	rseq := responseRSeq(t, ringing)
+	toTag := headerTag(ringing.To())
+	tp.receive(t, remoteRequest(t, sip.PRACK, "100rel-prack", 2, toTag, fmt.Sprintf("RAck: %d 1 INVITE", rseq)))
+	nextUpRequest(t, txl)
+	if err := tx.SendResponse(answer(req, sip.StatusOK, "bob")); err != nil {
+		t.Fatalf("send 200 failed: %s", err)
+	}
+	tp.nextResponse(t, sip.StatusOK)
+	tp.receive(t, remoteRequest(t, sip.ACK, "100rel-ack", 1, toTag))
+	nextUpRequest(t, txl)
+
+	tp.receive(t, remoteRequest(t, sip.INVITE, "reinvite", 3, toTag))
+	reinvite := nextUpRequest(t, txl)
+	if err := reinvite.Transaction().(sip.ServerTransaction).SendResponse(answer(reinvite, sip.StatusRinging, "")); err != nil {
+		t.Fatalf("send 180 for re-INVITE failed: %s", err)
+	}
+	if ringing := tp.nextResponse(t, sip.StatusRinging); sip.IsReliableProvisional(ringing) {
+		t.Errorf("180 of re-INVITE without 100rel is reliable")
+	}
+}
+
+// UAC 为新的可靠临时响应自动发送 PRACK，重发的可靠临时响应丢弃
+func TestAutoPrack(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+	contact, err := sip.ParseUri("sip:alice@192.0.2.2")
+	if err != nil {
+		t.Fatalf("parse contact failed: %s", err)
+	}
+
+	if _, err := txl.SendRequest(localRequest(t, sip.INVITE, "", "Supported: 100rel")); err != nil {
+		t.Fatalf("send INVITE failed: %s", err)
+	}
+	invite := tp.nextRequest(t, sip.INVITE)
+	reliable := func(status sip.StatusCode, rseq sip.RSeq) sip.Response {
+		return answer(invite, status, "alice", require100rel, &rseq,
+			&sip.ContactHeader{Address: contact, Params: sip.NewParams()})
+	}
+
+	ringing := reliable(sip.StatusRinging, 7)
+	tp.receive(t, ringing)
+	if up := nextUpResponse(t, txl); up.StatusCode() != sip.StatusRinging {
+		t.Fatalf("passed up %s", up.Short())
+	}
+	prack := tp.nextRequest(t, sip.PRACK)
+	if rack := sip.RequestRAck(prack); rack == nil || rack.RSeq != 7 || rack.CSeq != invite.CSeq().SeqNo || rack.Method != sip.INVITE {
+		t.Errorf("RAck = %v, want 7 %d INVITE", rack, invite.CSeq().SeqNo)
+	}
+	tp.receive(t, answer(prack, sip.StatusOK, ""))
+
+	tp.receive(t, ringing)
+	select {
+	case up := <-txl.Responses():
+		t.Errorf("retransmitted %s passed up", up.Short())
+	case <-time.After(100 * time.Millisecond):
+	}
+	tp.expectNone(t, 0)
+
+	tp.receive(t, reliable(sip.StatusSessionProgress, 8))
+	nextUpResponse(t, txl)
+	if rack := sip.RequestRAck(tp.nextRequest(t, sip.PRACK)); rack == nil || rack.RSeq != 8 {
+		t.Errorf("RAck = %v, want RSeq 8", rack)
+	}
+}
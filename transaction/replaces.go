@@ -0,0 +1,85 @@
+package transaction
+
+import (
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 被替换的对话已经处理，避免重发的 2xx 重复终止对话
+const replacedAttribute = "transaction.replaced"
+
+// 返回 INVITE 的 Replaces 头部匹配的对话 RFC 3891 - 3：to-tag 为本地 tag，from-tag 为远端 tag
+func (txl *layer) Replaces(req sip.Request) sip.Dialog {
+	replaces := sip.Replaces(req)
+	if replaces == nil {
+		return nil
+	}
+
+	return txl.dialogs.get(sip.DialogID(replaces.CallID, replaces.ToTag, replaces.FromTag))
+}
+
+// 校验 INVITE 的 Replaces 头部 RFC 3891 - 3：
+// 多个 Replaces 返回 400；没有匹配的对话，或者匹配到对方发起的早期对话返回 481；
+// early-only 匹配到已确认的对话返回 486
+func (txl *layer) checkReplaces(req sip.Request) bool {
+	if !req.IsInvite() || hasToTag(req) {
+		return true
+	}
+	headers := req.GetHeaders("Replaces")
+	if len(headers) == 0 {
+		return true
+	}
+
+	var res sip.Response
+	dialog := txl.Replaces(req)
+	switch {
+	case len(headers) > 1:
+		res = req.CreateResponseReason(sip.StatusBadRequest, "Multiple Replaces Headers")
+	case dialog == nil || (dialog.State() == sip.DialogStateEarly && dialog.Role() == sip.DialogRoleUAS):
+		res = req.CreateResponseReason(sip.StatusCallTransactionDoesNotExist, "Call/Transaction Does Not Exist")
+	case sip.Replaces(req).EarlyOnly && dialog.State() == sip.DialogStateConfirmed:
+		res = req.CreateResponseReason(sip.StatusBusyHere, "Busy Here")
+	default:
+		return true
+	}
+
+	logger.Warnf("[txl_layer] -> %s does not match a replaceable dialog: %d", req.Short(), res.StatusCode())
+	_ = txl.tpl.Send(res)
+
+	return false
+}
+
+// 带 Replaces 的 INVITE 发送 2xx 之后终止被替换的对话 RFC 3891 - 3：
+// 已确认的对话发送 BYE，本端发起的早期对话 CANCEL 对应的 INVITE
+func (txl *layer) replaceDialog(req sip.Request, res sip.Response) {
+	if !req.IsInvite() || !res.IsSuccess() || hasToTag(req) {
+		return
+	}
+	dialog := txl.Replaces(req)
+	if dialog == nil || dialog.GetAttribute(replacedAttribute) != nil {
+		return
+	}
+	dialog.SetAttribute(replacedAttribute, true)
+
+	logger.Debugf("[txl_layer] -> dialog %s replaced by %s", dialog.ID(), req.Short())
+	if dialog.State() == sip.DialogStateConfirmed {
+		if _, err := txl.startClientTx(dialog.CreateRequest(sip.BYE), false); err != nil {
+			logger.Errorf("[txl_layer] -> send BYE for replaced dialog %s failed: %s", dialog.ID(), err)
+		}
+		return
+	}
+
+	for _, tx := range txl.transactions.all() {
+		ctx, ok := tx.(ClientTx)
+		if !ok || !ctx.Origin().IsInvite() {
+			continue
+		}
+		callID, fromTag := callLeg(ctx.Origin())
+		if callID != string(dialog.CallID()) || fromTag != dialog.LocalTag() {
+			continue
+		}
+		if err := ctx.Cancel(); err != nil {
+			logger.Errorf("[txl_layer] -> cancel replaced dialog %s failed: %s", dialog.ID(), err)
+		}
+	}
+}
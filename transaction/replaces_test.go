@@ -0,0 +1,137 @@
+package transaction
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 对方 alice 呼叫本端 bob 建立的已确认对话，Call-ID 为 remote-call，本地 tag 为 bob
+func confirmRemoteDialog(t *testing.T, txl *layer, tp *testTransport) sip.Dialog {
+	t.Helper()
+	tp.receive(t, remoteRequest(t, sip.INVITE, "replaced", 1, ""))
+	req := nextUpRequest(t, txl)
+	if err := req.Transaction().(sip.ServerTransaction).SendResponse(answer(req, sip.StatusOK, "bob")); err != nil {
+		t.Fatalf("send 200 failed: %s", err)
+	}
+	res := tp.nextResponse(t, sip.StatusOK)
+	tp.receive(t, remoteRequest(t, sip.ACK, "replaced-ack", 1, "bob"))
+	nextUpRequest(t, txl)
+
+	dialog := txl.DialogOf(res)
+	if dialog == nil || dialog.State() != sip.DialogStateConfirmed {
+		t.Fatalf("dialog of %s not confirmed", res.Short())
+	}
+
+	return dialog
+}
+
+// 对方 carol 发送的替换对话的 INVITE
+func replacesInvite(t *testing.T, branch string, replaces ...string) sip.Request {
+	t.Helper()
+	headers := make([]string, 0, len(replaces))
+	for _, value := range replaces {
+		headers = append(headers, "Replaces: "+value)
+	}
+	req := remoteRequest(t, sip.INVITE, branch, 1, "", headers...)
+	callID := sip.CallID("replaces-" + branch)
+	req.ReplaceHeader(&callID)
+	req.From().Params.Add("tag", sip.String{Str: "carol"})
+
+	return req
+}
+
+// 接受替换的 2xx 以及终止被替换对话的 method 请求，不区分发送顺序
+func nextReplacing(t *testing.T, tp *testTransport, method sip.RequestMethod) sip.Request {
+	t.Helper()
+	var req sip.Request
+	for i := 0; i < 2; i++ {
+		switch msg := tp.next(t).(type) {
+		case sip.Request:
+			if msg.Method() != method {
+				t.Fatalf("sent %s, want %s", msg.Short(), method)
+			}
+			req = msg
+		case sip.Response:
+			if msg.StatusCode() != sip.StatusOK {
+				t.Fatalf("sent %s, want 200", msg.Short())
+			}
+		}
+	}
+	if req == nil {
+		t.Fatalf("%s not sent", method)
+	}
+
+	return req
+}
+
+// Replaces 匹配本地 tag 为 to-tag、远端 tag 为 from-tag 的对话，不匹配时返回错误响应，不传递给上层 RFC 3891 - 3
+func TestCheckReplaces(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+	dialog := confirmRemoteDialog(t, txl, tp)
+
+	tests := []struct {
+		name     string
+		replaces []string
+		status   sip.StatusCode
+	}{
+		{name: "matched", replaces: []string{"remote-call;to-tag=bob;from-tag=alice"}},
+		{name: "unknown dialog", replaces: []string{"remote-call;to-tag=alice;from-tag=bob"}, status: sip.StatusCallTransactionDoesNotExist},
+		{name: "early only", replaces: []string{"remote-call;to-tag=bob;from-tag=alice;early-only"}, status: sip.StatusBusyHere},
+		{
+			name:     "multiple replaces",
+			replaces: []string{"remote-call;to-tag=bob;from-tag=alice", "other-call;to-tag=bob;from-tag=alice"},
+			status:   sip.StatusBadRequest,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := replacesInvite(t, fmt.Sprintf("check%d", i), test.replaces...)
+			tp.receive(t, req)
+			if test.status != 0 {
+				tp.nextResponse(t, test.status)
+				return
+			}
+			up := nextUpRequest(t, txl)
+			if replaced := txl.Replaces(up); replaced == nil || replaced.ID() != dialog.ID() {
+				t.Errorf("replaced dialog = %v, want %s", replaced, dialog.ID())
+			}
+		})
+	}
+}
+
+// 接受替换已确认对话的 INVITE 之后发送 BYE 终止被替换的对话
+func TestReplaceConfirmedDialog(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+	dialog := confirmRemoteDialog(t, txl, tp)
+
+	tp.receive(t, replacesInvite(t, "confirmed", "remote-call;to-tag=bob;from-tag=alice"))
+	req := nextUpRequest(t, txl)
+	if err := req.Transaction().(sip.ServerTransaction).SendResponse(answer(req, sip.StatusOK, "bob2")); err != nil {
+		t.Fatalf("send 200 failed: %s", err)
+	}
+
+	bye := nextReplacing(t, tp, sip.BYE)
+	if callID := string(*bye.CallID()); callID != string(dialog.CallID()) || headerTag(bye.To()) != "alice" {
+		t.Errorf("BYE %s is not in the replaced dialog %s", bye.Short(), dialog.ID())
+	}
+}
+
+// 接受替换本端发起的早期对话的 INVITE 之后 CANCEL 该对话的 INVITE
+func TestReplaceEarlyDialog(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+	_, invite := sendInvite(t, txl, tp)
+	tp.receive(t, answer(invite, sip.StatusRinging, "alice"))
+	nextUpResponse(t, txl)
+
+	// 本地 tag 为 INVITE 的 From tag
+	tp.receive(t, replacesInvite(t, "early", "local-call;to-tag=bob;from-tag=alice;early-only"))
+	req := nextUpRequest(t, txl)
+	if err := req.Transaction().(sip.ServerTransaction).SendResponse(answer(req, sip.StatusOK, "bob2")); err != nil {
+		t.Fatalf("send 200 failed: %s", err)
+	}
+
+	checkCancel(t, nextReplacing(t, tp, sip.CANCEL), invite)
+}
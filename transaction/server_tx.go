@@ -2,6 +2,7 @@ package transaction
 
 import (
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -36,6 +37,7 @@ func NewServerTx(origin sip.Request, tpl transport.Layer) (ServerTx, error) {
 	tx.done = make(chan bool)
 
 	tx.origin = origin.(sip.Request)
+	tx.timers = defaultTimerConfig()
 
 	if viaHop, ok := origin.ViaHop(); ok {
 		tx.reliable = tx.tpl.IsReliable(viaHop.Transport)
@@ -61,9 +63,24 @@ type serverTx struct {
 	timerI    *time.Timer
 	timeITime time.Duration
 	timerJ    *time.Timer
-	timer1xx  *time.Timer
+	// Accepted 状态的持续时间 RFC 6026
+	timerL   *time.Timer
+	timer1xx *time.Timer
 	// 判断是否传输协议是否可靠
 	reliable bool
+	// 代理转发的响应，可靠临时响应由 UAC 与 UAS 端到端处理
+	proxy bool
+	// 可靠临时响应 RFC 3262：最后使用的 RSeq、等待 PRACK 的响应以及重发定时器
+	require100rel bool
+	rseq          uint32
+	reliableResp  sip.Response
+	timerRel      *time.Timer
+	timeRelTime   time.Duration
+	relDeadline   time.Time
+	// 发送响应时通知事务层更新对话
+	onResponse func(res sip.Response)
+	// 事务定时器
+	timers timerConfig
 	// 锁
 	mu sync.RWMutex
 	// 确保关闭方法只执行一次
@@ -82,9 +99,9 @@ func (tx *serverTx) Init() error {
 		tx.timeITime = 0
 	} else {
 		// 必须设置定时器 G=T1 秒
-		tx.timeGTime = TimeG
+		tx.timeGTime = tx.timers.timeG()
 		// 不可靠传输协议，那么就要设定一个定时器 I=T4 秒
-		tx.timeITime = TimeI
+		tx.timeITime = tx.timers.timeI()
 	}
 
 	tx.mu.Unlock()
@@ -93,10 +110,11 @@ func (tx *serverTx) Init() error {
 	// INVITE必须在 200ms 生成 100(Trying)应答
 	// TODO 需要改写 zhr
 	if tx.Origin().IsInvite() {
-		logger.Debugf("[serverTx] -> set timer1xx to %v", Time1xx)
-
 		tx.mu.Lock()
-		tx.timer1xx = time.AfterFunc(Time1xx, func() {
+
+		logger.Debugf("[serverTx] -> set timer1xx to %v", tx.timers.time1xx)
+
+		tx.timer1xx = time.AfterFunc(tx.timers.time1xx, func() {
 			logger.Debug("[serverTx] -> timer1xx fired")
 
 			if err := tx.SendResponse(
@@ -163,6 +181,14 @@ func (tx *serverTx) SendResponse(res sip.Response) error {
 		return tx.tpl.Send(res)
 	}
 
+	if tx.isReliable(res) {
+		if err := tx.prepareReliable(res); err != nil {
+			return err
+		}
+	} else if !res.IsProvisional() {
+		tx.stopReliable()
+	}
+
 	tx.mu.Lock()
 	tx.lastResp = res
 
@@ -170,8 +196,13 @@ func (tx *serverTx) SendResponse(res sip.Response) error {
 		tx.timer1xx.Stop()
 		tx.timer1xx = nil
 	}
+	onResponse := tx.onResponse
 	tx.mu.Unlock()
 
+	if onResponse != nil {
+		onResponse(res)
+	}
+
 	var input fsm.Input
 	switch {
 	case res.IsProvisional(): // 是否临时应答
@@ -185,6 +216,115 @@ func (tx *serverTx) SendResponse(res sip.Response) error {
 	return tx.fsm.Spin(input)
 }
 
+// INVITE 的临时响应(100 除外)在响应或者请求 Require 100rel 时可靠发送；
+// 配置 Require100rel 时只在对方支持 100rel 时可靠发送，否则(例如不校验 100rel 的 re-INVITE)作为普通临时响应发送
+func (tx *serverTx) isReliable(res sip.Response) bool {
+	if tx.proxy || !tx.Origin().IsInvite() || !res.IsProvisional() || res.StatusCode() == sip.StatusTrying {
+		return false
+	}
+
+	return sip.RequiresOption(res, sip.OptionTag100rel) || sip.RequiresOption(tx.Origin(), sip.OptionTag100rel) ||
+		(tx.require100rel && sip.SupportsOption(tx.Origin(), sip.OptionTag100rel))
+}
+
+// 为可靠临时响应添加 RSeq 并启动重发定时器 RFC 3262 - 3
+func (tx *serverTx) prepareReliable(res sip.Response) error {
+	if !sip.SupportsOption(tx.Origin(), sip.OptionTag100rel) {
+		return fmt.Errorf("[serverTx] -> %s does not support 100rel, it should be rejected with 421", tx.Origin().Short())
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	// 上一个可靠临时响应被确认之前不能发送新的可靠临时响应
+	if tx.reliableResp != nil {
+		return fmt.Errorf("[serverTx] -> reliable provisional response with RSeq %d is not acknowledged", tx.rseq)
+	}
+
+	if tx.rseq == 0 {
+		// 初始值在 1 到 2**31 - 1 之间
+		tx.rseq = uint32(rand.Int31n(1<<31-1)) + 1
+	} else {
+		tx.rseq++
+	}
+	rseq := sip.RSeq(tx.rseq)
+	res.ReplaceHeader(&rseq)
+	if !sip.RequiresOption(res, sip.OptionTag100rel) {
+		res.AddHeader(&sip.RequireHeader{Options: []string{sip.OptionTag100rel}})
+	}
+
+	tx.reliableResp = res
+	tx.timeRelTime = tx.timers.t1
+	tx.relDeadline = time.Now().Add(64 * tx.timers.t1)
+	tx.timerRel = time.AfterFunc(tx.timeRelTime, tx.retransmitReliable)
+
+	return nil
+}
+
+// 可靠临时响应的重发间隔从 T1 开始每次加倍，64*T1 内没有收到 PRACK 时以 5xx 拒绝请求
+func (tx *serverTx) retransmitReliable() {
+	tx.mu.Lock()
+	res := tx.reliableResp
+	if res == nil {
+		tx.mu.Unlock()
+		return
+	}
+	if !time.Now().Before(tx.relDeadline) {
+		tx.reliableResp = nil
+		tx.timerRel = nil
+		tx.mu.Unlock()
+
+		logger.Warnf("[serverTx] -> reliable provisional response %s is not acknowledged", res.Short())
+		if err := tx.SendResponse(tx.Origin().CreateResponseReason(sip.StatusServerInternalError,
+			"Reliable Provisional Response Not Acknowledged")); err != nil {
+			logger.Errorf("[serverTx] -> reject %s failed: %s", tx.Origin().Short(), err)
+		}
+		return
+	}
+	tx.timeRelTime *= 2
+	// 最后一次重发之后在 64*T1 时拒绝请求
+	next := tx.timeRelTime
+	if remaining := time.Until(tx.relDeadline); remaining < next {
+		next = remaining
+	}
+	tx.timerRel = time.AfterFunc(next, tx.retransmitReliable)
+	tx.mu.Unlock()
+
+	logger.Debugf("[serverTx] -> retransmit reliable provisional response %s", res.Short())
+	if err := tx.tpl.Send(res); err != nil {
+		logger.Warnf("[serverTx] -> retransmit %s failed: %s", res.Short(), err)
+	}
+}
+
+// PRACK 确认可靠临时响应，RSeq 与最后发送的可靠临时响应相同时停止重发
+func (tx *serverTx) acknowledge(rseq uint32) bool {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.rseq == 0 || tx.rseq != rseq {
+		return false
+	}
+	tx.reliableResp = nil
+	if tx.timerRel != nil {
+		tx.timerRel.Stop()
+		tx.timerRel = nil
+	}
+
+	return true
+}
+
+// 发送最终响应后停止可靠临时响应的重发
+func (tx *serverTx) stopReliable() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	tx.reliableResp = nil
+	if tx.timerRel != nil {
+		tx.timerRel.Stop()
+		tx.timerRel = nil
+	}
+}
+
 func (tx *serverTx) Requests() <-chan sip.Request {
 	return tx.requests
 }
@@ -221,7 +361,7 @@ func (tx *serverTx) initInviteFSM() {
 			// 收到 cancel 请求，返回 200，不改变原请求状态，传递给 TU 判断是否终止请求
 			serverInputCancel:       {serverStateProceeding, tx.actionCancel},
 			serverInputUser1xx:      {serverStateProceeding, tx.actionRespond},
-			serverInputUser2xx:      {serverStateTerminated, tx.actionRespondDelete},
+			serverInputUser2xx:      {serverStateAccepted, tx.actionRespondAccept},
 			serverInputUser300Plus:  {serverStateCompleted, tx.actionRespondComplete},
 			serverInputTransportErr: {serverStateTerminated, tx.actionTransErr},
 		},
@@ -261,6 +401,22 @@ func (tx *serverTx) initInviteFSM() {
 		},
 	}
 
+	// Accepted RFC 6026：吸收重发的 INVITE，TU 重发的 2xx 直接发送，定时器 L 触发后终止
+	serverStateDefAccepted := fsm.State{
+		Index: serverStateAccepted,
+		Outcomes: map[fsm.Input]fsm.Outcome{
+			serverInputRequest: {serverStateAccepted, fsm.NO_ACTION},
+			serverInputAck:     {serverStateAccepted, tx.actionPassUpAck},
+			// 收到 cancel 请求，返回 481，不改变原请求状态
+			serverInputCancel:       {serverStateAccepted, tx.actionCancelNotExist},
+			serverInputUser1xx:      {serverStateAccepted, fsm.NO_ACTION},
+			serverInputUser2xx:      {serverStateAccepted, tx.actionRespond},
+			serverInputUser300Plus:  {serverStateAccepted, fsm.NO_ACTION},
+			serverInputTimerL:       {serverStateTerminated, tx.actionDelete},
+			serverInputTransportErr: {serverStateTerminated, tx.actionTransErr},
+		},
+	}
+
 	// Terminated
 	serverStateDefTerminated := fsm.State{
 		Index: serverStateTerminated,
@@ -271,6 +427,7 @@ func (tx *serverTx) initInviteFSM() {
 			serverInputUser1xx:     {serverStateTerminated, fsm.NO_ACTION},
 			serverInputUser2xx:     {serverStateTerminated, fsm.NO_ACTION},
 			serverInputUser300Plus: {serverStateTerminated, fsm.NO_ACTION},
+			serverInputTimerL:      {serverStateTerminated, fsm.NO_ACTION},
 			serverInputDelete:      {serverStateTerminated, tx.actionDelete},
 		},
 	}
@@ -280,6 +437,7 @@ func (tx *serverTx) initInviteFSM() {
 		serverStateDefProceeding,
 		serverStateDefCompleted,
 		serverStateDefConfirmed,
+		serverStateDefAccepted,
 		serverStateDefTerminated,
 	)
 	if err != nil {
@@ -427,10 +585,19 @@ func (tx *serverTx) delete() {
 		tx.timerJ.Stop()
 		tx.timerJ = nil
 	}
+	if tx.timerL != nil {
+		tx.timerL.Stop()
+		tx.timerL = nil
+	}
 	if tx.timer1xx != nil {
 		tx.timer1xx.Stop()
 		tx.timer1xx = nil
 	}
+	if tx.timerRel != nil {
+		tx.timerRel.Stop()
+		tx.timerRel = nil
+	}
+	tx.reliableResp = nil
 	tx.mu.Unlock()
 
 	time.Sleep(time.Microsecond)
@@ -512,8 +679,8 @@ func (tx *serverTx) actionRespondComplete() fsm.Input {
 			})
 		} else {
 			tx.timeGTime *= 2
-			if tx.timeGTime > T2 {
-				tx.timeGTime = T2
+			if tx.timeGTime > tx.timers.t2 {
+				tx.timeGTime = tx.timers.t2
 			}
 
 			logger.Debugf("timerG reset to %v", tx.timeGTime)
@@ -525,9 +692,9 @@ func (tx *serverTx) actionRespondComplete() fsm.Input {
 
 	tx.mu.Lock()
 	if tx.timerH == nil {
-		logger.Debugf("timerH set to %v", TimeH)
+		logger.Debugf("timerH set to %v", tx.timers.timeH())
 
-		tx.timerH = time.AfterFunc(TimeH, func() {
+		tx.timerH = time.AfterFunc(tx.timers.timeH(), func() {
 			logger.Debug("timerH fired")
 
 			if err := tx.fsm.Spin(serverInputTimerH); err != nil {
@@ -565,9 +732,9 @@ func (tx *serverTx) actionFinal() fsm.Input {
 
 	tx.mu.Lock()
 
-	logger.Debugf("[serverTx] -> timerJ set to %v", TimeJ)
+	logger.Debugf("[serverTx] -> timerJ set to %v", tx.timers.timeJ())
 
-	tx.timerJ = time.AfterFunc(TimeJ, func() {
+	tx.timerJ = time.AfterFunc(tx.timers.timeJ(), func() {
 		logger.Debug("[serverTx] -> timerJ fired")
 
 		if err := tx.fsm.Spin(serverInputTimerJ); err != nil {
@@ -609,11 +776,9 @@ func (tx *serverTx) actionDelete() fsm.Input {
 	return fsm.NO_INPUT
 }
 
-// Send response and delete the transaction.
-func (tx *serverTx) actionRespondDelete() fsm.Input {
-	logger.Debug("[serverTx] -> actionRespond_delete")
-
-	tx.delete()
+// 发送 2xx 并转到 Accepted 状态，启动定时器 L RFC 6026
+func (tx *serverTx) actionRespondAccept() fsm.Input {
+	logger.Debug("[serverTx] -> actionRespondAccept")
 
 	tx.mu.RLock()
 	lastErr := tx.tpl.Send(tx.lastResp)
@@ -627,6 +792,41 @@ func (tx *serverTx) actionRespondDelete() fsm.Input {
 		return serverInputTransportErr
 	}
 
+	tx.mu.Lock()
+
+	logger.Debugf("[serverTx] -> timerL set to %v", tx.timers.timeL())
+
+	tx.timerL = time.AfterFunc(tx.timers.timeL(), func() {
+		logger.Debug("[serverTx] -> timerL fired")
+
+		if err := tx.fsm.Spin(serverInputTimerL); err != nil {
+			logger.Errorf("[serverTx] -> spin FSM to serverInputTimerL failed: %s", err)
+		}
+	})
+
+	tx.mu.Unlock()
+
+	return fsm.NO_INPUT
+}
+
+// Accepted 状态收到与事务匹配的 ACK，传递给 TU
+func (tx *serverTx) actionPassUpAck() fsm.Input {
+	logger.Debug("[serverTx] -> actionPassUpAck")
+
+	// todo bloody patch
+	defer func() { recover() }()
+
+	tx.mu.RLock()
+	ack := tx.lastAck
+	tx.mu.RUnlock()
+
+	if ack != nil {
+		select {
+		case <-tx.done:
+		case tx.requests <- ack:
+		}
+	}
+
 	return fsm.NO_INPUT
 }
 
@@ -649,9 +849,9 @@ func (tx *serverTx) actionConfirm() fsm.Input {
 		tx.timerH = nil
 	}
 
-	logger.Debugf("[serverTx] -> timerI set to %v", TimeI)
+	logger.Debugf("[serverTx] -> timerI set to %v", tx.timers.timeI())
 
-	tx.timerI = time.AfterFunc(TimeI, func() {
+	tx.timerI = time.AfterFunc(tx.timers.timeI(), func() {
 		logger.Debug("[serverTx] -> timerI fired")
 
 		if err := tx.fsm.Spin(serverInputTimerI); err != nil {
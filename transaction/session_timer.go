@@ -0,0 +1,314 @@
+package transaction
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 会话定时器 RFC 4028，保存在对话属性中
+type sessionTimer struct {
+	// 会话间隔(秒)
+	interval uint32
+	// 本端是否为刷新方
+	refresher bool
+	// 对方支持 UPDATE 时使用 UPDATE 刷新，否则使用 re-INVITE
+	update bool
+	// 本端的 Contact，刷新请求是目标刷新请求，需要携带 Contact
+	contact sip.Header
+	// re-INVITE 刷新时携带的本端会话描述
+	contentType sip.ContentType
+	body        []byte
+
+	// 刷新方在会话间隔的一半时刷新
+	timerRefresh *time.Timer
+	// 会话过期之前没有收到刷新时发送 BYE
+	timerExpire *time.Timer
+
+	mu sync.Mutex
+}
+
+func (st *sessionTimer) stop() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.stopTimers()
+}
+
+func (st *sessionTimer) stopTimers() {
+	if st.timerRefresh != nil {
+		st.timerRefresh.Stop()
+		st.timerRefresh = nil
+	}
+	if st.timerExpire != nil {
+		st.timerExpire.Stop()
+		st.timerExpire = nil
+	}
+}
+
+// 会话刷新请求：INVITE 以及 UPDATE RFC 4028 - 7
+func isSessionRefresh(method sip.RequestMethod) bool {
+	return method == sip.INVITE || method == sip.UPDATE
+}
+
+// 请求是否在对话内(带 To tag)
+func hasToTag(msg sip.Message) bool {
+	to := msg.To()
+	return to != nil && to.Params != nil && to.Params.Has("tag")
+}
+
+// 响应所属对话的 ID：UAC 的本地 tag 为 From tag，UAS 的本地 tag 为 To tag
+func responseDialogID(role sip.DialogRole, res sip.Response) string {
+	if role == sip.DialogRoleUAS {
+		return sip.SentDialogID(res)
+	}
+
+	return sip.ReceivedDialogID(res)
+}
+
+// 发送 INVITE、UPDATE 时请求会话定时器 RFC 4028 - 7.1：
+// 创建对话的 INVITE 使用配置的会话间隔，对话内的请求使用当前的会话间隔以及刷新方；
+// 请求已经包含 Session-Expires 时不修改
+func (txl *layer) requestSessionTimer(req sip.Request) {
+	if !isSessionRefresh(req.Method()) || sip.SessionExpires(req) != nil {
+		return
+	}
+
+	se := &sip.SessionExpiresHeader{}
+	if hasToTag(req) {
+		dialog := txl.dialogs.get(sip.SentDialogID(req))
+		if dialog == nil {
+			return
+		}
+		st, ok := dialog.GetAttribute(sessionTimerAttribute).(*sessionTimer)
+		if !ok {
+			return
+		}
+		st.mu.Lock()
+		se.Delta = st.interval
+		se.Refresher = sip.RefresherUAS
+		if st.refresher {
+			se.Refresher = sip.RefresherUAC
+		}
+		st.mu.Unlock()
+	} else if req.IsInvite() && txl.opts.sessionExpires > 0 {
+		se.Delta = txl.opts.sessionExpires
+	} else {
+		return
+	}
+
+	req.AddHeader(se)
+	if txl.opts.minSE > sip.MinSessionExpires && len(req.GetHeaders("Min-SE")) == 0 {
+		req.AddHeader(&sip.MinSEHeader{Delta: txl.opts.minSE})
+	}
+}
+
+// 校验请求的会话间隔，小于本端 Min-SE 时返回 422 RFC 4028 - 8.1
+func (txl *layer) checkSessionTimer(req sip.Request) bool {
+	if !isSessionRefresh(req.Method()) {
+		return true
+	}
+	se := sip.SessionExpires(req)
+	if se == nil || se.Delta >= txl.opts.minSE {
+		return true
+	}
+
+	logger.Warnf("[txl_layer] -> session interval %d of %s is smaller than %d", se.Delta, req.Short(), txl.opts.minSE)
+	res := req.CreateResponseReason(sip.StatusSessionIntervalTooSmall, "Session Interval Too Small")
+	res.AddHeader(&sip.MinSEHeader{Delta: txl.opts.minSE})
+	_ = txl.tpl.Send(res)
+
+	return false
+}
+
+// UAS 在 INVITE、UPDATE 的 2xx 中确定会话间隔以及刷新方 RFC 4028 - 9：
+// 请求包含 Session-Expires 时使用请求的会话间隔，配置的会话间隔更小时使用配置的值，但不小于请求的 Min-SE；
+// 请求不包含 Session-Expires 时使用配置的会话间隔，没有配置时不使用会话定时器；
+// UAC 不支持 timer 时由 UAS 刷新，否则刷新方为请求指定的一方，未指定时由 UAC 刷新
+func (txl *layer) answerSessionTimer(req sip.Request, res sip.Response) {
+	if !isSessionRefresh(req.Method()) || !res.IsSuccess() || sip.SessionExpires(res) != nil {
+		return
+	}
+
+	interval := txl.opts.sessionExpires
+	refresher := ""
+	if se := sip.SessionExpires(req); se != nil {
+		if interval == 0 || se.Delta < interval {
+			interval = se.Delta
+		}
+		refresher = strings.ToLower(se.Refresher)
+	}
+	if interval == 0 {
+		return
+	}
+	if minSE := sip.MinSE(req); interval < minSE {
+		interval = minSE
+	}
+
+	supported := sip.SupportsOption(req, sip.OptionTagTimer)
+	if !supported {
+		refresher = sip.RefresherUAS
+	} else if refresher != sip.RefresherUAS {
+		refresher = sip.RefresherUAC
+	}
+
+	res.AddHeader(&sip.SessionExpiresHeader{Delta: interval, Refresher: refresher})
+	// UAC 支持 timer 时 2xx 需要 Require: timer RFC 4028 - 9
+	if supported && !sip.RequiresOption(res, sip.OptionTagTimer) {
+		res.AddHeader(&sip.RequireHeader{Options: []string{sip.OptionTagTimer}})
+	}
+}
+
+// INVITE、UPDATE 的 2xx 启动或者重置对话的会话定时器 RFC 4028 - 10：
+// 2xx 不包含 Session-Expires 时停止会话定时器；
+// 刷新方在会话间隔的一半时刷新，会话过期之前 32 秒与会话间隔三分之一中较小者仍未刷新时发送 BYE
+func (txl *layer) startSessionTimer(role sip.DialogRole, req sip.Request, res sip.Response) {
+	if !isSessionRefresh(req.Method()) || !res.IsSuccess() {
+		return
+	}
+	dialog := txl.dialogs.get(responseDialogID(role, res))
+	if dialog == nil {
+		return
+	}
+
+	st, ok := dialog.GetAttribute(sessionTimerAttribute).(*sessionTimer)
+	se := sip.SessionExpires(res)
+	if se == nil || se.Delta == 0 {
+		if ok {
+			st.stop()
+			dialog.DelAttribute(sessionTimerAttribute)
+			logger.Debugf("[txl_layer] -> session timer of dialog %s stopped", dialog.ID())
+		}
+		return
+	}
+	if !ok {
+		st = &sessionTimer{}
+		dialog.SetAttribute(sessionTimerAttribute, st)
+		go func() {
+			<-dialog.Done()
+			st.stop()
+		}()
+	}
+
+	// 本端发送的消息以及对方发送的消息
+	var local, remote sip.Message = req, res
+	if role == sip.DialogRoleUAS {
+		local, remote = res, req
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.interval = se.Delta
+	st.refresher = strings.EqualFold(se.Refresher, sip.RefresherUAC) == (role == sip.DialogRoleUAC)
+	if len(remote.GetHeaders("Allow")) > 0 {
+		st.update = sip.AllowsMethod(remote, sip.UPDATE)
+	}
+	if contact := local.Contact(); contact != nil {
+		st.contact = contact.Copy()
+	}
+	if body := local.Body(); len(body) > 0 {
+		st.body = body
+		st.contentType = ""
+		if ct := local.ContentType(); ct != nil {
+			st.contentType = *ct
+		}
+	}
+
+	st.stopTimers()
+	interval := time.Duration(se.Delta) * time.Second
+	if st.refresher {
+		st.timerRefresh = time.AfterFunc(interval/2, func() {
+			txl.refreshSession(dialog, st)
+		})
+	}
+	before := interval / 3
+	if before > 32*time.Second {
+		before = 32 * time.Second
+	}
+	st.timerExpire = time.AfterFunc(interval-before, func() {
+		txl.expireSession(dialog)
+	})
+
+	logger.Debugf("[txl_layer] -> session timer of dialog %s started, interval %d, refresher %t",
+		dialog.ID(), st.interval, st.refresher)
+}
+
+// 刷新会话：对方支持 UPDATE 时发送 UPDATE，否则发送携带本端会话描述的 re-INVITE RFC 4028 - 7.4
+func (txl *layer) refreshSession(dialog sip.Dialog, st *sessionTimer) {
+	if dialog.State() != sip.DialogStateConfirmed {
+		return
+	}
+
+	st.mu.Lock()
+	method := sip.INVITE
+	if st.update {
+		method = sip.UPDATE
+	}
+	req := dialog.CreateRequest(method)
+	req.AddHeader(&sip.SessionExpiresHeader{Delta: st.interval, Refresher: sip.RefresherUAC})
+	if txl.opts.minSE > sip.MinSessionExpires {
+		req.AddHeader(&sip.MinSEHeader{Delta: txl.opts.minSE})
+	}
+	req.AddHeader(&sip.SupportedHeader{Options: txl.Supported()})
+	if st.contact != nil {
+		req.AddHeader(st.contact.Copy())
+	}
+	if method == sip.INVITE && len(st.body) > 0 {
+		if st.contentType != "" {
+			ct := st.contentType
+			req.AddHeader(&ct)
+		}
+		req.SetBody(st.body, true)
+	}
+	st.mu.Unlock()
+
+	logger.Debugf("[txl_layer] -> refresh session of dialog %s with %s", dialog.ID(), method)
+	if _, err := txl.startClientTx(req, false); err != nil {
+		logger.Errorf("[txl_layer] -> send session refresh %s failed: %s", req.Short(), err)
+	}
+}
+
+// 自动发送的刷新请求收到 422 时使用对方的 Min-SE 重新刷新 RFC 4028 - 7.4，re-INVITE 的 2xx 由 UAC 核心发送 ACK
+func (txl *layer) handleRefreshResponse(req sip.Request, res sip.Response) {
+	if !isSessionRefresh(req.Method()) || sip.SessionExpires(req) == nil ||
+		res.StatusCode() != sip.StatusSessionIntervalTooSmall {
+		return
+	}
+	dialog := txl.dialogs.get(sip.ReceivedDialogID(res))
+	if dialog == nil {
+		return
+	}
+	st, ok := dialog.GetAttribute(sessionTimerAttribute).(*sessionTimer)
+	if !ok {
+		return
+	}
+
+	minSE := sip.MinSE(res)
+	st.mu.Lock()
+	if minSE <= st.interval {
+		st.mu.Unlock()
+		return
+	}
+	st.interval = minSE
+	st.mu.Unlock()
+
+	txl.refreshSession(dialog, st)
+}
+
+// 会话过期之前没有收到刷新，发送 BYE 终止对话 RFC 4028 - 10
+func (txl *layer) expireSession(dialog sip.Dialog) {
+	if dialog.State() == sip.DialogStateTerminated {
+		return
+	}
+
+	logger.Warnf("[txl_layer] -> session of dialog %s expired, send BYE", dialog.ID())
+	bye := dialog.CreateRequest(sip.BYE)
+	if _, err := txl.startClientTx(bye, false); err != nil {
+		logger.Errorf("[txl_layer] -> send BYE for expired session %s failed: %s", dialog.ID(), err)
+	}
+	dialog.Close()
+}
@@ -0,0 +1,184 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+func TestAnswerSessionTimer(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		headers   []string
+		delta     uint32
+		refresher string
+		require   bool
+	}{
+		{
+			name:      "request interval",
+			opts:      []Option{SessionTimer(1800, 90)},
+			headers:   []string{"Supported: timer", "Session-Expires: 900;refresher=uas"},
+			delta:     900,
+			refresher: sip.RefresherUAS,
+			require:   true,
+		},
+		{
+			name:      "smaller configured interval",
+			opts:      []Option{SessionTimer(600, 90)},
+			headers:   []string{"Supported: timer", "Session-Expires: 900"},
+			delta:     600,
+			refresher: sip.RefresherUAC,
+			require:   true,
+		},
+		{
+			name:      "not smaller than request Min-SE",
+			opts:      []Option{SessionTimer(600, 90)},
+			headers:   []string{"Supported: timer", "Session-Expires: 1800", "Min-SE: 1200"},
+			delta:     1200,
+			refresher: sip.RefresherUAC,
+			require:   true,
+		},
+		{
+			name:      "uac without timer",
+			opts:      []Option{SessionTimer(1800, 90)},
+			delta:     1800,
+			refresher: sip.RefresherUAS,
+		},
+		{
+			name: "no session timer",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			txl := &layer{opts: newOptions(test.opts...)}
+			req := remoteRequest(t, sip.INVITE, "answer", 1, "", test.headers...)
+			res := answer(req, sip.StatusOK, "bob")
+			txl.answerSessionTimer(req, res)
+
+			se := sip.SessionExpires(res)
+			if test.delta == 0 {
+				if se != nil {
+					t.Fatalf("unexpected Session-Expires %s", se)
+				}
+				return
+			}
+			if se == nil {
+				t.Fatal("2xx without Session-Expires")
+			}
+			if se.Delta != test.delta || se.Refresher != test.refresher {
+				t.Errorf("Session-Expires = %s, want %d;refresher=%s", se, test.delta, test.refresher)
+			}
+			if require := sip.RequiresOption(res, sip.OptionTagTimer); require != test.require {
+				t.Errorf("Require: timer = %t, want %t", require, test.require)
+			}
+		})
+	}
+}
+
+// 会话间隔小于本端 Min-SE 的请求返回携带 Min-SE 的 422，不传递给上层
+func TestSessionIntervalTooSmall(t *testing.T) {
+	txl, tp := createTestLayer(t, true, SessionTimer(1800, 1200))
+
+	tp.receive(t, remoteRequest(t, sip.INVITE, "small", 1, "", "Session-Expires: 90"))
+	res := tp.nextResponse(t, sip.StatusSessionIntervalTooSmall)
+	if minSE := sip.MinSE(res); minSE != 1200 {
+		t.Errorf("Min-SE = %d, want 1200", minSE)
+	}
+
+	tp.receive(t, remoteRequest(t, sip.INVITE, "large", 2, "", "Session-Expires: 1200"))
+	if req := nextUpRequest(t, txl); sip.SessionExpires(req).Delta != 1200 {
+		t.Errorf("passed up %s", req.Short())
+	}
+}
+
+// 刷新方在会话间隔的一半时刷新：对方不支持 UPDATE 时使用携带会话描述的 re-INVITE，
+// 刷新收到 422 时使用对方的 Min-SE 重新刷新，之后对方支持 UPDATE 时使用 UPDATE
+func TestSessionRefresh(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+	sdp := "v=0\r\no=bob 1 1 IN IP4 192.0.2.1\r\n"
+
+	invite := localRequest(t, sip.INVITE, sdp, "Content-Type: application/sdp", "Supported: timer")
+	if _, err := txl.SendRequest(invite); err != nil {
+		t.Fatalf("send INVITE failed: %s", err)
+	}
+	sent := tp.nextRequest(t, sip.INVITE)
+	tp.receive(t, answer(sent, sip.StatusOK, "alice",
+		&sip.SessionExpiresHeader{Delta: 1, Refresher: sip.RefresherUAC},
+		sip.AllowHeader{sip.INVITE, sip.ACK, sip.BYE}))
+	if res := nextUpResponse(t, txl); res.StatusCode() != sip.StatusOK {
+		t.Fatalf("passed up %s", res.Short())
+	}
+	tp.nextRequest(t, sip.ACK)
+
+	start := time.Now()
+	refresh := tp.nextRequest(t, sip.INVITE)
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("refreshed after %s, want half of the session interval", elapsed)
+	}
+	if !hasToTag(refresh) || refresh.CSeq().SeqNo <= sent.CSeq().SeqNo {
+		t.Errorf("refresh %s is not in dialog", refresh.Short())
+	}
+	if se := sip.SessionExpires(refresh); se == nil || se.Delta != 1 || se.Refresher != sip.RefresherUAC {
+		t.Errorf("Session-Expires of refresh = %v", se)
+	}
+	if string(refresh.Body()) != sdp {
+		t.Errorf("body of refresh = %q, want %q", refresh.Body(), sdp)
+	}
+
+	tp.receive(t, answer(refresh, sip.StatusSessionIntervalTooSmall, "", &sip.MinSEHeader{Delta: 120}))
+	retry := tp.nextRequests(t, sip.ACK, sip.INVITE)[sip.INVITE]
+	if se := sip.SessionExpires(retry); se == nil || se.Delta != 120 {
+		t.Errorf("Session-Expires of retry = %v, want 120", se)
+	}
+
+	// 会话间隔由 2xx 确定
+	tp.receive(t, answer(retry, sip.StatusOK, "",
+		&sip.SessionExpiresHeader{Delta: 2, Refresher: sip.RefresherUAC},
+		sip.AllowHeader{sip.INVITE, sip.ACK, sip.BYE, sip.UPDATE}))
+	tp.nextRequest(t, sip.ACK)
+
+	start = time.Now()
+	update := tp.nextRequest(t, sip.UPDATE)
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("refreshed after %s, want half of the session interval", elapsed)
+	}
+	if se := sip.SessionExpires(update); se == nil || se.Delta != 2 {
+		t.Errorf("Session-Expires of UPDATE = %v, want 2", se)
+	}
+	if len(update.Body()) != 0 {
+		t.Errorf("UPDATE with body %q", update.Body())
+	}
+}
+
+// 对方为刷新方时，会话过期之前没有收到刷新则发送 BYE 并终止对话
+func TestSessionExpire(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+
+	if _, err := txl.SendRequest(localRequest(t, sip.INVITE, "", "Supported: timer")); err != nil {
+		t.Fatalf("send INVITE failed: %s", err)
+	}
+	sent := tp.nextRequest(t, sip.INVITE)
+	res := answer(sent, sip.StatusOK, "alice", &sip.SessionExpiresHeader{Delta: 1, Refresher: sip.RefresherUAS})
+	tp.receive(t, res)
+	nextUpResponse(t, txl)
+	tp.nextRequest(t, sip.ACK)
+	dialog := txl.DialogOf(res)
+	if dialog == nil {
+		t.Fatal("no dialog created")
+	}
+
+	// 会话间隔的三分之一之前过期
+	start := time.Now()
+	tp.nextRequest(t, sip.BYE)
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("expired after %s", elapsed)
+	}
+	select {
+	case <-dialog.Done():
+	case <-time.After(testTimeout):
+		t.Error("dialog not terminated")
+	}
+}
@@ -0,0 +1,108 @@
+package transaction
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 发送的 SUBSCRIBE 以及 REFER，按 Call-ID 以及 From tag 匹配在 2xx 之前或者由分叉到达的 NOTIFY RFC 6665 - 4.1.2.4
+type subscribePool struct {
+	subscribes map[string]sip.Request
+
+	mu sync.Mutex
+}
+
+func createSubscribePool() *subscribePool {
+	return &subscribePool{
+		subscribes: make(map[string]sip.Request),
+	}
+}
+
+func subscribeKey(callID string, tag string) string {
+	return callID + "__" + tag
+}
+
+// 创建订阅的请求：SUBSCRIBE，以及创建隐式订阅的 REFER RFC 3515 - 2.4.4
+func isSubscribing(method sip.RequestMethod) bool {
+	return method == sip.SUBSCRIBE || method == sip.REFER
+}
+
+// 记录对话外的 SUBSCRIBE 以及 REFER，2xx 之后 64*T1 内仍可能收到 NOTIFY，保留到事务超时之后的 64*T1
+func (txl *layer) recordSubscribe(req sip.Request, timers timerConfig) {
+	if !isSubscribing(req.Method()) || hasToTag(req) {
+		return
+	}
+	callID, fromTag := callLeg(req)
+	key := subscribeKey(callID, fromTag)
+
+	pool := txl.subscribes
+	pool.mu.Lock()
+	pool.subscribes[key] = req
+	pool.mu.Unlock()
+
+	time.AfterFunc(timers.timeF()+64*timers.t1, func() {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+
+		if pool.subscribes[key] == req {
+			delete(pool.subscribes, key)
+		}
+	})
+}
+
+// 对话外收到的 NOTIFY 匹配发送的 SUBSCRIBE 时创建订阅对话 RFC 6665 - 4.1.2.4：
+// NOTIFY 的 To tag 为 SUBSCRIBE 的 From tag，Event 与 SUBSCRIBE 相同，每个分叉的 NOTIFY 创建一个对话
+func (txl *layer) handleNotifyDialog(notify sip.Request) {
+	if notify.Method() != sip.NOTIFY || txl.dialogs.get(sip.ReceivedDialogID(notify)) != nil {
+		return
+	}
+	callID := ""
+	if c := notify.CallID(); c != nil {
+		callID = string(*c)
+	}
+	toTag := ""
+	if to := notify.To(); to != nil && to.Params != nil {
+		if tag, ok := to.Params.Get("tag"); ok && tag != nil {
+			toTag = tag.String()
+		}
+	}
+
+	txl.subscribes.mu.Lock()
+	subscribe, ok := txl.subscribes.subscribes[subscribeKey(callID, toTag)]
+	txl.subscribes.mu.Unlock()
+	if !ok || !sameEvent(subscribe, notify) {
+		return
+	}
+
+	dialog, err := sip.NewSubscriberDialog(subscribe, notify)
+	if err != nil {
+		logger.Warn(err)
+		return
+	}
+	txl.dialogs.put(dialog)
+	logger.Debugf("[txl_layer] -> dialog %s created by %s", dialog.ID(), notify.Short())
+}
+
+// 两个消息的 Event 是否相同：事件包以及 id 参数 RFC 6665 - 8.2.1；
+// REFER 没有 Event 头部，对应 refer 事件，id 为 REFER 的 CSeq RFC 3515 - 2.4.6
+func sameEvent(msg sip.Message, other sip.Message) bool {
+	if req, ok := msg.(sip.Request); ok && req.Method() == sip.REFER {
+		event := sip.Event(other)
+		if event == nil || !strings.EqualFold(event.EventType, "refer") {
+			return false
+		}
+		cseq := req.CSeq()
+		return cseq != nil && (event.ID() == "" || event.ID() == fmt.Sprintf("%d", cseq.SeqNo))
+	}
+	event, otherEvent := sip.Event(msg), sip.Event(other)
+	if event == nil || otherEvent == nil {
+		return false
+	}
+
+	return strings.EqualFold(event.EventType, otherEvent.EventType) && event.ID() == otherEvent.ID()
+}
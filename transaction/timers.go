@@ -0,0 +1,115 @@
+package transaction
+
+import (
+	"time"
+)
+
+// 事务定时器 RFC 3261 - 17 以及附录 A，定时器 A-M 由 T1、T2、T4 计算
+type timerConfig struct {
+	// RTT 估计值
+	t1 time.Duration
+	// 非 INVITE 请求以及 INVITE 响应的最大重发间隔
+	t2 time.Duration
+	// 消息在网络中保留的最长时间
+	t4 time.Duration
+	// INVITE 服务端事务自动发送 100 Trying 的延迟
+	time1xx time.Duration
+}
+
+// 单个定时器的配置选项，用于事务层的 Timers 以及单个请求
+type TimerOption func(t *timerConfig)
+
+func newTimerConfig(base timerConfig, opts ...TimerOption) timerConfig {
+	for _, o := range opts {
+		o(&base)
+	}
+
+	return base
+}
+
+func defaultTimerConfig() timerConfig {
+	return timerConfig{
+		t1:      T1,
+		t2:      T2,
+		t4:      T4,
+		time1xx: Time1xx,
+	}
+}
+
+// 配置 T1，默认 500ms
+func TimerT1(d time.Duration) TimerOption {
+	return func(t *timerConfig) {
+		if d > 0 {
+			t.t1 = d
+		}
+	}
+}
+
+// 配置 T2，默认 4s
+func TimerT2(d time.Duration) TimerOption {
+	return func(t *timerConfig) {
+		if d > 0 {
+			t.t2 = d
+		}
+	}
+}
+
+// 配置 T4，默认 5s
+func TimerT4(d time.Duration) TimerOption {
+	return func(t *timerConfig) {
+		if d > 0 {
+			t.t4 = d
+		}
+	}
+}
+
+// 配置 INVITE 服务端事务自动发送 100 Trying 的延迟，默认 200ms
+func Timer1xx(d time.Duration) TimerOption {
+	return func(t *timerConfig) {
+		if d > 0 {
+			t.time1xx = d
+		}
+	}
+}
+
+// INVITE 请求的初始重发间隔
+func (t timerConfig) timeA() time.Duration { return t.t1 }
+
+// INVITE 事务超时
+func (t timerConfig) timeB() time.Duration { return 64 * t.t1 }
+
+// 吸收重发的最终响应，不可靠传输时至少 32 秒
+func (t timerConfig) timeD() time.Duration {
+	if d := 64 * t.t1; d > 32*time.Second {
+		return d
+	}
+
+	return 32 * time.Second
+}
+
+// 非 INVITE 请求的初始重发间隔
+func (t timerConfig) timeE() time.Duration { return t.t1 }
+
+// 非 INVITE 事务超时
+func (t timerConfig) timeF() time.Duration { return 64 * t.t1 }
+
+// INVITE 最终响应的初始重发间隔
+func (t timerConfig) timeG() time.Duration { return t.t1 }
+
+// 等待 ACK 的超时
+func (t timerConfig) timeH() time.Duration { return 64 * t.t1 }
+
+// 吸收重发的 ACK
+func (t timerConfig) timeI() time.Duration { return t.t4 }
+
+// 吸收重发的非 INVITE 请求
+func (t timerConfig) timeJ() time.Duration { return 64 * t.t1 }
+
+// 吸收重发的非 INVITE 响应
+func (t timerConfig) timeK() time.Duration { return t.t4 }
+
+// INVITE 服务端事务 Accepted 状态的持续时间 RFC 6026
+func (t timerConfig) timeL() time.Duration { return 64 * t.t1 }
+
+// INVITE 客户端事务 Accepted 状态的持续时间 RFC 6026
+func (t timerConfig) timeM() time.Duration { return 64 * t.t1 }
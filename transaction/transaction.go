@@ -12,11 +12,15 @@ import (
 )
 
 // 创建实例化事务层
-func CreateLayer(tpl transport.Layer) Layer {
+func CreateLayer(tpl transport.Layer, opts ...Option) Layer {
 	txl := &layer{
 		tpl:          tpl,
+		opts:         newOptions(opts...),
 		transactions: createTransactionPool(),
-		sessionStore: createSessionPool(),
+		dialogs:      createDialogPool(),
+		invites:      createInviteSuccessPool(),
+		successes:    createPendingSuccessPool(),
+		subscribes:   createSubscribePool(),
 		requests:     make(chan sip.Request),
 		responses:    make(chan sip.Response),
 		errs:         make(chan error),
@@ -31,7 +35,15 @@ func CreateLayer(tpl transport.Layer) Layer {
 
 // 事务层定义与实现
 type Layer interface {
+	// 配置事务层选项
+	Init(opts ...Option)
+	// 支持的扩展(option tag)
+	Supported() []string
 	Send(message sip.Message) (sip.Transaction, error)
+	// 发送请求，opts 覆盖事务层配置的定时器
+	SendRequest(req sip.Request, opts ...TimerOption) (sip.ClientTransaction, error)
+	// 事务层配置的 T1，opts 覆盖事务层配置的定时器
+	T1(opts ...TimerOption) time.Duration
 	AutoFillMessageHeaderAndSend(message sip.Message, callback callback.Callback) (sip.Transaction, error)
 	// 传输层实例
 	Transport() transport.Layer
@@ -40,8 +52,12 @@ type Layer interface {
 	Requests() <-chan sip.Request
 	// Responses returns channel with not matched responses.
 	Responses() <-chan sip.Response
-	// 返回 session
-	Session(key string) sip.Session
+	// 根据对话 ID 返回对话
+	Dialog(id string) sip.Dialog
+	// 返回消息所属的对话
+	DialogOf(msg sip.Message) sip.Dialog
+	// 返回 INVITE 的 Replaces 头部匹配的对话
+	Replaces(req sip.Request) sip.Dialog
 	Errors() <-chan error
 	// 关闭释放资源
 	Close()
@@ -52,11 +68,18 @@ type Layer interface {
 
 type layer struct {
 	tpl          transport.Layer
+	opts         Options
 	requests     chan sip.Request
 	ackRequest   chan sip.Request
 	responses    chan sip.Response
 	transactions *transactionPool
-	sessionStore *sessionPool // session
+	dialogs      *dialogPool // 对话
+	// INVITE 的 2xx 以及 ACK
+	invites *inviteSuccessPool
+	// 等待 ACK 的 2xx
+	successes *pendingSuccessPool
+	// 对话外的 SUBSCRIBE
+	subscribes *subscribePool
 
 	errs     chan error
 	done     chan struct{}
@@ -66,6 +89,20 @@ type layer struct {
 	cancelOnce sync.Once
 }
 
+func (txl *layer) Init(opts ...Option) {
+	for _, o := range opts {
+		o(&txl.opts)
+	}
+}
+
+func (txl *layer) Supported() []string {
+	return append([]string(nil), txl.opts.supported...)
+}
+
+func (txl *layer) T1(opts ...TimerOption) time.Duration {
+	return newTimerConfig(txl.opts.timers, opts...).t1
+}
+
 // 返回 请求传输通道
 func (txl *layer) Requests() <-chan sip.Request {
 	return txl.requests
@@ -76,9 +113,18 @@ func (txl *layer) Responses() <-chan sip.Response {
 	return txl.responses
 }
 
-// 返回 session
-func (txl *layer) Session(key string) sip.Session {
-	return txl.sessionStore.get(key)
+// 根据对话 ID 返回对话
+func (txl *layer) Dialog(id string) sip.Dialog {
+	return txl.dialogs.get(id)
+}
+
+// 返回消息所属的对话：先按接收方查找，再按发送方查找
+func (txl *layer) DialogOf(msg sip.Message) sip.Dialog {
+	if dialog := txl.dialogs.get(sip.ReceivedDialogID(msg)); dialog != nil {
+		return dialog
+	}
+
+	return txl.dialogs.get(sip.SentDialogID(msg))
 }
 
 // 返回异常现象
@@ -125,7 +171,7 @@ func (txl *layer) Send(message sip.Message) (sip.Transaction, error) {
 
 	switch msg := message.(type) {
 	case sip.Request:
-		return txl.sendRequest(msg)
+		return txl.SendRequest(msg)
 	case sip.Response:
 		return txl.sendResponse(msg)
 	default:
@@ -139,7 +185,7 @@ func (txl *layer) AutoFillMessageHeaderAndSend(message sip.Message, callback cal
 
 	switch msg := message.(type) {
 	case sip.Request:
-		return txl.sendRequest(msg)
+		return txl.SendRequest(msg)
 	case sip.Response:
 		return txl.sendResponse(msg)
 	default:
@@ -148,22 +194,35 @@ func (txl *layer) AutoFillMessageHeaderAndSend(message sip.Message, callback cal
 	return nil, fmt.Errorf("[txl_layer] -> message type mismatch")
 }
 
-// 发送请求
-func (txl *layer) sendRequest(req sip.Request) (sip.ClientTransaction, error) {
+// 发送请求，opts 覆盖事务层配置的定时器
+func (txl *layer) SendRequest(req sip.Request, opts ...TimerOption) (sip.ClientTransaction, error) {
+	return txl.startClientTx(req, true, opts...)
+}
+
+// 创建客户端事务并发送请求，passUp 为 false 时响应不传递给上层(例如自动发送的 PRACK)
+func (txl *layer) startClientTx(req sip.Request, passUp bool, opts ...TimerOption) (sip.ClientTransaction, error) {
+	return txl.startClientTxTo(req, passUp, nil, opts...)
+}
+
+// 创建客户端事务并发送请求到指定的目标，targets 为空时按 RFC 3263 解析
+func (txl *layer) startClientTxTo(req sip.Request, passUp bool, targets []transport.Target, opts ...TimerOption) (sip.ClientTransaction, error) {
 	select {
 	case <-txl.canceled:
 		return nil, fmt.Errorf("[txl_layer] -> transaction layer is canceled")
 	default:
 	}
 
-	// session
-	txl.updateSession(req)
-
 	// RFC 3621 - 17.0 对于 ACK 来说，是不存在客户事务的
 	if req.IsAck() {
+		if !txl.opts.proxy {
+			txl.invites.recordAck(req)
+		}
 		err := txl.tpl.Send(req)
 		return nil, err
 	}
+	if !txl.opts.proxy {
+		txl.requestSessionTimer(req)
+	}
 
 	tx, err := NewClientTx(req, txl.tpl)
 	if err != nil {
@@ -173,6 +232,27 @@ func (txl *layer) sendRequest(req sip.Request) (sip.ClientTransaction, error) {
 	logger.Debug("[txl_layer] -> client transaction created")
 
 	txl.transactions.put(tx.Key(), tx)
+	// RFC 3263 - 4.3 切换目标后使用新的 branch，更新事务池中的 key
+	if ctx, ok := tx.(*clientTx); ok {
+		ctx.timers = newTimerConfig(txl.opts.timers, opts...)
+		if !txl.opts.proxy {
+			txl.recordSubscribe(req, ctx.timers)
+		}
+		ctx.rekey = func(oldKey, newKey TxKey) {
+			txl.transactions.drop(oldKey)
+			txl.transactions.put(newKey, tx)
+		}
+		// CANCEL 使用单独的非 INVITE 事务，响应不传递给上层，定时器与 INVITE 相同
+		ctx.sendCancel = func(cancel sip.Request, target transport.Target, ok bool) error {
+			var targets []transport.Target
+			if ok {
+				targets = []transport.Target{target}
+			}
+			_, err := txl.startClientTxTo(cancel, false, targets, opts...)
+			return err
+		}
+		ctx.targets = targets
+	}
 
 	err = tx.Init()
 	if err != nil {
@@ -180,7 +260,7 @@ func (txl *layer) sendRequest(req sip.Request) (sip.ClientTransaction, error) {
 	}
 
 	txl.txWg.Add(1)
-	go txl.serveTransaction(tx)
+	go txl.serveTransaction(tx, passUp)
 
 	return tx, nil
 }
@@ -198,9 +278,6 @@ func (txl *layer) sendResponse(res sip.Response) (sip.ServerTransaction, error)
 		return nil, err
 	}
 
-	// session
-	txl.handleSession(tx.Origin().Method(), res)
-
 	err = tx.SendResponse(res)
 	if err != nil {
 		return nil, err
@@ -244,7 +321,7 @@ func (txl *layer) listenMessages() {
 }
 
 // 监听该事务取消，释放资源
-func (txl *layer) serveTransaction(tx Tx) {
+func (txl *layer) serveTransaction(tx Tx, passUp bool) {
 	defer func() {
 		txl.transactions.drop(tx.Key())
 		logger.Debugf("[txl_layer] -> transaction[%s] deleted", tx.Key())
@@ -263,8 +340,25 @@ func (txl *layer) serveTransaction(tx Tx) {
 					if !ok {
 						return
 					}
-					// session
-					txl.handleSession(tx.Origin().Method(), resp)
+					// 代理转发所有响应，由 UAC 端到端处理
+					if txl.opts.proxy {
+						txl.responses <- resp
+						continue
+					}
+					// 对话
+					txl.handleDialog(sip.DialogRoleUAC, tx.Origin(), resp)
+					txl.startSessionTimer(sip.DialogRoleUAC, tx.Origin(), resp)
+					if resp.IsSuccess() && tx.Origin().IsInvite() && !txl.handleInviteSuccess(tx, resp, passUp) {
+						continue
+					}
+					if !passUp {
+						txl.handleReliable(tx.Origin(), resp)
+						txl.handleRefreshResponse(tx.Origin(), resp)
+						continue
+					}
+					if !txl.handleReliable(tx.Origin(), resp) {
+						continue
+					}
 					txl.responses <- resp
 				}
 			}
@@ -295,24 +389,221 @@ func (txl *layer) serveTransaction(tx Tx) {
 	}
 }
 
-// 处理 session 的更新
-func (txl *layer) updateSession(req sip.Request) {
-	if session := txl.Session(req.DialogId()); session != nil {
-		session.SeqAtom()
+// 处理对话的创建、更新以及终止 RFC 3261 - 12
+func (txl *layer) handleDialog(role sip.DialogRole, req sip.Request, res sip.Response) {
+	dialog := txl.dialogs.get(responseDialogID(role, res))
+
+	if req.IsInvite() {
+		switch {
+		case res.IsProvisional() || res.IsSuccess():
+			// 100 以及不带 To tag 的响应不创建对话 RFC 3261 - 12.1
+			if !hasToTag(res) {
+				return
+			}
+			if dialog != nil {
+				dialog.Update(req, res)
+				return
+			}
+
+			var err error
+			if role == sip.DialogRoleUAS {
+				dialog, err = sip.NewUASDialog(req, res)
+			} else {
+				dialog, err = sip.NewUACDialog(req, res)
+			}
+			if err != nil {
+				logger.Warn(err)
+				return
+			}
+			txl.dialogs.put(dialog)
+		default:
+			// RFC 3261 - 12.3 INVITE 的非 2xx 最终响应终止该请求创建的所有早期对话
+			txl.terminateEarlyDialogs(role, res)
+		}
+		return
+	}
+
+	// RFC 6665 - 4.1.2.1 对话外 SUBSCRIBE 的 2xx 创建订阅对话，NOTIFY 先到达时对话已经存在；
+	// REFER 创建隐式订阅，同样创建对话 RFC 3515 - 2.4.4
+	if dialog == nil && isSubscribing(req.Method()) && res.IsSuccess() && hasToTag(res) && !hasToTag(req) {
+		var err error
+		if role == sip.DialogRoleUAS {
+			dialog, err = sip.NewUASDialog(req, res)
+		} else {
+			dialog, err = sip.NewUACDialog(req, res)
+		}
+		if err != nil {
+			logger.Warn(err)
+			return
+		}
+		txl.dialogs.put(dialog)
+		return
+	}
+	if dialog == nil {
+		return
+	}
+	switch {
+	case res.IsSuccess():
+		dialog.Update(req, res)
+		if req.Method() == sip.BYE {
+			dialog.Close()
+		}
+	case role == sip.DialogRoleUAC && (res.StatusCode() == sip.StatusCallTransactionDoesNotExist ||
+		res.StatusCode() == sip.StatusRequestTimeout):
+		// RFC 3261 - 12.2.1.2 对话内请求收到 481 或者 408 时终止对话
+		dialog.Close()
+	}
+}
+
+// 终止 INVITE 创建的早期对话
+func (txl *layer) terminateEarlyDialogs(role sip.DialogRole, res sip.Response) {
+	callID := res.CallID()
+	if callID == nil {
+		return
+	}
+	var params sip.Params
+	if role == sip.DialogRoleUAS {
+		if to := res.To(); to != nil {
+			params = to.Params
+		}
+	} else if from := res.From(); from != nil {
+		params = from.Params
+	}
+	var localTag string
+	if params != nil {
+		if tag, ok := params.Get("tag"); ok && tag != nil {
+			localTag = tag.String()
+		}
+	}
+
+	for _, dialog := range txl.dialogs.all() {
+		if dialog.Role() == role && dialog.CallID() == *callID && dialog.LocalTag() == localTag &&
+			dialog.State() == sip.DialogStateEarly {
+			dialog.Close()
+		}
+	}
+}
+
+// 校验对话内请求 RFC 3261 - 12.2.2，乱序的请求返回 500
+func (txl *layer) checkDialogRequest(req sip.Request) bool {
+	dialog := txl.dialogs.get(sip.ReceivedDialogID(req))
+	if dialog == nil || dialog.ReceiveRequest(req) {
+		return true
+	}
+
+	logger.Warnf("[txl_layer] -> out of order request %s in dialog %s", req.Short(), dialog.ID())
+	_ = txl.tpl.Send(req.CreateResponse(sip.StatusServerInternalError))
+
+	return false
+}
+
+// 校验请求要求的扩展：不支持的扩展返回 420 RFC 3261 - 8.2.2.3，要求 100rel 时返回 421 RFC 3262 - 3
+func (txl *layer) checkExtensions(req sip.Request) bool {
+	if unsupported := sip.UnsupportedOptions(req, txl.opts.supported); len(unsupported) > 0 {
+		logger.Warnf("[txl_layer] -> %s requires unsupported extensions %v", req.Short(), unsupported)
+		res := req.CreateResponseReason(sip.StatusBadExtension, "Bad Extension")
+		res.AddHeader(&sip.UnsupportedHeader{Options: unsupported})
+		_ = txl.tpl.Send(res)
+
+		return false
+	}
+
+	// 只校验创建对话的 INVITE，对话内的 re-INVITE 不校验
+	if txl.opts.require100rel && req.IsInvite() && !hasToTag(req) && !sip.SupportsOption(req, sip.OptionTag100rel) {
+		res := req.CreateResponseReason(sip.StatusExtensionRequired, "Extension Required")
+		res.AddHeader(&sip.RequireHeader{Options: []string{sip.OptionTag100rel}})
+		_ = txl.tpl.Send(res)
+
+		return false
+	}
+
+	return true
+}
+
+// PRACK 确认对应的 INVITE 服务端事务中的可靠临时响应，返回是否匹配
+func (txl *layer) acknowledgeReliable(prack sip.Request) bool {
+	rack := sip.RequestRAck(prack)
+	if rack == nil {
+		return false
+	}
+	callID, fromTag := callLeg(prack)
+
+	for _, tx := range txl.transactions.all() {
+		stx, ok := tx.(*serverTx)
+		if !ok || !stx.Origin().IsInvite() {
+			continue
+		}
+		cseq := stx.Origin().CSeq()
+		if cseq == nil || cseq.SeqNo != rack.CSeq || cseq.MethodName != rack.Method {
+			continue
+		}
+		if originCallID, originFromTag := callLeg(stx.Origin()); originCallID != callID || originFromTag != fromTag {
+			continue
+		}
+		if stx.acknowledge(rack.RSeq) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UAC 处理可靠的临时响应 RFC 3262 - 4：新的可靠临时响应在早期对话中自动发送 PRACK，
+// PRACK 使用单独的非 INVITE 客户端事务；重发以及乱序的响应丢弃，返回 false 时不传递给上层
+func (txl *layer) handleReliable(req sip.Request, res sip.Response) bool {
+	if !req.IsInvite() || !sip.IsReliableProvisional(res) {
+		return true
+	}
+	rseq, ok := sip.ResponseRSeq(res)
+	if !ok {
+		logger.Warnf("[txl_layer] -> reliable provisional response %s has no RSeq", res.Short())
+		return true
+	}
+	dialog := txl.dialogs.get(sip.ReceivedDialogID(res))
+	if dialog == nil {
+		logger.Warnf("[txl_layer] -> no early dialog for reliable provisional response %s", res.Short())
+		return true
+	}
+
+	// 每个 INVITE 的 RSeq 单独计数
+	key := fmt.Sprintf("%s%d", rseqAttribute, res.CSeq().SeqNo)
+	if last, ok := dialog.GetAttribute(key).(uint32); ok {
+		if rseq <= last {
+			logger.Debugf("[txl_layer] -> discard retransmitted reliable provisional response %s", res.Short())
+			return false
+		}
+		if rseq != last+1 {
+			logger.Warnf("[txl_layer] -> discard out of order reliable provisional response %s, RSeq %d, expected %d",
+				res.Short(), rseq, last+1)
+			return false
+		}
+	}
+	dialog.SetAttribute(key, rseq)
+
+	prack, err := sip.CreatePrack(dialog, res)
+	if err != nil {
+		logger.Warn(err)
+		return true
 	}
+	if _, err := txl.startClientTx(prack, false); err != nil {
+		logger.Errorf("[txl_layer] -> send PRACK for %s failed: %s", res.Short(), err)
+	}
+
+	return true
 }
 
-// 处理 session 的创建以及销毁
-func (txl *layer) handleSession(method sip.RequestMethod, resp sip.Response) {
-	if resp.IsSuccess() {
-		if method == sip.BYE { // 销毁 session
-			txl.sessionStore.drop(resp.DialogId())
-		} else if method == sip.INVITE { // 创建 session
-			session := sip.CreateSession(resp.DialogId())
-			session.SaveMessage(resp)
-			txl.sessionStore.put(resp.DialogId(), session)
+// 返回消息的 Call-ID 以及 From tag
+func callLeg(msg sip.Message) (callID string, fromTag string) {
+	if c := msg.CallID(); c != nil {
+		callID = string(*c)
+	}
+	if from := msg.From(); from != nil && from.Params != nil {
+		if tag, ok := from.Params.Get("tag"); ok && tag != nil {
+			fromTag = tag.String()
 		}
 	}
+
+	return
 }
 
 // 处理数据
@@ -344,10 +635,23 @@ func (txl *layer) handleRequest(req sip.Request) {
 	default:
 	}
 
-	// session
-	txl.updateSession(req)
-
+	// ACK on 2xx：停止 2xx 的重发，重发的 ACK 不传递给上层
+	if req.IsAck() && !txl.opts.proxy {
+		matched, first := txl.confirmSuccess(req)
+		if matched && !first {
+			logger.Debugf("[txl_layer] -> discard retransmitted %s", req.Short())
+			return
+		}
+		if matched {
+			select {
+			case <-txl.canceled:
+			case txl.requests <- req:
+			}
+			return
+		}
+	}
 	// try to match to existent tx: request retransmission, or ACKs on non-2xx, or CANCEL
+	// RFC 6026 Accepted 状态的 INVITE 事务吸收重发的 INVITE
 	tx, err := txl.getServerTx(req)
 	if err == nil {
 		if err := tx.Receive(req); err != nil {
@@ -356,8 +660,8 @@ func (txl *layer) handleRequest(req sip.Request) {
 
 		return
 	}
-	// ACK on 2xx
-	if req.IsAck() {
+	// ACK on 2xx；无状态转发时所有请求不创建服务端事务
+	if req.IsAck() || txl.opts.stateless {
 		select {
 		case <-txl.canceled:
 		case txl.requests <- req:
@@ -370,6 +674,9 @@ func (txl *layer) handleRequest(req sip.Request) {
 		_ = txl.tpl.Send(req.CreateResponse(sip.StatusCallTransactionDoesNotExist))
 		return
 	}
+	if !txl.opts.proxy && !txl.checkUASRequest(req) {
+		return
+	}
 
 	// 创建新的服务端事务
 	tx, err = NewServerTx(req, txl.tpl)
@@ -379,6 +686,22 @@ func (txl *layer) handleRequest(req sip.Request) {
 	}
 
 	logger.Debug("[txl_layer] -> new server transaction created")
+	if stx, ok := tx.(*serverTx); ok {
+		stx.timers = txl.opts.timers
+		stx.proxy = txl.opts.proxy
+	}
+	if stx, ok := tx.(*serverTx); ok && !txl.opts.proxy {
+		stx.require100rel = txl.opts.require100rel
+		stx.onResponse = func(res sip.Response) {
+			txl.answerSessionTimer(req, res)
+			txl.handleDialog(sip.DialogRoleUAS, req, res)
+			txl.startSessionTimer(sip.DialogRoleUAS, req, res)
+			if req.IsInvite() && res.IsSuccess() {
+				txl.retransmitSuccess(stx, res)
+				txl.replaceDialog(req, res)
+			}
+		}
+	}
 	// put tx to store, to match retransmitting requests later
 	// 将新的事务 tx 存储在事务池中
 	txl.transactions.put(tx.Key(), tx)
@@ -397,7 +720,7 @@ func (txl *layer) handleRequest(req sip.Request) {
 
 	txl.txWg.Add(1)
 	// 监听该事务取消，释放资源
-	go txl.serveTransaction(tx)
+	go txl.serveTransaction(tx, true)
 
 	// pass up request
 	// 往上层传递
@@ -413,6 +736,23 @@ func (txl *layer) handleRequest(req sip.Request) {
 	}
 }
 
+// UAS 核心校验新的请求，返回是否创建服务端事务：对话内请求的 CSeq、要求的扩展、会话定时器、Replaces 以及 PRACK
+func (txl *layer) checkUASRequest(req sip.Request) bool {
+	txl.handleNotifyDialog(req)
+	if !txl.checkDialogRequest(req) || !txl.checkExtensions(req) || !txl.checkSessionTimer(req) ||
+		!txl.checkReplaces(req) {
+		return false
+	}
+	// RFC 3262 - 7.2 PRACK 没有对应的可靠临时响应时返回 481
+	if req.Method() == sip.PRACK && !txl.acknowledgeReliable(req) {
+		logger.Warnf("[txl_layer] -> %s does not match any reliable provisional response", req.Short())
+		_ = txl.tpl.Send(req.CreateResponse(sip.StatusCallTransactionDoesNotExist))
+		return false
+	}
+
+	return true
+}
+
 // 处理响应
 func (txl *layer) handleResponse(res sip.Response) {
 	select {
@@ -424,7 +764,6 @@ func (txl *layer) handleResponse(res sip.Response) {
 	// 获取事务池中对应的客户端事务
 	tx, err := txl.getClientTx(res)
 	if err != nil {
-
 		logger.Info("[txl_layer] -> passing up non-matched SIP response")
 		// RFC 3261 - 17.1.1.2.
 		// Not matched responses should be passed directly to the UA
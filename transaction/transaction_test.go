@@ -0,0 +1,264 @@
+package transaction
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+	"github.com/zenghr0820/gsip/transport"
+)
+
+// 等待发送以及接收消息的超时时间
+const testTimeout = 2 * time.Second
+
+// 测试使用的传输层：记录发送的消息，通过 receive 注入接收的消息
+type testTransport struct {
+	messages chan sip.Message
+	sent     chan sip.Message
+	errs     chan error
+	done     chan struct{}
+	reliable bool
+
+	closeOnce sync.Once
+}
+
+func newTestTransport(reliable bool) *testTransport {
+	return &testTransport{
+		messages: make(chan sip.Message, 64),
+		sent:     make(chan sip.Message, 64),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+		reliable: reliable,
+	}
+}
+
+func (tp *testTransport) Init(opts ...transport.Option) {}
+
+func (tp *testTransport) IsReliable(network string) bool {
+	return tp.reliable
+}
+
+func (tp *testTransport) Listen(network string, addr string) error {
+	return nil
+}
+
+func (tp *testTransport) GetMessage() <-chan sip.Message {
+	return tp.messages
+}
+
+func (tp *testTransport) Errors() <-chan error {
+	return tp.errs
+}
+
+// 记录发送时的消息内容，之后对消息的修改不影响记录
+func (tp *testTransport) Send(message sip.Message) error {
+	msg, err := sip.ParseMessage([]byte(message.String()))
+	if err != nil {
+		return err
+	}
+	tp.sent <- msg
+
+	return nil
+}
+
+func (tp *testTransport) Resolve(req sip.Request) ([]transport.Target, error) {
+	return []transport.Target{{Network: "udp", Addr: &sip.Addr{Host: "192.0.2.2"}}}, nil
+}
+
+func (tp *testTransport) SendTo(req sip.Request, target transport.Target) error {
+	return tp.Send(req)
+}
+
+func (tp *testTransport) LocalIP() net.IP {
+	return net.ParseIP("192.0.2.1")
+}
+
+func (tp *testTransport) Close() {
+	tp.closeOnce.Do(func() {
+		close(tp.done)
+	})
+}
+
+func (tp *testTransport) Done() <-chan struct{} {
+	return tp.done
+}
+
+// 接收对方发送的消息
+func (tp *testTransport) receive(t *testing.T, message sip.Message) {
+	t.Helper()
+	msg, err := sip.ParseMessage([]byte(message.String()))
+	if err != nil {
+		t.Fatalf("parse %s failed: %s", message.Short(), err)
+	}
+	tp.messages <- msg
+}
+
+// 下一个发送的消息
+func (tp *testTransport) next(t *testing.T) sip.Message {
+	t.Helper()
+	select {
+	case msg := <-tp.sent:
+		return msg
+	case <-time.After(testTimeout):
+		t.Fatal("no message sent")
+	}
+
+	return nil
+}
+
+// 下一个发送的消息是 method 请求
+func (tp *testTransport) nextRequest(t *testing.T, method sip.RequestMethod) sip.Request {
+	t.Helper()
+	msg := tp.next(t)
+	req, ok := msg.(sip.Request)
+	if !ok || req.Method() != method {
+		t.Fatalf("sent %s, want %s", msg.Short(), method)
+	}
+
+	return req
+}
+
+// 接下来发送的请求是 methods 中的请求，不区分发送顺序
+func (tp *testTransport) nextRequests(t *testing.T, methods ...sip.RequestMethod) map[sip.RequestMethod]sip.Request {
+	t.Helper()
+	requests := make(map[sip.RequestMethod]sip.Request)
+	for range methods {
+		msg := tp.next(t)
+		req, ok := msg.(sip.Request)
+		if !ok {
+			t.Fatalf("sent %s, want %v", msg.Short(), methods)
+		}
+		requests[req.Method()] = req
+	}
+	for _, method := range methods {
+		if _, ok := requests[method]; !ok {
+			t.Fatalf("%s not sent", method)
+		}
+	}
+
+	return requests
+}
+
+// 下一个发送的消息是 status 响应
+func (tp *testTransport) nextResponse(t *testing.T, status sip.StatusCode) sip.Response {
+	t.Helper()
+	msg := tp.next(t)
+	res, ok := msg.(sip.Response)
+	if !ok || res.StatusCode() != status {
+		t.Fatalf("sent %s, want %d", msg.Short(), status)
+	}
+
+	return res
+}
+
+// d 时间内没有发送消息
+func (tp *testTransport) expectNone(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case msg := <-tp.sent:
+		t.Fatalf("unexpected %s sent", msg.Short())
+	case <-time.After(d):
+	}
+}
+
+// 使用测试传输层的事务层，测试结束时关闭
+func createTestLayer(t *testing.T, reliable bool, opts ...Option) (*layer, *testTransport) {
+	tp := newTestTransport(reliable)
+	txl := CreateLayer(tp, opts...).(*layer)
+	t.Cleanup(txl.Close)
+
+	return txl, tp
+}
+
+// 解析测试消息，lines 为起始行以及头部，自动添加 Content-Length
+func parseMessage(t *testing.T, body string, lines ...string) sip.Message {
+	t.Helper()
+	data := strings.Join(lines, "\r\n") + fmt.Sprintf("\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	msg, err := sip.ParseMessage([]byte(data))
+	if err != nil {
+		t.Fatalf("parse message failed: %s", err)
+	}
+
+	return msg
+}
+
+// alice(对方)发送给 bob(本端)的请求，toTag 不为空时为对话内请求
+func remoteRequest(t *testing.T, method sip.RequestMethod, branch string, seq uint32, toTag string, headers ...string) sip.Request {
+	t.Helper()
+	to := "To: <sip:bob@192.0.2.1>"
+	if toTag != "" {
+		to += ";tag=" + toTag
+	}
+	lines := append([]string{
+		fmt.Sprintf("%s sip:bob@192.0.2.1 SIP/2.0", method),
+		"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK" + branch,
+		"From: <sip:alice@192.0.2.2>;tag=alice",
+		to,
+		"Call-ID: remote-call",
+		fmt.Sprintf("CSeq: %d %s", seq, method),
+		"Contact: <sip:alice@192.0.2.2>",
+		"Max-Forwards: 70",
+	}, headers...)
+
+	return parseMessage(t, "", lines...).(sip.Request)
+}
+
+// bob(本端)发送给 alice(对方)的请求
+func localRequest(t *testing.T, method sip.RequestMethod, body string, headers ...string) sip.Request {
+	t.Helper()
+	lines := append([]string{
+		fmt.Sprintf("%s sip:alice@192.0.2.2 SIP/2.0", method),
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=" + sip.GenerateBranch(),
+		"From: <sip:bob@192.0.2.1>;tag=bob",
+		"To: <sip:alice@192.0.2.2>",
+		"Call-ID: local-call",
+		fmt.Sprintf("CSeq: 1 %s", method),
+		"Contact: <sip:bob@192.0.2.1>",
+		"Max-Forwards: 70",
+	}, headers...)
+
+	return parseMessage(t, body, lines...).(sip.Request)
+}
+
+// 请求的响应，toTag 不为空时作为 To tag，同一事务的响应使用相同的 tag
+func answer(req sip.Request, status sip.StatusCode, toTag string, headers ...sip.Header) sip.Response {
+	res := req.CreateResponse(status)
+	if toTag != "" {
+		res.To().Params.Add("tag", sip.String{Str: toTag})
+	}
+	for _, header := range headers {
+		res.AddHeader(header)
+	}
+
+	return res
+}
+
+// 下一个传递给上层的请求
+func nextUpRequest(t *testing.T, txl *layer) sip.Request {
+	t.Helper()
+	select {
+	case req := <-txl.Requests():
+		return req
+	case <-time.After(testTimeout):
+		t.Fatal("no request passed up")
+	}
+
+	return nil
+}
+
+// 下一个传递给上层的响应
+func nextUpResponse(t *testing.T, txl *layer) sip.Response {
+	t.Helper()
+	select {
+	case res := <-txl.Responses():
+		return res
+	case <-time.After(testTimeout):
+		t.Fatal("no response passed up")
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package transaction
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// UAC 核心在收到 INVITE 的第一个 2xx 之后保存 64*T1，用于重发 ACK 以及处理分叉的 2xx RFC 3261 - 13.2.2.4，
+// 客户端事务在 Accepted 状态(RFC 6026)将重发以及分叉的 2xx 传递给 UAC 核心
+type inviteSuccess struct {
+	invite sip.Request
+	// 是否自动发送 ACK，内部发送的 INVITE 总是自动发送
+	autoAck bool
+	// 2xx 是否传递给上层
+	passUp bool
+	// 每个 To tag 对应的 ACK，上层尚未发送 ACK 时为 nil
+	acks map[string]sip.Request
+}
+
+// 保存 INVITE 的 2xx 状态
+type inviteSuccessPool struct {
+	invites map[TxKey]*inviteSuccess
+
+	mu sync.Mutex
+}
+
+func createInviteSuccessPool() *inviteSuccessPool {
+	return &inviteSuccessPool{
+		invites: make(map[TxKey]*inviteSuccess),
+	}
+}
+
+// 返回客户端事务对应的状态，不存在时创建，定时器 M 之后删除
+func (pool *inviteSuccessPool) getOrCreate(tx ClientTx, autoAck bool, passUp bool, timeM time.Duration) *inviteSuccess {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	key := tx.Key()
+	if entry, ok := pool.invites[key]; ok {
+		return entry
+	}
+
+	entry := &inviteSuccess{
+		invite:  tx.Origin(),
+		autoAck: autoAck,
+		passUp:  passUp,
+		acks:    make(map[string]sip.Request),
+	}
+	pool.invites[key] = entry
+	time.AfterFunc(timeM, func() {
+		pool.mu.Lock()
+		delete(pool.invites, key)
+		pool.mu.Unlock()
+	})
+
+	return entry
+}
+
+// 记录收到 2xx 的 To tag，返回该 To tag 是否第一次收到 2xx、是否为分叉的 2xx 以及需要重发的 ACK
+func (pool *inviteSuccessPool) receive(entry *inviteSuccess, toTag string) (first bool, fork bool, ack sip.Request) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if ack, ok := entry.acks[toTag]; ok {
+		return false, false, ack
+	}
+	entry.acks[toTag] = nil
+
+	return true, len(entry.acks) > 1, nil
+}
+
+func (pool *inviteSuccessPool) setAck(entry *inviteSuccess, toTag string, ack sip.Request) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	entry.acks[toTag] = ack
+}
+
+// 上层发送的 2xx 的 ACK：按 Call-ID、From tag 以及 CSeq 序号找到对应的 INVITE，2xx 重发时重发该 ACK
+func (pool *inviteSuccessPool) recordAck(ack sip.Request) {
+	cseq := ack.CSeq()
+	if cseq == nil {
+		return
+	}
+	callID, fromTag := callLeg(ack)
+	toTag := headerTag(ack.To())
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for _, entry := range pool.invites {
+		inviteCallID, inviteFromTag := callLeg(entry.invite)
+		if inviteCallID != callID || inviteFromTag != fromTag {
+			continue
+		}
+		if inviteCSeq := entry.invite.CSeq(); inviteCSeq == nil || inviteCSeq.SeqNo != cseq.SeqNo {
+			continue
+		}
+		entry.acks[toTag] = ack
+	}
+}
+
+// 生成对话内 2xx 的 ACK RFC 3261 - 13.2.2.4：CSeq 序号与 INVITE 相同，携带 INVITE 的认证头部
+func createAck(dialog sip.Dialog, invite sip.Request) sip.Request {
+	ack := dialog.CreateRequest(sip.ACK)
+	if cseq := invite.CSeq(); cseq != nil {
+		ack.ReplaceHeader(&sip.CSeq{SeqNo: cseq.SeqNo, MethodName: sip.ACK})
+	}
+	sip.CopyHeaders("Authorization", invite, ack)
+	sip.CopyHeaders("Proxy-Authorization", invite, ack)
+
+	return ack
+}
+
+// To 头部的 tag
+func headerTag(to *sip.ToHeader) string {
+	if to == nil || to.Params == nil {
+		return ""
+	}
+	if tag, ok := to.Params.Get("tag"); ok && tag != nil {
+		return tag.String()
+	}
+
+	return ""
+}
+
+// 客户端事务收到 INVITE 的 2xx，返回是否传递给上层
+func (txl *layer) handleInviteSuccess(tx ClientTx, res sip.Response, passUp bool) bool {
+	timeM := txl.opts.timers.timeM()
+	if ctx, ok := tx.(*clientTx); ok {
+		timeM = ctx.timers.timeM()
+	}
+	entry := txl.invites.getOrCreate(tx, txl.opts.autoAck || !passUp, passUp, timeM)
+
+	return txl.acknowledgeSuccess(entry, res)
+}
+
+// 处理 INVITE 的 2xx RFC 3261 - 13.2.2.4，返回是否传递给上层：
+// 每个不同 To tag 的 2xx 对应一个对话，自动发送 ACK 时为该对话发送 ACK；重发的 2xx 重发 ACK，不传递给上层；
+// 不接受分叉时，后续不同 To tag 的 2xx 发送 ACK 之后立即发送 BYE 终止对话
+func (txl *layer) acknowledgeSuccess(entry *inviteSuccess, res sip.Response) bool {
+	toTag := headerTag(res.To())
+	first, fork, ack := txl.invites.receive(entry, toTag)
+	if !first {
+		if ack != nil {
+			logger.Debugf("[txl_layer] -> retransmit ACK for retransmitted %s", res.Short())
+			if err := txl.tpl.Send(ack); err != nil {
+				logger.Warnf("[txl_layer] -> retransmit ACK for %s failed: %s", res.Short(), err)
+			}
+		}
+		return false
+	}
+
+	dialog := txl.dialogs.get(sip.ReceivedDialogID(res))
+	reject := fork && !txl.opts.acceptForks
+	if dialog == nil || (!entry.autoAck && !reject) {
+		return entry.passUp
+	}
+
+	ack = createAck(dialog, entry.invite)
+	txl.invites.setAck(entry, toTag, ack)
+	if err := txl.tpl.Send(ack); err != nil {
+		logger.Warnf("[txl_layer] -> send ACK for %s failed: %s", res.Short(), err)
+	}
+
+	if reject {
+		logger.Infof("[txl_layer] -> terminate forked dialog %s", dialog.ID())
+		if _, err := txl.startClientTx(dialog.CreateRequest(sip.BYE), false); err != nil {
+			logger.Warnf("[txl_layer] -> send BYE for forked dialog %s failed: %s", dialog.ID(), err)
+		}
+		dialog.Close()
+		return false
+	}
+
+	return entry.passUp
+}
@@ -0,0 +1,106 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 对方 2xx 的 Contact
+func remoteContact(t *testing.T, uri string) *sip.ContactHeader {
+	t.Helper()
+	address, err := sip.ParseUri(uri)
+	if err != nil {
+		t.Fatalf("parse %s failed: %s", uri, err)
+	}
+
+	return &sip.ContactHeader{Address: address, Params: sip.NewParams()}
+}
+
+// 自动为 2xx 发送 ACK：发送到 Contact，CSeq 序号与 INVITE 相同，使用新的 branch RFC 3261 - 13.2.2.4
+func TestAutoAck(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+	_, invite := sendInvite(t, txl, tp)
+
+	tp.receive(t, answer(invite, sip.StatusOK, "alice", remoteContact(t, "sip:alice@192.0.2.3")))
+	if res := nextUpResponse(t, txl); res.StatusCode() != sip.StatusOK {
+		t.Fatalf("passed up %s", res.Short())
+	}
+	ack := tp.nextRequest(t, sip.ACK)
+	if uri := ack.Recipient().String(); uri != "sip:alice@192.0.2.3" {
+		t.Errorf("ACK Request-URI = %s, want the Contact of 2xx", uri)
+	}
+	if cseq := ack.CSeq(); cseq.SeqNo != invite.CSeq().SeqNo || cseq.MethodName != sip.ACK {
+		t.Errorf("ACK CSeq = %s, want %d ACK", cseq, invite.CSeq().SeqNo)
+	}
+	if headerTag(ack.To()) != "alice" || ack.CallID().String() != invite.CallID().String() {
+		t.Errorf("ACK %s is not in the dialog of 2xx", ack.Short())
+	}
+	ackVia, _ := ack.ViaHop()
+	inviteVia, _ := invite.ViaHop()
+	if viaBranch(ackVia) == viaBranch(inviteVia) {
+		t.Error("ACK of 2xx uses the branch of INVITE")
+	}
+}
+
+// 手动发送 ACK 时不自动发送 ACK
+func TestManualAck(t *testing.T) {
+	txl, tp := createTestLayer(t, true, ManualAck())
+	_, invite := sendInvite(t, txl, tp)
+
+	tp.receive(t, answer(invite, sip.StatusOK, "alice"))
+	nextUpResponse(t, txl)
+	tp.expectNone(t, 100*time.Millisecond)
+}
+
+// 默认不接受分叉：不同 To tag 的 2xx 发送 ACK 之后发送 BYE，不传递给上层，分叉对话终止
+func TestForkedSuccess(t *testing.T) {
+	txl, tp := createTestLayer(t, true)
+	_, invite := sendInvite(t, txl, tp)
+
+	tp.receive(t, answer(invite, sip.StatusOK, "alice"))
+	nextUpResponse(t, txl)
+	tp.nextRequest(t, sip.ACK)
+
+	fork := answer(invite, sip.StatusOK, "alice-fork")
+	tp.receive(t, fork)
+	requests := tp.nextRequests(t, sip.ACK, sip.BYE)
+	for method, req := range requests {
+		if headerTag(req.To()) != "alice-fork" {
+			t.Errorf("%s To tag = %s, want alice-fork", method, headerTag(req.To()))
+		}
+	}
+	select {
+	case res := <-txl.Responses():
+		t.Errorf("forked %s passed up", res.Short())
+	case <-time.After(100 * time.Millisecond):
+	}
+	if dialog := txl.DialogOf(fork); dialog != nil {
+		select {
+		case <-dialog.Done():
+		case <-time.After(testTimeout):
+			t.Error("forked dialog not terminated")
+		}
+	}
+	if txl.DialogOf(answer(invite, sip.StatusOK, "alice")) == nil {
+		t.Error("first dialog terminated")
+	}
+}
+
+// 接受分叉时不同 To tag 的 2xx 分别发送 ACK 并传递给上层
+func TestAcceptForks(t *testing.T) {
+	txl, tp := createTestLayer(t, true, AcceptForks())
+	_, invite := sendInvite(t, txl, tp)
+
+	for _, tag := range []string{"alice", "alice-fork"} {
+		tp.receive(t, answer(invite, sip.StatusOK, tag))
+		if res := nextUpResponse(t, txl); headerTag(res.To()) != tag {
+			t.Errorf("passed up To tag %s, want %s", headerTag(res.To()), tag)
+		}
+		if ack := tp.nextRequest(t, sip.ACK); headerTag(ack.To()) != tag {
+			t.Errorf("ACK To tag = %s, want %s", headerTag(ack.To()), tag)
+		}
+	}
+	tp.expectNone(t, 100*time.Millisecond)
+}
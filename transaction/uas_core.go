@@ -0,0 +1,179 @@
+package transaction
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// INVITE 服务端事务发送 2xx 之后终止，由 UAS 核心重发 2xx 直到收到 ACK RFC 3261 - 13.3.1.4
+type pendingSuccess struct {
+	tx  ServerTx
+	res sip.Response
+	// 是否已经收到 ACK
+	acked bool
+
+	// 服务端事务的定时器，重发间隔从 T1 开始每次加倍，最大为 T2
+	timers   timerConfig
+	interval time.Duration
+	// 64*T1 之后仍未收到 ACK 时终止对话
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// 保存等待 ACK 的 2xx，按对话 ID 以及 CSeq 序号匹配 ACK
+type pendingSuccessPool struct {
+	pending map[string]*pendingSuccess
+
+	mu sync.Mutex
+}
+
+func createPendingSuccessPool() *pendingSuccessPool {
+	return &pendingSuccessPool{
+		pending: make(map[string]*pendingSuccess),
+	}
+}
+
+func pendingSuccessKey(dialogID string, cseq *sip.CSeq) string {
+	return fmt.Sprintf("%s__%d", dialogID, cseq.SeqNo)
+}
+
+// 上层发送 INVITE 的 2xx 时开始重发
+func (txl *layer) retransmitSuccess(tx ServerTx, res sip.Response) {
+	cseq := res.CSeq()
+	if cseq == nil || !hasToTag(res) {
+		return
+	}
+	timers := txl.opts.timers
+	if stx, ok := tx.(*serverTx); ok {
+		timers = stx.timers
+	}
+	key := pendingSuccessKey(sip.SentDialogID(res), cseq)
+	entry := &pendingSuccess{
+		tx:       tx,
+		res:      res,
+		timers:   timers,
+		interval: timers.t1,
+		deadline: time.Now().Add(64 * timers.t1),
+	}
+
+	pool := txl.successes
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if old, ok := pool.pending[key]; ok && old.timer != nil {
+		old.timer.Stop()
+	}
+	pool.pending[key] = entry
+	entry.timer = time.AfterFunc(entry.interval, func() {
+		txl.retransmitPending(key, entry)
+	})
+}
+
+// 重发 2xx，64*T1 之后仍未收到 ACK 时发送 BYE 终止对话并通知上层
+func (txl *layer) retransmitPending(key string, entry *pendingSuccess) {
+	pool := txl.successes
+	pool.mu.Lock()
+	if pool.pending[key] != entry {
+		pool.mu.Unlock()
+		return
+	}
+	if entry.acked {
+		delete(pool.pending, key)
+		pool.mu.Unlock()
+		return
+	}
+	select {
+	case <-txl.canceled:
+		delete(pool.pending, key)
+		pool.mu.Unlock()
+		return
+	default:
+	}
+	dialog := txl.dialogs.get(sip.SentDialogID(entry.res))
+	if dialog == nil || dialog.State() == sip.DialogStateTerminated {
+		delete(pool.pending, key)
+		pool.mu.Unlock()
+		return
+	}
+	if time.Now().After(entry.deadline) {
+		delete(pool.pending, key)
+		entry.timer = nil
+		pool.mu.Unlock()
+
+		txl.ackTimeout(entry, dialog)
+		return
+	}
+	entry.interval *= 2
+	if entry.interval > entry.timers.t2 {
+		entry.interval = entry.timers.t2
+	}
+	entry.timer = time.AfterFunc(entry.interval, func() {
+		txl.retransmitPending(key, entry)
+	})
+	pool.mu.Unlock()
+
+	logger.Debugf("[txl_layer] -> retransmit %s, waiting for ACK", entry.res.Short())
+	if err := txl.tpl.Send(entry.res); err != nil {
+		logger.Warnf("[txl_layer] -> retransmit %s failed: %s", entry.res.Short(), err)
+	}
+}
+
+// 没有收到 ACK：对话已确认但会话应当终止，发送 BYE RFC 3261 - 13.3.1.4，
+// 以 408 超时异常终止对话(Dialog.Err)并通知上层
+func (txl *layer) ackTimeout(entry *pendingSuccess, dialog sip.Dialog) {
+	logger.Warnf("[txl_layer] -> no ACK received for %s, send BYE", entry.res.Short())
+	timeoutErr := &TxTimeoutError{
+		Err: fmt.Errorf("%d %s: no ACK received for %s, dialog %s terminated",
+			sip.StatusRequestTimeout, "Request Timeout", entry.res.Short(), dialog.ID()),
+		TxKey: entry.tx.Key(),
+		TxPtr: fmt.Sprintf("%p", entry.tx),
+	}
+
+	bye := dialog.CreateRequest(sip.BYE)
+	if _, err := txl.startClientTx(bye, false); err != nil {
+		logger.Errorf("[txl_layer] -> send BYE for dialog %s failed: %s", dialog.ID(), err)
+	}
+	dialog.CloseWithError(timeoutErr)
+
+	select {
+	case <-txl.canceled:
+	case txl.errs <- timeoutErr:
+	}
+}
+
+// 2xx 的 ACK 停止重发，返回是否匹配以及是否为第一个 ACK；
+// 匹配的 ACK 设置对应的 INVITE 服务端事务，之后重发的 ACK 不再传递给上层
+func (txl *layer) confirmSuccess(ack sip.Request) (matched bool, first bool) {
+	cseq := ack.CSeq()
+	if cseq == nil {
+		return false, false
+	}
+	key := pendingSuccessKey(sip.ReceivedDialogID(ack), cseq)
+
+	pool := txl.successes
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	entry, ok := pool.pending[key]
+	if !ok {
+		return false, false
+	}
+	if entry.acked {
+		return true, false
+	}
+	entry.acked = true
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	// 保留到 64*T1，用于丢弃重发的 ACK
+	entry.timer = time.AfterFunc(time.Until(entry.deadline), func() {
+		txl.retransmitPending(key, entry)
+	})
+	ack.SetTransaction(entry.tx)
+
+	return true, true
+}
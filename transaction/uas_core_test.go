@@ -0,0 +1,109 @@
+package transaction
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 没有收到 ACK 时 2xx 的重发间隔从 T1 加倍到 T2，64*T1 之后发送 BYE 并以超时异常终止对话
+func TestRetransmitSuccessWithoutAck(t *testing.T) {
+	const (
+		t1 = 20 * time.Millisecond
+		t2 = 80 * time.Millisecond
+	)
+	txl, tp := createTestLayer(t, false, Timers(TimerT1(t1), TimerT2(t2)))
+
+	tp.receive(t, remoteRequest(t, sip.INVITE, "no-ack", 1, ""))
+	req := nextUpRequest(t, txl)
+	res := answer(req, sip.StatusOK, "bob")
+	if err := req.Transaction().(sip.ServerTransaction).SendResponse(res); err != nil {
+		t.Fatalf("send 200 failed: %s", err)
+	}
+	tp.nextResponse(t, sip.StatusOK)
+	start := time.Now()
+	dialog := txl.DialogOf(res)
+	if dialog == nil {
+		t.Fatal("no dialog created")
+	}
+
+	sent := []time.Time{start}
+	for {
+		msg := tp.next(t)
+		if req, ok := msg.(sip.Request); ok {
+			if req.Method() != sip.BYE {
+				t.Fatalf("sent %s, want BYE", req.Short())
+			}
+			break
+		}
+		if res, ok := msg.(sip.Response); !ok || res.StatusCode() != sip.StatusOK {
+			t.Fatalf("sent %s, want 200", msg.Short())
+		}
+		sent = append(sent, time.Now())
+	}
+	if elapsed := time.Since(start); elapsed < 64*t1 {
+		t.Errorf("BYE sent after %s, want 64*T1", elapsed)
+	}
+
+	// 允许定时器的误差
+	const tolerance = 10 * time.Millisecond
+	interval := t1
+	for i := 1; i < len(sent); i++ {
+		gap := sent[i].Sub(sent[i-1])
+		if gap < interval-tolerance || gap > interval+4*tolerance {
+			t.Errorf("retransmission %d after %s, want %s", i, gap, interval)
+		}
+		if interval *= 2; interval > t2 {
+			interval = t2
+		}
+	}
+	if len(sent) < 10 {
+		t.Errorf("%d retransmissions, want backing off to T2", len(sent)-1)
+	}
+
+	select {
+	case <-dialog.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("dialog not terminated")
+	}
+	var timeoutErr *TxTimeoutError
+	if !errors.As(dialog.Err(), &timeoutErr) {
+		t.Errorf("dialog error = %v, want timeout", dialog.Err())
+	}
+	select {
+	case err := <-txl.Errors():
+		if !errors.As(err, &timeoutErr) {
+			t.Errorf("layer error = %v, want timeout", err)
+		}
+	case <-time.After(testTimeout):
+		t.Error("timeout not reported")
+	}
+}
+
+// 收到 ACK 之后停止重发 2xx，对话正常
+func TestRetransmitSuccessUntilAck(t *testing.T) {
+	txl, tp := createTestLayer(t, false, Timers(TimerT1(20*time.Millisecond)))
+
+	tp.receive(t, remoteRequest(t, sip.INVITE, "ack", 1, ""))
+	req := nextUpRequest(t, txl)
+	res := answer(req, sip.StatusOK, "bob")
+	if err := req.Transaction().(sip.ServerTransaction).SendResponse(res); err != nil {
+		t.Fatalf("send 200 failed: %s", err)
+	}
+	tp.nextResponse(t, sip.StatusOK)
+	tp.nextResponse(t, sip.StatusOK)
+
+	tp.receive(t, remoteRequest(t, sip.ACK, "ack-2xx", 1, headerTag(res.To())))
+	if up := nextUpRequest(t, txl); !up.IsAck() {
+		t.Fatalf("passed up %s, want ACK", up.Short())
+	}
+	for len(tp.sent) > 0 {
+		tp.nextResponse(t, sip.StatusOK)
+	}
+	tp.expectNone(t, 64*20*time.Millisecond)
+	if dialog := txl.DialogOf(res); dialog == nil || dialog.State() != sip.DialogStateConfirmed || dialog.Err() != nil {
+		t.Errorf("dialog = %v after ACK", dialog)
+	}
+}
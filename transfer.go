@@ -0,0 +1,533 @@
+package gsip
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 转移进度的通知 RFC 3515 - 2.4.5，来自隐式订阅的 NOTIFY
+type TransferEvent struct {
+	// sipfrag 中转移目标的响应状态码，还没有收到 sipfrag 时为 0
+	StatusCode sip.StatusCode
+	Reason     string
+	// 隐式订阅已经终止，之后不再有通知
+	Final  bool
+	Notify sip.Request
+	Err    error
+}
+
+// 转移目标是否接受了 INVITE
+func (event TransferEvent) Succeeded() bool {
+	return event.StatusCode >= 200 && event.StatusCode < 300
+}
+
+// 转移的配置选项
+type TransferOptions struct {
+	contact sip.Uri
+	handler func(event TransferEvent)
+}
+
+type TransferOption func(*TransferOptions)
+
+func newTransferOptions(opts ...TransferOption) TransferOptions {
+	opt := TransferOptions{}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
+// REFER 的联系地址，默认为对话的本地 URI 用户名@本地 IP
+func TransferContact(contact sip.Uri) TransferOption {
+	return func(o *TransferOptions) {
+		o.contact = contact
+	}
+}
+
+// 转移进度的回调函数，也可以通过 Transfer.Events 接收
+func TransferCallback(handler func(event TransferEvent)) TransferOption {
+	return func(o *TransferOptions) {
+		o.handler = handler
+	}
+}
+
+// 转移方发送的 REFER RFC 3515 - 2.4.1，通过隐式订阅的 NOTIFY 跟踪被转移方呼叫转移目标的结果
+type Transfer struct {
+	service  *service
+	dialog   sip.Dialog
+	request  sip.Request
+	response sip.Response
+	opts     TransferOptions
+	key      string
+	// 最后一个通知的进度
+	result TransferEvent
+
+	notifies chan sip.Request
+	events   chan TransferEvent
+	done     chan struct{}
+	mu       sync.RWMutex
+}
+
+// 发送的 REFER
+func (transfer *Transfer) Request() sip.Request {
+	return transfer.request
+}
+
+// REFER 的 2xx，通常为 202 Accepted
+func (transfer *Transfer) Response() sip.Response {
+	return transfer.response
+}
+
+// 转移所在的对话
+func (transfer *Transfer) Dialog() sip.Dialog {
+	return transfer.dialog
+}
+
+// 转移进度，未及时接收的事件会被丢弃
+func (transfer *Transfer) Events() <-chan TransferEvent {
+	return transfer.events
+}
+
+// 隐式订阅终止
+func (transfer *Transfer) Done() <-chan struct{} {
+	return transfer.done
+}
+
+// 最后一个通知的进度，Done 之后为转移的结果
+func (transfer *Transfer) Result() TransferEvent {
+	transfer.mu.RLock()
+	defer transfer.mu.RUnlock()
+
+	return transfer.result
+}
+
+// 接收隐式订阅的通知，直到订阅终止、对话终止或者订阅过期
+func (transfer *Transfer) run() {
+	defer close(transfer.done)
+	defer transfer.service.removeTransfer(transfer)
+
+	timer := time.NewTimer(time.Duration(ReferPackage.DefaultExpires()) * time.Second)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-transfer.dialog.Done():
+			err := fmt.Errorf("[G.SIP] -> dialog %s of transfer terminated", transfer.dialog.ID())
+			transfer.emit(TransferEvent{Final: true, Err: err})
+			return
+		case notify := <-transfer.notifies:
+			state := sip.SubscriptionState(notify)
+			event := TransferEvent{Notify: notify}
+			event.StatusCode, event.Reason = sipfragStatus(notify)
+			if state.State == sip.SubscriptionTerminated {
+				event.Final = true
+				transfer.emit(event)
+				return
+			}
+			if expires, ok := state.Expires(); ok {
+				resetTimer(timer, time.Duration(expires)*time.Second)
+			}
+			transfer.emit(event)
+		case <-timer.C:
+			err := fmt.Errorf("[G.SIP] -> transfer to %s expired without final notify", transfer.dialog.RemoteUri())
+			logger.Warn(err)
+			transfer.emit(TransferEvent{Final: true, Err: err})
+			return
+		}
+	}
+}
+
+// 通知没有 sipfrag 时沿用上一个通知的状态码
+func (transfer *Transfer) emit(event TransferEvent) {
+	transfer.mu.Lock()
+	if event.StatusCode == 0 {
+		event.StatusCode, event.Reason = transfer.result.StatusCode, transfer.result.Reason
+	}
+	transfer.result = event
+	transfer.mu.Unlock()
+
+	select {
+	case transfer.events <- event:
+	default:
+	}
+	if transfer.opts.handler != nil {
+		transfer.opts.handler(event)
+	}
+}
+
+// 校验收到的 NOTIFY，返回响应的状态码，没有 Subscription-State 时返回 400
+func (transfer *Transfer) receiveNotify(notify sip.Request) sip.StatusCode {
+	if sip.SubscriptionState(notify) == nil {
+		return sip.StatusBadRequest
+	}
+
+	select {
+	case transfer.notifies <- notify:
+	default:
+		logger.Warnf("[G.SIP] -> drop %s, transfer is not receiving", notify.Short())
+	}
+
+	return sip.StatusOK
+}
+
+// 盲转：在对话内发送 REFER，由对方呼叫 target RFC 5589 - 6
+func (s *service) Transfer(ctx context.Context, dialog sip.Dialog, target sip.Uri, opts ...TransferOption) (*Transfer, error) {
+	return s.refer(ctx, dialog, target, opts...)
+}
+
+// 咨询转：在对话内发送 REFER，由对方呼叫 replaced 对话的远端目标并替换该对话 RFC 5589 - 7，
+// Refer-To 携带 replaced 对话的 Replaces，转移成功之后转移目标结束 replaced 对话
+func (s *service) AttendedTransfer(ctx context.Context, dialog sip.Dialog, replaced sip.Dialog, opts ...TransferOption) (*Transfer, error) {
+	if replaced == nil || replaced.RemoteTarget() == nil {
+		return nil, fmt.Errorf("[G.SIP] -> replaced dialog has no remote target")
+	}
+
+	return s.refer(ctx, dialog, sip.ReferTarget(replaced.RemoteTarget(), sip.DialogReplaces(replaced, false)), opts...)
+}
+
+// 发送 REFER，2xx 之后开始接收隐式订阅的通知
+func (s *service) refer(ctx context.Context, dialog sip.Dialog, target sip.Uri, opts ...TransferOption) (*Transfer, error) {
+	if dialog == nil || dialog.State() != sip.DialogStateConfirmed {
+		return nil, fmt.Errorf("[G.SIP] -> transfer requires a confirmed dialog")
+	}
+
+	options := newTransferOptions(opts...)
+	contact := options.contact
+	if contact == nil {
+		contact = dialog.LocalUri().Copy()
+		contact.SetDomain(sip.Addr{Host: s.opts.tp.LocalIP().String()})
+		contact.SetUriParams(sip.NewParams())
+	}
+
+	req := dialog.CreateRequest(sip.REFER)
+	req.AddHeader(&sip.ReferToHeader{Address: target, Params: sip.NewParams()})
+	req.AddHeader(&sip.ReferredByHeader{Address: dialog.LocalUri().Copy(), Params: sip.NewParams()})
+	req.AddHeader(&sip.ContactHeader{
+		Address: contact.Copy(),
+		Params:  sip.NewParams(),
+	})
+
+	transfer := &Transfer{
+		service:  s,
+		dialog:   dialog,
+		request:  req,
+		opts:     options,
+		notifies: make(chan sip.Request, 16),
+		events:   make(chan TransferEvent, 16),
+		done:     make(chan struct{}),
+	}
+	// RFC 3515 - 2.4.6 通知的 id 参数为 REFER 的 CSeq
+	transfer.key = subscriptionKey(dialog.ID(), ReferPackage.Name(), fmt.Sprintf("%d", req.CSeq().SeqNo))
+	// 发送之前注册，NOTIFY 可能先于 2xx 到达
+	s.smu.Lock()
+	s.transfers[transfer.key] = transfer
+	s.smu.Unlock()
+
+	res, err := s.Request(ctx, req)
+	if err == nil && !res.IsSuccess() {
+		err = fmt.Errorf("[G.SIP] -> refer %s to %s failed: %d %s", dialog.RemoteUri(), target, res.StatusCode(), res.Reason())
+	}
+	if err != nil {
+		s.removeTransfer(transfer)
+		return nil, err
+	}
+	transfer.response = res
+
+	go transfer.run()
+	return transfer, nil
+}
+
+func (s *service) removeTransfer(transfer *Transfer) {
+	s.smu.Lock()
+	if s.transfers[transfer.key] == transfer {
+		delete(s.transfers, transfer.key)
+	}
+	s.smu.Unlock()
+}
+
+// 将 refer 事件的 NOTIFY 交给对应的转移，返回是否已处理
+func (s *service) handleTransferNotify(notify sip.Request, id string) bool {
+	dialogID := sip.ReceivedDialogID(notify)
+
+	s.smu.RLock()
+	transfer, ok := s.transfers[subscriptionKey(dialogID, ReferPackage.Name(), id)]
+	if !ok && id == "" {
+		// 对话中第一个 REFER 的通知可以不带 id 参数 RFC 3515 - 2.4.6
+		for _, t := range s.transfers {
+			if t.dialog.ID() == dialogID {
+				transfer, ok = t, true
+				break
+			}
+		}
+	}
+	s.smu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	status := transfer.receiveNotify(notify)
+	if _, err := s.Send(notify.CreateResponse(status)); err != nil {
+		logger.Errorf("[G.SIP] -> send %d for %s failed: %s", status, notify.Short(), err)
+	}
+
+	return true
+}
+
+// 处理收到的 REFER，返回错误时以 603 拒绝；
+// 可以修改 Referral.Invite 发送给转移目标的 INVITE，例如设置 SDP 以及 Contact
+type ReferHandler func(referral *Referral) error
+
+// 被转移方收到的 REFER RFC 3515 - 2.4.2：
+// 接受之后呼叫 Refer-To 的目标，通过隐式订阅的 sipfrag NOTIFY 向转移方报告进度
+type Referral struct {
+	service *service
+	request sip.Request
+	// 去掉 URI 头部之后的 Refer-To
+	target   sip.Uri
+	replaces *sip.ReplacesHeader
+	invite   sip.Request
+	dialog   sip.Dialog
+	// 隐式订阅
+	subscriber *Subscriber
+	response   sip.Response
+	err        error
+
+	done chan struct{}
+	mu   sync.RWMutex
+}
+
+// 收到的 REFER
+func (referral *Referral) Request() sip.Request {
+	return referral.request
+}
+
+// 转移目标
+func (referral *Referral) Target() sip.Uri {
+	return referral.target
+}
+
+// Refer-To 中的 Replaces，咨询转移时不为 nil
+func (referral *Referral) Replaces() *sip.ReplacesHeader {
+	return referral.replaces
+}
+
+// 发送给转移目标的 INVITE，在处理函数中修改
+func (referral *Referral) Invite() sip.Request {
+	return referral.invite
+}
+
+// REFER 所在的对话，对话外的 REFER 在接受之前为 nil
+func (referral *Referral) Dialog() sip.Dialog {
+	referral.mu.RLock()
+	defer referral.mu.RUnlock()
+
+	return referral.dialog
+}
+
+// 呼叫转移目标结束
+func (referral *Referral) Done() <-chan struct{} {
+	return referral.done
+}
+
+// 转移目标对 INVITE 的最终响应，Done 之前为 nil
+func (referral *Referral) Response() sip.Response {
+	referral.mu.RLock()
+	defer referral.mu.RUnlock()
+
+	return referral.response
+}
+
+// 呼叫转移目标的错误，Done 之前为 nil
+func (referral *Referral) Err() error {
+	referral.mu.RLock()
+	defer referral.mu.RUnlock()
+
+	return referral.err
+}
+
+// 发送给转移目标的 INVITE RFC 3515 - 2.4.2：
+// From 为对话的本地 URI，Request-URI 以及 To 为转移目标，携带 Refer-To 中的 Replaces 以及 REFER 的 Referred-By
+func (referral *Referral) createInvite() sip.Request {
+	from := referral.request.To().Address
+	if referral.dialog != nil {
+		from = referral.dialog.LocalUri()
+	}
+
+	invite := referral.service.CreateRequest(sip.INVITE, "", from.Copy(), referral.target.Copy())
+	invite.SetRecipient(referral.target.Copy())
+	invite.CSeq().MethodName = sip.INVITE
+	invite.AddHeader(&sip.ContactHeader{
+		Address: referral.request.Recipient().Copy(),
+		Params:  sip.NewParams(),
+	})
+	if referral.replaces != nil {
+		invite.AddHeader(referral.replaces.Copy())
+	}
+	if referredBy := sip.ReferredBy(referral.request); referredBy != nil {
+		invite.AddHeader(referredBy.Copy())
+	}
+
+	return invite
+}
+
+// 呼叫转移目标，每个不同的响应发送一个 sipfrag 通知，最终响应的通知终止隐式订阅 RFC 3515 - 2.4.5；
+// 隐式订阅终止(过期或者服务关闭)时取消 INVITE
+func (referral *Referral) run() {
+	defer close(referral.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-referral.subscriber.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// 接受 REFER 之后立即通知 100 Trying RFC 3515 - 2.4.4
+	referral.notify(sipfrag(sip.StatusTrying, "Trying"))
+	last := sip.StatusTrying
+	res, err := referral.service.Request(ctx, referral.invite, ProvisionalCallback(func(res sip.Response, tx sip.ClientTransaction) {
+		if res.StatusCode() != last {
+			last = res.StatusCode()
+			referral.notify(sipfrag(res.StatusCode(), res.Reason()))
+		}
+	}))
+	if err != nil {
+		logger.Warnf("[G.SIP] -> refer %s to %s failed: %s", referral.request.Short(), referral.target, err)
+	}
+
+	referral.mu.Lock()
+	referral.response, referral.err = res, err
+	referral.mu.Unlock()
+
+	body := sipfrag(sip.StatusServiceUnavailable, "Service Unavailable")
+	if res != nil {
+		body = sipfrag(res.StatusCode(), res.Reason())
+	}
+
+	nctx, ncancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer ncancel()
+
+	referral.subscriber.SetContent(body)
+	if err := referral.subscriber.Terminate(nctx, sip.SubscriptionReasonNoResource); err != nil {
+		logger.Warn(err)
+	}
+}
+
+func (referral *Referral) notify(body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	if err := referral.subscriber.Notify(ctx, body); err != nil {
+		logger.Warn(err)
+	}
+}
+
+// 被转移方处理 REFER RFC 3515 - 2.4.2：
+// Refer-To 不是一个时返回 400，处理函数返回错误时返回 603，
+// 接受之后返回 202 并创建 refer 事件的隐式订阅，id 参数为 REFER 的 CSeq
+func (s *service) handleRefer(request sip.Request, tx sip.ServerTransaction) {
+	referTo := sip.ReferTo(request)
+	if len(request.GetHeaders("Refer-To")) != 1 || referTo == nil || referTo.Address == nil {
+		s.respond(request.CreateResponseReason(sip.StatusBadRequest, "Bad Refer-To"))
+		return
+	}
+	replaces, err := sip.ReferReplaces(referTo.Address)
+	if err != nil {
+		logger.Warnf("[G.SIP] -> bad replaces in %s: %s", request.Short(), err)
+		s.respond(request.CreateResponseReason(sip.StatusBadRequest, "Bad Replaces"))
+		return
+	}
+
+	referral := &Referral{
+		service:  s,
+		request:  request,
+		target:   sip.ReferRecipient(referTo.Address),
+		replaces: replaces,
+		dialog:   s.DialogOf(request),
+		done:     make(chan struct{}),
+	}
+	referral.invite = referral.createInvite()
+	if err := s.opts.referHandler(referral); err != nil {
+		logger.Warnf("[G.SIP] -> refer %s to %s declined: %s", request.Short(), referral.target, err)
+		s.respond(request.CreateResponseReason(sip.StatusDecline, "Decline"))
+		return
+	}
+
+	contact := request.Recipient().Copy()
+	response := request.CreateResponseReason(sip.StatusAccepted, "Accepted")
+	response.AddHeader(&sip.ContactHeader{
+		Address: contact.Copy(),
+		Params:  sip.NewParams(),
+	})
+	if !s.respond(response) {
+		return
+	}
+	dialog := s.DialogOf(response)
+	if dialog == nil {
+		logger.Errorf("[G.SIP] -> no dialog for %s", request.Short())
+		return
+	}
+
+	id := fmt.Sprintf("%d", request.CSeq().SeqNo)
+	subscriber := &Subscriber{
+		service: s,
+		pkg:     ReferPackage,
+		request: request,
+		id:      id,
+		dialog:  dialog,
+		key:     subscriptionKey(dialog.ID(), ReferPackage.Name(), id),
+		contact: contact,
+		// 对话内的 REFER 与邀请会话共用对话
+		sharedDialog: referral.dialog != nil,
+		state:        sip.SubscriptionActive,
+		done:         make(chan struct{}),
+	}
+	s.smu.Lock()
+	s.subscribers[subscriber.key] = subscriber
+	s.smu.Unlock()
+	subscriber.setExpires(ReferPackage.DefaultExpires())
+
+	referral.mu.Lock()
+	referral.dialog = dialog
+	referral.subscriber = subscriber
+	referral.mu.Unlock()
+
+	go referral.run()
+}
+
+// 只能由 REFER 创建隐式订阅，对话外的 refer 事件 SUBSCRIBE 返回 403，对话内的 SUBSCRIBE 刷新隐式订阅
+func rejectReferSubscribe(subscriber *Subscriber) error {
+	return fmt.Errorf("[G.SIP] -> refer subscription must be created by REFER")
+}
+
+// sipfrag 消息体，只包含状态行 RFC 3420
+func sipfrag(code sip.StatusCode, reason string) []byte {
+	return []byte(fmt.Sprintf("%s %d %s\r\n", sip.SipVersion, code, reason))
+}
+
+// 解析 NOTIFY 中 sipfrag 的状态行，没有或者无法解析时返回 0
+func sipfragStatus(notify sip.Request) (sip.StatusCode, string) {
+	body := strings.TrimSpace(string(notify.Body()))
+	if body == "" {
+		return 0, ""
+	}
+	line := strings.TrimSpace(strings.SplitN(body, "\n", 2)[0])
+	_, code, reason, err := sip.ParseStatusLine(line)
+	if err != nil {
+		logger.Warnf("[G.SIP] -> bad sipfrag in %s: %s", notify.Short(), err)
+		return 0, ""
+	}
+
+	return code, reason
+}
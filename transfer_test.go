@@ -0,0 +1,209 @@
+package gsip
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/callback"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 接受呼叫并返回携带 Contact 的 2xx，收到的 INVITE 通过 invites 返回
+func answerCalls(cb callback.Callback, contact sip.Uri, invites chan<- sip.Request) {
+	cb.AddRequestHandle(sip.INVITE, func(req sip.Request, tx sip.ServerTransaction) {
+		if invites != nil {
+			invites <- req
+		}
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusRinging))
+		// 180 先于 2xx 到达
+		time.Sleep(50 * time.Millisecond)
+		res := req.CreateResponse(sip.StatusOK)
+		res.AddHeader(&sip.ContactHeader{Address: contact.Copy(), Params: sip.NewParams()})
+		_ = tx.SendResponse(res)
+	})
+	cb.AddRequestHandle(sip.ACK, func(req sip.Request, tx sip.ServerTransaction) {})
+}
+
+// from 呼叫 to，返回已确认的对话
+func call(t *testing.T, s Service, from sip.Uri, to sip.Uri) sip.Dialog {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	invite := s.CreateRequest(sip.INVITE, to.Domain().Host+":5060", from, to)
+	invite.AddHeader(&sip.ContactHeader{Address: from.Copy(), Params: sip.NewParams()})
+	res, err := s.Request(ctx, invite)
+	if err != nil || !res.IsSuccess() {
+		t.Fatalf("call %s failed: %v, %v", to, res, err)
+	}
+	dialog := s.DialogOf(res)
+	if dialog == nil || dialog.State() != sip.DialogStateConfirmed {
+		t.Fatalf("dialog of %s not confirmed", res.Short())
+	}
+
+	return dialog
+}
+
+func nextTransferEvent(t *testing.T, transfer *Transfer) TransferEvent {
+	t.Helper()
+	select {
+	case event := <-transfer.Events():
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no transfer event")
+	}
+
+	return TransferEvent{}
+}
+
+// 转移方 alice 127.0.7.1 与被转移方 bob 127.0.7.2 通话，bob 接受 REFER 之后呼叫转移目标 carol 127.0.7.3
+func newTransferTest(t *testing.T, handler ReferHandler) (alice Service, aliceCallback callback.Callback, dialog sip.Dialog, carolCallback callback.Callback) {
+	_, bobCallback := newTestService(t, "127.0.7.2", ReferConfig(handler))
+	answerCalls(bobCallback, testUri("bob", "127.0.7.2"), nil)
+	_, carolCallback = newTestService(t, "127.0.7.3")
+	alice, aliceCallback = newTestService(t, "127.0.7.1")
+	dialog = call(t, alice, testUri("alice", "127.0.7.1"), testUri("bob", "127.0.7.2"))
+
+	return
+}
+
+// 盲转：被转移方呼叫 Refer-To 的目标，通过 sipfrag 通知报告 100、180 以及最终的 200
+func TestTransfer(t *testing.T) {
+	referrals := make(chan *Referral, 1)
+	alice, _, dialog, carolCallback := newTransferTest(t, func(referral *Referral) error {
+		referrals <- referral
+		return nil
+	})
+	invites := make(chan sip.Request, 1)
+	answerCalls(carolCallback, testUri("carol", "127.0.7.3"), invites)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	transfer, err := alice.Transfer(ctx, dialog, testUri("carol", "127.0.7.3"))
+	if err != nil {
+		t.Fatalf("transfer failed: %s", err)
+	}
+	if status := transfer.Response().StatusCode(); status != sip.StatusAccepted {
+		t.Errorf("REFER response = %d, want 202", status)
+	}
+
+	for _, status := range []sip.StatusCode{sip.StatusTrying, sip.StatusRinging, sip.StatusOK} {
+		event := nextTransferEvent(t, transfer)
+		if event.StatusCode != status || event.Final != (status == sip.StatusOK) {
+			t.Fatalf("event = %d (final %t), want %d", event.StatusCode, event.Final, status)
+		}
+		if id := sip.Event(event.Notify).ID(); id == "" {
+			t.Error("NOTIFY of refer without id")
+		}
+	}
+	select {
+	case <-transfer.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("transfer not done")
+	}
+	if result := transfer.Result(); !result.Succeeded() {
+		t.Errorf("result = %d, want success", result.StatusCode)
+	}
+
+	invite := receiveRequest(t, invites, sip.INVITE)
+	if user := invite.From().Address.User(); user.String() != "bob" {
+		t.Errorf("INVITE from %s, want bob", invite.From().Address)
+	}
+	if referredBy := sip.ReferredBy(invite); referredBy == nil || referredBy.Address.User().String() != "alice" {
+		t.Errorf("Referred-By = %v, want alice", referredBy)
+	}
+	referral := <-referrals
+	<-referral.Done()
+	if referral.Err() != nil || !referral.Response().IsSuccess() {
+		t.Errorf("referral = %v, %v", referral.Response(), referral.Err())
+	}
+	// 隐式订阅终止之后邀请会话的对话仍然有效
+	if dialog.State() != sip.DialogStateConfirmed {
+		t.Errorf("dialog state = %s after transfer", dialog.State())
+	}
+}
+
+// 处理函数返回错误时以 603 拒绝 REFER
+func TestTransferDeclined(t *testing.T) {
+	alice, _, dialog, _ := newTransferTest(t, func(referral *Referral) error {
+		return errors.New("declined")
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := alice.Transfer(ctx, dialog, testUri("carol", "127.0.7.3")); err == nil {
+		t.Fatal("declined transfer succeeded")
+	}
+}
+
+// 咨询转：被转移方携带 Replaces 呼叫转移目标，转移目标接受之后以 BYE 结束与转移方的对话
+func TestAttendedTransfer(t *testing.T) {
+	referrals := make(chan *Referral, 1)
+	alice, aliceCallback, dialog, carolCallback := newTransferTest(t, func(referral *Referral) error {
+		referrals <- referral
+		return nil
+	})
+	invites := make(chan sip.Request, 2)
+	answerCalls(carolCallback, testUri("carol", "127.0.7.3"), invites)
+	byes := make(chan sip.Request, 1)
+	aliceCallback.AddRequestHandle(sip.BYE, func(req sip.Request, tx sip.ServerTransaction) {
+		_ = tx.SendResponse(req.CreateResponse(sip.StatusOK))
+		byes <- req
+	})
+
+	consultation := call(t, alice, testUri("alice", "127.0.7.1"), testUri("carol", "127.0.7.3"))
+	receiveRequest(t, invites, sip.INVITE)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	transfer, err := alice.AttendedTransfer(ctx, dialog, consultation)
+	if err != nil {
+		t.Fatalf("transfer failed: %s", err)
+	}
+	referral := <-referrals
+	if replaces := referral.Replaces(); replaces == nil || replaces.CallID != string(consultation.CallID()) {
+		t.Fatalf("Replaces = %v, want Call-ID %s", replaces, consultation.CallID())
+	}
+
+	invite := receiveRequest(t, invites, sip.INVITE)
+	replaces := sip.Replaces(invite)
+	if replaces == nil || replaces.ToTag != consultation.RemoteTag() || replaces.FromTag != consultation.LocalTag() {
+		t.Fatalf("Replaces = %v, want the consultation dialog", replaces)
+	}
+	select {
+	case bye := <-byes:
+		if string(*bye.CallID()) != string(consultation.CallID()) {
+			t.Errorf("BYE Call-ID = %s, want %s", bye.CallID(), consultation.CallID())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replaced dialog not terminated")
+	}
+	select {
+	case <-transfer.Done():
+		if !transfer.Result().Succeeded() {
+			t.Errorf("result = %d, want success", transfer.Result().StatusCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("transfer not done")
+	}
+}
+
+func TestSipfragStatus(t *testing.T) {
+	notify := sip.CreateRequest(sip.NOTIFY, "", testUri("bob", "127.0.7.2"), testUri("alice", "127.0.7.1"))
+	notify.SetBody(sipfrag(sip.StatusRinging, "Ringing"), true)
+	if code, reason := sipfragStatus(notify); code != sip.StatusRinging || reason != "Ringing" {
+		t.Errorf("sipfrag = %d %s, want 180 Ringing", code, reason)
+	}
+
+	notify.SetBody([]byte("SIP/2.0 603 Declined\r\nContent-Length: 0\r\n"), true)
+	if code, _ := sipfragStatus(notify); code != sip.StatusDecline {
+		t.Errorf("sipfrag = %d, want 603", code)
+	}
+
+	notify.SetBody(nil, true)
+	if code, _ := sipfragStatus(notify); code != 0 {
+		t.Errorf("empty sipfrag = %d, want 0", code)
+	}
+}
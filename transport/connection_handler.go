@@ -138,6 +138,12 @@ func (handler *connectionHandler) ConnectionServer(done func()) {
 				return
 			}
 
+			// RFC 3261 - 18.2.2 面向连接的传输，响应需通过接收请求的连接返回
+			// RFC 7118 - 5 WebSocket 客户端的 Via 地址通常无法访问(.invalid)
+			if req, ok := msg.(sip.Request); ok && handler.Connection().RemoteAddr() != nil {
+				req.SetSource(handler.Connection().RemoteAddr().String())
+			}
+
 			// pass up
 			select {
 			case <-handler.cancel:
@@ -230,7 +230,7 @@ func (pool *connectionPool) all() []Connection {
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
 
-	connections := make([]Connection, len(pool.handleMap))
+	connections := make([]Connection, 0, len(pool.handleMap))
 	for _, value := range pool.handleMap {
 		connections = append(connections, value.Connection())
 	}
@@ -241,7 +241,7 @@ func (pool *connectionPool) allKeys() []ConnectionKey {
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
 
-	keys := make([]ConnectionKey, len(pool.handleMap))
+	keys := make([]ConnectionKey, 0, len(pool.handleMap))
 	for k := range pool.handleMap {
 		keys = append(keys, k)
 	}
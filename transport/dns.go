@@ -0,0 +1,285 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// DNS 相关常量 RFC 1035
+const (
+	dnsTypeNAPTR uint16 = 35
+	dnsClassINET uint16 = 1
+	// 未配置 DNS 服务器时读取的系统配置
+	dnsResolvConf = "/etc/resolv.conf"
+	// UDP 响应的最大长度(未使用 EDNS)
+	dnsUdpSize = 512
+)
+
+// NAPTR 记录 RFC 3403
+type naptrRecord struct {
+	order       uint16
+	preference  uint16
+	flags       string
+	service     string
+	regexp      string
+	replacement string
+}
+
+/**
+查询 NAPTR 记录：
+	标准库 net.Resolver 不支持 NAPTR 查询，此处直接构造 DNS 报文
+	server：DNS 服务器地址 ip:port
+	name：查询的域名
+*/
+func lookupNAPTR(ctx context.Context, server string, name string) ([]naptrRecord, error) {
+	idBytes := make([]byte, 2)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idBytes)
+	query, err := buildDnsQuery(id, name, dnsTypeNAPTR)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := dnsExchange(ctx, "udp", server, query)
+	if err != nil {
+		return nil, err
+	}
+	// TC 标志，响应被截断时使用 TCP 重新查询
+	if len(res) > 3 && res[2]&0x02 != 0 {
+		if res, err = dnsExchange(ctx, "tcp", server, query); err != nil {
+			return nil, err
+		}
+	}
+
+	return parseNAPTRResponse(id, res)
+}
+
+// 发送查询并读取响应
+func dnsExchange(ctx context.Context, network string, server string, query []byte) ([]byte, error) {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(dnsTimeout))
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, dnsUdpSize)
+		num, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:num], nil
+	}
+
+	// RFC 1035 - 4.2.2 TCP 报文使用两个字节的长度前缀
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// 构造查询报文
+func buildDnsQuery(id uint16, name string, qType uint16) ([]byte, error) {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	// RD: 期望递归查询
+	binary.BigEndian.PutUint16(msg[2:], 0x0100)
+	// QDCOUNT
+	binary.BigEndian.PutUint16(msg[4:], 1)
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("[dns] -> invalid domain name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(msg[len(msg)-4:], qType)
+	binary.BigEndian.PutUint16(msg[len(msg)-2:], dnsClassINET)
+
+	return msg, nil
+}
+
+// 解析 NAPTR 查询响应
+func parseNAPTRResponse(id uint16, msg []byte) ([]naptrRecord, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("[dns] -> response too short")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, errors.New("[dns] -> response id mismatch")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&0x8000 == 0 {
+		return nil, errors.New("[dns] -> message is not a response")
+	}
+	switch rcode := flags & 0x000F; rcode {
+	case 0:
+	case 3:
+		// NXDOMAIN
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("[dns] -> server returned rcode %d", rcode)
+	}
+
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	anCount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	for i := 0; i < qdCount; i++ {
+		_, next, err := readDnsName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+
+	records := make([]naptrRecord, 0, anCount)
+	for i := 0; i < anCount; i++ {
+		_, next, err := readDnsName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		if off+10 > len(msg) {
+			return nil, errors.New("[dns] -> truncated resource record")
+		}
+		rrType := binary.BigEndian.Uint16(msg[off:])
+		rdLength := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdLength > len(msg) {
+			return nil, errors.New("[dns] -> truncated resource data")
+		}
+		if rrType == dnsTypeNAPTR {
+			record, err := parseNAPTRData(msg, off, off+rdLength)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+		off += rdLength
+	}
+
+	return records, nil
+}
+
+// 解析 NAPTR RDATA RFC 3403 - 4.1
+func parseNAPTRData(msg []byte, off int, end int) (naptrRecord, error) {
+	var record naptrRecord
+	if off+4 > end {
+		return record, errors.New("[dns] -> truncated NAPTR record")
+	}
+	record.order = binary.BigEndian.Uint16(msg[off:])
+	record.preference = binary.BigEndian.Uint16(msg[off+2:])
+	off += 4
+
+	fields := make([]string, 3)
+	for i := range fields {
+		if off >= end || off+1+int(msg[off]) > end {
+			return record, errors.New("[dns] -> truncated NAPTR character-string")
+		}
+		length := int(msg[off])
+		fields[i] = string(msg[off+1 : off+1+length])
+		off += 1 + length
+	}
+	record.flags, record.service, record.regexp = fields[0], fields[1], fields[2]
+
+	replacement, _, err := readDnsName(msg, off)
+	if err != nil {
+		return record, err
+	}
+	record.replacement = replacement
+
+	return record, nil
+}
+
+// 读取域名，支持压缩指针 RFC 1035 - 4.1.4
+// 返回域名以及域名之后的偏移
+func readDnsName(msg []byte, off int) (string, int, error) {
+	labels := make([]string, 0)
+	next := -1
+	// 防止压缩指针循环
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("[dns] -> truncated domain name")
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("[dns] -> truncated compression pointer")
+			}
+			if jumps++; jumps > 32 {
+				return "", 0, errors.New("[dns] -> too many compression pointers")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errors.New("[dns] -> truncated label")
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// 读取系统配置的第一个 DNS 服务器
+func systemNameserver() string {
+	file, err := os.Open(dnsResolvConf)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+
+	return ""
+}
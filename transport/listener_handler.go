@@ -141,7 +141,11 @@ func (handler *listenerHandler) acceptConnections(wg *sync.WaitGroup, connection
 			return
 		}
 
-		key := ConnectionKey(strings.ToLower(baseConn.RemoteAddr().Network()) + ":" + baseConn.RemoteAddr().String())
+		network := listenerNetwork(handler.Listener())
+		if network == "" {
+			network = strings.ToLower(baseConn.RemoteAddr().Network())
+		}
+		key := ConnectionKey(network + ":" + baseConn.RemoteAddr().String())
 		conn := CreateConnection(key, baseConn)
 
 		select {
@@ -228,10 +232,14 @@ func (handler *listenerHandler) Close() {
 }
 
 // 返回监听的协议
-func listenerNetwork(ls net.Listener) string {
-	switch ls.(type) {
+func listenerNetwork(listener net.Listener) string {
+	switch ls := listener.(type) {
 	case *net.TCPListener:
 		return "tcp"
+	case *tlsListener:
+		return "tls"
+	case *wsListener:
+		return ls.network
 	case *net.UnixListener:
 		return "unix"
 	default:
@@ -228,7 +228,7 @@ func (pool *listenerPool) all() []net.Listener {
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
 
-	listeners := make([]net.Listener, len(pool.handleMap))
+	listeners := make([]net.Listener, 0, len(pool.handleMap))
 	for _, value := range pool.handleMap {
 		listeners = append(listeners, value.Listener())
 	}
@@ -239,7 +239,7 @@ func (pool *listenerPool) allKeys() []ListenerKey {
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
 
-	keys := make([]ListenerKey, len(pool.handleMap))
+	keys := make([]ListenerKey, 0, len(pool.handleMap))
 	for k := range pool.handleMap {
 		keys = append(keys, k)
 	}
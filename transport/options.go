@@ -2,6 +2,10 @@ package transport
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"net"
 
 	"github.com/zenghr0820/gsip/logger"
@@ -14,6 +18,14 @@ type Options struct {
 	localIP net.IP
 	// DNS 配置
 	dnsResolver *net.Resolver
+	// DNS 服务器地址(NAPTR 查询使用)
+	dnsServer string
+	// TLS 服务端配置(监听)
+	tlsServerConfig *tls.Config
+	// TLS 客户端配置(主动连接)
+	tlsClientConfig *tls.Config
+	// 加载 TLS 证书的错误，监听或者连接 TLS、WSS 时返回
+	tlsErr error
 }
 
 type Option func(o *Options)
@@ -34,7 +46,7 @@ func newOptions(opts ...Option) Options {
 		}
 	}
 
-	if opt.localIP == nil {
+	if opt.dnsResolver == nil {
 		opt.dnsResolver = net.DefaultResolver
 	}
 
@@ -56,7 +68,7 @@ func LocalAddr(localhost string) Option {
 	}
 }
 
-// 配置 DNS
+// 配置 DNS 服务器地址 ip:port，SRV、A/AAAA 以及 NAPTR 查询均使用该服务器
 func DnsResolverConfig(dns string) Option {
 	return func(o *Options) {
 		var dnsResolver *net.Resolver
@@ -65,10 +77,108 @@ func DnsResolverConfig(dns string) Option {
 				PreferGo: true,
 				Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
 					d := net.Dialer{}
-					return d.DialContext(ctx, "udp", dns)
+					return d.DialContext(ctx, network, dns)
 				},
 			}
 			o.dnsResolver = dnsResolver
+			o.dnsServer = dns
+		}
+	}
+}
+
+// 配置 TLS 服务端证书(监听 TLS 时使用)
+func TlsCertificate(certFile, keyFile string) Option {
+	return func(o *Options) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			o.setTlsErr(fmt.Errorf("[transport_option] -> load TLS certificate failed: %s", err))
+			return
+		}
+		config := o.serverTlsConfig()
+		config.Certificates = append(config.Certificates, cert)
+	}
+}
+
+// 配置 TLS 客户端证书(对端要求双向认证时使用)
+func TlsClientCertificate(certFile, keyFile string) Option {
+	return func(o *Options) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			o.setTlsErr(fmt.Errorf("[transport_option] -> load TLS client certificate failed: %s", err))
+			return
 		}
+		config := o.clientTlsConfig()
+		config.Certificates = append(config.Certificates, cert)
+	}
+}
+
+// 配置信任的 CA 证书：
+// 作为客户端时用于校验服务端证书，作为服务端时用于校验客户端证书，是否要求客户端证书由 TlsClientAuth 配置
+func TlsRootCAs(caFiles ...string) Option {
+	return func(o *Options) {
+		pool := x509.NewCertPool()
+		for _, caFile := range caFiles {
+			data, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				o.setTlsErr(fmt.Errorf("[transport_option] -> read TLS CA file failed: %s", err))
+				return
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				o.setTlsErr(fmt.Errorf("[transport_option] -> no certificate found in TLS CA file %s", caFile))
+				return
+			}
+		}
+
+		o.clientTlsConfig().RootCAs = pool
+		o.serverTlsConfig().ClientCAs = pool
+	}
+}
+
+// 作为服务端时对客户端证书的要求，双向认证使用 tls.RequireAndVerifyClientCert，默认不要求客户端证书
+func TlsClientAuth(auth tls.ClientAuthType) Option {
+	return func(o *Options) {
+		o.serverTlsConfig().ClientAuth = auth
+	}
+}
+
+// 跳过对端证书校验(仅用于测试环境)
+func TlsInsecureSkipVerify(skip bool) Option {
+	return func(o *Options) {
+		o.clientTlsConfig().InsecureSkipVerify = skip
+	}
+}
+
+// 直接配置 TLS 服务端 tls.Config
+func TlsServerConfig(config *tls.Config) Option {
+	return func(o *Options) {
+		o.tlsServerConfig = config
+	}
+}
+
+// 直接配置 TLS 客户端 tls.Config
+func TlsClientConfig(config *tls.Config) Option {
+	return func(o *Options) {
+		o.tlsClientConfig = config
+	}
+}
+
+func (o *Options) serverTlsConfig() *tls.Config {
+	if o.tlsServerConfig == nil {
+		o.tlsServerConfig = &tls.Config{}
+	}
+	return o.tlsServerConfig
+}
+
+func (o *Options) clientTlsConfig() *tls.Config {
+	if o.tlsClientConfig == nil {
+		o.tlsClientConfig = &tls.Config{}
+	}
+	return o.tlsClientConfig
+}
+
+// 只保留第一个错误
+func (o *Options) setTlsErr(err error) {
+	if o.tlsErr == nil {
+		o.tlsErr = err
 	}
 }
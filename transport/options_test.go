@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 自签名的 CA 证书
+const testCA = `-----BEGIN CERTIFICATE-----
+MIIBYDCCAQegAwIBAgIBATAKBggqhkjOPQQDAjAXMRUwEwYDVQQDEwxnc2lwIHRl
+c3QgQ0EwIBcNMjAwMTAxMDAwMDAwWhgPMjA1MDAxMDEwMDAwMDBaMBcxFTATBgNV
+BAMTDGdzaXAgdGVzdCBDQTBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABLScsAMs
+5wD4SWMpeNn8zzgSa7tYPlLi85OoK+GgyWqSQmSgX5KDthST/VfGwIrXTjmsQ8qy
+oDRVLX5SjSaBAwCjQjBAMA4GA1UdDwEB/wQEAwICBDAPBgNVHRMBAf8EBTADAQH/
+MB0GA1UdDgQWBBTfINK9R3KpCK0L5PkaKwbMfxgHBzAKBggqhkjOPQQDAgNHADBE
+AiA7FNZh4a9dZsUE2K3541tyvOFAWFxU4O5IL2mNmzJdrAIgcqCaRzaQlxPiXkMu
+qYTpIRfPwq44R9/T3BUauW6WBk4=
+-----END CERTIFICATE-----
+`
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gsip")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	return dir
+}
+
+// 加载 TLS 证书的错误在监听 TLS、WSS 时返回
+func TestTlsOptionError(t *testing.T) {
+	dir := tempDir(t)
+	tpl := CreateLayer(LocalAddr("127.0.0.1"), TlsCertificate(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")))
+	defer tpl.Close()
+
+	for _, network := range []string{"tls", "wss"} {
+		err := tpl.Listen(network, "127.0.0.1:0")
+		if err == nil || !strings.Contains(err.Error(), "load TLS certificate failed") {
+			t.Errorf("listen %s error = %v, want load TLS certificate failed", network, err)
+		}
+	}
+	if err := tpl.Listen("udp", "127.0.0.1:0"); err != nil {
+		t.Errorf("listen udp failed: %s", err)
+	}
+}
+
+// 信任的 CA 不要求客户端证书，双向认证由 TlsClientAuth 配置
+func TestTlsClientAuth(t *testing.T) {
+	caFile := filepath.Join(tempDir(t), "ca.pem")
+	if err := ioutil.WriteFile(caFile, []byte(testCA), 0600); err != nil {
+		t.Fatalf("write CA file failed: %s", err)
+	}
+
+	opts := newOptions(LocalAddr("127.0.0.1"), TlsRootCAs(caFile))
+	if opts.tlsErr != nil {
+		t.Fatalf("load CA failed: %s", opts.tlsErr)
+	}
+	if opts.tlsServerConfig.ClientCAs == nil || opts.tlsClientConfig.RootCAs == nil {
+		t.Error("CA pool not configured")
+	}
+	if opts.tlsServerConfig.ClientAuth != tls.NoClientCert {
+		t.Errorf("client auth = %s, want NoClientCert", opts.tlsServerConfig.ClientAuth)
+	}
+
+	opts = newOptions(LocalAddr("127.0.0.1"), TlsRootCAs(caFile), TlsClientAuth(tls.RequireAndVerifyClientCert))
+	if opts.tlsServerConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("client auth = %s, want RequireAndVerifyClientCert", opts.tlsServerConfig.ClientAuth)
+	}
+}
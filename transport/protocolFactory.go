@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/zenghr0820/gsip/sip"
@@ -10,18 +11,32 @@ import (
 // receiveMessage: 传输层用于接收 协议层数据的 chan
 // receiveError: 传输层用于接收 协议层异常的 chan
 // notifyCancel：传输层用于通知 协议层关闭的 chan
+// opts: 传输层配置(TLS/WSS 证书等)
 var protocolFactory = func(
 	network string,
 	receiveMessage chan<- sip.Message,
 	receiveError chan<- error,
 	notifyCancel <-chan struct{},
+	opts Options,
 ) (Protocol, error) {
 	switch strings.ToLower(network) {
 	case "udp":
 		return CreateUdpProtocol(receiveMessage, receiveError, notifyCancel), nil
 	case "tcp":
 		return CreateTcpProtocol(receiveMessage, receiveError, notifyCancel), nil
+	case "tls":
+		if opts.tlsErr != nil {
+			return nil, opts.tlsErr
+		}
+		return CreateTlsProtocol(receiveMessage, receiveError, notifyCancel, opts.tlsServerConfig, opts.tlsClientConfig), nil
+	case "ws":
+		return CreateWsProtocol(receiveMessage, receiveError, notifyCancel, false, nil, nil), nil
+	case "wss":
+		if opts.tlsErr != nil {
+			return nil, opts.tlsErr
+		}
+		return CreateWsProtocol(receiveMessage, receiveError, notifyCancel, true, opts.tlsServerConfig, opts.tlsClientConfig), nil
 	default:
-		return nil, nil
+		return nil, UnsupportedProtocolError(fmt.Sprintf("[protocol_factory] -> protocol %s is not supported", network))
 	}
 }
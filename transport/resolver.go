@@ -0,0 +1,227 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// DNS 查询超时时间
+const dnsTimeout = 5 * time.Second
+
+// RFC 3263 - 4.1 NAPTR service 字段对应的传输协议
+// RFC 7118 - 9 WebSocket 对应 D2W
+var naptrServices = map[string]string{
+	"SIP+D2U":  "udp",
+	"SIP+D2T":  "tcp",
+	"SIPS+D2T": "tls",
+	"SIP+D2W":  "ws",
+	"SIPS+D2W": "wss",
+}
+
+// 请求的发送目标：传输协议以及解析后的 IP 地址、端口
+type Target struct {
+	Network string
+	Addr    *sip.Addr
+}
+
+func (target Target) String() string {
+	return fmt.Sprintf("%s:%s", target.Network, target.Addr.Addr())
+}
+
+// RFC 3263 服务器定位
+type resolver struct {
+	dns *net.Resolver
+	// NAPTR 查询使用的 DNS 服务器，为空时不查询 NAPTR
+	nameserver string
+}
+
+func createResolver(opts Options) *resolver {
+	r := &resolver{
+		dns:        opts.dnsResolver,
+		nameserver: opts.dnsServer,
+	}
+	if r.dns == nil {
+		r.dns = net.DefaultResolver
+	}
+	if r.nameserver == "" {
+		r.nameserver = systemNameserver()
+	}
+
+	return r
+}
+
+/**
+解析请求目标 RFC 3263 - 4：
+	host、port：目标地址，port 为空时才进行 NAPTR 以及 SRV 查询
+	networks：可以使用的传输协议(按优先级排序)
+	explicit：传输协议由 transport 参数或 sips 指定，不进行 NAPTR 查询
+返回按优先级排序的目标列表，发送失败时依次尝试下一个目标
+*/
+func (r *resolver) resolve(ctx context.Context, host string, port *sip.Port, networks []string, explicit bool) ([]Target, error) {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" {
+		return nil, fmt.Errorf("[resolver] -> empty target host")
+	}
+
+	// 目标为 IP 地址，直接使用
+	if ip := net.ParseIP(host); ip != nil {
+		targets := make([]Target, 0, len(networks))
+		for _, nt := range networks {
+			targets = append(targets, newTarget(nt, ip.String(), port))
+		}
+		return targets, nil
+	}
+
+	// RFC 3263 - 4.2 指定了端口时只查询 A/AAAA 记录
+	if port != nil {
+		return r.lookupHost(ctx, host, port, networks)
+	}
+
+	// RFC 3263 - 4.1 NAPTR 选择传输协议
+	if !explicit {
+		if targets := r.lookupNAPTR(ctx, host, networks); len(targets) > 0 {
+			return targets, nil
+		}
+	}
+
+	// RFC 3263 - 4.2 SRV 查询
+	targets := make([]Target, 0)
+	for _, nt := range networks {
+		service, proto, ok := srvServiceProto(nt)
+		if !ok {
+			continue
+		}
+		_, srvs, err := r.dns.LookupSRV(ctx, service, proto, host)
+		if err != nil {
+			logger.Debugf("[resolver] -> lookup SRV _%s._%s.%s failed: %s", service, proto, host, err)
+			continue
+		}
+		targets = append(targets, r.expandSRV(ctx, nt, srvs)...)
+	}
+	if len(targets) > 0 {
+		return targets, nil
+	}
+
+	// 没有 SRV 记录时使用 A/AAAA 记录以及默认端口
+	return r.lookupHost(ctx, host, nil, networks)
+}
+
+// 查询 NAPTR 记录，按 order、preference 排序后展开对应的 SRV 记录
+func (r *resolver) lookupNAPTR(ctx context.Context, host string, networks []string) []Target {
+	if r.nameserver == "" {
+		return nil
+	}
+
+	records, err := lookupNAPTR(ctx, r.nameserver, host)
+	if err != nil {
+		logger.Debugf("[resolver] -> lookup NAPTR %s failed: %s", host, err)
+		return nil
+	}
+
+	// 仅保留支持的传输协议，且 flags 必须为 "s"
+	supported := make([]naptrRecord, 0, len(records))
+	for _, record := range records {
+		nt, ok := naptrServices[strings.ToUpper(record.service)]
+		if !ok || !strings.EqualFold(record.flags, "s") || !containsNetwork(networks, nt) {
+			continue
+		}
+		supported = append(supported, record)
+	}
+	sort.SliceStable(supported, func(i, j int) bool {
+		if supported[i].order != supported[j].order {
+			return supported[i].order < supported[j].order
+		}
+		return supported[i].preference < supported[j].preference
+	})
+
+	targets := make([]Target, 0)
+	for _, record := range supported {
+		nt := naptrServices[strings.ToUpper(record.service)]
+		// service、proto 为空时直接查询 replacement 域名
+		_, srvs, err := r.dns.LookupSRV(ctx, "", "", record.replacement)
+		if err != nil {
+			logger.Debugf("[resolver] -> lookup SRV %s failed: %s", record.replacement, err)
+			continue
+		}
+		targets = append(targets, r.expandSRV(ctx, nt, srvs)...)
+	}
+
+	return targets
+}
+
+// SRV 记录已由标准库按 priority 排序并在同一 priority 中按 weight 随机选择 RFC 2782
+func (r *resolver) expandSRV(ctx context.Context, network string, srvs []*net.SRV) []Target {
+	targets := make([]Target, 0, len(srvs))
+	for _, srv := range srvs {
+		port := sip.Port(srv.Port)
+		hostTargets, err := r.lookupHost(ctx, srv.Target, &port, []string{network})
+		if err != nil {
+			logger.Debugf("[resolver] -> lookup host %s failed: %s", srv.Target, err)
+			continue
+		}
+		targets = append(targets, hostTargets...)
+	}
+
+	return targets
+}
+
+// 查询 A/AAAA 记录
+func (r *resolver) lookupHost(ctx context.Context, host string, port *sip.Port, networks []string) ([]Target, error) {
+	addrs, err := r.dns.LookupIPAddr(ctx, strings.TrimSuffix(host, "."))
+	if err != nil {
+		return nil, fmt.Errorf("[resolver] -> lookup host %s failed: %w", host, err)
+	}
+
+	targets := make([]Target, 0, len(addrs)*len(networks))
+	for _, nt := range networks {
+		for _, addr := range addrs {
+			targets = append(targets, newTarget(nt, addr.IP.String(), port))
+		}
+	}
+
+	return targets, nil
+}
+
+func newTarget(network string, host string, port *sip.Port) Target {
+	target := Target{
+		Network: network,
+		Addr: &sip.Addr{
+			Host: host,
+			Port: port.Copy(),
+		},
+	}
+	sip.FillTargetHostAndPort(network, target.Addr)
+
+	return target
+}
+
+// 传输协议对应的 SRV 服务以及协议，RFC 7118 未定义 WebSocket 的 SRV 记录
+func srvServiceProto(network string) (string, string, bool) {
+	switch network {
+	case "udp":
+		return "sip", "udp", true
+	case "tcp":
+		return "sip", "tcp", true
+	case "tls":
+		// RFC 3263 - 4.1 TLS 对应 _sips._tcp
+		return "sips", "tcp", true
+	default:
+		return "", "", false
+	}
+}
+
+func containsNetwork(networks []string, network string) bool {
+	for _, nt := range networks {
+		if nt == network {
+			return true
+		}
+	}
+	return false
+}
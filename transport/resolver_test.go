@@ -0,0 +1,274 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+const (
+	dnsTypeA   uint16 = 1
+	dnsTypeSRV uint16 = 33
+)
+
+// 本地 DNS 服务器，按查询类型以及域名返回预设的记录，并记录收到的查询
+type dnsStub struct {
+	conn    net.PacketConn
+	records map[string][][]byte
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func newDnsStub(t *testing.T) *dnsStub {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen DNS stub failed: %s", err)
+	}
+	stub := &dnsStub{
+		conn:    conn,
+		records: make(map[string][][]byte),
+	}
+	go stub.serve()
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return stub
+}
+
+func (stub *dnsStub) addr() string {
+	return stub.conn.LocalAddr().String()
+}
+
+func dnsKey(qType uint16, name string) string {
+	return fmt.Sprintf("%d %s", qType, strings.ToLower(strings.TrimSuffix(name, ".")))
+}
+
+func (stub *dnsStub) addA(name string, ip string) {
+	key := dnsKey(dnsTypeA, name)
+	stub.records[key] = append(stub.records[key], net.ParseIP(ip).To4())
+}
+
+func (stub *dnsStub) addSRV(name string, priority, weight, port uint16, target string) {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[0:], priority)
+	binary.BigEndian.PutUint16(data[2:], weight)
+	binary.BigEndian.PutUint16(data[4:], port)
+	key := dnsKey(dnsTypeSRV, name)
+	stub.records[key] = append(stub.records[key], append(data, encodeDnsName(target)...))
+}
+
+func (stub *dnsStub) addNAPTR(name string, order, preference uint16, service, replacement string) {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:], order)
+	binary.BigEndian.PutUint16(data[2:], preference)
+	for _, field := range []string{"s", service, ""} {
+		data = append(data, byte(len(field)))
+		data = append(data, field...)
+	}
+	key := dnsKey(dnsTypeNAPTR, name)
+	stub.records[key] = append(stub.records[key], append(data, encodeDnsName(replacement)...))
+}
+
+// 收到的 NAPTR 以及 SRV 查询
+func (stub *dnsStub) lookups() []string {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	lookups := make([]string, 0)
+	for _, query := range stub.queries {
+		if strings.HasPrefix(query, "NAPTR ") || strings.HasPrefix(query, "SRV ") {
+			lookups = append(lookups, query)
+		}
+	}
+
+	return lookups
+}
+
+func (stub *dnsStub) serve() {
+	buf := make([]byte, 1500)
+	for {
+		num, addr, err := stub.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if res := stub.answer(buf[:num]); res != nil {
+			_, _ = stub.conn.WriteTo(res, addr)
+		}
+	}
+}
+
+func (stub *dnsStub) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	name, off, err := readDnsName(query, 12)
+	if err != nil || off+4 > len(query) {
+		return nil
+	}
+	qType := binary.BigEndian.Uint16(query[off:])
+
+	stub.mu.Lock()
+	switch qType {
+	case dnsTypeNAPTR:
+		stub.queries = append(stub.queries, "NAPTR "+name)
+	case dnsTypeSRV:
+		stub.queries = append(stub.queries, "SRV "+name)
+	}
+	stub.mu.Unlock()
+
+	records := stub.records[dnsKey(qType, name)]
+	res := make([]byte, 12, 512)
+	copy(res, query[:2])
+	// QR、RD、RA
+	binary.BigEndian.PutUint16(res[2:], 0x8180)
+	binary.BigEndian.PutUint16(res[4:], 1)
+	binary.BigEndian.PutUint16(res[6:], uint16(len(records)))
+	res = append(res, query[12:off+4]...)
+	for _, data := range records {
+		rr := make([]byte, 12)
+		// 指向查询中的域名
+		binary.BigEndian.PutUint16(rr[0:], 0xC00C)
+		binary.BigEndian.PutUint16(rr[2:], qType)
+		binary.BigEndian.PutUint16(rr[4:], dnsClassINET)
+		binary.BigEndian.PutUint32(rr[6:], 60)
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(data)))
+		res = append(res, rr...)
+		res = append(res, data...)
+	}
+
+	return res
+}
+
+func encodeDnsName(name string) []byte {
+	data := make([]byte, 0, len(name)+2)
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		data = append(data, byte(len(label)))
+		data = append(data, label...)
+	}
+
+	return append(data, 0)
+}
+
+func newResolveRequest(t *testing.T, uri string) sip.Request {
+	to, err := sip.ParseUri(uri)
+	if err != nil {
+		t.Fatalf("parse %s failed: %s", uri, err)
+	}
+	from, _ := sip.ParseUri("sip:alice@192.0.2.100")
+
+	return sip.CreateRequest(sip.INVITE, "", from, to)
+}
+
+func TestResolve(t *testing.T) {
+	stub := newDnsStub(t)
+	// sip.test：NAPTR 指向 TCP 以及 UDP 的 SRV
+	stub.addNAPTR("sip.test", 20, 10, "SIP+D2U", "_sip._udp.sip.test")
+	stub.addNAPTR("sip.test", 10, 10, "SIP+D2T", "_sip._tcp.sip.test")
+	stub.addSRV("_sip._tcp.sip.test", 0, 0, 5070, "pbx.sip.test")
+	stub.addSRV("_sip._udp.sip.test", 0, 0, 5080, "pbx.sip.test")
+	stub.addA("pbx.sip.test", "192.0.2.10")
+	stub.addA("sip.test", "192.0.2.1")
+	// srv.test：没有 NAPTR，只有 UDP 的 SRV
+	stub.addSRV("_sip._udp.srv.test", 0, 0, 5090, "pbx.sip.test")
+	stub.addA("srv.test", "192.0.2.2")
+	// a.test：只有 A 记录
+	stub.addA("a.test", "192.0.2.3")
+
+	tpl := CreateLayer(LocalAddr("127.0.0.1"), DnsResolverConfig(stub.addr()))
+	defer tpl.Close()
+
+	tests := []struct {
+		name    string
+		uri     string
+		dest    string
+		targets []string
+		lookups []string
+	}{
+		{
+			name:    "NAPTR then SRV then A",
+			uri:     "sip:bob@sip.test",
+			targets: []string{"tcp:192.0.2.10:5070", "udp:192.0.2.10:5080"},
+			lookups: []string{"NAPTR sip.test", "SRV _sip._tcp.sip.test", "SRV _sip._udp.sip.test"},
+		},
+		{
+			name:    "SRV without NAPTR",
+			uri:     "sip:bob@srv.test",
+			targets: []string{"udp:192.0.2.10:5090"},
+			lookups: []string{"NAPTR srv.test", "SRV _sip._udp.srv.test", "SRV _sip._tcp.srv.test"},
+		},
+		{
+			name:    "A without NAPTR and SRV",
+			uri:     "sip:bob@a.test",
+			targets: []string{"udp:192.0.2.3:5060", "tcp:192.0.2.3:5060"},
+			lookups: []string{"NAPTR a.test", "SRV _sip._udp.a.test", "SRV _sip._tcp.a.test"},
+		},
+		{
+			name:    "explicit port",
+			uri:     "sip:bob@sip.test:5062",
+			targets: []string{"udp:192.0.2.1:5062", "tcp:192.0.2.1:5062"},
+			lookups: []string{},
+		},
+		{
+			name:    "transport parameter",
+			uri:     "sip:bob@sip.test;transport=tcp",
+			targets: []string{"tcp:192.0.2.10:5070"},
+			lookups: []string{"SRV _sip._tcp.sip.test"},
+		},
+		{
+			name:    "explicit destination",
+			uri:     "sip:bob@sip.test",
+			dest:    "192.0.2.30:5000",
+			targets: []string{"udp:192.0.2.30:5000", "tcp:192.0.2.30:5000"},
+			lookups: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub.mu.Lock()
+			stub.queries = nil
+			stub.mu.Unlock()
+
+			req := newResolveRequest(t, test.uri)
+			if test.dest != "" {
+				req.SetDestination(test.dest)
+			}
+			targets, err := tpl.Resolve(req)
+			if err != nil {
+				t.Fatalf("resolve %s failed: %s", test.uri, err)
+			}
+			got := make([]string, 0, len(targets))
+			for _, target := range targets {
+				got = append(got, target.String())
+			}
+			if !reflect.DeepEqual(got, test.targets) {
+				t.Errorf("targets = %v, want %v", got, test.targets)
+			}
+			if lookups := stub.lookups(); !reflect.DeepEqual(lookups, test.lookups) {
+				t.Errorf("lookups = %v, want %v", lookups, test.lookups)
+			}
+		})
+	}
+}
+
+// 复制的请求以及 CANCEL 不会把计算得到的目的地址当作显式设置的地址
+func TestRequestTargetOfCopy(t *testing.T) {
+	req := newResolveRequest(t, "sip:bob@sip.test")
+	for _, r := range []sip.Request{req, sip.CopyRequest(req), sip.CreateCancel(req)} {
+		host, port, err := requestTarget(r)
+		if err != nil {
+			t.Fatalf("request target failed: %s", err)
+		}
+		if host != "sip.test" || port != nil {
+			t.Errorf("target = %s:%v, want sip.test without port", host, port)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package transport
+
+import (
+	cryptoTls "crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+/**
+创建 TLS 协议：
+	receiveMessage: 传输层接收 Tls 协议数据的 chan
+	receiveError: 传输层接收 Tls 协议异常的 chan
+	notifyCancel：传输层通知 Tls 协议关闭的 chan
+	serverConfig：监听使用的 TLS 配置(需包含证书)
+	clientConfig：主动连接使用的 TLS 配置
+*/
+func CreateTlsProtocol(
+	receiveMessage chan<- sip.Message,
+	receiveError chan<- error,
+	notifyCancel <-chan struct{},
+	serverConfig *cryptoTls.Config,
+	clientConfig *cryptoTls.Config,
+) Protocol {
+	tls := new(tlsProtocol)
+	tls.network = "tls"
+	tls.reliable = true
+	tls.streamed = true
+	tls.serverConfig = serverConfig
+	tls.clientConfig = clientConfig
+	tls.receiveConnection = make(chan Connection)
+
+	tls.listeners = CreateListenerPool(tls.receiveConnection, receiveError, notifyCancel)
+	tls.connections = CreateConnectionPool(receiveMessage, receiveError, notifyCancel)
+	// pipe listener and connection pools
+	// 添加新的连接到连接池
+	go tls.pipePools()
+	return tls
+}
+
+// TLS protocol implementation
+type tlsProtocol struct {
+	protocol
+	connections       ConnectionPool
+	listeners         ListenerPool
+	receiveConnection chan Connection
+	serverConfig      *cryptoTls.Config
+	clientConfig      *cryptoTls.Config
+}
+
+// 包装 TLS 监听，用于区分 TCP 监听
+type tlsListener struct {
+	net.Listener
+}
+
+func (tls *tlsProtocol) Done() <-chan struct{} {
+	return tls.connections.Done()
+}
+
+// 解析地址
+func (tls *tlsProtocol) resolveAddr(addr *sip.Addr) (*net.TCPAddr, error) {
+	address := addr.Addr()
+
+	remoteAddr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, &ProtocolError{
+			err,
+			fmt.Sprintf("[tls_protocol] -> resolve target address %s %s", tls.Network(), address),
+			fmt.Sprintf("%p", tls),
+		}
+	}
+	return remoteAddr, err
+}
+
+// 监听
+func (tls *tlsProtocol) Listen(addr *sip.Addr) error {
+	if tls.serverConfig == nil || (len(tls.serverConfig.Certificates) == 0 && tls.serverConfig.GetCertificate == nil) {
+		return &ProtocolError{
+			fmt.Errorf("[tls_protocol] -> server certificate not configured"),
+			fmt.Sprintf("[tls_protocol] -> listen on %s %s address", tls.Network(), addr.Addr()),
+			fmt.Sprintf("%p", tls),
+		}
+	}
+
+	addr = sip.FillTargetHostAndPort(tls.Network(), addr)
+	// resolve local TCP endpoint
+	localAddr, err := tls.resolveAddr(addr)
+	if err != nil {
+		return err
+	}
+	listener, err := net.ListenTCP("tcp", localAddr)
+	if err != nil {
+		return &ProtocolError{
+			err,
+			fmt.Sprintf("[tls_protocol] -> listen on %s %s address", tls.Network(), localAddr),
+			fmt.Sprintf("%p", tls),
+		}
+	}
+
+	logger.Infof("[tls_protocol] -> begin listen on %s %s", tls.Network(), localAddr)
+
+	// 创建连接
+	key := ListenerKey(fmt.Sprintf("tls:0.0.0.0:%d", localAddr.Port))
+	// 将监听的连接添加进 连接池
+	err = tls.listeners.Put(key, &tlsListener{cryptoTls.NewListener(listener, tls.serverConfig)})
+	return err
+}
+
+// 发送
+func (tls *tlsProtocol) Send(rAddr *sip.Addr, msg sip.Message) error {
+	// 验证发送地址
+	if rAddr.Host == "" {
+		return &ProtocolError{
+			fmt.Errorf("[tls_protocol] -> empty remote target host"),
+			fmt.Sprintf("[tls_protocol] -> send SIP message to %s %s", tls.Network(), rAddr.Addr()),
+			fmt.Sprintf("%p", tls),
+		}
+	}
+	// 解析地址
+	remoteAddr, err := tls.resolveAddr(rAddr)
+	if err != nil {
+		return err
+	}
+
+	// 获取连接
+	conn, err := tls.getOrCreateConnection(rAddr.Host, remoteAddr)
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("[tls_protocol] -> writing SIP message to %s %s", tls.Network(), remoteAddr)
+
+	// send message
+	_, err = conn.Write([]byte(msg.String()))
+
+	return err // should be nil
+}
+
+// 添加新的连接到连接池
+func (tls *tlsProtocol) pipePools() {
+	defer close(tls.receiveConnection)
+
+	logger.Debug("[tls_protocol] -> start pipe pools")
+	defer logger.Debug("[tls_protocol] -> stop pipe pools")
+
+	for {
+		select {
+		case <-tls.listeners.Done():
+			return
+		case conn := <-tls.receiveConnection:
+			if err := tls.connections.Put(conn, SockTTL); err != nil {
+				logger.Errorf("[tls_protocol] -> put new TLS connection failed: %s", err)
+
+				continue
+			}
+		}
+	}
+}
+
+// 获取连接或者创建连接
+// host: 对端域名，用于 SNI 以及证书校验
+func (tls *tlsProtocol) getOrCreateConnection(host string, remoteAddr *net.TCPAddr) (Connection, error) {
+	key := ConnectionKey("tls:" + remoteAddr.String())
+	conn, err := tls.connections.Get(key)
+	if err != nil {
+		logger.Debugf("[tls_protocol] -> connection for remote address %s %s not found, create a new one", tls.Network(), remoteAddr)
+
+		tlsConn, err := cryptoTls.Dial("tcp", remoteAddr.String(), tls.dialConfig(host))
+		if err != nil {
+			return nil, &ProtocolError{
+				err,
+				fmt.Sprintf("[tls_protocol] -> connect to %s %s address", tls.Network(), remoteAddr),
+				fmt.Sprintf("%p", tls),
+			}
+		}
+
+		conn = CreateConnection(key, tlsConn)
+		if err := tls.connections.Put(conn, SockTTL); err != nil {
+			return conn, err
+		}
+	}
+
+	return conn, nil
+}
+
+// 生成本次连接使用的客户端配置
+func (tls *tlsProtocol) dialConfig(host string) *cryptoTls.Config {
+	config := tls.clientConfig
+	if config == nil {
+		config = &cryptoTls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	// RFC 5922 - 使用 SIP 域名校验对端证书
+	if config.ServerName == "" && net.ParseIP(host) == nil {
+		config.ServerName = strings.TrimSuffix(host, ".")
+	}
+
+	return config
+}
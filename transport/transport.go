@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -30,6 +31,7 @@ func CreateLayer(opts ...Option) Layer {
 
 	// 配置
 	tpl.opts = newOptions(opts...)
+	tpl.resolver = createResolver(tpl.opts)
 
 	// 监听协议层通知
 	go tpl.listenProtocolNotice()
@@ -50,6 +52,12 @@ type Layer interface {
 	Errors() <-chan error
 	// 发送
 	Send(message sip.Message) error
+	// 解析请求的发送目标 RFC 3263
+	Resolve(req sip.Request) ([]Target, error)
+	// 发送请求到指定的目标
+	SendTo(req sip.Request, target Target) error
+	// 本地 IP 地址
+	LocalIP() net.IP
 	// 关闭
 	Close()
 	// 确认关闭是否完成
@@ -61,6 +69,8 @@ type layer struct {
 	opts Options
 	// 传输层实例化的协议层
 	protocols *protocolPool
+	// 并发发送时按需创建协议，避免重复创建
+	protocolMu sync.Mutex
 	// 向上传递消息
 	upMessage chan sip.Message
 	// 向上传递异常
@@ -70,10 +80,14 @@ type layer struct {
 	// 监听接收异常
 	receiveError chan error
 
+	// RFC 3263 服务器定位
+	resolver *resolver
+
 	cancel chan struct{}
 	done   chan struct{}
 
 	wg sync.WaitGroup
+	mu sync.RWMutex
 }
 
 func (tpl *layer) Init(opts ...Option) {
@@ -88,6 +102,10 @@ func (tpl *layer) Init(opts ...Option) {
 	if tpl.opts.dnsResolver == nil {
 		DnsResolverConfig("")(&tpl.opts)
 	}
+
+	tpl.mu.Lock()
+	tpl.resolver = createResolver(tpl.opts)
+	tpl.mu.Unlock()
 }
 
 func (tpl *layer) Done() <-chan struct{} {
@@ -118,14 +136,9 @@ func (tpl *layer) Listen(network string, addr string) error {
 	}
 
 	// 检查 协议池是否有该协议，有则取出，无则创建添加进协议池
-	protocol, ok := tpl.protocols.get(protocolKey(network))
-	if !ok {
-		var err error
-		protocol, err = protocolFactory(network, tpl.receiveMessage, tpl.receiveError, tpl.cancel)
-		if err != nil {
-			return err
-		}
-		tpl.protocols.put(protocolKey(network), protocol)
+	protocol, err := tpl.getOrCreateProtocol(network)
+	if err != nil {
+		return err
 	}
 
 	// 格式化地址
@@ -158,92 +171,17 @@ func (tpl *layer) Send(message sip.Message) error {
 	switch msg := message.(type) {
 	// RFC 3261 - 18.1.1.
 	case sip.Request:
-		nets := make([]string, 0)
-		msgLen := len(msg.String())
-		// todo check for reliable/non-reliable
-		// 检查是可靠还是不可靠传输
-		if msgLen > int(MTU)-200 {
-			nets = append(nets, "tcp", "udp")
-		} else {
-			nets = append(nets, "udp", "tcp")
-		}
-
-		viaHop.Host = tpl.opts.localIP.String()
-		if viaHop.Params == nil {
-			viaHop.Params = sip.NewParams()
-		}
-		if !viaHop.Params.Has("rport") {
-			viaHop.Params.Add("rport", nil)
-		}
-
-		// RFC 3621 - 12.1.2
-		// 请求是 INVITE 必须在Contact头域中提供一个地址
-		if msg.IsInvite() {
-			if contact := msg.Contact(); contact == nil {
-				if from := msg.From(); from != nil {
-					contact = &sip.ContactHeader{
-						DisplayName: nil,
-						Address:     from.Address.Copy(),
-						Params:      nil,
-					}
-					msg.PrependHeaderAfter(contact, "CSeq")
-				}
-			}
+		targets, err := tpl.Resolve(msg)
+		if err != nil {
+			return err
 		}
 
-		var err error
-		for _, nt := range nets {
-			protocol, ok := tpl.protocols.get(protocolKey(nt))
-			if !ok {
-				err = UnsupportedProtocolError(fmt.Sprintf("[tpl_layer] -> protocol %s is not supported", nt))
-				continue
-			}
-			// rewrite sent-by transport
-			viaHop.Transport = strings.ToUpper(nt)
-			// rewrite sent-by port
-			// TODO if port, ok := tpl.listenPorts[nt]; ok
-			defPort := sip.DefaultPort(nt)
-			viaHop.Port = &defPort
-
-			var target *sip.Addr
-			// logger.Info("msg.Destination() -> ", msg.Destination())
-			target, err = sip.NewTargetFromAddr(msg.Destination())
-			if err != nil {
-				continue
-			}
-
-			// dns srv lookup
-			if net.ParseIP(target.Host) == nil {
-				ctx := context.Background()
-				proto := strings.ToLower(nt)
-				if _, adders, err := tpl.opts.dnsResolver.LookupSRV(ctx, "sip", proto, target.Host); err == nil && len(adders) > 0 {
-					addr := adders[0]
-					addrStr := fmt.Sprintf("%s:%d", addr.Target[:len(addr.Target)-1], addr.Port)
-					switch nt {
-					case "UDP":
-						if addr, err := net.ResolveUDPAddr("udp", addrStr); err == nil {
-							port := sip.Port(addr.Port)
-							target.Host = addr.IP.String()
-							target.Port = &port
-						}
-					case "TCP":
-						if addr, err := net.ResolveTCPAddr("tcp", addrStr); err == nil {
-							port := sip.Port(addr.Port)
-							target.Host = addr.IP.String()
-							target.Port = &port
-						}
-					}
-				}
-			}
-
-			logger.Debugf("[tpl_layer] -> sending SIP request:\n%s", msg)
-
-			err = protocol.Send(target, msg)
-			if err == nil {
-				break
-			} else {
-				continue
+		// RFC 3263 - 4.3 发送失败时尝试下一个目标
+		for _, target := range targets {
+			if err = tpl.SendTo(msg, target); err == nil {
+				return nil
 			}
+			logger.Warnf("[tpl_layer] -> send SIP request to %s failed: %s", target, err)
 		}
 
 		return err
@@ -271,6 +209,227 @@ func (tpl *layer) Send(message sip.Message) error {
 	}
 }
 
+// 解析请求的发送目标 RFC 3263
+func (tpl *layer) Resolve(req sip.Request) ([]Target, error) {
+	select {
+	case <-tpl.cancel:
+		return nil, fmt.Errorf("[tpl_layer] -> transport layer is canceled")
+	default:
+	}
+
+	var (
+		networks []string
+		explicit bool
+	)
+	// 检查是可靠还是不可靠传输
+	if nt := requestTransport(req); nt != "" {
+		// RFC 3261 - 26.2.2 sips URI 以及指定了 transport 参数的 URI 只能使用对应的协议
+		networks = []string{nt}
+		// RFC 3263 - 4.1 指定了 transport 参数时不查询 NAPTR
+		if uri := requestUri(req); uri != nil && uri.UriParams() != nil {
+			explicit = uri.UriParams().Has("transport")
+		}
+	} else if len(req.String()) > int(MTU)-200 {
+		networks = []string{"tcp", "udp"}
+	} else {
+		networks = []string{"udp", "tcp"}
+	}
+
+	host, port, err := requestTarget(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	tpl.mu.RLock()
+	r := tpl.resolver
+	tpl.mu.RUnlock()
+
+	targets, err := r.resolve(ctx, host, port, networks, explicit)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("[tpl_layer] -> no target resolved for %s", host)
+	}
+
+	logger.Debugf("[tpl_layer] -> resolved targets %v for %s", targets, req.Short())
+
+	return targets, nil
+}
+
+// 发送请求到指定的目标
+func (tpl *layer) SendTo(req sip.Request, target Target) error {
+	select {
+	case <-tpl.cancel:
+		return fmt.Errorf("[tpl_layer] -> transport layer is canceled")
+	default:
+	}
+
+	viaHop, ok := req.ViaHop()
+	if !ok {
+		return &sip.MalformedMessageError{
+			Err: fmt.Errorf("[tpl_layer] -> missing required 'Via' header"),
+			Msg: req.String(),
+		}
+	}
+
+	nt := strings.ToLower(target.Network)
+	protocol, ok := tpl.protocols.get(protocolKey(nt))
+	if !ok && (nt == "tls" || nt == "ws" || nt == "wss") {
+		// TLS/WS/WSS 作为客户端使用时无需监听，按需创建
+		var err error
+		if protocol, err = tpl.getOrCreateProtocol(nt); err != nil {
+			return err
+		}
+		ok = true
+	}
+	if !ok {
+		return UnsupportedProtocolError(fmt.Sprintf("[tpl_layer] -> protocol %s is not supported", nt))
+	}
+
+	viaHop.Host = tpl.opts.localIP.String()
+	if viaHop.Params == nil {
+		viaHop.Params = sip.NewParams()
+	}
+	if !viaHop.Params.Has("rport") {
+		viaHop.Params.Add("rport", nil)
+	}
+	// rewrite sent-by transport
+	viaHop.Transport = strings.ToUpper(nt)
+	// rewrite sent-by port
+	// TODO if port, ok := tpl.listenPorts[nt]; ok
+	defPort := sip.DefaultPort(nt)
+	viaHop.Port = &defPort
+
+	// RFC 3621 - 12.1.2
+	// 请求是 INVITE 必须在Contact头域中提供一个地址
+	if req.IsInvite() {
+		if contact := req.Contact(); contact == nil {
+			if from := req.From(); from != nil {
+				contact = &sip.ContactHeader{
+					DisplayName: nil,
+					Address:     from.Address.Copy(),
+					Params:      nil,
+				}
+				req.PrependHeaderAfter(contact, "CSeq")
+			}
+		}
+	}
+	// RFC 7118 - 5.2 Contact 中的 transport 参数需与 WebSocket 传输一致
+	if nt == "ws" || nt == "wss" {
+		setContactTransport(req, nt)
+	}
+
+	logger.Debugf("[tpl_layer] -> sending SIP request to %s:\n%s", target, req)
+
+	return protocol.Send(target.Addr, req)
+}
+
+func (tpl *layer) LocalIP() net.IP {
+	return tpl.opts.localIP
+}
+
+// 获取协议，协议池中不存在则创建并添加进协议池
+func (tpl *layer) getOrCreateProtocol(network string) (Protocol, error) {
+	tpl.protocolMu.Lock()
+	defer tpl.protocolMu.Unlock()
+
+	if protocol, ok := tpl.protocols.get(protocolKey(network)); ok {
+		return protocol, nil
+	}
+
+	protocol, err := protocolFactory(network, tpl.receiveMessage, tpl.receiveError, tpl.cancel, tpl.opts)
+	if err != nil {
+		return nil, err
+	}
+	tpl.protocols.put(protocolKey(network), protocol)
+
+	return protocol, nil
+}
+
+// 请求目标 URI：Route 头域的第一个 URI，否则为 Request-URI
+func requestUri(req sip.Request) sip.Uri {
+	if hdrs := req.GetHeaders("Route"); len(hdrs) > 0 {
+		if route, ok := hdrs[0].(*sip.RouteHeader); ok && len(route.Addresses) > 0 {
+			return route.Addresses[0]
+		}
+	}
+
+	return req.Recipient()
+}
+
+// 请求的目标地址：优先使用显式设置的 Destination，否则使用目标 URI
+// 端口为空时进行 NAPTR 以及 SRV 查询
+func requestTarget(req sip.Request) (string, *sip.Port, error) {
+	if dest := req.ExplicitDestination(); dest != "" {
+		host, port, err := net.SplitHostPort(dest)
+		if err != nil {
+			// 没有端口
+			return strings.Trim(dest, "[]"), nil, nil
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return "", nil, fmt.Errorf("[tpl_layer] -> invalid destination %s: %w", dest, err)
+		}
+		sipPort := sip.Port(p)
+		return host, &sipPort, nil
+	}
+
+	uri := requestUri(req)
+	if uri == nil {
+		return "", nil, fmt.Errorf("[tpl_layer] -> request %s has no destination", req.Short())
+	}
+	domain := uri.Domain()
+	return domain.Host, domain.Port.Copy(), nil
+}
+
+// 根据请求目标 URI 确定必须使用的传输协议
+// sips URI 必须使用 TLS, 否则使用 URI 中的 transport 参数, 都没有则返回空
+func requestTransport(req sip.Request) string {
+	uri := requestUri(req)
+	if uri == nil {
+		return ""
+	}
+
+	if uri.IsEncrypted() {
+		return "tls"
+	}
+
+	if params := uri.UriParams(); params != nil {
+		if transport, ok := params.Get("transport"); ok && transport != nil {
+			switch nt := strings.ToLower(transport.String()); nt {
+			case "udp", "tcp", "tls", "ws", "wss":
+				return nt
+			}
+		}
+	}
+
+	return ""
+}
+
+// 为请求中未指定 transport 参数的 Contact 地址设置传输协议
+func setContactTransport(req sip.Request, network string) {
+	for _, hdr := range req.GetHeaders("Contact") {
+		contact, ok := hdr.(*sip.ContactHeader)
+		if !ok {
+			continue
+		}
+		uri, ok := contact.Address.(*sip.SipUri)
+		if !ok {
+			continue
+		}
+		if uri.FUriParams == nil {
+			uri.FUriParams = sip.NewParams()
+		}
+		if !uri.FUriParams.Has("transport") {
+			uri.FUriParams.Add("transport", sip.String{Str: network})
+		}
+	}
+}
+
 func (tpl *layer) Close() {
 	select {
 	case <-tpl.cancel:
@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 并发发送时按需创建的协议只创建一次
+func TestGetOrCreateProtocolConcurrent(t *testing.T) {
+	var created int32
+	factory := protocolFactory
+	protocolFactory = func(network string, receiveMessage chan<- sip.Message, receiveError chan<- error, notifyCancel <-chan struct{}, opts Options) (Protocol, error) {
+		atomic.AddInt32(&created, 1)
+		// 扩大并发创建的时间窗口
+		time.Sleep(10 * time.Millisecond)
+		return factory(network, receiveMessage, receiveError, notifyCancel, opts)
+	}
+	defer func() {
+		protocolFactory = factory
+	}()
+
+	tpl := CreateLayer(LocalAddr("127.0.0.1")).(*layer)
+	defer tpl.Close()
+
+	const n = 16
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	protocols := make([]Protocol, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			protocol, err := tpl.getOrCreateProtocol("ws")
+			if err != nil {
+				t.Errorf("create ws protocol failed: %s", err)
+				return
+			}
+			protocols[i] = protocol
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if created != 1 {
+		t.Fatalf("ws protocol created %d times, want once", created)
+	}
+	for _, protocol := range protocols {
+		if protocol != protocols[0] {
+			t.Fatal("different ws protocols returned")
+		}
+	}
+}
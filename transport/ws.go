@@ -0,0 +1,244 @@
+package transport
+
+import (
+	cryptoTls "crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/zenghr0820/gsip/logger"
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// 主动连接时 HTTP Upgrade 请求使用的路径
+const wsDefaultPath = "/"
+
+/**
+创建 WebSocket 协议 RFC 7118：
+	receiveMessage: 传输层接收 WS 协议数据的 chan
+	receiveError: 传输层接收 WS 协议异常的 chan
+	notifyCancel：传输层通知 WS 协议关闭的 chan
+	serverConfig：WSS 监听使用的 TLS 配置，为 nil 时为 WS
+	clientConfig：WSS 主动连接使用的 TLS 配置
+*/
+func CreateWsProtocol(
+	receiveMessage chan<- sip.Message,
+	receiveError chan<- error,
+	notifyCancel <-chan struct{},
+	secure bool,
+	serverConfig *cryptoTls.Config,
+	clientConfig *cryptoTls.Config,
+) Protocol {
+	ws := new(wsProtocol)
+	ws.network = "ws"
+	if secure {
+		ws.network = "wss"
+	}
+	ws.reliable = true
+	// 每个 WebSocket 消息包含一条完整的 SIP 消息，使用非流式解析
+	ws.streamed = false
+	ws.secure = secure
+	ws.serverConfig = serverConfig
+	ws.clientConfig = clientConfig
+	ws.receiveConnection = make(chan Connection)
+
+	ws.listeners = CreateListenerPool(ws.receiveConnection, receiveError, notifyCancel)
+	ws.connections = CreateConnectionPool(receiveMessage, receiveError, notifyCancel)
+	// pipe listener and connection pools
+	// 添加新的连接到连接池
+	go ws.pipePools()
+	return ws
+}
+
+// WS/WSS protocol implementation
+type wsProtocol struct {
+	protocol
+	secure            bool
+	connections       ConnectionPool
+	listeners         ListenerPool
+	receiveConnection chan Connection
+	serverConfig      *cryptoTls.Config
+	clientConfig      *cryptoTls.Config
+}
+
+// 包装 WebSocket 监听，Accept 返回的连接在第一次读写时完成 HTTP Upgrade
+type wsListener struct {
+	net.Listener
+	network string
+}
+
+func (ls *wsListener) Accept() (net.Conn, error) {
+	conn, err := ls.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return newServerWsConn(conn), nil
+}
+
+func (ws *wsProtocol) Done() <-chan struct{} {
+	return ws.connections.Done()
+}
+
+// 解析地址
+func (ws *wsProtocol) resolveAddr(addr *sip.Addr) (*net.TCPAddr, error) {
+	address := addr.Addr()
+
+	remoteAddr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, &ProtocolError{
+			err,
+			fmt.Sprintf("[ws_protocol] -> resolve target address %s %s", ws.Network(), address),
+			fmt.Sprintf("%p", ws),
+		}
+	}
+	return remoteAddr, err
+}
+
+// 监听
+func (ws *wsProtocol) Listen(addr *sip.Addr) error {
+	if ws.secure && (ws.serverConfig == nil || (len(ws.serverConfig.Certificates) == 0 && ws.serverConfig.GetCertificate == nil)) {
+		return &ProtocolError{
+			fmt.Errorf("[ws_protocol] -> server certificate not configured"),
+			fmt.Sprintf("[ws_protocol] -> listen on %s %s address", ws.Network(), addr.Addr()),
+			fmt.Sprintf("%p", ws),
+		}
+	}
+
+	addr = sip.FillTargetHostAndPort(ws.Network(), addr)
+	// resolve local TCP endpoint
+	localAddr, err := ws.resolveAddr(addr)
+	if err != nil {
+		return err
+	}
+	listener, err := net.ListenTCP("tcp", localAddr)
+	if err != nil {
+		return &ProtocolError{
+			err,
+			fmt.Sprintf("[ws_protocol] -> listen on %s %s address", ws.Network(), localAddr),
+			fmt.Sprintf("%p", ws),
+		}
+	}
+
+	logger.Infof("[ws_protocol] -> begin listen on %s %s", ws.Network(), localAddr)
+
+	var ls net.Listener = listener
+	if ws.secure {
+		ls = cryptoTls.NewListener(listener, ws.serverConfig)
+	}
+
+	// 创建连接
+	key := ListenerKey(fmt.Sprintf("%s:0.0.0.0:%d", ws.network, localAddr.Port))
+	// 将监听的连接添加进 连接池
+	err = ws.listeners.Put(key, &wsListener{ls, ws.network})
+	return err
+}
+
+// 发送
+func (ws *wsProtocol) Send(rAddr *sip.Addr, msg sip.Message) error {
+	// 验证发送地址
+	if rAddr.Host == "" {
+		return &ProtocolError{
+			fmt.Errorf("[ws_protocol] -> empty remote target host"),
+			fmt.Sprintf("[ws_protocol] -> send SIP message to %s %s", ws.Network(), rAddr.Addr()),
+			fmt.Sprintf("%p", ws),
+		}
+	}
+	// 解析地址
+	remoteAddr, err := ws.resolveAddr(rAddr)
+	if err != nil {
+		return err
+	}
+
+	// 获取连接
+	conn, err := ws.getOrCreateConnection(rAddr, remoteAddr)
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("[ws_protocol] -> writing SIP message to %s %s", ws.Network(), remoteAddr)
+
+	// 一条 SIP 消息对应一个 WebSocket 消息
+	_, err = conn.Write([]byte(msg.String()))
+
+	return err // should be nil
+}
+
+// 添加新的连接到连接池
+func (ws *wsProtocol) pipePools() {
+	defer close(ws.receiveConnection)
+
+	logger.Debug("[ws_protocol] -> start pipe pools")
+	defer logger.Debug("[ws_protocol] -> stop pipe pools")
+
+	for {
+		select {
+		case <-ws.listeners.Done():
+			return
+		case conn := <-ws.receiveConnection:
+			if err := ws.connections.Put(conn, SockTTL); err != nil {
+				logger.Errorf("[ws_protocol] -> put new %s connection failed: %s", ws.Network(), err)
+
+				continue
+			}
+		}
+	}
+}
+
+// 获取连接或者创建连接
+func (ws *wsProtocol) getOrCreateConnection(rAddr *sip.Addr, remoteAddr *net.TCPAddr) (Connection, error) {
+	key := ConnectionKey(ws.network + ":" + remoteAddr.String())
+	conn, err := ws.connections.Get(key)
+	if err != nil {
+		logger.Debugf("[ws_protocol] -> connection for remote address %s %s not found, create a new one", ws.Network(), remoteAddr)
+
+		wsConn, err := ws.dial(rAddr, remoteAddr)
+		if err != nil {
+			return nil, &ProtocolError{
+				err,
+				fmt.Sprintf("[ws_protocol] -> connect to %s %s address", ws.Network(), remoteAddr),
+				fmt.Sprintf("%p", ws),
+			}
+		}
+
+		conn = CreateConnection(key, wsConn)
+		if err := ws.connections.Put(conn, SockTTL); err != nil {
+			return conn, err
+		}
+	}
+
+	return conn, nil
+}
+
+// 建立连接并完成 HTTP Upgrade 握手
+func (ws *wsProtocol) dial(rAddr *sip.Addr, remoteAddr *net.TCPAddr) (*wsConn, error) {
+	var (
+		baseConn net.Conn
+		err      error
+	)
+	if ws.secure {
+		config := ws.clientConfig
+		if config == nil {
+			config = &cryptoTls.Config{}
+		} else {
+			config = config.Clone()
+		}
+		if config.ServerName == "" && net.ParseIP(rAddr.Host) == nil {
+			config.ServerName = strings.TrimSuffix(rAddr.Host, ".")
+		}
+		baseConn, err = cryptoTls.Dial("tcp", remoteAddr.String(), config)
+	} else {
+		baseConn, err = net.DialTCP("tcp", nil, remoteAddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := newClientWsConn(baseConn, rAddr.Addr(), wsDefaultPath)
+	if err != nil {
+		_ = baseConn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,347 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// RFC 6455 - 1.3 计算 Sec-WebSocket-Accept 使用的 GUID
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// RFC 7118 - 4.1 SIP over WebSocket 子协议名称
+const wsSubProtocol = "sip"
+
+// RFC 6455 - 5.2 帧类型
+const (
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+	wsOpPing         byte = 0x9
+	wsOpPong         byte = 0xA
+)
+
+/**
+包装 WebSocket 连接：
+	每次 ReadFrom 返回一条完整的 WebSocket 消息(即一条 SIP 消息)，
+	实现 net.PacketConn 使连接以非流式(non-streamed)模式解析
+*/
+type wsConn struct {
+	net.Conn
+	reader *bufio.Reader
+	// 客户端发送的帧必须使用掩码 RFC 6455 - 5.3
+	client bool
+	// 服务端在第一次读写时完成握手
+	handshakeOnce sync.Once
+	handshakeErr  error
+	// 写锁，保证帧的完整性
+	wmu sync.Mutex
+}
+
+// 创建服务端连接，握手在第一次读写时进行，避免阻塞监听 Accept
+func newServerWsConn(conn net.Conn) *wsConn {
+	return &wsConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+// 创建客户端连接并完成握手
+func newClientWsConn(conn net.Conn, host string, path string) (*wsConn, error) {
+	c := &wsConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+		client: true,
+	}
+	c.handshakeOnce.Do(func() {
+		c.handshakeErr = c.clientHandshake(host, path)
+	})
+
+	return c, c.handshakeErr
+}
+
+func (c *wsConn) handshake() error {
+	c.handshakeOnce.Do(func() {
+		c.handshakeErr = c.serverHandshake()
+	})
+	return c.handshakeErr
+}
+
+// 服务端握手 RFC 6455 - 4.2
+func (c *wsConn) serverHandshake() error {
+	req, err := http.ReadRequest(c.reader)
+	if err != nil {
+		return fmt.Errorf("[ws_conn] -> read upgrade request failed: %w", err)
+	}
+
+	reject := func(reason string) error {
+		_, _ = io.WriteString(c.Conn, "HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n")
+		return fmt.Errorf("[ws_conn] -> reject upgrade request: %s", reason)
+	}
+
+	if req.Method != http.MethodGet {
+		return reject("method is not GET")
+	}
+	if !headerContainsToken(req.Header, "Connection", "upgrade") ||
+		!headerContainsToken(req.Header, "Upgrade", "websocket") {
+		return reject("missing upgrade headers")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		return reject("unsupported websocket version")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return reject("missing Sec-WebSocket-Key")
+	}
+	// RFC 7118 - 4.1 必须协商 sip 子协议
+	if !headerContainsToken(req.Header, "Sec-WebSocket-Protocol", wsSubProtocol) {
+		return reject("sip subprotocol not requested")
+	}
+
+	res := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n" +
+		"Sec-WebSocket-Protocol: " + wsSubProtocol + "\r\n\r\n"
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := io.WriteString(c.Conn, res); err != nil {
+		return fmt.Errorf("[ws_conn] -> write upgrade response failed: %w", err)
+	}
+
+	return nil
+}
+
+// 客户端握手 RFC 6455 - 4.1
+func (c *wsConn) clientHandshake(host string, path string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", wsSubProtocol)
+
+	if err := req.Write(c.Conn); err != nil {
+		return fmt.Errorf("[ws_conn] -> write upgrade request failed: %w", err)
+	}
+
+	res, err := http.ReadResponse(c.reader, req)
+	if err != nil {
+		return fmt.Errorf("[ws_conn] -> read upgrade response failed: %w", err)
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("[ws_conn] -> upgrade rejected: %s", res.Status)
+	}
+	if res.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return fmt.Errorf("[ws_conn] -> invalid Sec-WebSocket-Accept")
+	}
+	if !headerContainsToken(res.Header, "Sec-WebSocket-Protocol", wsSubProtocol) {
+		return fmt.Errorf("[ws_conn] -> server did not accept sip subprotocol")
+	}
+
+	return nil
+}
+
+// 读取一条完整的消息
+func (c *wsConn) ReadFrom(buf []byte) (int, net.Addr, error) {
+	if err := c.handshake(); err != nil {
+		return 0, c.RemoteAddr(), err
+	}
+
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, c.RemoteAddr(), err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return 0, c.RemoteAddr(), err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			_ = c.writeFrame(wsOpClose, payload)
+			return 0, c.RemoteAddr(), io.EOF
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			message = append(message, payload...)
+		default:
+			return 0, c.RemoteAddr(), fmt.Errorf("[ws_conn] -> unknown opcode %#x", opcode)
+		}
+
+		if len(message) > len(buf) {
+			return 0, c.RemoteAddr(), fmt.Errorf("[ws_conn] -> message too large: %d bytes", len(message))
+		}
+
+		if fin {
+			return copy(buf, message), c.RemoteAddr(), nil
+		}
+	}
+}
+
+func (c *wsConn) Read(buf []byte) (int, error) {
+	num, _, err := c.ReadFrom(buf)
+	return num, err
+}
+
+// 一次写入作为一条消息发送
+func (c *wsConn) Write(buf []byte) (int, error) {
+	if !c.client {
+		if err := c.handshake(); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := c.writeFrame(wsOpText, buf); err != nil {
+		return 0, err
+	}
+
+	return len(buf), nil
+}
+
+func (c *wsConn) WriteTo(buf []byte, _ net.Addr) (int, error) {
+	return c.Write(buf)
+}
+
+// 读取一帧 RFC 6455 - 5.2
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(c.reader, header); err != nil {
+		return
+	}
+
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(c.reader, ext); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(c.reader, ext); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+
+	if length > uint64(BufferSize) {
+		err = fmt.Errorf("[ws_conn] -> frame too large: %d bytes", length)
+		return
+	}
+	// RFC 6455 - 5.1 客户端发往服务端的帧必须使用掩码
+	if !c.client && !masked {
+		err = errors.New("[ws_conn] -> received unmasked frame from client")
+		return
+	}
+
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(c.reader, mask); err != nil {
+			return
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return
+}
+
+// 写入一帧 RFC 6455 - 5.2
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[len(frame)-2:], uint16(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(length))
+	}
+
+	if c.client {
+		mask := make([]byte, 4)
+		if _, err := rand.Read(mask); err != nil {
+			return err
+		}
+		frame = append(frame, mask...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Conn.Write(frame)
+
+	return err
+}
+
+// 计算 Sec-WebSocket-Accept
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// 判断逗号分隔的头部值中是否包含 token (忽略大小写)
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,469 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zenghr0820/gsip/sip"
+)
+
+// RFC 6455 - 1.3 的示例 key 以及对应的 Sec-WebSocket-Accept
+const (
+	rfc6455Key    = "dGhlIHNhbXBsZSBub25jZQ=="
+	rfc6455Accept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+const wsTestMessage = "OPTIONS sip:bob@127.0.0.1 SIP/2.0\r\n" +
+	"Via: SIP/2.0/WS df7jal23ls0d.invalid;branch=z9hG4bKws\r\n" +
+	"From: <sip:alice@127.0.0.1>;tag=alice\r\n" +
+	"To: <sip:bob@127.0.0.1>\r\n" +
+	"Call-ID: ws-call\r\n" +
+	"CSeq: 1 OPTIONS\r\n" +
+	"Max-Forwards: 70\r\n" +
+	"Content-Length: 0\r\n\r\n"
+
+// 监听方的 WebSocket 连接以及对方的 TCP 连接，对方的握手以及帧由测试完成
+func wsConnPair(t *testing.T) (*wsConn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := (&wsListener{Listener: ln, network: "ws"}).Accept()
+		if err != nil {
+			t.Errorf("accept failed: %s", err)
+		}
+		accepted <- conn
+	}()
+
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		_ = raw.Close()
+		_ = conn.Close()
+	})
+
+	return conn.(*wsConn), raw
+}
+
+// 升级请求，headers 替换默认的头部，值为空时删除
+func upgradeRequest(headers map[string]string) string {
+	req := map[string]string{
+		"Upgrade":                "websocket",
+		"Connection":             "Upgrade",
+		"Sec-WebSocket-Key":      rfc6455Key,
+		"Sec-WebSocket-Version":  "13",
+		"Sec-WebSocket-Protocol": "sip",
+	}
+	for name, value := range headers {
+		req[name] = value
+	}
+
+	lines := []string{"GET / HTTP/1.1", "Host: 127.0.0.1"}
+	for name, value := range req {
+		if value != "" {
+			lines = append(lines, name+": "+value)
+		}
+	}
+
+	return strings.Join(lines, "\r\n") + "\r\n\r\n"
+}
+
+// 对方完成握手，返回读取服务端帧的 reader
+func rawHandshake(t *testing.T, raw net.Conn) *bufio.Reader {
+	t.Helper()
+	if _, err := io.WriteString(raw, upgradeRequest(nil)); err != nil {
+		t.Fatalf("write upgrade request failed: %s", err)
+	}
+	reader := bufio.NewReader(raw)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("read upgrade response failed: %s", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade response = %s, want 101", res.Status)
+	}
+
+	return reader
+}
+
+// 客户端发送的帧，mask 为空时不使用掩码
+func clientFrame(fin bool, opcode byte, payload []byte, mask []byte) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+	if len(payload) < 126 {
+		frame = append(frame, maskBit|byte(len(payload)))
+	} else {
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[len(frame)-2:], uint16(len(payload)))
+	}
+
+	if mask == nil {
+		return append(frame, payload...)
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	return frame
+}
+
+// 读取服务端发送的一帧，服务端的帧不使用掩码
+func readServerFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatalf("read frame failed: %s", err)
+	}
+	if header[0]&0x80 == 0 {
+		t.Error("server frame without FIN")
+	}
+	if header[1]&0x80 != 0 {
+		t.Error("server frame masked")
+	}
+	payload := make([]byte, header[1]&0x7F)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatalf("read payload failed: %s", err)
+	}
+
+	return header[0] & 0x0F, payload
+}
+
+type wsReadResult struct {
+	message string
+	err     error
+}
+
+// 在后台读取一条消息
+func readMessage(conn *wsConn) <-chan wsReadResult {
+	result := make(chan wsReadResult, 1)
+	go func() {
+		buf := make([]byte, BufferSize)
+		num, err := conn.Read(buf)
+		result <- wsReadResult{string(buf[:num]), err}
+	}()
+
+	return result
+}
+
+func waitMessage(t *testing.T, result <-chan wsReadResult) wsReadResult {
+	t.Helper()
+	select {
+	case res := <-result:
+		return res
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message read")
+	}
+
+	return wsReadResult{}
+}
+
+func TestWsAcceptKey(t *testing.T) {
+	if accept := wsAcceptKey(rfc6455Key); accept != rfc6455Accept {
+		t.Errorf("accept = %s, want %s", accept, rfc6455Accept)
+	}
+}
+
+// 客户端与服务端协商 sip 子协议后，双向发送消息
+func TestWsHandshake(t *testing.T) {
+	server, raw := wsConnPair(t)
+	result := readMessage(server)
+
+	client, err := newClientWsConn(raw, "127.0.0.1:5060", wsDefaultPath)
+	if err != nil {
+		t.Fatalf("client handshake failed: %s", err)
+	}
+	if _, err := client.Write([]byte(wsTestMessage)); err != nil {
+		t.Fatalf("client write failed: %s", err)
+	}
+	if res := waitMessage(t, result); res.err != nil || res.message != wsTestMessage {
+		t.Fatalf("server read %q, %v", res.message, res.err)
+	}
+
+	result = readMessage(client)
+	if _, err := server.Write([]byte("pong")); err != nil {
+		t.Fatalf("server write failed: %s", err)
+	}
+	if res := waitMessage(t, result); res.err != nil || res.message != "pong" {
+		t.Errorf("client read %q, %v", res.message, res.err)
+	}
+}
+
+// 服务端的响应使用 RFC 6455 的 Sec-WebSocket-Accept 以及 sip 子协议
+func TestWsServerHandshake(t *testing.T) {
+	server, raw := wsConnPair(t)
+	readMessage(server)
+
+	if _, err := io.WriteString(raw, upgradeRequest(nil)); err != nil {
+		t.Fatalf("write upgrade request failed: %s", err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(raw), nil)
+	if err != nil {
+		t.Fatalf("read upgrade response failed: %s", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade response = %s, want 101", res.Status)
+	}
+	if accept := res.Header.Get("Sec-WebSocket-Accept"); accept != rfc6455Accept {
+		t.Errorf("Sec-WebSocket-Accept = %s, want %s", accept, rfc6455Accept)
+	}
+	if protocol := res.Header.Get("Sec-WebSocket-Protocol"); protocol != "sip" {
+		t.Errorf("Sec-WebSocket-Protocol = %s, want sip", protocol)
+	}
+}
+
+func TestWsHandshakeRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{name: "without sip subprotocol", headers: map[string]string{"Sec-WebSocket-Protocol": ""}},
+		{name: "other subprotocol", headers: map[string]string{"Sec-WebSocket-Protocol": "chat"}},
+		{name: "unsupported version", headers: map[string]string{"Sec-WebSocket-Version": "8"}},
+		{name: "without key", headers: map[string]string{"Sec-WebSocket-Key": ""}},
+		{name: "without upgrade", headers: map[string]string{"Upgrade": ""}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, raw := wsConnPair(t)
+			result := readMessage(server)
+
+			if _, err := io.WriteString(raw, upgradeRequest(test.headers)); err != nil {
+				t.Fatalf("write upgrade request failed: %s", err)
+			}
+			res, err := http.ReadResponse(bufio.NewReader(raw), nil)
+			if err != nil {
+				t.Fatalf("read upgrade response failed: %s", err)
+			}
+			if res.StatusCode != http.StatusBadRequest {
+				t.Errorf("upgrade response = %s, want 400", res.Status)
+			}
+			if res := waitMessage(t, result); res.err == nil {
+				t.Error("server read without error")
+			}
+		})
+	}
+}
+
+// 服务端没有接受 sip 子协议时客户端握手失败
+func TestWsClientHandshakeRejected(t *testing.T) {
+	server, raw := wsConnPair(t)
+	go func() {
+		req, err := http.ReadRequest(bufio.NewReader(server.Conn))
+		if err != nil {
+			return
+		}
+		_, _ = io.WriteString(server.Conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: "+wsAcceptKey(req.Header.Get("Sec-WebSocket-Key"))+"\r\n\r\n")
+	}()
+
+	_, err := newClientWsConn(raw, "127.0.0.1:5060", wsDefaultPath)
+	if err == nil || !strings.Contains(err.Error(), "sip subprotocol") {
+		t.Errorf("error = %v, want sip subprotocol not accepted", err)
+	}
+}
+
+// 客户端发送的帧使用掩码，服务端拒绝未使用掩码的帧
+func TestWsMasking(t *testing.T) {
+	t.Run("client frames masked", func(t *testing.T) {
+		server, raw := wsConnPair(t)
+		client := &wsConn{Conn: raw, reader: bufio.NewReader(raw), client: true}
+		if _, err := client.Write([]byte(wsTestMessage)); err != nil {
+			t.Fatalf("client write failed: %s", err)
+		}
+
+		reader := bufio.NewReader(server.Conn)
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			t.Fatalf("read frame failed: %s", err)
+		}
+		if header[0] != 0x80|wsOpText {
+			t.Errorf("first byte = %#x, want FIN text frame", header[0])
+		}
+		if header[1] != 0x80|126 || int(binary.BigEndian.Uint16(header[2:])) != len(wsTestMessage) {
+			t.Fatalf("length header = %#x %d, want masked %d", header[1], binary.BigEndian.Uint16(header[2:]), len(wsTestMessage))
+		}
+		mask := make([]byte, 4)
+		payload := make([]byte, len(wsTestMessage))
+		if _, err := io.ReadFull(reader, mask); err != nil {
+			t.Fatalf("read mask failed: %s", err)
+		}
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			t.Fatalf("read payload failed: %s", err)
+		}
+		if string(payload) == wsTestMessage {
+			t.Error("payload not masked")
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		if string(payload) != wsTestMessage {
+			t.Errorf("unmasked payload = %q", payload)
+		}
+	})
+
+	t.Run("unmasked client frame rejected", func(t *testing.T) {
+		server, raw := wsConnPair(t)
+		result := readMessage(server)
+		rawHandshake(t, raw)
+
+		if _, err := raw.Write(clientFrame(true, wsOpText, []byte(wsTestMessage), nil)); err != nil {
+			t.Fatalf("write frame failed: %s", err)
+		}
+		if res := waitMessage(t, result); res.err == nil || !strings.Contains(res.err.Error(), "unmasked") {
+			t.Errorf("error = %v, want unmasked frame", res.err)
+		}
+	})
+}
+
+// 分片的消息合并为一条，分片之间的 Ping 回复 Pong
+func TestWsFragmentation(t *testing.T) {
+	server, raw := wsConnPair(t)
+	result := readMessage(server)
+	reader := rawHandshake(t, raw)
+
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	half := len(wsTestMessage) / 2
+	frames := append(clientFrame(false, wsOpText, []byte(wsTestMessage[:half]), mask),
+		clientFrame(true, wsOpPing, []byte("ping"), mask)...)
+	frames = append(frames, clientFrame(true, wsOpContinuation, []byte(wsTestMessage[half:]), mask)...)
+	if _, err := raw.Write(frames); err != nil {
+		t.Fatalf("write frames failed: %s", err)
+	}
+
+	if opcode, payload := readServerFrame(t, reader); opcode != wsOpPong || string(payload) != "ping" {
+		t.Errorf("server replied opcode %#x with %q, want pong", opcode, payload)
+	}
+	if res := waitMessage(t, result); res.err != nil || res.message != wsTestMessage {
+		t.Errorf("server read %q, %v", res.message, res.err)
+	}
+}
+
+// 长度超过缓冲区的帧
+func TestWsFrameTooLarge(t *testing.T) {
+	server, raw := wsConnPair(t)
+	result := readMessage(server)
+	rawHandshake(t, raw)
+
+	header := []byte{0x80 | wsOpText, 0x80 | 127, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(header[2:], uint64(BufferSize)+1)
+	if _, err := raw.Write(header); err != nil {
+		t.Fatalf("write frame failed: %s", err)
+	}
+	if res := waitMessage(t, result); res.err == nil || !strings.Contains(res.err.Error(), "too large") {
+		t.Errorf("error = %v, want frame too large", res.err)
+	}
+}
+
+// 收到 Close 帧时回复 Close 并结束读取
+func TestWsClose(t *testing.T) {
+	server, raw := wsConnPair(t)
+	result := readMessage(server)
+	reader := rawHandshake(t, raw)
+
+	// 1000 正常关闭
+	status := []byte{0x03, 0xE8}
+	if _, err := raw.Write(clientFrame(true, wsOpClose, status, []byte{1, 2, 3, 4})); err != nil {
+		t.Fatalf("write close frame failed: %s", err)
+	}
+	if opcode, payload := readServerFrame(t, reader); opcode != wsOpClose || string(payload) != string(status) {
+		t.Errorf("server replied opcode %#x with %v, want close 1000", opcode, payload)
+	}
+	if res := waitMessage(t, result); res.err != io.EOF {
+		t.Errorf("error = %v, want EOF", res.err)
+	}
+}
+
+// 协议监听 WS 地址，接收的 SIP 消息传递给传输层，发送时主动建立连接
+func TestWsProtocol(t *testing.T) {
+	messages := make(chan sip.Message, 1)
+	errs := make(chan error, 8)
+	cancel := make(chan struct{})
+	ws := CreateWsProtocol(messages, errs, cancel, false, nil, nil)
+	defer close(cancel)
+
+	port := sip.Port(15080)
+	if err := ws.Listen(&sip.Addr{Host: "127.0.0.1", Port: &port}); err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+
+	raw, err := net.Dial("tcp", "127.0.0.1:15080")
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	defer raw.Close()
+	client, err := newClientWsConn(raw, "127.0.0.1:15080", wsDefaultPath)
+	if err != nil {
+		t.Fatalf("client handshake failed: %s", err)
+	}
+	if _, err := client.Write([]byte(wsTestMessage)); err != nil {
+		t.Fatalf("client write failed: %s", err)
+	}
+	select {
+	case msg := <-messages:
+		if req, ok := msg.(sip.Request); !ok || req.Method() != sip.OPTIONS {
+			t.Errorf("received %s, want OPTIONS", msg.Short())
+		}
+	case err := <-errs:
+		t.Fatalf("receive failed: %s", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	// 向另一个 WS 服务端发送
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	defer ln.Close()
+	result := make(chan wsReadResult, 1)
+	go func() {
+		conn, err := (&wsListener{Listener: ln, network: "ws"}).Accept()
+		if err != nil {
+			result <- wsReadResult{err: err}
+			return
+		}
+		defer conn.Close()
+		result <- <-readMessage(conn.(*wsConn))
+	}()
+
+	msg, err := sip.ParseMessage([]byte(wsTestMessage))
+	if err != nil {
+		t.Fatalf("parse message failed: %s", err)
+	}
+	target := sip.Port(ln.Addr().(*net.TCPAddr).Port)
+	if err := ws.Send(&sip.Addr{Host: "127.0.0.1", Port: &target}, msg); err != nil {
+		t.Fatalf("send failed: %s", err)
+	}
+	if res := waitMessage(t, result); res.err != nil || res.message != msg.String() {
+		t.Errorf("server read %q, %v", res.message, res.err)
+	}
+}